@@ -0,0 +1,138 @@
+package addpodcast
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/feedworker"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"github.com/webbgeorge/castkeeper/pkg/util"
+	"golang.org/x/term"
+	"gorm.io/gorm"
+)
+
+var AddPodcastCmd = &cobra.Command{
+	Use:   "add <feed-url>",
+	Short: "Add a podcast to CastKeeper",
+	Long: "Utility script for adding a podcast by its feed URL for the given CastKeeper configuration. " +
+		"If the podcast is already added from another feed, the feed is added to the existing podcast.",
+	Args: cobra.ExactArgs(1),
+	Run:  run,
+}
+
+var (
+	username     string
+	password     string
+	isPremium    bool
+	refreshFeeds bool
+)
+
+func init() {
+	cli.InitGlobalFlags(AddPodcastCmd)
+	cli.InitJSONFlag(AddPodcastCmd)
+	AddPodcastCmd.Flags().StringVar(&username, "username", "", "username for a password protected feed")
+	AddPodcastCmd.Flags().StringVar(&password, "password", "", "password for a password protected feed (otherwise entered interactively when a username is given)")
+	AddPodcastCmd.Flags().BoolVar(&isPremium, "premium", false, "prefer episodes from this feed over other feeds of the same podcast")
+	AddPodcastCmd.Flags().BoolVar(&refreshFeeds, "refresh", false, "check the podcast for episodes immediately, instead of waiting for the server")
+}
+
+func run(cmd *cobra.Command, args []string) {
+	feedURL := args[0]
+
+	ctx, cfg, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := podcasts.CheckDEKExists(ctx, db, cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	encService, err := encryption.ConfigureEncryptedValueService(cfg)
+	if err != nil {
+		log.Fatalf("failed to configure encryption: %v", err)
+	}
+
+	objstore, err := objectstorage.ConfigureObjectStorage(ctx, cfg, encService)
+	if err != nil {
+		log.Fatalf("failed to configure objectstorage: %v", err)
+	}
+
+	creds, err := readCredentials()
+	if err != nil {
+		log.Fatalf("failed to read password: %v", err)
+	}
+
+	feedService := &podcasts.FeedService{
+		HTTPClient: framework.NewHTTPClient(time.Second * 30),
+	}
+
+	podcast, addedToExisting, err := podcasts.AddPodcast(ctx, db, feedService, encService, feedURL, creds, isPremium)
+	if err != nil {
+		if errors.Is(err, gorm.ErrDuplicatedKey) {
+			log.Fatal("this podcast is already added")
+		}
+		log.Fatalf("failed to add podcast: %v", err)
+	}
+
+	// when the podcast already existed, the feed is added to it instead
+	if !addedToExisting {
+		fileName := fmt.Sprintf("%s.%s", util.SanitiseGUID(podcast.GUID), "jpg")
+		_, err = objstore.SaveRemoteFile(ctx, creds, podcast.ImageURL, util.SanitiseGUID(podcast.GUID), fileName, objectstorage.SaveOptions{})
+		if err != nil {
+			log.Printf("failed to download image, continuing without: %v", err)
+		}
+	}
+
+	if refreshFeeds {
+		err = feedworker.RefreshPodcast(ctx, db, feedService, encService, podcast)
+		if err != nil {
+			log.Fatalf("failed to refresh podcast: %v", err)
+		}
+	} else {
+		err = framework.PushQueueTask(ctx, db, feedworker.FeedWorkerQueueName, "")
+		if err != nil {
+			log.Printf("failed to queue feed worker, continuing without: %v", err)
+		}
+	}
+
+	err = cli.PrintResult(podcast, func() {
+		if addedToExisting {
+			log.Printf("successfully added feed to existing podcast '%s' (%s)", podcast.Title, podcast.GUID)
+			return
+		}
+		log.Printf("successfully added podcast '%s' (%s)", podcast.Title, podcast.GUID)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func readCredentials() (*podcasts.PodcastCredentials, error) {
+	if username == "" {
+		return nil, nil
+	}
+
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Enter feed password: ")
+		pwBytes, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		password = string(pwBytes)
+	}
+
+	return &podcasts.PodcastCredentials{
+		Username: username,
+		Password: password,
+	}, nil
+}
@@ -0,0 +1,63 @@
+package dedupestorage
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/blobs"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+)
+
+var DedupeStorageCmd = &cobra.Command{
+	Use:   "dedupe",
+	Short: "Store existing episode files by their content, removing duplicates",
+	Long: "Utility script for moving the files of episodes downloaded before content-addressed storage, so that " +
+		"episodes with identical files share a single copy. Each file is checked against its episode's recorded " +
+		"hash before it is moved. It is safe to run more than once, and episodes which are already stored by their " +
+		"content are skipped.",
+	Args: cobra.NoArgs,
+	Run:  run,
+}
+
+func init() {
+	cli.InitGlobalFlags(DedupeStorageCmd)
+	cli.InitJSONFlag(DedupeStorageCmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, cfg, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encService, err := encryption.ConfigureEncryptedValueService(cfg)
+	if err != nil {
+		log.Fatalf("failed to configure encryption: %v", err)
+	}
+
+	objstore, err := objectstorage.ConfigureObjectStorage(ctx, cfg, encService)
+	if err != nil {
+		log.Fatalf("failed to configure objectstorage: %v", err)
+	}
+
+	result, err := blobs.DeduplicateEpisodes(ctx, db, objstore)
+	if err != nil {
+		log.Fatalf("failed to deduplicate storage: %v", err)
+	}
+
+	err = cli.PrintResult(result, func() {
+		for _, problem := range result.Problems {
+			fmt.Printf("failed\tepisode %s\t%s\n", problem.EpisodeGUID, problem.Problem)
+		}
+		fmt.Printf(
+			"stored %d, deduplicated %d (%d bytes saved), failed %d\n",
+			result.Stored, result.Deduplicated, result.SavedBytes, result.Failed,
+		)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
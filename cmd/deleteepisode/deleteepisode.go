@@ -0,0 +1,36 @@
+package deleteepisode
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+)
+
+var DeleteEpisodeCmd = &cobra.Command{
+	Use:   "delete <episode-guid>",
+	Short: "Delete a CastKeeper episode",
+	Long: "Utility script for deleting an episode from the database for the given CastKeeper configuration. " +
+		"Deleted episodes are not downloaded again. Downloaded files are not deleted from object storage.",
+	Args: cobra.ExactArgs(1),
+	Run:  run,
+}
+
+func init() {
+	cli.InitGlobalFlags(DeleteEpisodeCmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, _, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = podcasts.DeleteEpisode(ctx, db, args[0])
+	if err != nil {
+		log.Fatalf("failed to delete episode: %v", err)
+	}
+
+	log.Printf("successfully deleted episode '%s'", args[0])
+}
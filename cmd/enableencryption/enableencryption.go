@@ -0,0 +1,134 @@
+package enableencryption
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+)
+
+var EnableEncryptionCmd = &cobra.Command{
+	Use:   "enable",
+	Short: "Set up encryption, and encrypt existing files",
+	Long: "Utility script for enabling encryption on an existing instance, once an encryption driver is configured. " +
+		"The key of the encryption driver is checked to work, and the data encryption key, and the media key when " +
+		"Encryption.EncryptMedia is enabled, are created if they don't exist yet. Episode files saved before media " +
+		"encryption was enabled are then encrypted in place. It is safe to run more than once. CastKeeper should be " +
+		"stopped while this is run.",
+	Args: cobra.NoArgs,
+	Run:  run,
+}
+
+type feature struct {
+	Name      string
+	Available bool
+	Note      string `json:",omitempty"`
+}
+
+type enableResult struct {
+	encryption.EnableResult
+	Media    *objectstorage.EncryptResult `json:",omitempty"`
+	Features []feature
+}
+
+func init() {
+	cli.InitGlobalFlags(EnableEncryptionCmd)
+	cli.InitJSONFlag(EnableEncryptionCmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, cfg, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if cfg.Encryption.Driver == "" {
+		log.Fatal("no encryption driver is configured, set Encryption.Driver and the options of the driver first")
+	}
+	if err := podcasts.CheckDEKExists(ctx, db, cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	enabled, err := encryption.Enable(cfg)
+	if err != nil {
+		log.Fatalf("failed to enable encryption: %v", err)
+	}
+	result := enableResult{EnableResult: enabled, Features: features(cfg)}
+
+	if cfg.Encryption.EncryptMedia {
+		encService, err := encryption.ConfigureEncryptedValueService(cfg)
+		if err != nil {
+			log.Fatalf("failed to configure encryption: %v", err)
+		}
+		objstore, err := objectstorage.ConfigureObjectStorage(ctx, cfg, encService)
+		if err != nil {
+			log.Fatalf("failed to configure objectstorage: %v", err)
+		}
+		media, err := objectstorage.EncryptExistingFiles(ctx, objstore)
+		if err != nil {
+			log.Fatalf("failed to encrypt existing files: %v", err)
+		}
+		result.Media = &media
+	}
+
+	err = cli.PrintResult(result, func() {
+		fmt.Printf("encryption driver: %s\n", result.Driver)
+		fmt.Printf("data encryption key: %s\n", createdOrLoaded(result.DEKCreated))
+		if cfg.Encryption.EncryptMedia {
+			fmt.Printf("media key: %s\n", createdOrLoaded(result.MediaKeyCreated))
+		}
+		if result.Media != nil {
+			for _, problem := range result.Media.Problems {
+				fmt.Printf("failed\t%s\t%s\n", problem.Path, problem.Problem)
+			}
+			fmt.Printf(
+				"encrypted %d existing files (%d bytes), failed %d\n",
+				result.Media.Encrypted, result.Media.EncryptedBytes, result.Media.Failed,
+			)
+		}
+		for _, f := range result.Features {
+			status := "available"
+			if !f.Available {
+				status = "unavailable"
+			}
+			if f.Note != "" {
+				fmt.Printf("%s\t%s\t%s\n", status, f.Name, f.Note)
+			} else {
+				fmt.Printf("%s\t%s\n", status, f.Name)
+			}
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// features describes which encryption features can be used with cfg
+func features(cfg config.Config) []feature {
+	features := []feature{
+		{Name: "password protected feeds", Available: true},
+		{Name: "media encryption", Available: cfg.Encryption.EncryptMedia},
+		{Name: "data encryption key rotation (rotate-dek)", Available: true},
+		{Name: "secret key rotation (rotate-kek)", Available: cfg.Encryption.Driver == config.EncryptionDriverSecretKey},
+		{Name: "migrating between encryption drivers (migrate-kek)", Available: true},
+	}
+	if !cfg.Encryption.EncryptMedia {
+		features[1].Note = "set Encryption.EncryptMedia to true and run this command again to enable"
+	}
+	if cfg.Encryption.Driver != config.EncryptionDriverSecretKey {
+		features[3].Note = fmt.Sprintf("the '%s' driver has no secret key, use migrate-kek to change its key", cfg.Encryption.Driver)
+	}
+	return features
+}
+
+func createdOrLoaded(created bool) string {
+	if created {
+		return "created"
+	}
+	return "already exists"
+}
@@ -0,0 +1,68 @@
+package exportpodcast
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcastarchive"
+	"github.com/webbgeorge/castkeeper/pkg/util"
+)
+
+var ExportPodcastCmd = &cobra.Command{
+	Use:   "export <podcast-guid>",
+	Short: "Export a CastKeeper podcast as a zip archive",
+	Long: "Utility script for exporting a podcast, its downloaded episodes and artwork as a zip archive for the " +
+		"given CastKeeper configuration. The archive includes a feed linking to the episode files and a manifest " +
+		"of the podcast's metadata, and can be restored into another instance with the import command.",
+	Args: cobra.ExactArgs(1),
+	Run:  run,
+}
+
+var output string
+
+func init() {
+	cli.InitGlobalFlags(ExportPodcastCmd)
+	ExportPodcastCmd.Flags().StringVarP(&output, "output", "o", "", "file to write the archive to (default '<podcast-guid>.zip')")
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, cfg, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encService, err := encryption.ConfigureEncryptedValueService(cfg)
+	if err != nil {
+		log.Fatalf("failed to configure encryption: %v", err)
+	}
+
+	objstore, err := objectstorage.ConfigureObjectStorage(ctx, cfg, encService)
+	if err != nil {
+		log.Fatalf("failed to configure objectstorage: %v", err)
+	}
+
+	if output == "" {
+		output = fmt.Sprintf("%s.zip", util.SanitiseGUID(args[0]))
+	}
+	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0640)
+	if err != nil {
+		log.Fatalf("failed to create archive: %v", err)
+	}
+	defer f.Close()
+
+	err = podcastarchive.Export(ctx, db, objstore, args[0], f)
+	if err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		_ = os.Remove(output)
+		log.Fatalf("failed to export podcast: %v", err)
+	}
+
+	log.Printf("successfully exported podcast '%s' to '%s'", args[0], output)
+}
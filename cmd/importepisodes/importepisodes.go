@@ -0,0 +1,72 @@
+package importepisodes
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/fileimport"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+)
+
+var ImportEpisodesCmd = &cobra.Command{
+	Use:   "import <podcast-guid> <path...>",
+	Short: "Import local media files as episodes of a CastKeeper podcast",
+	Long: "Utility script for importing previously downloaded media files into a podcast for the given CastKeeper " +
+		"configuration, without downloading them again. Directories are searched recursively. Each file is matched " +
+		"to an episode by its file name, the title in its metadata tags, or a date (YYYY-MM-DD) in its file name. " +
+		"Files which don't match an episode are added as new episodes, unless --skip-unmatched is set.",
+	Args: cobra.MinimumNArgs(2),
+	Run:  run,
+}
+
+var skipUnmatched bool
+
+func init() {
+	cli.InitGlobalFlags(ImportEpisodesCmd)
+	cli.InitJSONFlag(ImportEpisodesCmd)
+	ImportEpisodesCmd.Flags().BoolVar(&skipUnmatched, "skip-unmatched", false, "skip files which don't match an existing episode, instead of adding them as new episodes")
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, cfg, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encService, err := encryption.ConfigureEncryptedValueService(cfg)
+	if err != nil {
+		log.Fatalf("failed to configure encryption: %v", err)
+	}
+
+	objstore, err := objectstorage.ConfigureObjectStorage(ctx, cfg, encService)
+	if err != nil {
+		log.Fatalf("failed to configure objectstorage: %v", err)
+	}
+
+	results, err := fileimport.ImportFiles(ctx, db, objstore, args[0], args[1:], fileimport.Options{
+		CreateUnmatched: !skipUnmatched,
+	})
+	if err != nil {
+		log.Fatalf("failed to import files: %v", err)
+	}
+
+	err = cli.PrintResult(results, func() {
+		if len(results) == 0 {
+			fmt.Println("No files found")
+			return
+		}
+		for _, r := range results {
+			if r.Skipped != "" {
+				fmt.Printf("%s\tskipped: %s\n", r.Path, r.Skipped)
+				continue
+			}
+			fmt.Printf("%s\t%s\t%s\n", r.Path, r.MatchedBy, r.EpisodeGUID)
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
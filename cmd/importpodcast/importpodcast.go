@@ -0,0 +1,60 @@
+package importpodcast
+
+import (
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcastarchive"
+)
+
+var ImportPodcastCmd = &cobra.Command{
+	Use:   "import <archive-file>",
+	Short: "Import a CastKeeper podcast from a zip archive",
+	Long: "Utility script for restoring a podcast from an archive created by the export command, for the given " +
+		"CastKeeper configuration. Password protected feeds must be added to the podcast again, as credentials " +
+		"are not included in archives.",
+	Args: cobra.ExactArgs(1),
+	Run:  run,
+}
+
+func init() {
+	cli.InitGlobalFlags(ImportPodcastCmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, cfg, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encService, err := encryption.ConfigureEncryptedValueService(cfg)
+	if err != nil {
+		log.Fatalf("failed to configure encryption: %v", err)
+	}
+
+	objstore, err := objectstorage.ConfigureObjectStorage(ctx, cfg, encService)
+	if err != nil {
+		log.Fatalf("failed to configure objectstorage: %v", err)
+	}
+
+	f, err := os.Open(args[0])
+	if err != nil {
+		log.Fatalf("failed to open archive: %v", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		log.Fatalf("failed to open archive: %v", err)
+	}
+
+	pod, err := podcastarchive.Import(ctx, db, objstore, f, info.Size())
+	if err != nil {
+		log.Fatalf("failed to import podcast: %v", err)
+	}
+
+	log.Printf("successfully imported podcast '%s' (%s)", pod.Title, pod.GUID)
+}
@@ -0,0 +1,63 @@
+package listepisodes
+
+import (
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+)
+
+var ListEpisodesCmd = &cobra.Command{
+	Use:   "list <podcast-guid>",
+	Short: "List the episodes of a CastKeeper podcast",
+	Long:  "Utility script for listing the episodes of a podcast from the database for the given CastKeeper configuration.",
+	Args:  cobra.ExactArgs(1),
+	Run:   run,
+}
+
+var status string
+
+func init() {
+	cli.InitGlobalFlags(ListEpisodesCmd)
+	cli.InitJSONFlag(ListEpisodesCmd)
+	ListEpisodesCmd.Flags().StringVar(&status, "status", "", "only list episodes with this status (pending, in-progress, success, failed, paused)")
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, _, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pod, err := podcasts.GetPodcast(ctx, db, args[0])
+	if err != nil {
+		log.Fatalf("failed to get podcast: %v", err)
+	}
+
+	eps, err := podcasts.ListEpisodes(ctx, db, pod.GUID)
+	if err != nil {
+		log.Fatalf("failed to list episodes: %v", err)
+	}
+
+	if status != "" {
+		eps = slices.DeleteFunc(eps, func(ep podcasts.Episode) bool {
+			return ep.Status != status
+		})
+	}
+
+	err = cli.PrintResult(eps, func() {
+		if len(eps) == 0 {
+			fmt.Println("No episodes found")
+			return
+		}
+		for _, ep := range eps {
+			fmt.Printf("%s\t%s\t%s\t%s\n", ep.GUID, ep.PublishedAt.Format("2006-01-02"), ep.Status, ep.Title)
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,47 @@
+package listpodcasts
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+)
+
+var ListPodcastsCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List CastKeeper podcasts",
+	Long:  "Utility script for listing podcasts from the database for the given CastKeeper configuration.",
+	Run:   run,
+}
+
+func init() {
+	cli.InitGlobalFlags(ListPodcastsCmd)
+	cli.InitJSONFlag(ListPodcastsCmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, _, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pods, err := podcasts.ListPodcasts(ctx, db)
+	if err != nil {
+		log.Fatalf("failed to list podcasts: %v", err)
+	}
+
+	err = cli.PrintResult(pods, func() {
+		if len(pods) == 0 {
+			fmt.Println("No podcasts found")
+			return
+		}
+		for _, pod := range pods {
+			fmt.Printf("%s\t%s\n", pod.GUID, pod.Title)
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
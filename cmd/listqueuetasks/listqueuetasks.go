@@ -0,0 +1,77 @@
+package listqueuetasks
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+)
+
+var ListQueueTasksCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List CastKeeper queue tasks",
+	Long:  "Utility script for listing the tasks in the queues of the database for the given CastKeeper configuration.",
+	Args:  cobra.NoArgs,
+	Run:   run,
+}
+
+var (
+	queueName string
+	state     string
+)
+
+func init() {
+	cli.InitGlobalFlags(ListQueueTasksCmd)
+	cli.InitJSONFlag(ListQueueTasksCmd)
+	ListQueueTasksCmd.Flags().StringVar(&queueName, "queue", "", "only list tasks in this queue")
+	ListQueueTasksCmd.Flags().StringVar(&state, "state", "", "only list tasks in this state (ready, in-flight, stale)")
+}
+
+type queueTaskDetails struct {
+	framework.QueueTask
+	State string
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, _, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tasks, err := framework.ListQueueTasks(ctx, db, framework.QueueTaskFilter{
+		QueueName: queueName,
+		State:     state,
+	})
+	if err != nil {
+		log.Fatalf("failed to list queue tasks: %v", err)
+	}
+
+	details := make([]queueTaskDetails, 0, len(tasks))
+	for _, t := range tasks {
+		details = append(details, queueTaskDetails{QueueTask: t, State: t.State()})
+	}
+
+	err = cli.PrintResult(details, func() {
+		if len(details) == 0 {
+			fmt.Println("No queue tasks found")
+			return
+		}
+		for _, t := range details {
+			fmt.Printf(
+				"%d\t%s\t%s\treceives=%d\tvisible_after=%s\tdata=%v\n",
+				t.ID,
+				t.QueueName,
+				t.State,
+				t.ReceiveCount,
+				t.VisibleAfter.Format(time.RFC3339),
+				t.Data,
+			)
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
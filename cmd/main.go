@@ -5,12 +5,37 @@ import (
 	"os"
 
 	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/cmd/addpodcast"
 	"github.com/webbgeorge/castkeeper/cmd/changepassword"
 	"github.com/webbgeorge/castkeeper/cmd/createuser"
+	"github.com/webbgeorge/castkeeper/cmd/dedupestorage"
+	"github.com/webbgeorge/castkeeper/cmd/deleteepisode"
 	"github.com/webbgeorge/castkeeper/cmd/deleteuser"
 	"github.com/webbgeorge/castkeeper/cmd/edituser"
+	"github.com/webbgeorge/castkeeper/cmd/enableencryption"
+	"github.com/webbgeorge/castkeeper/cmd/exportpodcast"
+	"github.com/webbgeorge/castkeeper/cmd/importepisodes"
+	"github.com/webbgeorge/castkeeper/cmd/importpodcast"
+	"github.com/webbgeorge/castkeeper/cmd/listepisodes"
+	"github.com/webbgeorge/castkeeper/cmd/listpodcasts"
+	"github.com/webbgeorge/castkeeper/cmd/listqueuetasks"
 	"github.com/webbgeorge/castkeeper/cmd/listusers"
+	"github.com/webbgeorge/castkeeper/cmd/migratekek"
+	"github.com/webbgeorge/castkeeper/cmd/migratestorage"
+	"github.com/webbgeorge/castkeeper/cmd/mirrorpodcasts"
+	"github.com/webbgeorge/castkeeper/cmd/purgequeuetasks"
+	"github.com/webbgeorge/castkeeper/cmd/queuestats"
+	"github.com/webbgeorge/castkeeper/cmd/reconcilestorage"
+	"github.com/webbgeorge/castkeeper/cmd/refreshpodcast"
+	"github.com/webbgeorge/castkeeper/cmd/removepodcast"
+	"github.com/webbgeorge/castkeeper/cmd/requeueepisodes"
+	"github.com/webbgeorge/castkeeper/cmd/retryqueuetasks"
+	"github.com/webbgeorge/castkeeper/cmd/rotatedek"
+	"github.com/webbgeorge/castkeeper/cmd/rotatekek"
 	"github.com/webbgeorge/castkeeper/cmd/serve"
+	"github.com/webbgeorge/castkeeper/cmd/setpodcastquota"
+	"github.com/webbgeorge/castkeeper/cmd/showpodcast"
+	"github.com/webbgeorge/castkeeper/cmd/storageusage"
 	"github.com/webbgeorge/castkeeper/cmd/version"
 )
 
@@ -22,10 +47,50 @@ func main() {
 	userRootCmd.AddCommand(edituser.EditUserCmd)
 	userRootCmd.AddCommand(deleteuser.DeleteUserCmd)
 
+	podcastRootCmd := &cobra.Command{Use: "podcasts"}
+	podcastRootCmd.AddCommand(listpodcasts.ListPodcastsCmd)
+	podcastRootCmd.AddCommand(showpodcast.ShowPodcastCmd)
+	podcastRootCmd.AddCommand(addpodcast.AddPodcastCmd)
+	podcastRootCmd.AddCommand(refreshpodcast.RefreshPodcastCmd)
+	podcastRootCmd.AddCommand(removepodcast.RemovePodcastCmd)
+	podcastRootCmd.AddCommand(mirrorpodcasts.MirrorPodcastsCmd)
+	podcastRootCmd.AddCommand(exportpodcast.ExportPodcastCmd)
+	podcastRootCmd.AddCommand(importpodcast.ImportPodcastCmd)
+	podcastRootCmd.AddCommand(setpodcastquota.SetPodcastQuotaCmd)
+
+	episodeRootCmd := &cobra.Command{Use: "episodes"}
+	episodeRootCmd.AddCommand(listepisodes.ListEpisodesCmd)
+	episodeRootCmd.AddCommand(requeueepisodes.RequeueEpisodesCmd)
+	episodeRootCmd.AddCommand(deleteepisode.DeleteEpisodeCmd)
+	episodeRootCmd.AddCommand(importepisodes.ImportEpisodesCmd)
+
+	queueRootCmd := &cobra.Command{Use: "queue"}
+	queueRootCmd.AddCommand(listqueuetasks.ListQueueTasksCmd)
+	queueRootCmd.AddCommand(queuestats.QueueStatsCmd)
+	queueRootCmd.AddCommand(retryqueuetasks.RetryQueueTasksCmd)
+	queueRootCmd.AddCommand(purgequeuetasks.PurgeQueueTasksCmd)
+
+	storageRootCmd := &cobra.Command{Use: "storage"}
+	storageRootCmd.AddCommand(reconcilestorage.ReconcileStorageCmd)
+	storageRootCmd.AddCommand(migratestorage.MigrateStorageCmd)
+	storageRootCmd.AddCommand(storageusage.StorageUsageCmd)
+	storageRootCmd.AddCommand(dedupestorage.DedupeStorageCmd)
+
+	encryptionRootCmd := &cobra.Command{Use: "encryption"}
+	encryptionRootCmd.AddCommand(enableencryption.EnableEncryptionCmd)
+	encryptionRootCmd.AddCommand(rotatekek.RotateKEKCmd)
+	encryptionRootCmd.AddCommand(rotatedek.RotateDEKCmd)
+	encryptionRootCmd.AddCommand(migratekek.MigrateKEKCmd)
+
 	rootCmd := &cobra.Command{Use: "castkeeper"}
 	rootCmd.AddCommand(
 		serve.ServeCmd,
 		userRootCmd,
+		podcastRootCmd,
+		episodeRootCmd,
+		queueRootCmd,
+		storageRootCmd,
+		encryptionRootCmd,
 		version.VersionCmd,
 	)
 
@@ -0,0 +1,58 @@
+package migratekek
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+)
+
+var MigrateKEKCmd = &cobra.Command{
+	Use:   "migrate-kek --from-config <config-file> --to-config <config-file>",
+	Short: "Re-encrypt the data encryption key with the key of a different encryption driver",
+	Long: "Utility script for moving to a different encryption driver, e.g. from 'secretkey' to 'awskms'. The data " +
+		"encryption key is decrypted with the encryption config of --from-config and re-encrypted with that of " +
+		"--to-config, which is checked to work first. Values encrypted with the data encryption key are not changed. " +
+		"Both configs must have the same DataPath. CastKeeper should be stopped while this is run, and started with " +
+		"the encryption config of --to-config afterwards.",
+	Args: cobra.NoArgs,
+	Run:  run,
+}
+
+var (
+	fromConfig string
+	toConfig   string
+)
+
+func init() {
+	cli.InitVerboseFlag(MigrateKEKCmd)
+	MigrateKEKCmd.Flags().StringVar(&fromConfig, "from-config", "", "config file of the encryption driver to migrate from")
+	MigrateKEKCmd.Flags().StringVar(&toConfig, "to-config", "", "config file of the encryption driver to migrate to")
+	_ = MigrateKEKCmd.MarkFlagRequired("from-config")
+	_ = MigrateKEKCmd.MarkFlagRequired("to-config")
+}
+
+func run(cmd *cobra.Command, args []string) {
+	// sets the log level of the configs, which respects --verbose
+	cli.ConfigureCLIContext()
+
+	fromCfg, _, err := config.LoadConfig(fromConfig)
+	if err != nil {
+		log.Fatalf("failed to read config '%s': %v", fromConfig, err)
+	}
+	toCfg, _, err := config.LoadConfig(toConfig)
+	if err != nil {
+		log.Fatalf("failed to read config '%s': %v", toConfig, err)
+	}
+
+	if err := encryption.MigrateKEK(fromCfg, toCfg); err != nil {
+		log.Fatalf("failed to migrate KEK: %v", err)
+	}
+
+	log.Printf(
+		"successfully migrated KEK from the '%s' driver to the '%s' driver, use the encryption config of '%s' before starting CastKeeper",
+		fromCfg.Encryption.Driver, toCfg.Encryption.Driver, toConfig,
+	)
+}
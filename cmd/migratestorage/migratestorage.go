@@ -0,0 +1,105 @@
+package migratestorage
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+)
+
+var MigrateStorageCmd = &cobra.Command{
+	Use:   "migrate --from-config <config-file> --to-config <config-file>",
+	Short: "Copy all files from one object storage to another",
+	Long: "Utility script for moving to a different object storage driver, e.g. from 'local' to 'awss3'. Every " +
+		"file in the object storage of --from-config is copied to the object storage of --to-config, and read back " +
+		"to check it matches. Files already copied are skipped, so an interrupted migration can be resumed by " +
+		"running it again. Use --verify to check every file has been copied before switching to the new config.",
+	Args: cobra.NoArgs,
+	Run:  run,
+}
+
+var (
+	fromConfig string
+	toConfig   string
+	verify     bool
+)
+
+func init() {
+	cli.InitVerboseFlag(MigrateStorageCmd)
+	cli.InitJSONFlag(MigrateStorageCmd)
+	MigrateStorageCmd.Flags().StringVar(&fromConfig, "from-config", "", "config file of the object storage to copy from")
+	MigrateStorageCmd.Flags().StringVar(&toConfig, "to-config", "", "config file of the object storage to copy to")
+	MigrateStorageCmd.Flags().BoolVar(&verify, "verify", false, "compare every file with the destination by size and hash, without copying anything")
+	_ = MigrateStorageCmd.MarkFlagRequired("from-config")
+	_ = MigrateStorageCmd.MarkFlagRequired("to-config")
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx := cli.ConfigureCLIContext()
+
+	fromCfg, _, err := config.LoadConfig(fromConfig)
+	if err != nil {
+		log.Fatalf("failed to read config '%s': %v", fromConfig, err)
+	}
+	toCfg, _, err := config.LoadConfig(toConfig)
+	if err != nil {
+		log.Fatalf("failed to read config '%s': %v", toConfig, err)
+	}
+
+	fromEncService, err := encryption.ConfigureEncryptedValueService(fromCfg)
+	if err != nil {
+		log.Fatalf("failed to configure encryption of '%s': %v", fromConfig, err)
+	}
+	toEncService, err := encryption.ConfigureEncryptedValueService(toCfg)
+	if err != nil {
+		log.Fatalf("failed to configure encryption of '%s': %v", toConfig, err)
+	}
+
+	from, err := objectstorage.ConfigureObjectStorage(ctx, fromCfg, fromEncService)
+	if err != nil {
+		log.Fatalf("failed to configure objectstorage of '%s': %v", fromConfig, err)
+	}
+	to, err := objectstorage.ConfigureObjectStorage(ctx, toCfg, toEncService)
+	if err != nil {
+		log.Fatalf("failed to configure objectstorage of '%s': %v", toConfig, err)
+	}
+
+	result, err := objectstorage.Migrate(ctx, from, to, objectstorage.MigrateOptions{
+		VerifyOnly: verify,
+		// progress goes to stderr, to keep stdout clean for JSON output
+		Progress: func(p objectstorage.MigrateProgress) {
+			if p.Err != nil {
+				fmt.Fprintf(os.Stderr, "[%d/%d] %s %s: %v\n", p.Done, p.Total, p.Action, p.Object.Path(), p.Err)
+				return
+			}
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s %s\n", p.Done, p.Total, p.Action, p.Object.Path())
+		},
+	})
+	if err != nil {
+		log.Fatalf("failed to migrate storage: %v", err)
+	}
+
+	err = cli.PrintResult(result, func() {
+		if verify {
+			fmt.Printf("verified %d, failed %d\n", result.Verified, result.Failed)
+		} else {
+			fmt.Printf("copied %d (%d bytes), already present %d, failed %d\n",
+				result.Copied, result.CopiedBytes, result.AlreadyPresent, result.Failed)
+		}
+		for _, problem := range result.Problems {
+			fmt.Printf("failed\t%s\t%s\n", problem.Path, problem.Problem)
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if result.Failed > 0 {
+		os.Exit(1)
+	}
+}
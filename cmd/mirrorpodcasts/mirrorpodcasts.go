@@ -0,0 +1,68 @@
+package mirrorpodcasts
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/mirror"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+)
+
+var MirrorPodcastsCmd = &cobra.Command{
+	Use:   "mirror <directory>",
+	Short: "Copy downloaded episodes into a human readable directory layout",
+	Long: "Utility script for copying downloaded episodes into a directory laid out as " +
+		"'Podcast Title/YYYY-MM-DD - Episode Title.ext', with each podcast's artwork as folder.jpg, " +
+		"for the given CastKeeper configuration. Files which are already up to date are skipped, so it can " +
+		"be run repeatedly to keep the directory in sync, e.g. for indexing by Plex or Jellyfin.",
+	Args: cobra.ExactArgs(1),
+	Run:  run,
+}
+
+var (
+	nfo   bool
+	prune bool
+)
+
+func init() {
+	cli.InitGlobalFlags(MirrorPodcastsCmd)
+	cli.InitJSONFlag(MirrorPodcastsCmd)
+	MirrorPodcastsCmd.Flags().BoolVar(&nfo, "nfo", false, "write NFO files describing each podcast and episode, for media servers")
+	MirrorPodcastsCmd.Flags().BoolVar(&prune, "prune", false, "remove files from the directory which aren't part of the mirror (use with care)")
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, cfg, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encService, err := encryption.ConfigureEncryptedValueService(cfg)
+	if err != nil {
+		log.Fatalf("failed to configure encryption: %v", err)
+	}
+
+	objstore, err := objectstorage.ConfigureObjectStorage(ctx, cfg, encService)
+	if err != nil {
+		log.Fatalf("failed to configure objectstorage: %v", err)
+	}
+
+	result, err := mirror.Mirror(ctx, db, objstore, args[0], mirror.Options{
+		NFO:   nfo,
+		Prune: prune,
+	})
+	if err != nil {
+		log.Fatalf("failed to mirror podcasts: %v", err)
+	}
+
+	err = cli.PrintResult(result, func() {
+		fmt.Printf("copied %d, unchanged %d, removed %d, failed %d\n",
+			result.Copied, result.Unchanged, result.Removed, result.Failed)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
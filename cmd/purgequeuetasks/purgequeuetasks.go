@@ -0,0 +1,73 @@
+package purgequeuetasks
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+)
+
+var PurgeQueueTasksCmd = &cobra.Command{
+	Use:   "purge [task-id...]",
+	Short: "Delete CastKeeper queue tasks",
+	Long:  "Utility script for deleting queue tasks, e.g. stale tasks which will not be processed again. Tasks are selected by ID, or by the --queue and --state flags.",
+	Args:  validateArgs,
+	Run:   run,
+}
+
+var (
+	queueName string
+	state     string
+)
+
+func init() {
+	cli.InitGlobalFlags(PurgeQueueTasksCmd)
+	PurgeQueueTasksCmd.Flags().StringVar(&queueName, "queue", "", "only delete tasks in this queue")
+	PurgeQueueTasksCmd.Flags().StringVar(&state, "state", "", "only delete tasks in this state (ready, in-flight, stale)")
+}
+
+func validateArgs(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 && queueName == "" && state == "" {
+		return errors.New("task IDs, --queue or --state must be provided")
+	}
+	return nil
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, _, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ids, err := parseIDs(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	n, err := framework.DeleteQueueTasks(ctx, db, framework.QueueTaskFilter{
+		QueueName: queueName,
+		IDs:       ids,
+		State:     state,
+	})
+	if err != nil {
+		log.Fatalf("failed to delete queue tasks: %v", err)
+	}
+
+	log.Printf("successfully deleted %d queue tasks", n)
+}
+
+func parseIDs(args []string) ([]uint, error) {
+	ids := make([]uint, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid task ID '%s'", arg)
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
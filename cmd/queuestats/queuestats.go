@@ -0,0 +1,49 @@
+package queuestats
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+)
+
+var QueueStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show statistics for CastKeeper queues",
+	Long:  "Utility script for showing the number of ready, in-flight and stale tasks in each queue of the database for the given CastKeeper configuration.",
+	Args:  cobra.NoArgs,
+	Run:   run,
+}
+
+func init() {
+	cli.InitGlobalFlags(QueueStatsCmd)
+	cli.InitJSONFlag(QueueStatsCmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, _, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stats, err := framework.GetQueueStats(ctx, db)
+	if err != nil {
+		log.Fatalf("failed to get queue stats: %v", err)
+	}
+
+	err = cli.PrintResult(stats, func() {
+		if len(stats) == 0 {
+			fmt.Println("All queues are empty")
+			return
+		}
+		fmt.Println("QUEUE\tTOTAL\tREADY\tIN-FLIGHT\tSTALE")
+		for _, s := range stats {
+			fmt.Printf("%s\t%d\t%d\t%d\t%d\n", s.QueueName, s.Total, s.Ready, s.InFlight, s.Stale)
+		}
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,76 @@
+package reconcilestorage
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/reconcile"
+)
+
+var ReconcileStorageCmd = &cobra.Command{
+	Use:   "reconcile",
+	Short: "Find orphaned and missing files in object storage",
+	Long: "Utility script for comparing the files in object storage with the podcasts and episodes in the " +
+		"database. Orphaned files, e.g. of deleted podcasts and episodes, are deleted, and downloaded episodes " +
+		"whose files are missing are queued to be downloaded again. Use --dry-run to only report what would be done.",
+	Args: cobra.NoArgs,
+	Run:  run,
+}
+
+var dryRun bool
+
+func init() {
+	cli.InitGlobalFlags(ReconcileStorageCmd)
+	cli.InitJSONFlag(ReconcileStorageCmd)
+	ReconcileStorageCmd.Flags().BoolVar(&dryRun, "dry-run", false, "report orphaned and missing files without deleting or requeueing anything")
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, cfg, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	encService, err := encryption.ConfigureEncryptedValueService(cfg)
+	if err != nil {
+		log.Fatalf("failed to configure encryption: %v", err)
+	}
+
+	objstore, err := objectstorage.ConfigureObjectStorage(ctx, cfg, encService)
+	if err != nil {
+		log.Fatalf("failed to configure objectstorage: %v", err)
+	}
+
+	result, err := reconcile.Reconcile(ctx, db, objstore, reconcile.Options{
+		DeleteOrphans:  !dryRun,
+		RequeueMissing: !dryRun,
+	})
+	if err != nil {
+		log.Fatalf("failed to reconcile storage: %v", err)
+	}
+
+	err = cli.PrintResult(result, func() {
+		for _, orphan := range result.Orphans {
+			action := "orphaned"
+			if orphan.Deleted {
+				action = "deleted"
+			}
+			fmt.Printf("%s\t%s\t%d bytes\t%s\n", action, orphan.Path(), orphan.Bytes, orphan.Reason)
+		}
+		for _, missing := range result.Missing {
+			action := "missing"
+			if missing.Requeued {
+				action = "requeued"
+			}
+			fmt.Printf("%s\t%s/%s\tepisode %s\n", action, missing.Dir, missing.FileName, missing.EpisodeGUID)
+		}
+		fmt.Printf("orphaned %d, missing %d, failed %d\n", len(result.Orphans), len(result.Missing), result.Failed)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,75 @@
+package refreshpodcast
+
+import (
+	"log"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/feedworker"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+)
+
+var RefreshPodcastCmd = &cobra.Command{
+	Use:   "refresh [podcast-guid...]",
+	Short: "Check CastKeeper podcasts for new episodes",
+	Long: "Utility script for checking the feeds of podcasts for new episodes, which are queued for download by the CastKeeper server. " +
+		"All podcasts are checked if no podcast GUIDs are given.",
+	Run: run,
+}
+
+func init() {
+	cli.InitGlobalFlags(RefreshPodcastCmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, cfg, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := podcasts.CheckDEKExists(ctx, db, cfg); err != nil {
+		log.Fatal(err)
+	}
+
+	encService, err := encryption.ConfigureEncryptedValueService(cfg)
+	if err != nil {
+		log.Fatalf("failed to configure encryption: %v", err)
+	}
+
+	feedService := &podcasts.FeedService{
+		HTTPClient: framework.NewHTTPClient(time.Second * 30),
+	}
+
+	pods := make([]podcasts.Podcast, 0)
+	if len(args) == 0 {
+		pods, err = podcasts.ListPodcasts(ctx, db)
+		if err != nil {
+			log.Fatalf("failed to list podcasts: %v", err)
+		}
+	}
+	for _, guid := range args {
+		pod, err := podcasts.GetPodcast(ctx, db, guid)
+		if err != nil {
+			log.Fatalf("failed to get podcast '%s': %v", guid, err)
+		}
+		pods = append(pods, pod)
+	}
+
+	failed := false
+	for _, pod := range pods {
+		err = feedworker.RefreshPodcast(ctx, db, feedService, encService, pod)
+		if err != nil {
+			log.Printf("failed to refresh podcast '%s': %v", pod.GUID, err)
+			failed = true
+			continue
+		}
+		log.Printf("successfully refreshed podcast '%s'", pod.GUID)
+	}
+
+	if failed {
+		log.Fatal("some podcasts failed to refresh")
+	}
+}
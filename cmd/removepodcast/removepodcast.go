@@ -0,0 +1,36 @@
+package removepodcast
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+)
+
+var RemovePodcastCmd = &cobra.Command{
+	Use:   "remove <podcast-guid>",
+	Short: "Remove a CastKeeper podcast",
+	Long: "Utility script for removing a podcast and its episodes from the database for the given CastKeeper configuration. " +
+		"Downloaded files are not deleted from object storage.",
+	Args: cobra.ExactArgs(1),
+	Run:  run,
+}
+
+func init() {
+	cli.InitGlobalFlags(RemovePodcastCmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, _, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = podcasts.DeletePodcast(ctx, db, args[0])
+	if err != nil {
+		log.Fatalf("failed to remove podcast: %v", err)
+	}
+
+	log.Printf("successfully removed podcast '%s'", args[0])
+}
@@ -0,0 +1,43 @@
+package requeueepisodes
+
+import (
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/downloadworker"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+)
+
+var RequeueEpisodesCmd = &cobra.Command{
+	Use:   "requeue <episode-guid...>",
+	Short: "Requeue the download of CastKeeper episodes",
+	Long:  "Utility script for queueing episodes to be downloaded again by the CastKeeper server, e.g. after their download failed.",
+	Args:  cobra.MinimumNArgs(1),
+	Run:   run,
+}
+
+func init() {
+	cli.InitGlobalFlags(RequeueEpisodesCmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, _, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, guid := range args {
+		ep, err := podcasts.GetEpisode(ctx, db, guid)
+		if err != nil {
+			log.Fatalf("failed to get episode '%s': %v", guid, err)
+		}
+
+		err = downloadworker.RequeueDownload(ctx, db, &ep)
+		if err != nil {
+			log.Fatalf("failed to requeue episode '%s': %v", guid, err)
+		}
+
+		log.Printf("successfully requeued episode '%s'", guid)
+	}
+}
@@ -0,0 +1,78 @@
+package retryqueuetasks
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+)
+
+var RetryQueueTasksCmd = &cobra.Command{
+	Use:   "retry [task-id...]",
+	Short: "Retry CastKeeper queue tasks",
+	Long:  "Utility script for making queue tasks immediately available to the CastKeeper server again, resetting their receive count. Tasks are selected by ID, or by the --queue and --state flags. In-flight tasks are skipped unless --force is set, as they may still be being processed.",
+	Args:  validateArgs,
+	Run:   run,
+}
+
+var (
+	queueName string
+	state     string
+	force     bool
+)
+
+func init() {
+	cli.InitGlobalFlags(RetryQueueTasksCmd)
+	RetryQueueTasksCmd.Flags().StringVar(&queueName, "queue", "", "only retry tasks in this queue")
+	RetryQueueTasksCmd.Flags().StringVar(&state, "state", "", "only retry tasks in this state (ready, in-flight, stale)")
+	RetryQueueTasksCmd.Flags().BoolVar(&force, "force", false, "also retry in-flight tasks, which may still be being processed")
+}
+
+func validateArgs(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 && queueName == "" && state == "" {
+		return errors.New("task IDs, --queue or --state must be provided")
+	}
+	return nil
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, _, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ids, err := parseIDs(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	n, err := framework.RetryQueueTasks(ctx, db, framework.QueueTaskFilter{
+		QueueName: queueName,
+		IDs:       ids,
+		State:     state,
+	}, force)
+	if err != nil {
+		if errors.Is(err, framework.ErrQueueTasksInFlight) {
+			log.Fatal("in-flight tasks may still be being processed, use --force to retry them anyway")
+		}
+		log.Fatalf("failed to retry queue tasks: %v", err)
+	}
+
+	log.Printf("successfully retried %d queue tasks", n)
+}
+
+func parseIDs(args []string) ([]uint, error) {
+	ids := make([]uint, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 0)
+		if err != nil {
+			return nil, fmt.Errorf("invalid task ID '%s'", arg)
+		}
+		ids = append(ids, uint(id))
+	}
+	return ids, nil
+}
@@ -0,0 +1,71 @@
+package rotatedek
+
+import (
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+)
+
+var RotateDEKCmd = &cobra.Command{
+	Use:   "rotate-dek",
+	Short: "Add a new data encryption key and re-encrypt credentials with it",
+	Long: "Utility script for rotating the data encryption key. A new key is added to the data encryption key and " +
+		"used to re-encrypt the credentials of every podcast in a single transaction. The old keys are only removed " +
+		"once every value is re-encrypted, so it is safe to run again if it fails. CastKeeper must be stopped " +
+		"while this is run, as a running server would keep encrypting values with the old keys.",
+	Args: cobra.NoArgs,
+	Run:  run,
+}
+
+type rotateResult struct {
+	Reencrypted int
+}
+
+func init() {
+	cli.InitGlobalFlags(RotateDEKCmd)
+	cli.InitJSONFlag(RotateDEKCmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, cfg, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	lock, err := config.LockDataPath(cfg)
+	if errors.Is(err, config.ErrDataPathLocked) {
+		log.Fatal("CastKeeper is running, stop it before rotating the data encryption key")
+	}
+	if err != nil {
+		log.Fatalf("failed to lock DataPath: %v", err)
+	}
+	defer lock.Close()
+
+	encService, err := encryption.AddDEKPrimaryKey(cfg)
+	if err != nil {
+		log.Fatalf("failed to add data encryption key: %v", err)
+	}
+
+	count, err := podcasts.ReencryptCredentials(ctx, db, encService)
+	if err != nil {
+		log.Fatalf("failed to re-encrypt credentials: %v", err)
+	}
+
+	if err := encryption.RemoveOldDEKKeys(cfg); err != nil {
+		log.Fatalf("failed to remove old data encryption keys: %v", err)
+	}
+
+	result := rotateResult{Reencrypted: count}
+	err = cli.PrintResult(result, func() {
+		fmt.Printf("rotated data encryption key, re-encrypted %d credentials\n", result.Reencrypted)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,61 @@
+package rotatekek
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"golang.org/x/term"
+)
+
+var RotateKEKCmd = &cobra.Command{
+	Use:   "rotate-kek",
+	Short: "Re-encrypt the data encryption key with a new secret key",
+	Long: "Utility script for changing the secret key which the data encryption key is encrypted with. Values " +
+		"encrypted with the data encryption key are not changed. Encryption.SecretKey must be changed to the new " +
+		"secret key before CastKeeper is next started. CastKeeper should be stopped while this is run.",
+	Args: cobra.NoArgs,
+	Run:  run,
+}
+
+var newSecretKey string
+
+func init() {
+	cli.InitGlobalFlags(RotateKEKCmd)
+	RotateKEKCmd.Flags().StringVar(&newSecretKey, "new-secret-key", "", "the new secret key (otherwise entered interactively)")
+}
+
+func run(cmd *cobra.Command, args []string) {
+	_, cfg, _, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	secretKey, err := readSecretKey()
+	if err != nil {
+		log.Fatalf("failed to read secret key: %v", err)
+	}
+
+	if err := encryption.RotateKEK(cfg, secretKey); err != nil {
+		log.Fatalf("failed to rotate secret key: %v", err)
+	}
+
+	log.Printf("successfully rotated secret key, set Encryption.SecretKey to the new secret key before starting CastKeeper")
+}
+
+func readSecretKey() (string, error) {
+	if newSecretKey != "" {
+		return newSecretKey, nil
+	}
+
+	fmt.Print("Enter new secret key: ")
+	keyBytes, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	return string(keyBytes), nil
+}
@@ -2,7 +2,11 @@ package serve
 
 import (
 	"context"
+	"errors"
 	"log"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -13,9 +17,13 @@ import (
 	"github.com/webbgeorge/castkeeper/pkg/downloadworker"
 	"github.com/webbgeorge/castkeeper/pkg/feedworker"
 	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"github.com/webbgeorge/castkeeper/pkg/integrityworker"
 	"github.com/webbgeorge/castkeeper/pkg/itunes"
 	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
 	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"github.com/webbgeorge/castkeeper/pkg/reconcile"
+	"github.com/webbgeorge/castkeeper/pkg/storagestats"
+	"github.com/webbgeorge/castkeeper/pkg/util"
 	"github.com/webbgeorge/castkeeper/pkg/webserver"
 	"golang.org/x/sync/errgroup"
 )
@@ -39,16 +47,26 @@ func run(cmd *cobra.Command, args []string) {
 		log.Fatalf("failed to read config: %v", err)
 	}
 
+	// held while running, so that commands which require CastKeeper to be
+	// stopped, e.g. rotate-dek, refuse to run
+	lock, err := config.LockDataPath(cfg)
+	if err != nil {
+		log.Fatalf("failed to lock DataPath: %v", err)
+	}
+	defer lock.Close()
+
 	ctx := framework.ContextWithLogger(context.Background(), logger)
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	db, err := database.ConfigureDatabase(cfg, logger, false)
 	if err != nil {
 		log.Fatalf("failed to connect to database: %v", err)
 	}
 
-	objstore, err := objectstorage.ConfigureObjectStorage(ctx, cfg)
-	if err != nil {
-		log.Fatalf("failed to configure objectstorage: %v", err)
+	// checked before anything creates a new DEK
+	if err := podcasts.CheckDEKExists(ctx, db, cfg); err != nil {
+		log.Fatal(err)
 	}
 
 	encService, err := encryption.ConfigureEncryptedValueService(cfg)
@@ -56,6 +74,11 @@ func run(cmd *cobra.Command, args []string) {
 		log.Fatalf("failed to configure encryption: %v", err)
 	}
 
+	objstore, err := objectstorage.ConfigureObjectStorage(ctx, cfg, encService)
+	if err != nil {
+		log.Fatalf("failed to configure objectstorage: %v", err)
+	}
+
 	feedService := &podcasts.FeedService{
 		HTTPClient: framework.NewHTTPClient(time.Second * 5),
 	}
@@ -63,6 +86,8 @@ func run(cmd *cobra.Command, args []string) {
 		HTTPClient: framework.NewHTTPClient(time.Second * 5),
 	}
 
+	totalQuotaBytes := cfg.Quotas.TotalMB * util.BytesPerMB
+
 	g, ctx := errgroup.WithContext(ctx)
 
 	g.Go(func() error {
@@ -71,13 +96,28 @@ func run(cmd *cobra.Command, args []string) {
 			Start(ctx)
 	})
 
+	scheduledTasks := []framework.ScheduledTaskDefinition{
+		{TaskName: feedworker.FeedWorkerQueueName, Interval: time.Minute},
+		{TaskName: sessions.HouseKeepingQueueName, Interval: time.Hour},
+		{TaskName: storagestats.StorageStatsWorkerQueueName, Interval: time.Hour},
+	}
+	if cfg.Integrity.AuditIntervalDays > 0 {
+		scheduledTasks = append(scheduledTasks, framework.ScheduledTaskDefinition{
+			TaskName: integrityworker.IntegrityWorkerQueueName,
+			Interval: time.Hour,
+		})
+	}
+	if cfg.Reconcile.IntervalHours > 0 {
+		scheduledTasks = append(scheduledTasks, framework.ScheduledTaskDefinition{
+			TaskName: reconcile.ReconcileWorkerQueueName,
+			Interval: time.Duration(cfg.Reconcile.IntervalHours) * time.Hour,
+		})
+	}
+
 	g.Go(func() error {
 		scheduler := framework.TaskScheduler{
-			DB: db,
-			Tasks: []framework.ScheduledTaskDefinition{
-				{TaskName: feedworker.FeedWorkerQueueName, Interval: time.Minute},
-				{TaskName: sessions.HouseKeepingQueueName, Interval: time.Hour},
-			},
+			DB:    db,
+			Tasks: scheduledTasks,
 		}
 		return scheduler.Start(ctx)
 	})
@@ -95,7 +135,42 @@ func run(cmd *cobra.Command, args []string) {
 		qw := framework.QueueWorker{
 			DB:        db,
 			QueueName: downloadworker.DownloadWorkerQueueName,
-			HandlerFn: downloadworker.NewDownloadWorkerQueueHandler(db, objstore, encService),
+			HandlerFn: downloadworker.NewDownloadWorkerQueueHandler(db, objstore, encService, cfg.Tagging.Enabled, totalQuotaBytes),
+		}
+		return qw.Start(ctx)
+	})
+
+	g.Go(func() error {
+		qw := framework.QueueWorker{
+			DB:        db,
+			QueueName: integrityworker.IntegrityWorkerQueueName,
+			HandlerFn: integrityworker.NewIntegrityWorkerQueueHandler(
+				db,
+				objstore,
+				time.Duration(cfg.Integrity.AuditIntervalDays)*time.Hour*24,
+				cfg.Integrity.AutoRedownload,
+			),
+		}
+		return qw.Start(ctx)
+	})
+
+	g.Go(func() error {
+		qw := framework.QueueWorker{
+			DB:        db,
+			QueueName: reconcile.ReconcileWorkerQueueName,
+			HandlerFn: reconcile.NewReconcileWorkerQueueHandler(db, objstore, reconcile.Options{
+				DeleteOrphans:  cfg.Reconcile.DeleteOrphans,
+				RequeueMissing: cfg.Reconcile.RequeueMissing,
+			}),
+		}
+		return qw.Start(ctx)
+	})
+
+	g.Go(func() error {
+		qw := framework.QueueWorker{
+			DB:        db,
+			QueueName: storagestats.StorageStatsWorkerQueueName,
+			HandlerFn: storagestats.NewStorageStatsWorkerQueueHandler(db, totalQuotaBytes),
 		}
 		return qw.Start(ctx)
 	})
@@ -109,7 +184,8 @@ func run(cmd *cobra.Command, args []string) {
 		return qw.Start(ctx)
 	})
 
-	if err := g.Wait(); err != nil {
+	if err := g.Wait(); err != nil && !errors.Is(err, context.Canceled) {
 		log.Fatalf("fatal error: %s", err.Error())
 	}
+	logger.Info("CastKeeper stopped")
 }
@@ -0,0 +1,56 @@
+package setpodcastquota
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/downloadworker"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"github.com/webbgeorge/castkeeper/pkg/util"
+)
+
+var SetPodcastQuotaCmd = &cobra.Command{
+	Use:   "set-quota [podcast-guid] [quota-mb]",
+	Short: "Set the storage quota of a CastKeeper podcast",
+	Long: "Utility script for limiting the storage used by the downloaded episodes of a podcast, in MB. " +
+		"A quota of 0 removes the limit. Paused downloads which fit within the new quota are queued again.",
+	Args: cobra.ExactArgs(2),
+	Run:  run,
+}
+
+func init() {
+	cli.InitGlobalFlags(SetPodcastQuotaCmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, cfg, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	quotaMB, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || quotaMB < 0 {
+		log.Fatalf("quota must be a whole number of MB, got '%s'", args[1])
+	}
+
+	pod, err := podcasts.GetPodcast(ctx, db, args[0])
+	if err != nil {
+		log.Fatalf("failed to get podcast '%s': %v", args[0], err)
+	}
+
+	err = podcasts.UpdatePodcastQuota(ctx, db, &pod, quotaMB*util.BytesPerMB)
+	if err != nil {
+		log.Fatalf("failed to update quota of podcast '%s': %v", pod.GUID, err)
+	}
+	log.Printf("successfully updated quota of podcast '%s'", pod.GUID)
+
+	resumed, err := downloadworker.ResumePausedDownloads(ctx, db, cfg.Quotas.TotalMB*util.BytesPerMB)
+	if err != nil {
+		log.Fatalf("failed to resume paused downloads: %v", err)
+	}
+	if resumed > 0 {
+		log.Printf("resumed %d paused downloads", resumed)
+	}
+}
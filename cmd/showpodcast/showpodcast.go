@@ -0,0 +1,92 @@
+package showpodcast
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+)
+
+var ShowPodcastCmd = &cobra.Command{
+	Use:   "show <podcast-guid>",
+	Short: "Show details of a CastKeeper podcast",
+	Long:  "Utility script for showing a podcast, its feeds and a summary of its episodes for the given CastKeeper configuration.",
+	Args:  cobra.ExactArgs(1),
+	Run:   run,
+}
+
+type podcastDetails struct {
+	Podcast       podcasts.Podcast
+	Feeds         []podcasts.PodcastFeed
+	EpisodeCounts map[string]int
+}
+
+func init() {
+	cli.InitGlobalFlags(ShowPodcastCmd)
+	cli.InitJSONFlag(ShowPodcastCmd)
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, _, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pod, err := podcasts.GetPodcast(ctx, db, args[0])
+	if err != nil {
+		log.Fatalf("failed to get podcast: %v", err)
+	}
+
+	feeds, err := podcasts.ListFeeds(ctx, db, pod)
+	if err != nil {
+		log.Fatalf("failed to list podcast feeds: %v", err)
+	}
+
+	eps, err := podcasts.ListEpisodes(ctx, db, pod.GUID)
+	if err != nil {
+		log.Fatalf("failed to list episodes: %v", err)
+	}
+
+	counts := make(map[string]int)
+	for _, ep := range eps {
+		counts[ep.Status]++
+	}
+
+	details := podcastDetails{
+		Podcast:       pod,
+		Feeds:         feeds,
+		EpisodeCounts: counts,
+	}
+
+	err = cli.PrintResult(details, func() {
+		fmt.Printf("GUID:\t\t%s\n", pod.GUID)
+		fmt.Printf("Title:\t\t%s\n", pod.Title)
+		fmt.Printf("Author:\t\t%s\n", pod.Author)
+		if pod.LastCheckedAt != nil {
+			fmt.Printf("Last checked:\t%s\n", pod.LastCheckedAt.Format("2006-01-02 15:04:05"))
+		}
+		if pod.IsPrivate() {
+			fmt.Println("Private:\tyes, episodes are uploaded to CastKeeper")
+		}
+		fmt.Println("Feeds:")
+		for _, feed := range feeds {
+			premium := ""
+			if feed.IsPremium {
+				premium = " (premium)"
+			}
+			fmt.Printf("\t%d\t%s%s\n", feed.ID, feed.FeedURL, premium)
+		}
+		fmt.Printf("Episodes:\t%d total, %d success, %d pending, %d in progress, %d failed\n",
+			len(eps),
+			counts[podcasts.EpisodeStatusSuccess],
+			counts[podcasts.EpisodeStatusPending],
+			counts[podcasts.EpisodeStatusInProgress],
+			counts[podcasts.EpisodeStatusFailed],
+		)
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
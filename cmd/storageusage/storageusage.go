@@ -0,0 +1,87 @@
+package storageusage
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+	"github.com/webbgeorge/castkeeper/pkg/config/cli"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"github.com/webbgeorge/castkeeper/pkg/util"
+)
+
+var StorageUsageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Show the storage used by CastKeeper podcasts",
+	Long:  "Utility script for showing the storage used by the downloaded episodes of each podcast, and in total, along with any storage quotas, for the given CastKeeper configuration.",
+	Args:  cobra.NoArgs,
+	Run:   run,
+}
+
+func init() {
+	cli.InitGlobalFlags(StorageUsageCmd)
+	cli.InitJSONFlag(StorageUsageCmd)
+}
+
+type podcastUsage struct {
+	podcasts.StorageUsage
+	Title      string
+	QuotaBytes int64
+}
+
+type usageResult struct {
+	Total           podcasts.StorageUsage
+	TotalQuotaBytes int64
+	Podcasts        []podcastUsage
+}
+
+func run(cmd *cobra.Command, args []string) {
+	ctx, cfg, db, err := cli.ConfigureCLI()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	total, err := podcasts.GetStorageUsage(ctx, db, "")
+	if err != nil {
+		log.Fatalf("failed to get storage usage: %v", err)
+	}
+	usage, err := podcasts.ListStorageUsage(ctx, db)
+	if err != nil {
+		log.Fatalf("failed to list storage usage: %v", err)
+	}
+
+	result := usageResult{
+		Total:           total,
+		TotalQuotaBytes: cfg.Quotas.TotalMB * util.BytesPerMB,
+		Podcasts:        make([]podcastUsage, 0, len(usage)),
+	}
+	for _, u := range usage {
+		pod, err := podcasts.GetPodcast(ctx, db, u.PodcastGUID)
+		if err != nil {
+			log.Fatalf("failed to get podcast '%s': %v", u.PodcastGUID, err)
+		}
+		result.Podcasts = append(result.Podcasts, podcastUsage{
+			StorageUsage: u,
+			Title:        pod.Title,
+			QuotaBytes:   pod.QuotaBytes,
+		})
+	}
+
+	err = cli.PrintResult(result, func() {
+		fmt.Println("GUID\tTITLE\tEPISODES\tSIZE\tQUOTA")
+		for _, p := range result.Podcasts {
+			fmt.Printf("%s\t%s\t%d\t%s\t%s\n", p.PodcastGUID, p.Title, p.Episodes, util.FormatBytes(p.Bytes), formatQuota(p.QuotaBytes))
+		}
+		fmt.Printf("TOTAL\t\t%d\t%s\t%s\n", total.Episodes, util.FormatBytes(total.Bytes), formatQuota(result.TotalQuotaBytes))
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func formatQuota(quotaBytes int64) string {
+	if quotaBytes == 0 {
+		return "unlimited"
+	}
+	return util.FormatBytes(quotaBytes)
+}
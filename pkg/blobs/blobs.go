@@ -0,0 +1,150 @@
+// Package blobs stores episode files by their content, so that episodes with
+// identical files, e.g. reruns or episodes of twin feeds, share a single copy
+// in object storage.
+package blobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"github.com/webbgeorge/castkeeper/pkg/util"
+	"gorm.io/gorm"
+)
+
+// Store stores an episode's file, which has been saved as fileName in its
+// podcast's directory, as the blob of its content. If the blob already exists
+// the episode's copy is deleted, and true is returned. If the file can't be
+// stored, it is left in the podcast's directory and the episode is updated to
+// use it.
+func Store(ctx context.Context, db *gorm.DB, objstore objectstorage.ObjectStorage, episode *podcasts.Episode, fileName string, saved objectstorage.SavedFile) (bool, error) {
+	dir := util.SanitiseGUID(episode.PodcastGUID)
+
+	if err := podcasts.ReserveBlob(ctx, db, saved.SHA256, saved.Bytes); err != nil {
+		return false, unstore(ctx, db, episode, fmt.Errorf("failed to reserve blob: %w", err))
+	}
+
+	info, err := objstore.Stat(ctx, podcasts.BlobDir, saved.SHA256)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, release(ctx, db, episode, saved, fmt.Errorf("failed to check blob: %w", err))
+	}
+	// a blob of the wrong size is damaged, so is replaced by the episode's copy
+	deduplicated := err == nil && info.Bytes == saved.Bytes
+
+	if !deduplicated {
+		err := objectstorage.Move(ctx, objstore, dir, fileName, podcasts.BlobDir, saved.SHA256)
+		if err != nil {
+			return false, release(ctx, db, episode, saved, fmt.Errorf("failed to move file to blob: %w", err))
+		}
+	}
+
+	if err := podcasts.SetEpisodeBlob(ctx, db, episode, saved.SHA256); err != nil {
+		if !deduplicated {
+			_ = objectstorage.Move(ctx, objstore, podcasts.BlobDir, saved.SHA256, dir, fileName)
+		}
+		return false, release(ctx, db, episode, saved, fmt.Errorf("failed to update episode blob: %w", err))
+	}
+
+	if deduplicated {
+		if err := objstore.Delete(ctx, dir, fileName); err != nil {
+			// not an error, as the copy is no longer used, and is found by
+			// reconcile
+			framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to delete duplicate file of episode '%s': %s", episode.GUID, err.Error()))
+		}
+	}
+
+	return deduplicated, nil
+}
+
+// release releases a blob reserved by Store, when its file couldn't be stored
+func release(ctx context.Context, db *gorm.DB, episode *podcasts.Episode, saved objectstorage.SavedFile, err error) error {
+	if relErr := podcasts.ReleaseBlob(ctx, db, saved.SHA256); relErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to release blob: %w", relErr))
+	}
+	return unstore(ctx, db, episode, err)
+}
+
+// unstore updates an episode to use the file in its podcast's directory, when
+// its file couldn't be stored as a blob. Episodes which are downloaded again
+// would otherwise still use the blob of their previous file.
+func unstore(ctx context.Context, db *gorm.DB, episode *podcasts.Episode, err error) error {
+	if episode.BlobSHA256 == "" {
+		return err
+	}
+	if setErr := podcasts.SetEpisodeBlob(ctx, db, episode, ""); setErr != nil {
+		err = errors.Join(err, fmt.Errorf("failed to update episode blob: %w", setErr))
+	}
+	return err
+}
+
+type DedupeProblem struct {
+	EpisodeGUID string
+	Problem     string
+}
+
+type DedupeResult struct {
+	Stored       int
+	Deduplicated int
+	// SavedBytes is the size of the duplicate files which were deleted
+	SavedBytes int64
+	Failed     int
+	Problems   []DedupeProblem
+}
+
+// DeduplicateEpisodes stores the files of episodes downloaded before
+// content-addressed storage as blobs, deleting files which are duplicates of
+// others. Each file is checked against the episode's hash before it is stored.
+// Episodes which fail are recorded as problems, and don't stop the rest.
+func DeduplicateEpisodes(ctx context.Context, db *gorm.DB, objstore objectstorage.ObjectStorage) (DedupeResult, error) {
+	eps, err := podcasts.ListEpisodesWithoutBlobs(ctx, db)
+	if err != nil {
+		return DedupeResult{}, fmt.Errorf("failed to list episodes: %w", err)
+	}
+
+	result := DedupeResult{Problems: make([]DedupeProblem, 0)}
+	for _, ep := range eps {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		deduplicated, err := dedupeEpisode(ctx, db, objstore, &ep)
+		if err != nil {
+			result.Failed++
+			result.Problems = append(result.Problems, DedupeProblem{EpisodeGUID: ep.GUID, Problem: err.Error()})
+			continue
+		}
+		result.Stored++
+		if deduplicated {
+			result.Deduplicated++
+			result.SavedBytes += ep.Bytes
+		}
+	}
+
+	return result, nil
+}
+
+func dedupeEpisode(ctx context.Context, db *gorm.DB, objstore objectstorage.ObjectStorage, ep *podcasts.Episode) (bool, error) {
+	dir, fileName, err := podcasts.EpisodeFile(*ep)
+	if err != nil {
+		return false, err
+	}
+
+	f, err := objstore.Open(ctx, dir, fileName)
+	if err != nil {
+		return false, fmt.Errorf("failed to open file: %w", err)
+	}
+	saved, err := objectstorage.HashFile(f)
+	_ = f.Close()
+	if err != nil {
+		return false, fmt.Errorf("failed to read file: %w", err)
+	}
+	if saved.SHA256 != ep.SHA256 {
+		return false, errors.New("file does not match its recorded hash")
+	}
+
+	return Store(ctx, db, objstore, ep, fileName, saved)
+}
@@ -0,0 +1,193 @@
+package blobs_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/blobs"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"gorm.io/gorm"
+)
+
+func TestStore(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{Root: root}
+	ep1 := getEpisode(db, fixtures.PodEpGUID("ep-1"))
+	ep2 := getEpisode(db, fixtures.PodEpGUID("ep-2"))
+
+	saved := putEpisodeFile(root, ep1, "content")
+	deduplicated, err := blobs.Store(ctx, db, objstore, &ep1, ep1.GUID+".mp3", saved)
+
+	assert.Nil(t, err)
+	assert.False(t, deduplicated)
+	assert.Equal(t, saved.SHA256, getEpisode(db, ep1.GUID).BlobSHA256)
+	assertBlobContent(t, root, saved.SHA256, "content")
+	assertNoEpisodeFile(t, root, ep1)
+
+	// an identical file is stored once
+	saved = putEpisodeFile(root, ep2, "content")
+	deduplicated, err = blobs.Store(ctx, db, objstore, &ep2, ep2.GUID+".mp3", saved)
+
+	assert.Nil(t, err)
+	assert.True(t, deduplicated)
+	assert.Equal(t, saved.SHA256, getEpisode(db, ep2.GUID).BlobSHA256)
+	assertNoEpisodeFile(t, root, ep2)
+	assertRefCount(t, db, saved.SHA256, 2)
+
+	// downloaded again with different content
+	newSaved := putEpisodeFile(root, ep2, "new content")
+	deduplicated, err = blobs.Store(ctx, db, objstore, &ep2, ep2.GUID+".mp3", newSaved)
+
+	assert.Nil(t, err)
+	assert.False(t, deduplicated)
+	assertBlobContent(t, root, newSaved.SHA256, "new content")
+	assertRefCount(t, db, saved.SHA256, 1)
+	assertRefCount(t, db, newSaved.SHA256, 1)
+}
+
+func TestStore_ReplacesDamagedBlob(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{Root: root}
+	ep := getEpisode(db, fixtures.PodEpGUID("ep-1"))
+
+	saved := putEpisodeFile(root, ep, "content")
+	if _, err := objstore.Put(ctx, podcasts.BlobDir, saved.SHA256, strings.NewReader("truncated")); err != nil {
+		panic(err)
+	}
+
+	deduplicated, err := blobs.Store(ctx, db, objstore, &ep, ep.GUID+".mp3", saved)
+
+	assert.Nil(t, err)
+	assert.False(t, deduplicated)
+	assertBlobContent(t, root, saved.SHA256, "content")
+}
+
+func TestStore_KeepsFileOnFailure(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{Root: root}
+	ep := getEpisode(db, fixtures.PodEpGUID("ep-1"))
+
+	saved := putEpisodeFile(root, ep, "content")
+	if _, err := blobs.Store(ctx, db, objstore, &ep, ep.GUID+".mp3", saved); err != nil {
+		panic(err)
+	}
+
+	// downloaded again, but the file can't be moved to its blob
+	newSaved := putEpisodeFile(root, ep, "new content")
+	_, err := blobs.Store(ctx, db, objstore, &ep, "missing.mp3", newSaved)
+
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	ep = getEpisode(db, ep.GUID)
+	assert.Equal(t, "", ep.BlobSHA256)
+	assertRefCount(t, db, saved.SHA256, 0)
+	assertRefCount(t, db, newSaved.SHA256, 0)
+	_, err = root.Stat(fmt.Sprintf("%s/%s.mp3", ep.PodcastGUID, ep.GUID))
+	assert.Nil(t, err)
+}
+
+func TestDeduplicateEpisodes(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{Root: root}
+	ep1 := getEpisode(db, fixtures.PodEpGUID("ep-1"))
+	ep2 := getEpisode(db, fixtures.PodEpGUID("ep-2"))
+	ep3 := getEpisode(db, fixtures.PodEpGUID("pending-ep-1"))
+
+	// downloaded before content-addressed storage
+	for _, ep := range []podcasts.Episode{ep1, ep2, ep3} {
+		saved := putEpisodeFile(root, ep, "rerun")
+		setEpisodeFile(db, ep.GUID, saved)
+	}
+	// changed since it was downloaded
+	putEpisodeFile(root, ep3, "changed")
+
+	result, err := blobs.DeduplicateEpisodes(ctx, db, objstore)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result.Stored)
+	assert.Equal(t, 1, result.Deduplicated)
+	assert.Equal(t, int64(5), result.SavedBytes)
+	assert.Equal(t, 1, result.Failed)
+	assert.Equal(t, []blobs.DedupeProblem{{
+		EpisodeGUID: ep3.GUID,
+		Problem:     "file does not match its recorded hash",
+	}}, result.Problems)
+
+	sha := fmt.Sprintf("%x", sha256.Sum256([]byte("rerun")))
+	assertBlobContent(t, root, sha, "rerun")
+	assertRefCount(t, db, sha, 2)
+	assertNoEpisodeFile(t, root, ep1)
+	assertNoEpisodeFile(t, root, ep2)
+
+	// stored episodes are skipped when run again
+	result, err = blobs.DeduplicateEpisodes(ctx, db, objstore)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result.Stored)
+	assert.Equal(t, 1, result.Failed)
+}
+
+func getEpisode(db *gorm.DB, guid string) podcasts.Episode {
+	ep, err := podcasts.GetEpisode(context.Background(), db, guid)
+	if err != nil {
+		panic(err)
+	}
+	return ep
+}
+
+func setEpisodeFile(db *gorm.DB, guid string, saved objectstorage.SavedFile) {
+	err := db.Exec("UPDATE episodes SET status = ?, bytes = ?, sha256 = ? WHERE guid = ?",
+		podcasts.EpisodeStatusSuccess, saved.Bytes, saved.SHA256, guid).Error
+	if err != nil {
+		panic(err)
+	}
+}
+
+func putEpisodeFile(root *os.Root, ep podcasts.Episode, content string) objectstorage.SavedFile {
+	objstore := &objectstorage.LocalObjectStorage{Root: root}
+	saved, err := objstore.Put(context.Background(), ep.PodcastGUID, ep.GUID+".mp3", strings.NewReader(content))
+	if err != nil {
+		panic(err)
+	}
+	return saved
+}
+
+func assertBlobContent(t *testing.T, root *os.Root, sha256, expected string) {
+	t.Helper()
+	data, err := root.ReadFile(fmt.Sprintf("%s/%s", podcasts.BlobDir, sha256))
+	assert.Nil(t, err)
+	assert.Equal(t, expected, string(data))
+}
+
+func assertNoEpisodeFile(t *testing.T, root *os.Root, ep podcasts.Episode) {
+	t.Helper()
+	_, err := root.Stat(fmt.Sprintf("%s/%s.mp3", ep.PodcastGUID, ep.GUID))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func assertRefCount(t *testing.T, db *gorm.DB, sha256 string, expected int64) {
+	t.Helper()
+	var blob podcasts.Blob
+	if err := db.First(&blob, "sha256 = ?", sha256).Error; err != nil {
+		panic(err)
+	}
+	assert.Equal(t, expected, blob.RefCount)
+}
@@ -2,6 +2,7 @@ package cli
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"os"
@@ -14,24 +15,44 @@ import (
 )
 
 var (
-	cfgFile string
-	verbose bool
+	cfgFile    string
+	verbose    bool
+	jsonOutput bool
 )
 
 func InitGlobalFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().StringVarP(&cfgFile, "config", "c", "", "config file (otherwise uses default locations)")
+	InitVerboseFlag(cmd)
+}
+
+// InitVerboseFlag adds only the --verbose flag, for commands which take their
+// config files as flags of their own
+func InitVerboseFlag(cmd *cobra.Command) {
 	cmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "enable verbose output")
 }
 
-func ConfigureCLI() (context.Context, config.Config, *gorm.DB, error) {
-	logLevel := slog.LevelWarn
-	_ = os.Setenv("CASTKEEPER_LOGLEVEL", "warn")
-	if verbose {
-		logLevel = slog.LevelDebug
-		_ = os.Setenv("CASTKEEPER_LOGLEVEL", "debug")
+// InitJSONFlag adds the --json flag to commands which support machine readable
+// output, for use in scripts and automation
+func InitJSONFlag(cmd *cobra.Command) {
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "output results as JSON")
+}
+
+// PrintResult prints v as JSON when the --json flag is set, otherwise calls
+// printText to print the result in a human readable format
+func PrintResult(v any, printText func()) error {
+	if !jsonOutput {
+		printText()
+		return nil
 	}
-	logger := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{Level: logLevel}))
-	ctx := framework.ContextWithLogger(context.Background(), logger)
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func ConfigureCLI() (context.Context, config.Config, *gorm.DB, error) {
+	ctx := ConfigureCLIContext()
+	logger := framework.GetLogger(ctx)
 
 	cfg, _, err := config.LoadConfig(cfgFile)
 	if err != nil {
@@ -45,3 +66,22 @@ func ConfigureCLI() (context.Context, config.Config, *gorm.DB, error) {
 
 	return ctx, cfg, db, nil
 }
+
+// ConfigureCLIContext creates the context of commands which load their own
+// config, e.g. from more than one config file, with a logger which respects
+// the --verbose and --json flags
+func ConfigureCLIContext() context.Context {
+	logLevel := slog.LevelWarn
+	_ = os.Setenv("CASTKEEPER_LOGLEVEL", "warn")
+	if verbose {
+		logLevel = slog.LevelDebug
+		_ = os.Setenv("CASTKEEPER_LOGLEVEL", "debug")
+	}
+	// keep stdout clean for JSON output
+	logOut := os.Stdout
+	if jsonOutput {
+		logOut = os.Stderr
+	}
+	logger := slog.New(slog.NewTextHandler(logOut, &slog.HandlerOptions{Level: logLevel}))
+	return framework.ContextWithLogger(context.Background(), logger)
+}
@@ -18,7 +18,12 @@ const (
 	applicationName           = "castkeeper"
 	ObjectStorageDriverLocal  = "local"
 	ObjectStorageDriverS3     = "awss3"
+	ObjectStorageDriverWebDAV = "webdav"
+	ObjectStorageDriverSFTP   = "sftp"
 	EncryptionDriverSecretKey = "secretkey"
+	EncryptionDriverKeyFile   = "keyfile"
+	EncryptionDriverVault     = "vault"
+	EncryptionDriverAWSKMS    = "awskms"
 	LogLevelDebug             = "debug"
 	LogLevelInfo              = "info"
 	LogLevelWarn              = "warn"
@@ -33,22 +38,65 @@ type Config struct {
 	WebServer     WebServerConfig     `validate:"required"`
 	ObjectStorage ObjectStorageConfig `validate:"required"`
 	Encryption    EncryptionConfig    `validate:"omitempty"`
+	Integrity     IntegrityConfig     `validate:"omitempty"`
+	Tagging       TaggingConfig       `validate:"omitempty"`
+	Reconcile     ReconcileConfig     `validate:"omitempty"`
+	Quotas        QuotasConfig        `validate:"omitempty"`
 }
 
 type WebServerConfig struct {
-	Port int `validate:"required,gt=0,lte=65535"`
+	Port        int   `validate:"required,gt=0,lte=65535"`
+	MaxUploadMB int64 `validate:"gte=0"` // limits the size of uploaded episodes, 0 is unlimited
 }
 
 type ObjectStorageConfig struct {
-	Driver           string `validate:"required,oneof=local awss3"`
-	S3Bucket         string `validate:"required_if=Driver awss3"`
-	S3Prefix         string `validate:"lte=250"`
-	S3ForcePathStyle bool   // used for localstack testing, unlikely to be ever used in prod
+	Driver             string `validate:"required,oneof=local awss3 webdav sftp"`
+	S3Bucket           string `validate:"required_if=Driver awss3"`
+	S3Prefix           string `validate:"lte=250"`
+	S3ForcePathStyle   bool   // used for localstack testing, unlikely to be ever used in prod
+	WebDAVURL          string `validate:"required_if=Driver webdav,omitempty,http_url"`
+	WebDAVUsername     string
+	WebDAVPassword     string `secret:"true"`
+	SFTPAddress        string `validate:"required_if=Driver sftp,omitempty,hostname_port"`
+	SFTPUsername       string `validate:"required_if=Driver sftp"`
+	SFTPPassword       string `validate:"required_if=Driver sftp SFTPPrivateKeyPath ''" secret:"true"`
+	SFTPPrivateKeyPath string
+	SFTPHostKey        string `validate:"required_if=Driver sftp"` // in authorized_keys format
+	SFTPRoot           string // defaults to the user's home directory
 }
 
 type EncryptionConfig struct {
-	Driver    string `validate:"omitempty,oneof=secretkey"`
-	SecretKey string `validate:"omitempty,required_if=Driver secretkey,gte=16,lte=64" secret:"true"`
+	Driver            string `validate:"omitempty,oneof=secretkey keyfile vault awskms"`
+	SecretKey         string `validate:"omitempty,required_if=Driver secretkey,gte=16,lte=64" secret:"true"`
+	KeyFile           string `validate:"required_if=Driver keyfile"`
+	VaultAddress      string `validate:"required_if=Driver vault,omitempty,http_url"`
+	VaultToken        string `validate:"required_if=Driver vault" secret:"true"`
+	VaultTransitMount string // defaults to "transit"
+	VaultKeyName      string `validate:"required_if=Driver vault"`
+	AWSKMSKeyID       string `validate:"required_if=Driver awskms"` // key ID, ARN or alias
+	// encrypts downloaded media files before they are saved to object storage
+	EncryptMedia bool `validate:"excluded_without=Driver"`
+}
+
+type IntegrityConfig struct {
+	AuditIntervalDays int `validate:"gte=0"` // 0 disables scheduled integrity audits
+	AutoRedownload    bool
+}
+
+type TaggingConfig struct {
+	// writes podcast and episode metadata into downloaded files. Tagged files
+	// differ for each episode, so identical downloads are no longer deduplicated.
+	Enabled bool
+}
+
+type ReconcileConfig struct {
+	IntervalHours  int `validate:"gte=0"` // 0 disables scheduled storage reconciliation
+	DeleteOrphans  bool
+	RequeueMissing bool
+}
+
+type QuotasConfig struct {
+	TotalMB int64 `validate:"gte=0"` // limits storage used by all downloaded episodes, 0 is unlimited
 }
 
 func LoadConfig(configFilePath string) (Config, *slog.Logger, error) {
@@ -68,6 +116,7 @@ func loadConfig(v *viper.Viper, configFilePath string) (Config, *slog.Logger, er
 	v.SetDefault("LogLevel", LogLevelInfo)
 	v.SetDefault("EnvName", "unknown")
 	v.SetDefault("WebServer.Port", 8080)
+	v.SetDefault("WebServer.MaxUploadMB", 2048)
 
 	// allow config to optionally be set using environment variables
 	// e.g. CASTKEEPER_WEBSERVER_PORT
@@ -125,6 +174,10 @@ func debugConfig(cfg Config) string {
 	debugStruct(cfg.WebServer, "WebServer.", &debugVals)
 	debugStruct(cfg.ObjectStorage, "ObjectStorage.", &debugVals)
 	debugStruct(cfg.Encryption, "Encryption.", &debugVals)
+	debugStruct(cfg.Integrity, "Integrity.", &debugVals)
+	debugStruct(cfg.Tagging, "Tagging.", &debugVals)
+	debugStruct(cfg.Reconcile, "Reconcile.", &debugVals)
+	debugStruct(cfg.Quotas, "Quotas.", &debugVals)
 	return strings.Join(debugVals, ", ")
 }
 
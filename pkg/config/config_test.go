@@ -17,7 +17,8 @@ func TestLoadConfig_ValidLocal(t *testing.T) {
 		BaseURL:  "http://www.example.com",
 		DataPath: "./data",
 		WebServer: config.WebServerConfig{
-			Port: 80,
+			Port:        80,
+			MaxUploadMB: 2048,
 		},
 		ObjectStorage: config.ObjectStorageConfig{
 			Driver: "local",
@@ -38,7 +39,8 @@ func TestLoadConfig_ValidS3(t *testing.T) {
 		BaseURL:  "http://www.example.com",
 		DataPath: "./data",
 		WebServer: config.WebServerConfig{
-			Port: 80,
+			Port:        80,
+			MaxUploadMB: 2048,
 		},
 		ObjectStorage: config.ObjectStorageConfig{
 			Driver:   "awss3",
@@ -48,6 +50,70 @@ func TestLoadConfig_ValidS3(t *testing.T) {
 	}, cfg)
 }
 
+func TestLoadConfig_ValidWebDAV(t *testing.T) {
+	cfg, _, err := config.LoadConfig("testdata/valid-webdav.yml")
+	assert.Nil(t, err)
+	assert.Equal(t, config.ObjectStorageConfig{
+		Driver:         "webdav",
+		WebDAVURL:      "https://nas.local/dav/castkeeper",
+		WebDAVUsername: "castkeeper",
+		WebDAVPassword: "some-password",
+	}, cfg.ObjectStorage)
+}
+
+func TestLoadConfig_ValidSFTP(t *testing.T) {
+	cfg, _, err := config.LoadConfig("testdata/valid-sftp.yml")
+	assert.Nil(t, err)
+	assert.Equal(t, config.ObjectStorageConfig{
+		Driver:             "sftp",
+		SFTPAddress:        "nas.local:22",
+		SFTPUsername:       "castkeeper",
+		SFTPPrivateKeyPath: "/etc/castkeeper/id_ed25519",
+		SFTPHostKey:        "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHdSzmWkc4BeAvbRQlNIxBa7AFjPmdnCqjU3DEFdNNzn",
+		SFTPRoot:           "/volume1/castkeeper",
+	}, cfg.ObjectStorage)
+}
+
+func TestLoadConfig_ValidEncryptionDrivers(t *testing.T) {
+	testCases := map[string]struct {
+		configFile string
+		expected   config.EncryptionConfig
+	}{
+		"keyfile": {
+			configFile: "testdata/valid-enc-keyfile.yml",
+			expected: config.EncryptionConfig{
+				Driver:  "keyfile",
+				KeyFile: "/run/secrets/castkeeper-kek",
+			},
+		},
+		"vault": {
+			configFile: "testdata/valid-enc-vault.yml",
+			expected: config.EncryptionConfig{
+				Driver:            "vault",
+				VaultAddress:      "https://vault.local:8200",
+				VaultToken:        "some-token",
+				VaultTransitMount: "castkeeper-transit",
+				VaultKeyName:      "castkeeper",
+			},
+		},
+		"awskms": {
+			configFile: "testdata/valid-enc-awskms.yml",
+			expected: config.EncryptionConfig{
+				Driver:      "awskms",
+				AWSKMSKeyID: "alias/castkeeper",
+			},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg, _, err := config.LoadConfig(tc.configFile)
+			assert.Nil(t, err)
+			assert.Equal(t, tc.expected, cfg.Encryption)
+		})
+	}
+}
+
 func TestLoadConfig_EnvVarsOnly(t *testing.T) {
 	os.Setenv("CASTKEEPER_ENVNAME", "testdata")
 	os.Setenv("CASTKEEPER_LOGLEVEL", "error")
@@ -80,7 +146,8 @@ func TestLoadConfig_EnvVarsOnly(t *testing.T) {
 		BaseURL:  "http://www.example.com",
 		DataPath: "./data",
 		WebServer: config.WebServerConfig{
-			Port: 80,
+			Port:        80,
+			MaxUploadMB: 2048,
 		},
 		ObjectStorage: config.ObjectStorageConfig{
 			Driver:   "awss3",
@@ -108,7 +175,8 @@ func TestLoadConfig_EnvVarOverride(t *testing.T) {
 		BaseURL:  "http://www.example.com",
 		DataPath: "./data",
 		WebServer: config.WebServerConfig{
-			Port: 80,
+			Port:        80,
+			MaxUploadMB: 2048,
 		},
 		ObjectStorage: config.ObjectStorageConfig{
 			Driver: "local",
@@ -145,6 +213,18 @@ func TestLoadConfig_ValidationErr(t *testing.T) {
 			configFile:  "testdata/invalid-s3-bucket.yml",
 			expectedErr: "Key: 'Config.ObjectStorage.S3Bucket' Error:Field validation for 'S3Bucket' failed on the 'required_if' tag",
 		},
+		"invalidWebDAVURL": {
+			configFile:  "testdata/invalid-webdav-url.yml",
+			expectedErr: "Key: 'Config.ObjectStorage.WebDAVURL' Error:Field validation for 'WebDAVURL' failed on the 'http_url' tag",
+		},
+		"missingSFTPAuth": {
+			configFile:  "testdata/invalid-sftp-auth.yml",
+			expectedErr: "Key: 'Config.ObjectStorage.SFTPPassword' Error:Field validation for 'SFTPPassword' failed on the 'required_if' tag",
+		},
+		"missingSFTPHostKey": {
+			configFile:  "testdata/invalid-sftp-host-key.yml",
+			expectedErr: "Key: 'Config.ObjectStorage.SFTPHostKey' Error:Field validation for 'SFTPHostKey' failed on the 'required_if' tag",
+		},
 		"invalidEncryptionDriver": {
 			configFile:  "testdata/invalid-enc-driver.yml",
 			expectedErr: "Key: 'Config.Encryption.Driver' Error:Field validation for 'Driver' failed on the 'oneof' tag",
@@ -153,6 +233,18 @@ func TestLoadConfig_ValidationErr(t *testing.T) {
 			configFile:  "testdata/invalid-enc-key.yml",
 			expectedErr: "Key: 'Config.Encryption.SecretKey' Error:Field validation for 'SecretKey' failed on the 'gte' tag",
 		},
+		"missingVaultKeyName": {
+			configFile:  "testdata/invalid-enc-vault.yml",
+			expectedErr: "Key: 'Config.Encryption.VaultKeyName' Error:Field validation for 'VaultKeyName' failed on the 'required_if' tag",
+		},
+		"missingAWSKMSKeyID": {
+			configFile:  "testdata/invalid-enc-awskms.yml",
+			expectedErr: "Key: 'Config.Encryption.AWSKMSKeyID' Error:Field validation for 'AWSKMSKeyID' failed on the 'required_if' tag",
+		},
+		"encryptMediaWithoutDriver": {
+			configFile:  "testdata/invalid-enc-media.yml",
+			expectedErr: "Key: 'Config.Encryption.EncryptMedia' Error:Field validation for 'EncryptMedia' failed on the 'excluded_without' tag",
+		},
 	}
 
 	for name, tc := range testCases {
@@ -163,3 +255,19 @@ func TestLoadConfig_ValidationErr(t *testing.T) {
 		})
 	}
 }
+
+func TestLockDataPath(t *testing.T) {
+	cfg := config.Config{DataPath: t.TempDir()}
+
+	lock, err := config.LockDataPath(cfg)
+	assert.Nil(t, err)
+
+	_, err = config.LockDataPath(cfg)
+	assert.ErrorIs(t, err, config.ErrDataPathLocked)
+
+	// can be locked again once released
+	assert.Nil(t, lock.Close())
+	lock, err = config.LockDataPath(cfg)
+	assert.Nil(t, err)
+	assert.Nil(t, lock.Close())
+}
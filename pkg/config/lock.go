@@ -0,0 +1,32 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+const lockFileName = "castkeeper.lock"
+
+var ErrDataPathLocked = errors.New("the DataPath is locked by another CastKeeper process, e.g. a running server")
+
+// LockDataPath takes an exclusive lock of the DataPath of cfg, so that
+// commands which must not run while CastKeeper is running can check that it
+// is stopped. The lock is released when the returned file is closed, or the
+// process exits. ErrDataPathLocked is returned if it is already locked.
+func LockDataPath(cfg Config) (*os.File, error) {
+	f, err := MustOpenLocalFSRoot(cfg.DataPath).OpenFile(lockFileName, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		_ = f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, ErrDataPathLocked
+		}
+		return nil, err
+	}
+	return f, nil
+}
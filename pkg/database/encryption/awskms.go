@@ -0,0 +1,59 @@
+package encryption
+
+import (
+	"context"
+	"encoding/hex"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+const awsKMSRequestTimeout = 30 * time.Second
+
+// AWSKMSAEAD encrypts with a symmetric AWS KMS key, so that the key never
+// leaves KMS. Associated data is bound to the ciphertext as its encryption
+// context.
+type AWSKMSAEAD struct {
+	KMSClient *kms.Client
+	KeyID     string
+}
+
+func (a *AWSKMSAEAD) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), awsKMSRequestTimeout)
+	defer cancel()
+
+	out, err := a.KMSClient.Encrypt(ctx, &kms.EncryptInput{
+		KeyId:             aws.String(a.KeyID),
+		Plaintext:         plaintext,
+		EncryptionContext: kmsEncryptionContext(associatedData),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.CiphertextBlob, nil
+}
+
+func (a *AWSKMSAEAD) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), awsKMSRequestTimeout)
+	defer cancel()
+
+	out, err := a.KMSClient.Decrypt(ctx, &kms.DecryptInput{
+		// decrypting with the configured key only, rather than any key the
+		// ciphertext names
+		KeyId:             aws.String(a.KeyID),
+		CiphertextBlob:    ciphertext,
+		EncryptionContext: kmsEncryptionContext(associatedData),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out.Plaintext, nil
+}
+
+func kmsEncryptionContext(associatedData []byte) map[string]string {
+	if len(associatedData) == 0 {
+		return nil
+	}
+	return map[string]string{"associatedData": hex.EncodeToString(associatedData)}
+}
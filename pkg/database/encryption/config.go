@@ -2,37 +2,36 @@ package encryption
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"path"
 
 	"github.com/tink-crypto/tink-go/v2/aead"
 	"github.com/tink-crypto/tink-go/v2/keyset"
+	tinkpb "github.com/tink-crypto/tink-go/v2/proto/tink_go_proto"
 	"github.com/tink-crypto/tink-go/v2/tink"
 	"github.com/webbgeorge/castkeeper/pkg/config"
 )
 
 const dekFileName = "dek.json"
 
+var ErrDEKMissing = errors.New("the data encryption key (dek.json) is missing")
+
 func ConfigureEncryptedValueService(
 	cfg config.Config,
 ) (*EncryptedValueService, error) {
-	if cfg.Encryption.Driver != config.EncryptionDriverSecretKey {
+	if cfg.Encryption.Driver == "" {
 		return nil, nil
 	}
 
-	// TODO KEK dependent on driver
-	kekAEAD, err := DeriveAEADFromSecret(
-		cfg.Encryption.SecretKey,
-	)
+	kekAEAD, err := configureKEK(cfg)
 	if err != nil {
 		return nil, err
 	}
 
 	dekAEAD, err := loadOrCreateDEK(
 		kekAEAD,
-		config.MustOpenLocalFSRoot(
-			path.Join(cfg.DataPath),
-		),
+		openDataDir(cfg),
 	)
 	if err != nil {
 		return nil, err
@@ -43,56 +42,114 @@ func ConfigureEncryptedValueService(
 	}, nil
 }
 
+// DEKExists reports whether the DEK has been created in the DataPath of cfg
+func DEKExists(cfg config.Config) (bool, error) {
+	return keysetExists(openDataDir(cfg), dekFileName)
+}
+
+func openDataDir(cfg config.Config) *os.Root {
+	return config.MustOpenLocalFSRoot(
+		path.Join(cfg.DataPath),
+	)
+}
+
 func loadOrCreateDEK(kekAEAD tink.AEAD, dataDir *os.Root) (tink.AEAD, error) {
-	_, err := dataDir.Stat(dekFileName)
+	// the media key is encrypted with the DEK, so if it exists without the DEK
+	// then the DEK was lost, and a new DEK couldn't decrypt it
+	dekExists, err := keysetExists(dataDir, dekFileName)
+	if err != nil {
+		return nil, err
+	}
+	mediaKeyExists, err := keysetExists(dataDir, mediaKeyFileName)
+	if err != nil {
+		return nil, err
+	}
+	if !dekExists && mediaKeyExists {
+		return nil, fmt.Errorf("%w, but %s which is encrypted with it exists", ErrDEKMissing, mediaKeyFileName)
+	}
+
+	handle, err := loadOrCreateKeyset(kekAEAD, dataDir, dekFileName, aead.AES256GCMSIVKeyTemplate())
+	if err != nil {
+		return nil, err
+	}
+	return aead.New(handle)
+}
+
+// loadOrCreateKeyset loads the keyset in fileName, which is encrypted with
+// kekAEAD, or creates it from template if it doesn't exist yet
+func loadOrCreateKeyset(kekAEAD tink.AEAD, dataDir *os.Root, fileName string, template *tinkpb.KeyTemplate) (*keyset.Handle, error) {
+	_, err := dataDir.Stat(fileName)
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
-			return createDEK(kekAEAD, dataDir)
+			return createKeyset(kekAEAD, dataDir, fileName, template)
 		}
 		return nil, err
 	}
-	return loadDEK(kekAEAD, dataDir)
+	return loadKeyset(kekAEAD, dataDir, fileName)
 }
 
-func loadDEK(kekAEAD tink.AEAD, dataDir *os.Root) (tink.AEAD, error) {
-	f, err := dataDir.Open(dekFileName)
+func keysetExists(dataDir *os.Root, fileName string) (bool, error) {
+	_, err := dataDir.Stat(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
 	if err != nil {
-		return nil, err
+		return false, err
 	}
-	defer f.Close()
+	return true, nil
+}
 
-	reader := keyset.NewJSONReader(f)
-	handle, err := keyset.Read(reader, kekAEAD)
+func loadKeyset(kekAEAD tink.AEAD, dataDir *os.Root, fileName string) (*keyset.Handle, error) {
+	f, err := dataDir.Open(fileName)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
 
-	return aead.New(handle)
+	reader := keyset.NewJSONReader(f)
+	return keyset.Read(reader, kekAEAD)
 }
 
-func createDEK(kekAEAD tink.AEAD, dataDir *os.Root) (tink.AEAD, error) {
-	handle, err := keyset.NewHandle(aead.AES256GCMSIVKeyTemplate())
+func createKeyset(kekAEAD tink.AEAD, dataDir *os.Root, fileName string, template *tinkpb.KeyTemplate) (*keyset.Handle, error) {
+	handle, err := keyset.NewHandle(template)
 	if err != nil {
 		return nil, err
 	}
 
-	f, err := dataDir.Create(dekFileName)
-	if err != nil {
+	if err := writeKeyset(kekAEAD, dataDir, fileName, handle); err != nil {
 		return nil, err
 	}
+	return handle, nil
+}
+
+// writeKeyset encrypts the keyset with kekAEAD and saves it to fileName. The
+// keyset is written to a temporary file first, so that an existing keyset is
+// only replaced once the new one is completely written.
+func writeKeyset(kekAEAD tink.AEAD, dataDir *os.Root, fileName string, handle *keyset.Handle) error {
+	tmpFileName := fileName + ".tmp"
+	f, err := dataDir.Create(tmpFileName)
+	if err != nil {
+		return err
+	}
 	defer f.Close()
 
 	writer := keyset.NewJSONWriter(f)
 	err = handle.Write(writer, kekAEAD)
 	if err != nil {
-		return nil, err
+		_ = dataDir.Remove(tmpFileName)
+		return err
 	}
 
 	// we want to be sure the key is written to disk before we start using it
 	err = f.Sync()
 	if err != nil {
-		return nil, err
+		_ = dataDir.Remove(tmpFileName)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = dataDir.Remove(tmpFileName)
+		return err
 	}
 
-	return aead.New(handle)
+	return dataDir.Rename(tmpFileName, fileName)
 }
@@ -68,6 +68,26 @@ func TestConfigureEncryptedValueService_SecretKeyDriver_ChangingSecretPreventsLo
 	assert.Equal(t, "keyset.Handle: decryption failed: aes_gcm_siv: message authentication failure", err.Error())
 }
 
+func TestConfigureEncryptedValueService_SecretKeyDriver_DEKMissingWithMediaKey(t *testing.T) {
+	randomHex := fixtures.RandomHex()
+	rootPath := path.Join(os.TempDir(), "castkeepertest", randomHex)
+	cfg := encryptionConfigForTest(randomHex, "secretKeyForTest111")
+	cfg.Encryption.EncryptMedia = true
+	if _, err := encryption.Enable(cfg); err != nil {
+		panic(err)
+	}
+
+	// the DEK which the media key is encrypted with is lost
+	if err := os.Remove(path.Join(rootPath, "dek.json")); err != nil {
+		panic(err)
+	}
+
+	_, err := configureEVSForTest(randomHex, "secretKeyForTest111")
+	assert.ErrorIs(t, err, encryption.ErrDEKMissing)
+	_, err = os.Stat(path.Join(rootPath, "dek.json"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
 func TestConfigureEncryptedValueService_NoDriver(t *testing.T) {
 	rootPath := path.Join(os.TempDir(), "castkeepertest", fixtures.RandomHex())
 	evs, _ := encryption.ConfigureEncryptedValueService(config.Config{
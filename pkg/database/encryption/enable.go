@@ -0,0 +1,55 @@
+package encryption
+
+import (
+	"fmt"
+
+	"github.com/webbgeorge/castkeeper/pkg/config"
+)
+
+type EnableResult struct {
+	Driver          string
+	DEKCreated      bool
+	MediaKeyCreated bool
+}
+
+// Enable initialises encryption, e.g. when it is configured on an existing
+// instance. The KEK of the configured driver is checked to work, then the DEK,
+// and the media key if media encryption is enabled, are created if they don't
+// exist yet, or loaded to check they can be decrypted if they do.
+func Enable(cfg config.Config) (EnableResult, error) {
+	if cfg.Encryption.Driver == "" {
+		return EnableResult{}, ErrEncryptionNotConfigured
+	}
+
+	kekAEAD, err := configureKEK(cfg)
+	if err != nil {
+		return EnableResult{}, err
+	}
+	if err := verifyKEK(kekAEAD); err != nil {
+		return EnableResult{}, fmt.Errorf("failed to verify KEK: %w", err)
+	}
+
+	dataDir := openDataDir(cfg)
+	dekExists, err := keysetExists(dataDir, dekFileName)
+	if err != nil {
+		return EnableResult{}, err
+	}
+	mediaKeyExists, err := keysetExists(dataDir, mediaKeyFileName)
+	if err != nil {
+		return EnableResult{}, err
+	}
+
+	evs, err := ConfigureEncryptedValueService(cfg)
+	if err != nil {
+		return EnableResult{}, fmt.Errorf("failed to load DEK: %w", err)
+	}
+	if _, err := ConfigureMediaEncryptionService(cfg, evs); err != nil {
+		return EnableResult{}, err
+	}
+
+	return EnableResult{
+		Driver:          cfg.Encryption.Driver,
+		DEKCreated:      !dekExists,
+		MediaKeyCreated: cfg.Encryption.EncryptMedia && !mediaKeyExists,
+	}, nil
+}
@@ -0,0 +1,65 @@
+package encryption_test
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+)
+
+func TestEnable(t *testing.T) {
+	cfg := encryptionConfigForTest(fixtures.RandomHex(), "secretKeyForTest111")
+	cfg.Encryption.EncryptMedia = true
+
+	result, err := encryption.Enable(cfg)
+
+	assert.Nil(t, err)
+	assert.Equal(t, encryption.EnableResult{
+		Driver:          "secretkey",
+		DEKCreated:      true,
+		MediaKeyCreated: true,
+	}, result)
+	_, err = os.Stat(path.Join(cfg.DataPath, "dek.json"))
+	assert.Nil(t, err)
+	_, err = os.Stat(path.Join(cfg.DataPath, "media_key.json"))
+	assert.Nil(t, err)
+
+	// existing keys are loaded when run again
+	result, err = encryption.Enable(cfg)
+	assert.Nil(t, err)
+	assert.False(t, result.DEKCreated)
+	assert.False(t, result.MediaKeyCreated)
+}
+
+func TestEnable_InvalidKEK(t *testing.T) {
+	randomHex := fixtures.RandomHex()
+	if _, err := configureEVSForTest(randomHex, "secretKeyForTest111"); err != nil {
+		panic(err)
+	}
+
+	// a different secret key can't load the existing DEK
+	_, err := encryption.Enable(encryptionConfigForTest(randomHex, "differentSecret111"))
+	assert.Equal(t, "failed to load DEK: keyset.Handle: decryption failed: aes_gcm_siv: message authentication failure", err.Error())
+
+	vault := newFakeVault()
+	defer vault.Close()
+	cfg := vaultConfigForTest(fixtures.RandomHex(), vault.URL, "castkeeper")
+	cfg.Encryption.VaultToken = "invalid"
+
+	_, err = encryption.Enable(cfg)
+	assert.Equal(t, "failed to verify KEK: vault transit encrypt failed with status 403: permission denied", err.Error())
+	exists, err := encryption.DEKExists(cfg)
+	assert.Nil(t, err)
+	assert.False(t, exists)
+}
+
+func TestEnable_NoDriver(t *testing.T) {
+	cfg := encryptionConfigForTest(fixtures.RandomHex(), "")
+	cfg.Encryption.Driver = ""
+
+	_, err := encryption.Enable(cfg)
+	assert.ErrorIs(t, err, encryption.ErrEncryptionNotConfigured)
+}
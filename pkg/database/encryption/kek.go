@@ -0,0 +1,72 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/tink-crypto/tink-go/v2/tink"
+	"github.com/webbgeorge/castkeeper/pkg/config"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+)
+
+const defaultVaultTransitMount = "transit"
+
+// configureKEK returns the key encryption key (KEK) of the configured driver,
+// which the DEK is encrypted with
+func configureKEK(cfg config.Config) (tink.AEAD, error) {
+	switch cfg.Encryption.Driver {
+	case config.EncryptionDriverSecretKey:
+		return DeriveAEADFromSecret(
+			cfg.Encryption.SecretKey,
+		)
+
+	case config.EncryptionDriverKeyFile:
+		return readKeyFile(cfg.Encryption.KeyFile)
+
+	case config.EncryptionDriverVault:
+		mount := cfg.Encryption.VaultTransitMount
+		if mount == "" {
+			mount = defaultVaultTransitMount
+		}
+		return &VaultTransitAEAD{
+			HTTPClient: framework.NewHTTPClient(30 * time.Second),
+			Address:    cfg.Encryption.VaultAddress,
+			Token:      cfg.Encryption.VaultToken,
+			Mount:      mount,
+			KeyName:    cfg.Encryption.VaultKeyName,
+		}, nil
+
+	case config.EncryptionDriverAWSKMS:
+		// uses aws environment variables to configure the SDK
+		awsCfg, err := awsConfig.LoadDefaultConfig(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		return &AWSKMSAEAD{
+			KMSClient: kms.NewFromConfig(awsCfg),
+			KeyID:     cfg.Encryption.AWSKMSKeyID,
+		}, nil
+
+	default:
+		return nil, ErrEncryptionNotConfigured
+	}
+}
+
+// readKeyFile derives a KEK from the secret in a key file, so that the secret
+// can be kept out of the config, e.g. as a docker or systemd secret
+func readKeyFile(keyFile string) (tink.AEAD, error) {
+	data, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	secret := strings.TrimSpace(string(data))
+	if len(secret) < 16 {
+		return nil, fmt.Errorf("key file '%s' must contain at least 16 characters", keyFile)
+	}
+	return DeriveAEADFromSecret(secret)
+}
@@ -0,0 +1,301 @@
+package encryption_test
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/config"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+)
+
+func TestConfigureEncryptedValueService_KeyFileDriver(t *testing.T) {
+	randomHex := fixtures.RandomHex()
+	cfg := keyFileConfigForTest(randomHex, "keyFileSecretForTest111\n")
+
+	evs, err := encryption.ConfigureEncryptedValueService(cfg)
+	assert.Nil(t, err)
+	ev, err := evs.Encrypt([]byte("test"), []byte("testad"))
+	if err != nil {
+		panic(err)
+	}
+
+	// the DEK is loaded with the key file, ignoring surrounding whitespace
+	evs2, err := encryption.ConfigureEncryptedValueService(cfg)
+	assert.Nil(t, err)
+	pt, err := evs2.Decrypt(ev, []byte("testad"))
+	assert.Nil(t, err)
+	assert.Equal(t, "test", string(pt))
+}
+
+func TestConfigureEncryptedValueService_KeyFileDriver_InvalidKeyFile(t *testing.T) {
+	_, err := encryption.ConfigureEncryptedValueService(keyFileConfigForTest(fixtures.RandomHex(), "short\n"))
+	assert.Contains(t, err.Error(), "must contain at least 16 characters")
+
+	cfg := keyFileConfigForTest(fixtures.RandomHex(), "")
+	cfg.Encryption.KeyFile = path.Join(cfg.DataPath, "missing.key")
+	_, err = encryption.ConfigureEncryptedValueService(cfg)
+	assert.Contains(t, err.Error(), "failed to read key file")
+}
+
+func TestConfigureEncryptedValueService_VaultDriver(t *testing.T) {
+	vault := newFakeVault()
+	defer vault.Close()
+	cfg := vaultConfigForTest(fixtures.RandomHex(), vault.URL, "castkeeper")
+
+	evs, err := encryption.ConfigureEncryptedValueService(cfg)
+	assert.Nil(t, err)
+	ev, err := evs.Encrypt([]byte("test"), []byte("testad"))
+	if err != nil {
+		panic(err)
+	}
+
+	// the DEK is saved encrypted by vault
+	dek, err := os.ReadFile(path.Join(cfg.DataPath, "dek.json"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(dek), base64.StdEncoding.EncodeToString([]byte("vault:v1:")))
+
+	evs2, err := encryption.ConfigureEncryptedValueService(cfg)
+	assert.Nil(t, err)
+	pt, err := evs2.Decrypt(ev, []byte("testad"))
+	assert.Nil(t, err)
+	assert.Equal(t, "test", string(pt))
+}
+
+func TestConfigureEncryptedValueService_VaultDriver_Error(t *testing.T) {
+	vault := newFakeVault()
+	defer vault.Close()
+	cfg := vaultConfigForTest(fixtures.RandomHex(), vault.URL, "castkeeper")
+	cfg.Encryption.VaultToken = "invalid"
+
+	_, err := encryption.ConfigureEncryptedValueService(cfg)
+	assert.Equal(t, "keyset.Handle: encryption failed: vault transit encrypt failed with status 403: permission denied", err.Error())
+}
+
+func TestMigrateKEK(t *testing.T) {
+	randomHex := fixtures.RandomHex()
+	evs, err := configureEVSForTest(randomHex, "secretKeyForTest111")
+	if err != nil {
+		panic(err)
+	}
+	ev, err := evs.Encrypt([]byte("test"), []byte("testad"))
+	if err != nil {
+		panic(err)
+	}
+	toCfg := keyFileConfigForTest(randomHex, "keyFileSecretForTest111")
+
+	err = encryption.MigrateKEK(encryptionConfigForTest(randomHex, "secretKeyForTest111"), toCfg)
+	assert.Nil(t, err)
+
+	// the secret key can no longer load the DEK
+	_, err = configureEVSForTest(randomHex, "secretKeyForTest111")
+	assert.NotNil(t, err)
+
+	// values encrypted before migration can be decrypted with the new KEK
+	evs2, err := encryption.ConfigureEncryptedValueService(toCfg)
+	assert.Nil(t, err)
+	pt, err := evs2.Decrypt(ev, []byte("testad"))
+	assert.Nil(t, err)
+	assert.Equal(t, "test", string(pt))
+}
+
+func TestMigrateKEK_NewKEKFails(t *testing.T) {
+	randomHex := fixtures.RandomHex()
+	if _, err := configureEVSForTest(randomHex, "secretKeyForTest111"); err != nil {
+		panic(err)
+	}
+	vault := newFakeVault()
+	defer vault.Close()
+	toCfg := vaultConfigForTest(randomHex, vault.URL, "castkeeper")
+	toCfg.Encryption.VaultToken = "invalid"
+
+	err := encryption.MigrateKEK(encryptionConfigForTest(randomHex, "secretKeyForTest111"), toCfg)
+	assert.Contains(t, err.Error(), "failed to use new KEK")
+
+	// the DEK is unchanged
+	_, err = configureEVSForTest(randomHex, "secretKeyForTest111")
+	assert.Nil(t, err)
+}
+
+func TestMigrateKEK_DifferentDataPath(t *testing.T) {
+	randomHex := fixtures.RandomHex()
+	if _, err := configureEVSForTest(randomHex, "secretKeyForTest111"); err != nil {
+		panic(err)
+	}
+
+	err := encryption.MigrateKEK(
+		encryptionConfigForTest(randomHex, "secretKeyForTest111"),
+		keyFileConfigForTest(fixtures.RandomHex(), "keyFileSecretForTest111"),
+	)
+	assert.Equal(t, "both configs must have the same DataPath", err.Error())
+}
+
+func TestRotateKEK_OtherDriver(t *testing.T) {
+	cfg := keyFileConfigForTest(fixtures.RandomHex(), "keyFileSecretForTest111")
+
+	err := encryption.RotateKEK(cfg, "newSecretKeyForTest222")
+	assert.Equal(t, "the 'keyfile' encryption driver has no secret key, migrate to a new KEK instead", err.Error())
+}
+
+// TestVaultTransitKEK runs against a HashiCorp Vault server in dev mode, e.g.
+// from docker-compose.yml, configured with CASTKEEPER_TEST_VAULT_ADDR and
+// CASTKEEPER_TEST_VAULT_TOKEN. See `make test_kek`.
+func TestVaultTransitKEK(t *testing.T) {
+	addr := os.Getenv("CASTKEEPER_TEST_VAULT_ADDR")
+	if addr == "" {
+		t.Skip("CASTKEEPER_TEST_VAULT_ADDR is not set")
+	}
+	token := os.Getenv("CASTKEEPER_TEST_VAULT_TOKEN")
+	setupVaultTransit(addr, token, "castkeeper")
+
+	randomHex := fixtures.RandomHex()
+	cfg := vaultConfigForTest(randomHex, addr, "castkeeper")
+	cfg.Encryption.VaultToken = token
+
+	testRemoteKEK(t, randomHex, cfg)
+}
+
+// TestAWSKMSKEK runs against AWS KMS or a compatible service, e.g. localstack
+// from docker-compose.yml, configured with the standard AWS environment
+// variables and CASTKEEPER_TEST_KMS_KEY_ID. See `make test_kek`.
+func TestAWSKMSKEK(t *testing.T) {
+	keyID := os.Getenv("CASTKEEPER_TEST_KMS_KEY_ID")
+	if keyID == "" {
+		t.Skip("CASTKEEPER_TEST_KMS_KEY_ID is not set")
+	}
+
+	randomHex := fixtures.RandomHex()
+	cfg := config.Config{
+		DataPath: path.Join(os.TempDir(), "castkeepertest", randomHex),
+		Encryption: config.EncryptionConfig{
+			Driver:      config.EncryptionDriverAWSKMS,
+			AWSKMSKeyID: keyID,
+		},
+	}
+
+	testRemoteKEK(t, randomHex, cfg)
+}
+
+// testRemoteKEK creates a DEK encrypted by a remote KEK, and checks a DEK can
+// be migrated to and from it
+func testRemoteKEK(t *testing.T, randomHex string, cfg config.Config) {
+	evs, err := encryption.ConfigureEncryptedValueService(cfg)
+	assert.Nil(t, err)
+	ev, err := evs.Encrypt([]byte("test"), []byte("testad"))
+	assert.Nil(t, err)
+
+	evs2, err := encryption.ConfigureEncryptedValueService(cfg)
+	assert.Nil(t, err)
+	pt, err := evs2.Decrypt(ev, []byte("testad"))
+	assert.Nil(t, err)
+	assert.Equal(t, "test", string(pt))
+
+	secretKeyCfg := encryptionConfigForTest(randomHex, "secretKeyForTest111")
+	assert.Nil(t, encryption.MigrateKEK(cfg, secretKeyCfg))
+	assert.Nil(t, encryption.MigrateKEK(secretKeyCfg, cfg))
+
+	evs3, err := encryption.ConfigureEncryptedValueService(cfg)
+	assert.Nil(t, err)
+	pt, err = evs3.Decrypt(ev, []byte("testad"))
+	assert.Nil(t, err)
+	assert.Equal(t, "test", string(pt))
+}
+
+// setupVaultTransit enables the transit secrets engine and creates a key, if
+// they don't exist yet
+func setupVaultTransit(addr, token, keyName string) {
+	for _, req := range []struct{ path, body string }{
+		{"/v1/sys/mounts/transit", `{"type":"transit"}`},
+		{"/v1/transit/keys/" + keyName, `{}`},
+	} {
+		r, err := http.NewRequest(http.MethodPost, addr+req.path, strings.NewReader(req.body))
+		if err != nil {
+			panic(err)
+		}
+		r.Header.Set("X-Vault-Token", token)
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			panic(err)
+		}
+		// fails if transit is already enabled
+		_ = res.Body.Close()
+	}
+}
+
+// newFakeVault returns a server which implements the encrypt and decrypt
+// endpoints of vault's transit secrets engine, without encrypting anything
+func newFakeVault() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "test-token" {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/v1/transit/encrypt/castkeeper":
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"data": map[string]string{"ciphertext": "vault:v1:" + body["plaintext"]},
+			})
+		case "/v1/transit/decrypt/castkeeper":
+			plaintext, ok := strings.CutPrefix(body["ciphertext"], "vault:v1:")
+			if !ok {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"errors":["invalid ciphertext"]}`))
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"data": map[string]string{"plaintext": plaintext},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+}
+
+func keyFileConfigForTest(randomHex, key string) config.Config {
+	rootPath := path.Join(os.TempDir(), "castkeepertest", randomHex)
+	if err := os.MkdirAll(rootPath, 0o700); err != nil {
+		panic(err)
+	}
+	keyFile := path.Join(rootPath, "kek.key")
+	if key != "" {
+		if err := os.WriteFile(keyFile, []byte(key), 0o600); err != nil {
+			panic(err)
+		}
+	}
+	return config.Config{
+		DataPath: rootPath,
+		Encryption: config.EncryptionConfig{
+			Driver:  config.EncryptionDriverKeyFile,
+			KeyFile: keyFile,
+		},
+	}
+}
+
+func vaultConfigForTest(randomHex, addr, keyName string) config.Config {
+	return config.Config{
+		DataPath: path.Join(os.TempDir(), "castkeepertest", randomHex),
+		Encryption: config.EncryptionConfig{
+			Driver:       config.EncryptionDriverVault,
+			VaultAddress: addr,
+			VaultToken:   "test-token",
+			VaultKeyName: keyName,
+		},
+	}
+}
@@ -0,0 +1,320 @@
+package encryption
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/tink-crypto/tink-go/v2/keyset"
+	"github.com/tink-crypto/tink-go/v2/streamingaead"
+	"github.com/tink-crypto/tink-go/v2/tink"
+	"github.com/webbgeorge/castkeeper/pkg/config"
+)
+
+const (
+	mediaKeyFileName = "media_key.json"
+
+	// media files are encrypted in chunks, each of which is a separate
+	// streaming AEAD ciphertext, so that a file can be decrypted from any
+	// offset by only decrypting the chunk containing it
+	mediaChunkSize = 256 * 1024
+	// each chunk fits in a single segment of the media key, so the ciphertext
+	// is the AES-GCM-HKDF header (size byte, 32 byte salt and 7 byte nonce
+	// prefix) and a 16 byte tag larger than the plaintext
+	mediaChunkOverhead       = 1 + 32 + 7 + 16
+	mediaCiphertextChunkSize = mediaChunkSize + mediaChunkOverhead
+)
+
+// mediaMagic starts every encrypted media file, and identifies the version of
+// the format. It is followed by a random ID of the file, which every chunk is
+// bound to.
+const (
+	mediaMagic      = "CKMEDIA\x01"
+	mediaFileIDSize = 16
+	mediaHeaderSize = len(mediaMagic) + mediaFileIDSize
+)
+
+var ErrNotEncryptedMedia = errors.New("file is not encrypted media")
+
+// MediaEncryptionService encrypts media files with a streaming AEAD key, which
+// is itself encrypted with the DEK
+type MediaEncryptionService struct {
+	streamingAEAD tink.StreamingAEAD
+}
+
+func NewMediaEncryptionService(streamingAEAD tink.StreamingAEAD) (*MediaEncryptionService, error) {
+	s := &MediaEncryptionService{
+		streamingAEAD: streamingAEAD,
+	}
+
+	// the size of encrypted files is calculated from the chunk overhead, so
+	// keys which don't match it can't be used
+	chunk, err := s.encryptChunk(make([]byte, mediaChunkSize), make([]byte, mediaFileIDSize), 0, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunk) != mediaCiphertextChunkSize {
+		return nil, errors.New("media key is not an AES256 GCM HKDF 1MB key")
+	}
+
+	return s, nil
+}
+
+// ConfigureMediaEncryptionService loads the media key, or creates it if it
+// doesn't exist yet. It returns nil if media encryption is not enabled.
+func ConfigureMediaEncryptionService(
+	cfg config.Config,
+	evs *EncryptedValueService,
+) (*MediaEncryptionService, error) {
+	if !cfg.Encryption.EncryptMedia {
+		return nil, nil
+	}
+	if evs == nil {
+		return nil, ErrEncryptionNotConfigured
+	}
+
+	handle, err := loadOrCreateKeyset(
+		evs.dekAEAD,
+		openDataDir(cfg),
+		mediaKeyFileName,
+		streamingaead.AES256GCMHKDF1MBKeyTemplate(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load media key: %w", err)
+	}
+
+	return newMediaEncryptionServiceFromKeyset(handle)
+}
+
+func newMediaEncryptionServiceFromKeyset(handle *keyset.Handle) (*MediaEncryptionService, error) {
+	streamingAEAD, err := streamingaead.New(handle)
+	if err != nil {
+		return nil, err
+	}
+	return NewMediaEncryptionService(streamingAEAD)
+}
+
+// EncryptReader returns a reader of the encrypted contents of r
+func (s *MediaEncryptionService) EncryptReader(r io.Reader) io.Reader {
+	return &encryptingReader{
+		s:     s,
+		src:   bufio.NewReader(r),
+		chunk: make([]byte, mediaChunkSize),
+	}
+}
+
+// DecryptReader returns a reader of the decrypted contents of r, which is an
+// encrypted media file of ciphertextSize bytes. The reader can seek to any
+// offset, and only decrypts the chunks which are read.
+func (s *MediaEncryptionService) DecryptReader(r io.ReadSeeker, ciphertextSize int64) (io.ReadSeeker, error) {
+	size, err := MediaPlaintextSize(ciphertextSize)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, mediaHeaderSize)
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:len(mediaMagic)]) != mediaMagic {
+		return nil, ErrNotEncryptedMedia
+	}
+
+	return &decryptingReader{
+		s:              s,
+		r:              r,
+		fileID:         header[len(mediaMagic):],
+		ciphertextSize: ciphertextSize,
+		size:           size,
+		chunks:         mediaChunkCount(ciphertextSize),
+		chunkIndex:     -1,
+	}, nil
+}
+
+// MediaPlaintextSize is the size of an encrypted media file once decrypted
+func MediaPlaintextSize(ciphertextSize int64) (int64, error) {
+	body := ciphertextSize - int64(mediaHeaderSize)
+	lastChunk := body % mediaCiphertextChunkSize
+	if body < mediaChunkOverhead || (lastChunk > 0 && lastChunk < mediaChunkOverhead) {
+		return 0, errors.New("encrypted media file is truncated")
+	}
+	return body - mediaChunkCount(ciphertextSize)*mediaChunkOverhead, nil
+}
+
+func mediaChunkCount(ciphertextSize int64) int64 {
+	body := ciphertextSize - int64(mediaHeaderSize)
+	return (body + mediaCiphertextChunkSize - 1) / mediaCiphertextChunkSize
+}
+
+func (s *MediaEncryptionService) encryptChunk(plaintext, fileID []byte, index int64, final bool) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := s.streamingAEAD.NewEncryptingWriter(&buf, chunkAssociatedData(fileID, index, final))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (s *MediaEncryptionService) decryptChunk(ciphertext, fileID []byte, index int64, final bool) ([]byte, error) {
+	r, err := s.streamingAEAD.NewDecryptingReader(bytes.NewReader(ciphertext), chunkAssociatedData(fileID, index, final))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// chunkAssociatedData binds each chunk to its file and its position in it, so
+// that chunks can't be reordered, swapped between files, or the file
+// truncated, without decryption failing
+func chunkAssociatedData(fileID []byte, index int64, final bool) []byte {
+	ad := make([]byte, 0, mediaHeaderSize+9)
+	ad = append(ad, mediaMagic...)
+	ad = append(ad, fileID...)
+	ad = binary.BigEndian.AppendUint64(ad, uint64(index))
+	if final {
+		return append(ad, 1)
+	}
+	return append(ad, 0)
+}
+
+type encryptingReader struct {
+	s      *MediaEncryptionService
+	src    *bufio.Reader
+	chunk  []byte
+	fileID []byte
+	index  int64
+	// encrypted data which hasn't been read yet
+	buf  bytes.Buffer
+	done bool
+	err  error
+}
+
+func (r *encryptingReader) Read(b []byte) (int, error) {
+	for r.buf.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if r.done {
+			return 0, io.EOF
+		}
+		r.err = r.encryptNextChunk()
+	}
+	return r.buf.Read(b)
+}
+
+func (r *encryptingReader) encryptNextChunk() error {
+	if r.index == 0 {
+		r.fileID = make([]byte, mediaFileIDSize)
+		if _, err := rand.Read(r.fileID); err != nil {
+			return err
+		}
+		r.buf.WriteString(mediaMagic)
+		r.buf.Write(r.fileID)
+	}
+
+	n, err := io.ReadFull(r.src, r.chunk)
+	final := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	if err != nil && !final {
+		return err
+	}
+	if !final {
+		// a full chunk is only the final chunk if nothing follows it
+		_, err := r.src.Peek(1)
+		final = errors.Is(err, io.EOF)
+		if err != nil && !final {
+			return err
+		}
+	}
+
+	ciphertext, err := r.s.encryptChunk(r.chunk[:n], r.fileID, r.index, final)
+	if err != nil {
+		return err
+	}
+	r.buf.Write(ciphertext)
+	r.index++
+	r.done = final
+	return nil
+}
+
+type decryptingReader struct {
+	s              *MediaEncryptionService
+	r              io.ReadSeeker
+	fileID         []byte
+	ciphertextSize int64
+	size           int64
+	chunks         int64
+	offset         int64
+	// the most recently decrypted chunk, where chunkIndex is -1 before any
+	// chunk is decrypted
+	chunk      []byte
+	chunkIndex int64
+}
+
+func (r *decryptingReader) Read(b []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+
+	index := r.offset / mediaChunkSize
+	if index != r.chunkIndex {
+		if err := r.decryptChunk(index); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, r.chunk[r.offset-index*mediaChunkSize:])
+	r.offset += int64(n)
+	return n, nil
+}
+
+func (r *decryptingReader) decryptChunk(index int64) error {
+	start := int64(mediaHeaderSize) + index*mediaCiphertextChunkSize
+	if _, err := r.r.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	ciphertext := make([]byte, min(mediaCiphertextChunkSize, r.ciphertextSize-start))
+	if _, err := io.ReadFull(r.r, ciphertext); err != nil {
+		return err
+	}
+
+	plaintext, err := r.s.decryptChunk(ciphertext, r.fileID, index, index == r.chunks-1)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt chunk %d: %w", index, err)
+	}
+
+	r.chunk = plaintext
+	r.chunkIndex = index
+	return nil
+}
+
+func (r *decryptingReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("negative position")
+	}
+	r.offset = abs
+	return abs, nil
+}
@@ -0,0 +1,186 @@
+package encryption_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/config"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+)
+
+const testChunkSize = 256 * 1024
+
+func TestMediaEncryption_RoundTrip(t *testing.T) {
+	mes := fixtures.ConfigureMediaEncryptionServiceForTest()
+
+	for _, size := range []int{0, 1, testChunkSize - 1, testChunkSize, testChunkSize + 1, 3*testChunkSize + 100} {
+		plaintext := randomBytes(size)
+		ciphertext := encryptMedia(mes, plaintext)
+
+		if size >= 64 {
+			assert.NotContains(t, string(ciphertext), string(plaintext[:64]))
+		}
+		plaintextSize, err := encryption.MediaPlaintextSize(int64(len(ciphertext)))
+		assert.Nil(t, err)
+		assert.Equal(t, int64(size), plaintextSize)
+
+		r, err := mes.DecryptReader(bytes.NewReader(ciphertext), int64(len(ciphertext)))
+		assert.Nil(t, err)
+		decrypted, err := io.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, plaintext, decrypted, "size %d", size)
+	}
+}
+
+func TestMediaEncryption_Seek(t *testing.T) {
+	mes := fixtures.ConfigureMediaEncryptionServiceForTest()
+	plaintext := randomBytes(3*testChunkSize + 100)
+	ciphertext := encryptMedia(mes, plaintext)
+
+	r, err := mes.DecryptReader(bytes.NewReader(ciphertext), int64(len(ciphertext)))
+	if err != nil {
+		panic(err)
+	}
+
+	for _, offset := range []int64{2*testChunkSize + 5, 10, testChunkSize - 2, 3 * testChunkSize} {
+		pos, err := r.Seek(offset, io.SeekStart)
+		assert.Nil(t, err)
+		assert.Equal(t, offset, pos)
+		b := make([]byte, 50)
+		_, err = io.ReadFull(r, b)
+		assert.Nil(t, err)
+		assert.Equal(t, plaintext[offset:offset+50], b)
+	}
+
+	end, err := r.Seek(0, io.SeekEnd)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(plaintext)), end)
+	_, err = r.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMediaEncryption_Tampered(t *testing.T) {
+	mes := fixtures.ConfigureMediaEncryptionServiceForTest()
+	ciphertext := encryptMedia(mes, randomBytes(2*testChunkSize))
+
+	ciphertext[testChunkSize+1000] ^= 1
+
+	r, err := mes.DecryptReader(bytes.NewReader(ciphertext), int64(len(ciphertext)))
+	assert.Nil(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorContains(t, err, "failed to decrypt chunk 1")
+}
+
+func TestMediaEncryption_ChunkFromOtherFile(t *testing.T) {
+	mes := fixtures.ConfigureMediaEncryptionServiceForTest()
+	ciphertext := encryptMedia(mes, randomBytes(2*testChunkSize))
+	other := encryptMedia(mes, randomBytes(2*testChunkSize))
+
+	// replaces the first chunk with the first chunk of another file, which is
+	// at the same position and encrypted with the same key
+	chunkStart := len(ciphertext) - 2*(testChunkSize+56)
+	copy(ciphertext[chunkStart:chunkStart+testChunkSize+56], other[chunkStart:])
+
+	r, err := mes.DecryptReader(bytes.NewReader(ciphertext), int64(len(ciphertext)))
+	assert.Nil(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorContains(t, err, "failed to decrypt chunk 0")
+}
+
+func TestMediaEncryption_TruncatedAtChunk(t *testing.T) {
+	mes := fixtures.ConfigureMediaEncryptionServiceForTest()
+	ciphertext := encryptMedia(mes, randomBytes(2*testChunkSize+10))
+
+	// removes the final chunk, leaving a file which looks complete
+	truncated := ciphertext[:len(ciphertext)-(10+56)]
+
+	r, err := mes.DecryptReader(bytes.NewReader(truncated), int64(len(truncated)))
+	assert.Nil(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorContains(t, err, "failed to decrypt chunk 1")
+}
+
+func TestMediaEncryption_NotEncrypted(t *testing.T) {
+	mes := fixtures.ConfigureMediaEncryptionServiceForTest()
+	plaintext := randomBytes(1000)
+
+	_, err := mes.DecryptReader(bytes.NewReader(plaintext), int64(len(plaintext)))
+
+	assert.ErrorIs(t, err, encryption.ErrNotEncryptedMedia)
+}
+
+func TestMediaEncryption_DifferentKey(t *testing.T) {
+	ciphertext := encryptMedia(fixtures.ConfigureMediaEncryptionServiceForTest(), []byte("test"))
+
+	r, err := fixtures.ConfigureMediaEncryptionServiceForTest().DecryptReader(bytes.NewReader(ciphertext), int64(len(ciphertext)))
+	assert.Nil(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorContains(t, err, "failed to decrypt chunk 0")
+}
+
+func TestConfigureMediaEncryptionService_CreatesAndLoadsKey(t *testing.T) {
+	randomHex := fixtures.RandomHex()
+	rootPath := path.Join(os.TempDir(), "castkeepertest", randomHex)
+	cfg := config.Config{
+		DataPath: rootPath,
+		Encryption: config.EncryptionConfig{
+			Driver:       "secretkey",
+			SecretKey:    "secretKeyForTest111",
+			EncryptMedia: true,
+		},
+	}
+
+	evs, err := encryption.ConfigureEncryptedValueService(cfg)
+	if err != nil {
+		panic(err)
+	}
+	mes, err := encryption.ConfigureMediaEncryptionService(cfg, evs)
+	assert.Nil(t, err)
+	_, err = os.Stat(path.Join(rootPath, "media_key.json"))
+	assert.Nil(t, err)
+	ciphertext := encryptMedia(mes, []byte("test"))
+
+	// a second service loads the same key
+	mes2, err := encryption.ConfigureMediaEncryptionService(cfg, evs)
+	assert.Nil(t, err)
+	r, err := mes2.DecryptReader(bytes.NewReader(ciphertext), int64(len(ciphertext)))
+	assert.Nil(t, err)
+	plaintext, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "test", string(plaintext))
+}
+
+func TestConfigureMediaEncryptionService_Disabled(t *testing.T) {
+	mes, err := encryption.ConfigureMediaEncryptionService(config.Config{}, nil)
+
+	assert.Nil(t, err)
+	assert.Nil(t, mes)
+}
+
+func TestConfigureMediaEncryptionService_NoDriver(t *testing.T) {
+	_, err := encryption.ConfigureMediaEncryptionService(config.Config{
+		Encryption: config.EncryptionConfig{EncryptMedia: true},
+	}, nil)
+
+	assert.ErrorIs(t, err, encryption.ErrEncryptionNotConfigured)
+}
+
+func encryptMedia(mes *encryption.MediaEncryptionService, plaintext []byte) []byte {
+	ciphertext, err := io.ReadAll(mes.EncryptReader(bytes.NewReader(plaintext)))
+	if err != nil {
+		panic(err)
+	}
+	return ciphertext
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return b
+}
@@ -0,0 +1,202 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/tink-crypto/tink-go/v2/aead"
+	"github.com/tink-crypto/tink-go/v2/keyset"
+	"github.com/tink-crypto/tink-go/v2/tink"
+	"github.com/webbgeorge/castkeeper/pkg/config"
+)
+
+var ErrSecretKeyUnchanged = errors.New("new secret key is the same as the current secret key")
+
+// RotateKEK re-encrypts the DEK with a KEK derived from newSecretKey. Values
+// encrypted with the DEK are unchanged. The Encryption.SecretKey config option
+// must be changed to newSecretKey before CastKeeper is next started.
+func RotateKEK(cfg config.Config, newSecretKey string) error {
+	switch cfg.Encryption.Driver {
+	case config.EncryptionDriverSecretKey:
+	case "":
+		return ErrEncryptionNotConfigured
+	default:
+		return fmt.Errorf("the '%s' encryption driver has no secret key, migrate to a new KEK instead", cfg.Encryption.Driver)
+	}
+	if len(newSecretKey) < 16 || len(newSecretKey) > 64 {
+		return errors.New("new secret key must be between 16 and 64 characters")
+	}
+	if newSecretKey == cfg.Encryption.SecretKey {
+		return ErrSecretKeyUnchanged
+	}
+
+	toCfg := cfg
+	toCfg.Encryption.SecretKey = newSecretKey
+	return MigrateKEK(cfg, toCfg)
+}
+
+// MigrateKEK re-encrypts the DEK, which is encrypted with the KEK of cfg, with
+// the KEK of toCfg, e.g. to move from the secretkey driver to AWS KMS. Values
+// encrypted with the DEK are unchanged. The Encryption config must be changed
+// to that of toCfg before CastKeeper is next started.
+func MigrateKEK(cfg, toCfg config.Config) error {
+	if toCfg.Encryption.Driver == "" {
+		return ErrEncryptionNotConfigured
+	}
+	if path.Clean(cfg.DataPath) != path.Clean(toCfg.DataPath) {
+		return errors.New("both configs must have the same DataPath")
+	}
+
+	_, dataDir, handle, err := loadDEKKeyset(cfg)
+	if err != nil {
+		return err
+	}
+
+	// checks the new KEK works before the DEK is replaced
+	newKEKAEAD, err := configureKEK(toCfg)
+	if err != nil {
+		return err
+	}
+	if err := verifyKEK(newKEKAEAD); err != nil {
+		return fmt.Errorf("failed to use new KEK: %w", err)
+	}
+
+	if err := writeKeyset(newKEKAEAD, dataDir, dekFileName, handle); err != nil {
+		return fmt.Errorf("failed to save DEK: %w", err)
+	}
+
+	// checks the saved DEK can be loaded before the old KEK is discarded
+	if _, err := loadKeyset(newKEKAEAD, dataDir, dekFileName); err != nil {
+		return fmt.Errorf("failed to load migrated DEK: %w", err)
+	}
+	return nil
+}
+
+// AddDEKPrimaryKey adds a new key to the DEK, which values are encrypted with
+// from then on. The DEK's old keys are kept, so that existing values can still
+// be decrypted until they are re-encrypted and RemoveOldDEKKeys is called. The
+// returned service uses the rotated DEK.
+func AddDEKPrimaryKey(cfg config.Config) (*EncryptedValueService, error) {
+	kekAEAD, dataDir, handle, err := loadDEKKeyset(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	manager := keyset.NewManagerFromHandle(handle)
+	keyID, err := manager.Add(aead.AES256GCMSIVKeyTemplate())
+	if err != nil {
+		return nil, err
+	}
+	if err := manager.SetPrimary(keyID); err != nil {
+		return nil, err
+	}
+	handle, err = manager.Handle()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeKeyset(kekAEAD, dataDir, dekFileName, handle); err != nil {
+		return nil, fmt.Errorf("failed to save DEK: %w", err)
+	}
+
+	dekAEAD, err := aead.New(handle)
+	if err != nil {
+		return nil, err
+	}
+	return NewEncryptedValueService(dekAEAD), nil
+}
+
+// RemoveOldDEKKeys removes every key of the DEK except its primary key. The
+// media key, which is encrypted with the DEK, is re-encrypted with the primary
+// key first. It must only be called once every value encrypted with the DEK
+// has been re-encrypted with the primary key, as values encrypted with the old
+// keys can't be decrypted once they are removed.
+func RemoveOldDEKKeys(cfg config.Config) error {
+	kekAEAD, dataDir, handle, err := loadDEKKeyset(cfg)
+	if err != nil {
+		return err
+	}
+
+	dekAEAD, err := aead.New(handle)
+	if err != nil {
+		return err
+	}
+	if err := rewrapKeyset(dekAEAD, dataDir, mediaKeyFileName); err != nil {
+		return fmt.Errorf("failed to re-encrypt media key: %w", err)
+	}
+
+	primary, err := handle.Primary()
+	if err != nil {
+		return err
+	}
+	manager := keyset.NewManagerFromHandle(handle)
+	for _, info := range handle.KeysetInfo().GetKeyInfo() {
+		if info.GetKeyId() == primary.KeyID() {
+			continue
+		}
+		if err := manager.Delete(info.GetKeyId()); err != nil {
+			return err
+		}
+	}
+	handle, err = manager.Handle()
+	if err != nil {
+		return err
+	}
+
+	if err := writeKeyset(kekAEAD, dataDir, dekFileName, handle); err != nil {
+		return fmt.Errorf("failed to save DEK: %w", err)
+	}
+	return nil
+}
+
+func loadDEKKeyset(cfg config.Config) (kekAEAD tink.AEAD, dataDir *os.Root, handle *keyset.Handle, err error) {
+	if cfg.Encryption.Driver == "" {
+		return nil, nil, nil, ErrEncryptionNotConfigured
+	}
+
+	kekAEAD, err = configureKEK(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	dataDir = openDataDir(cfg)
+	handle, err = loadKeyset(kekAEAD, dataDir, dekFileName)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to load DEK: %w", err)
+	}
+	return kekAEAD, dataDir, handle, nil
+}
+
+// rewrapKeyset re-encrypts a keyset encrypted with a key of dekAEAD with its
+// primary key, if the keyset exists
+func rewrapKeyset(dekAEAD tink.AEAD, dataDir *os.Root, fileName string) error {
+	exists, err := keysetExists(dataDir, fileName)
+	if err != nil || !exists {
+		return err
+	}
+	handle, err := loadKeyset(dekAEAD, dataDir, fileName)
+	if err != nil {
+		return err
+	}
+	return writeKeyset(dekAEAD, dataDir, fileName, handle)
+}
+
+// verifyKEK checks that a value can be encrypted and decrypted with a KEK, so
+// that e.g. a misconfigured Vault or KMS key is found before it is used
+func verifyKEK(kekAEAD tink.AEAD) error {
+	plaintext := []byte("castkeeper:verify-kek")
+	ciphertext, err := kekAEAD.Encrypt(plaintext, nil)
+	if err != nil {
+		return err
+	}
+	decrypted, err := kekAEAD.Decrypt(ciphertext, nil)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(plaintext, decrypted) {
+		return errors.New("decrypted value does not match")
+	}
+	return nil
+}
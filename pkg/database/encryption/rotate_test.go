@@ -0,0 +1,132 @@
+package encryption_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/config"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+)
+
+func TestRotateKEK(t *testing.T) {
+	randomHex := fixtures.RandomHex()
+	evs, err := configureEVSForTest(randomHex, "secretKeyForTest111")
+	if err != nil {
+		panic(err)
+	}
+	ev, err := evs.Encrypt([]byte("test"), []byte("testad"))
+	if err != nil {
+		panic(err)
+	}
+
+	err = encryption.RotateKEK(encryptionConfigForTest(randomHex, "secretKeyForTest111"), "newSecretKeyForTest222")
+	assert.Nil(t, err)
+
+	// the old secret can no longer load the DEK
+	_, err = configureEVSForTest(randomHex, "secretKeyForTest111")
+	assert.NotNil(t, err)
+
+	// values encrypted before rotation can be decrypted with the new secret
+	evs2, err := configureEVSForTest(randomHex, "newSecretKeyForTest222")
+	assert.Nil(t, err)
+	pt, err := evs2.Decrypt(ev, []byte("testad"))
+	assert.Nil(t, err)
+	assert.Equal(t, "test", string(pt))
+}
+
+func TestRotateKEK_InvalidSecretKey(t *testing.T) {
+	randomHex := fixtures.RandomHex()
+	if _, err := configureEVSForTest(randomHex, "secretKeyForTest111"); err != nil {
+		panic(err)
+	}
+	cfg := encryptionConfigForTest(randomHex, "secretKeyForTest111")
+
+	err := encryption.RotateKEK(cfg, "secretKeyForTest111")
+	assert.ErrorIs(t, err, encryption.ErrSecretKeyUnchanged)
+
+	err = encryption.RotateKEK(cfg, "short")
+	assert.Equal(t, "new secret key must be between 16 and 64 characters", err.Error())
+
+	// the DEK can still be loaded with the current secret
+	_, err = configureEVSForTest(randomHex, "secretKeyForTest111")
+	assert.Nil(t, err)
+}
+
+func TestRotateKEK_NoDriver(t *testing.T) {
+	cfg := encryptionConfigForTest(fixtures.RandomHex(), "")
+	cfg.Encryption.Driver = ""
+
+	err := encryption.RotateKEK(cfg, "newSecretKeyForTest222")
+	assert.ErrorIs(t, err, encryption.ErrEncryptionNotConfigured)
+}
+
+func TestRotateDEK(t *testing.T) {
+	randomHex := fixtures.RandomHex()
+	secret := "secretKeyForTest111"
+	cfg := encryptionConfigForTest(randomHex, secret)
+	cfg.Encryption.EncryptMedia = true
+
+	evs, err := configureEVSForTest(randomHex, secret)
+	if err != nil {
+		panic(err)
+	}
+	ev, err := evs.Encrypt([]byte("test"), []byte("testad"))
+	if err != nil {
+		panic(err)
+	}
+	mes, err := encryption.ConfigureMediaEncryptionService(cfg, evs)
+	if err != nil {
+		panic(err)
+	}
+	media := encryptMedia(mes, []byte("media"))
+
+	rotatedEVS, err := encryption.AddDEKPrimaryKey(cfg)
+	assert.Nil(t, err)
+
+	// values encrypted with the old key can still be decrypted
+	pt, err := rotatedEVS.Decrypt(ev, []byte("testad"))
+	assert.Nil(t, err)
+	assert.Equal(t, "test", string(pt))
+	assert.Equal(t, 2, len(readKeyset(path.Join(os.TempDir(), "castkeepertest", randomHex), secret).KeysetInfo().GetKeyInfo()))
+
+	reencrypted, err := rotatedEVS.Encrypt(pt, []byte("testad"))
+	if err != nil {
+		panic(err)
+	}
+
+	err = encryption.RemoveOldDEKKeys(cfg)
+	assert.Nil(t, err)
+
+	// only the new key is kept
+	evs2, err := configureEVSForTest(randomHex, secret)
+	assert.Nil(t, err)
+	_, err = evs2.Decrypt(ev, []byte("testad"))
+	assert.Equal(t, "aead_factory: decryption failed", err.Error())
+	pt, err = evs2.Decrypt(reencrypted, []byte("testad"))
+	assert.Nil(t, err)
+	assert.Equal(t, "test", string(pt))
+
+	// the media key was re-encrypted, so media can still be decrypted
+	mes2, err := encryption.ConfigureMediaEncryptionService(cfg, evs2)
+	assert.Nil(t, err)
+	r, err := mes2.DecryptReader(bytes.NewReader(media), int64(len(media)))
+	assert.Nil(t, err)
+	decrypted, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "media", string(decrypted))
+}
+
+func encryptionConfigForTest(randomHex, secret string) config.Config {
+	return config.Config{
+		DataPath: path.Join(os.TempDir(), "castkeepertest", randomHex),
+		Encryption: config.EncryptionConfig{
+			Driver:    config.EncryptionDriverSecretKey,
+			SecretKey: secret,
+		},
+	}
+}
@@ -0,0 +1,117 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const vaultRequestTimeout = 30 * time.Second
+
+// VaultTransitAEAD encrypts with a key of HashiCorp Vault's transit secrets
+// engine, so that the key never leaves Vault
+type VaultTransitAEAD struct {
+	HTTPClient *http.Client
+	Address    string
+	Token      string
+	Mount      string
+	KeyName    string
+}
+
+type vaultTransitRequest struct {
+	Plaintext      string `json:"plaintext,omitempty"`
+	Ciphertext     string `json:"ciphertext,omitempty"`
+	AssociatedData string `json:"associated_data,omitempty"`
+}
+
+type vaultTransitResponse struct {
+	Data struct {
+		Plaintext  string `json:"plaintext"`
+		Ciphertext string `json:"ciphertext"`
+	} `json:"data"`
+	Errors []string `json:"errors"`
+}
+
+func (a *VaultTransitAEAD) Encrypt(plaintext, associatedData []byte) ([]byte, error) {
+	resp, err := a.do("encrypt", vaultTransitRequest{
+		Plaintext:      base64.StdEncoding.EncodeToString(plaintext),
+		AssociatedData: encodeAssociatedData(associatedData),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Data.Ciphertext == "" {
+		return nil, errors.New("vault transit encrypt returned no ciphertext")
+	}
+	return []byte(resp.Data.Ciphertext), nil
+}
+
+func (a *VaultTransitAEAD) Decrypt(ciphertext, associatedData []byte) ([]byte, error) {
+	resp, err := a.do("decrypt", vaultTransitRequest{
+		Ciphertext:     string(ciphertext),
+		AssociatedData: encodeAssociatedData(associatedData),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Data.Plaintext)
+}
+
+func (a *VaultTransitAEAD) do(operation string, reqBody vaultTransitRequest) (vaultTransitResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), vaultRequestTimeout)
+	defer cancel()
+
+	body, err := json.Marshal(reqBody)
+	if err != nil {
+		return vaultTransitResponse{}, err
+	}
+
+	reqURL := fmt.Sprintf(
+		"%s/v1/%s/%s/%s",
+		strings.TrimSuffix(a.Address, "/"),
+		strings.Trim(a.Mount, "/"),
+		operation,
+		url.PathEscape(a.KeyName),
+	)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(body))
+	if err != nil {
+		return vaultTransitResponse{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Vault-Token", a.Token)
+
+	res, err := a.HTTPClient.Do(req)
+	if err != nil {
+		return vaultTransitResponse{}, fmt.Errorf("vault transit %s failed: %w", operation, err)
+	}
+	defer res.Body.Close()
+
+	var resBody vaultTransitResponse
+	// errors are reported by status, so an unparseable body is only an error
+	// when the request succeeded
+	decodeErr := json.NewDecoder(res.Body).Decode(&resBody)
+	if res.StatusCode != http.StatusOK {
+		return vaultTransitResponse{}, fmt.Errorf(
+			"vault transit %s failed with status %d: %s",
+			operation, res.StatusCode, strings.Join(resBody.Errors, ", "),
+		)
+	}
+	if decodeErr != nil {
+		return vaultTransitResponse{}, fmt.Errorf("failed to read vault transit %s response: %w", operation, decodeErr)
+	}
+	return resBody, nil
+}
+
+func encodeAssociatedData(associatedData []byte) string {
+	if len(associatedData) == 0 {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(associatedData)
+}
@@ -19,6 +19,12 @@ type migration interface {
 var allMigrations = []migration{
 	migrations.Migration001Init{},
 	migrations.Migration002AddPodcastCredentials{},
+	migrations.Migration003AddPodcastFeeds{},
+	migrations.Migration004AddEpisodeDownloadProgress{},
+	migrations.Migration005AddEpisodeIntegrity{},
+	migrations.Migration006AddEpisodeMediaInfo{},
+	migrations.Migration007AddStorageQuotas{},
+	migrations.Migration008AddEpisodeBlobs{},
 }
 
 type appliedMigration struct {
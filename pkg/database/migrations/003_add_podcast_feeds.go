@@ -0,0 +1,29 @@
+package migrations
+
+import (
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"gorm.io/gorm"
+)
+
+type Migration003AddPodcastFeeds struct{}
+
+func (m Migration003AddPodcastFeeds) Name() string {
+	return "003-add-podcast-feeds"
+}
+
+func (m Migration003AddPodcastFeeds) Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&podcasts.PodcastFeed{}); err != nil {
+		return err
+	}
+	if !db.Migrator().HasColumn(&podcasts.Podcast{}, "IsPremium") {
+		if err := db.Migrator().AddColumn(&podcasts.Podcast{}, "IsPremium"); err != nil {
+			return err
+		}
+	}
+	if !db.Migrator().HasColumn(&podcasts.Episode{}, "FeedID") {
+		if err := db.Migrator().AddColumn(&podcasts.Episode{}, "FeedID"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"gorm.io/gorm"
+)
+
+type Migration004AddEpisodeDownloadProgress struct{}
+
+func (m Migration004AddEpisodeDownloadProgress) Name() string {
+	return "004-add-episode-download-progress"
+}
+
+func (m Migration004AddEpisodeDownloadProgress) Migrate(db *gorm.DB) error {
+	for _, column := range []string{"DownloadedBytes", "DownloadTotalBytes"} {
+		if !db.Migrator().HasColumn(&podcasts.Episode{}, column) {
+			if err := db.Migrator().AddColumn(&podcasts.Episode{}, column); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
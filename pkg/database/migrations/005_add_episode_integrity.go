@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"gorm.io/gorm"
+)
+
+type Migration005AddEpisodeIntegrity struct{}
+
+func (m Migration005AddEpisodeIntegrity) Name() string {
+	return "005-add-episode-integrity"
+}
+
+func (m Migration005AddEpisodeIntegrity) Migrate(db *gorm.DB) error {
+	for _, column := range []string{"SHA256", "VerifiedAt", "IntegrityProblem"} {
+		if !db.Migrator().HasColumn(&podcasts.Episode{}, column) {
+			if err := db.Migrator().AddColumn(&podcasts.Episode{}, column); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
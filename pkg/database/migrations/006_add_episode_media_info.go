@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"gorm.io/gorm"
+)
+
+type Migration006AddEpisodeMediaInfo struct{}
+
+func (m Migration006AddEpisodeMediaInfo) Name() string {
+	return "006-add-episode-media-info"
+}
+
+func (m Migration006AddEpisodeMediaInfo) Migrate(db *gorm.DB) error {
+	for _, column := range []string{"MediaDurationSecs", "Bitrate", "SampleRate", "Channels", "Chapters"} {
+		if !db.Migrator().HasColumn(&podcasts.Episode{}, column) {
+			if err := db.Migrator().AddColumn(&podcasts.Episode{}, column); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
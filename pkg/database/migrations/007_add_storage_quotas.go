@@ -0,0 +1,30 @@
+package migrations
+
+import (
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"github.com/webbgeorge/castkeeper/pkg/storagestats"
+	"gorm.io/gorm"
+)
+
+type Migration007AddStorageQuotas struct{}
+
+func (m Migration007AddStorageQuotas) Name() string {
+	return "007-add-storage-quotas"
+}
+
+func (m Migration007AddStorageQuotas) Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&storagestats.Snapshot{}); err != nil {
+		return err
+	}
+	if !db.Migrator().HasColumn(&podcasts.Podcast{}, "QuotaBytes") {
+		if err := db.Migrator().AddColumn(&podcasts.Podcast{}, "QuotaBytes"); err != nil {
+			return err
+		}
+	}
+	if !db.Migrator().HasColumn(&podcasts.Episode{}, "EnclosureBytes") {
+		if err := db.Migrator().AddColumn(&podcasts.Episode{}, "EnclosureBytes"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"gorm.io/gorm"
+)
+
+type Migration008AddEpisodeBlobs struct{}
+
+func (m Migration008AddEpisodeBlobs) Name() string {
+	return "008-add-episode-blobs"
+}
+
+func (m Migration008AddEpisodeBlobs) Migrate(db *gorm.DB) error {
+	if err := db.AutoMigrate(&podcasts.Blob{}); err != nil {
+		return err
+	}
+	if !db.Migrator().HasColumn(&podcasts.Episode{}, "BlobSHA256") {
+		if err := db.Migrator().AddColumn(&podcasts.Episode{}, "BlobSHA256"); err != nil {
+			return err
+		}
+		// existing files are stored in their podcast's directory
+		if err := db.Exec("UPDATE episodes SET blob_sha256 = '' WHERE blob_sha256 IS NULL").Error; err != nil {
+			return err
+		}
+	}
+	if !db.Migrator().HasIndex(&podcasts.Episode{}, "BlobSHA256") {
+		if err := db.Migrator().CreateIndex(&podcasts.Episode{}, "BlobSHA256"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -4,20 +4,37 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"slices"
+	"time"
 
+	"github.com/microcosm-cc/bluemonday"
+
+	"github.com/webbgeorge/castkeeper/pkg/blobs"
 	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"github.com/webbgeorge/castkeeper/pkg/mediainfo"
 	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
 	"github.com/webbgeorge/castkeeper/pkg/podcasts"
 	"github.com/webbgeorge/castkeeper/pkg/util"
 	"gorm.io/gorm"
 )
 
-const DownloadWorkerQueueName = "downloadWorker"
+const (
+	DownloadWorkerQueueName = "downloadWorker"
+
+	// progressInterval limits how often download progress is saved
+	progressInterval = time.Second * 2
+)
 
 func NewDownloadWorkerQueueHandler(
 	db *gorm.DB,
 	os objectstorage.ObjectStorage,
 	encService *encryption.EncryptedValueService,
+	tagging bool,
+	totalQuotaBytes int64,
 ) func(context.Context, any) error {
 	return func(ctx context.Context, episodeGUIDAny any) error {
 		episodeGUID, ok := episodeGUIDAny.(string)
@@ -29,23 +46,101 @@ func NewDownloadWorkerQueueHandler(
 		if err != nil {
 			return fmt.Errorf("failed to get a pending episode: %w", err)
 		}
+		if episode.Status == podcasts.EpisodeStatusSuccess {
+			// e.g. a file was imported for the episode after it was queued
+			framework.GetLogger(ctx).InfoContext(ctx, fmt.Sprintf("skipping download of episode '%s', as it is already downloaded", episode.GUID))
+			return nil
+		}
 
 		podcast, err := podcasts.GetPodcast(ctx, db, episode.PodcastGUID)
 		if err != nil {
 			return fmt.Errorf("failed to get podcast: %w", err)
 		}
-		creds, err := podcasts.GetCredentials(encService, podcast)
+		feed, err := podcasts.GetFeed(ctx, db, podcast, episode.FeedID)
+		if err != nil {
+			return fmt.Errorf("failed to get podcast feed: %w", err)
+		}
+		creds, err := podcasts.GetFeedCredentials(encService, feed)
 		if err != nil {
 			return fmt.Errorf("failed to get podcast credentials: %w", err)
 		}
 
+		if episode.DownloadURL == "" {
+			// e.g. imported from a local file, so there is nothing to download
+			upErr := podcasts.UpdateEpisodeStatus(ctx, db, &episode, podcasts.EpisodeStatusFailed, nil)
+			if upErr != nil {
+				return fmt.Errorf("failed to update episode '%s' status to failed: %w", episode.GUID, upErr)
+			}
+			return fmt.Errorf("episode '%s' has no download URL", episode.GUID)
+		}
+
 		extension, err := podcasts.MIMETypeExtension(episode.MimeType)
 		if err != nil {
 			return fmt.Errorf("failed to get episode file extension from MimeType: %w", err)
 		}
 
+		exceeded, err := CheckQuota(ctx, db, totalQuotaBytes, podcast, episode)
+		if err != nil {
+			return fmt.Errorf("failed to check storage quota: %w", err)
+		}
+		if exceeded != "" {
+			// not an error, the download is resumed once there is space
+			framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("pausing download of episode '%s', as it would exceed the %s", episode.GUID, exceeded))
+			err = podcasts.UpdateEpisodeStatus(ctx, db, &episode, podcasts.EpisodeStatusPaused, nil)
+			if err != nil {
+				return fmt.Errorf("failed to update episode '%s' status to paused: %w", episode.GUID, err)
+			}
+			return nil
+		}
+
+		err = podcasts.UpdateEpisodeProgress(ctx, db, &episode, 0, -1)
+		if err != nil {
+			return fmt.Errorf("failed to update episode '%s' status to in progress: %w", episode.GUID, err)
+		}
+
+		// the MIME type is corrected if the downloaded content is a different
+		// supported format to the one declared by the feed
 		fileName := fmt.Sprintf("%s.%s", util.SanitiseGUID(episode.GUID), extension)
-		n, err := os.SaveRemoteFile(ctx, creds, episode.DownloadURL, util.SanitiseGUID(episode.PodcastGUID), fileName)
+		mimeType := episode.MimeType
+		inspect := func(head []byte) (string, error) {
+			detected, err := podcasts.CheckMediaContent(episode.MimeType, head)
+			if err != nil {
+				return "", err
+			}
+			if detected != episode.MimeType {
+				framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf(
+					"episode '%s' content is '%s', not '%s' as declared by feed, correcting",
+					episode.GUID, detected, episode.MimeType,
+				))
+			}
+			ext, err := podcasts.MIMETypeExtension(detected)
+			if err != nil {
+				return "", err
+			}
+			mimeType = detected
+			fileName = fmt.Sprintf("%s.%s", util.SanitiseGUID(episode.GUID), ext)
+			return fileName, nil
+		}
+
+		saved, err := os.SaveRemoteFile(
+			ctx,
+			creds,
+			episode.DownloadURL,
+			util.SanitiseGUID(episode.PodcastGUID),
+			fileName,
+			objectstorage.SaveOptions{
+				Progress: newProgressRecorder(ctx, db, &episode),
+				Inspect:  inspect,
+			},
+		)
+		if err != nil && ctx.Err() != nil {
+			// shutting down, the download is resumed when the task is next received
+			upErr := podcasts.UpdateEpisodeStatus(ctx, db, &episode, podcasts.EpisodeStatusPending, nil)
+			if upErr != nil {
+				return fmt.Errorf("failed to update episode '%s' status to pending: %w", episode.GUID, upErr)
+			}
+			return fmt.Errorf("download of episode '%s' interrupted: %w", episode.GUID, err)
+		}
 		if err != nil {
 			upErr := podcasts.UpdateEpisodeStatus(ctx, db, &episode, podcasts.EpisodeStatusFailed, nil)
 			if upErr != nil {
@@ -54,11 +149,260 @@ func NewDownloadWorkerQueueHandler(
 			return fmt.Errorf("failed to download episode '%s': %w", episode.GUID, err)
 		}
 
-		err = podcasts.UpdateEpisodeStatus(ctx, db, &episode, podcasts.EpisodeStatusSuccess, &n)
+		// files are stored by the hash of their tagged content, which differs
+		// for each episode, so tagged files aren't deduplicated
+		if tagging {
+			saved = writeTags(ctx, os, podcast, episode, fileName, mimeType, saved)
+		}
+
+		err = podcasts.UpdateEpisodeDownloaded(ctx, db, &episode, saved.Bytes, saved.SHA256, mimeType)
 		if err != nil {
 			return fmt.Errorf("failed to update episode '%s' status to success: %w", episode.GUID, err)
 		}
 
+		// not an error, as the file is kept in the podcast's directory
+		if _, err := blobs.Store(ctx, db, os, &episode, fileName, saved); err != nil {
+			framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to store episode '%s' by its content: %s", episode.GUID, err.Error()))
+		}
+
+		readMediaInfo(ctx, db, os, &episode, saved.Bytes, mimeType)
+
 		return nil
 	}
 }
+
+// maxArtworkBytes limits the size of podcast artwork written into episode files
+const maxArtworkBytes = 5 * 1024 * 1024
+
+// writeTags rewrites a downloaded episode's file with podcast and episode
+// metadata, returning the details of the tagged file. Failures are logged and
+// the untagged file is kept, as it is still playable.
+func writeTags(ctx context.Context, os objectstorage.ObjectStorage, podcast podcasts.Podcast, episode podcasts.Episode, fileName, mimeType string, saved objectstorage.SavedFile) objectstorage.SavedFile {
+	if !mediainfo.CanWriteTags(mimeType) {
+		return saved
+	}
+
+	tags := mediainfo.Tags{
+		PodcastTitle: podcast.Title,
+		Author:       podcast.Author,
+		EpisodeTitle: episode.Title,
+		Description:  html.UnescapeString(bluemonday.StrictPolicy().Sanitize(episode.Description)),
+		PublishedAt:  episode.PublishedAt,
+	}
+	tags.Artwork, tags.ArtworkMIMEType = readArtwork(ctx, os, podcast)
+
+	f, err := os.Open(ctx, util.SanitiseGUID(episode.PodcastGUID), fileName)
+	if err != nil {
+		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to open episode '%s' to write tags: %s", episode.GUID, err.Error()))
+		return saved
+	}
+	defer f.Close()
+
+	pr, pw := io.Pipe()
+	defer pr.Close()
+	go func() {
+		pw.CloseWithError(mediainfo.WriteTags(f, pw, mimeType, tags))
+	}()
+
+	tagged, err := os.Put(ctx, util.SanitiseGUID(episode.PodcastGUID), fileName, pr)
+	if err != nil {
+		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to write tags to episode '%s': %s", episode.GUID, err.Error()))
+		return saved
+	}
+	return tagged
+}
+
+// readArtwork returns the podcast's stored image, when it is a JPEG or PNG
+// which can be embedded in episode files
+func readArtwork(ctx context.Context, os objectstorage.ObjectStorage, podcast podcasts.Podcast) ([]byte, string) {
+	fileName := fmt.Sprintf("%s.%s", util.SanitiseGUID(podcast.GUID), "jpg")
+	f, err := os.Open(ctx, util.SanitiseGUID(podcast.GUID), fileName)
+	if err != nil {
+		return nil, ""
+	}
+	defer f.Close()
+
+	image, err := io.ReadAll(io.LimitReader(f, maxArtworkBytes+1))
+	if err != nil || len(image) > maxArtworkBytes {
+		return nil, ""
+	}
+	mimeType := http.DetectContentType(image)
+	if mimeType != "image/jpeg" && mimeType != "image/png" {
+		return nil, ""
+	}
+	return image, mimeType
+}
+
+// readMediaInfo records the duration and technical metadata of a downloaded
+// episode. Failures are logged rather than failing the download, as the
+// episode is still playable without them.
+func readMediaInfo(ctx context.Context, db *gorm.DB, os objectstorage.ObjectStorage, episode *podcasts.Episode, fileBytes int64, mimeType string) {
+	dir, fileName, err := podcasts.EpisodeFile(*episode)
+	if err != nil {
+		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to get file of episode '%s' to read media info: %s", episode.GUID, err.Error()))
+		return
+	}
+	f, err := os.Open(ctx, dir, fileName)
+	if err != nil {
+		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to open episode '%s' to read media info: %s", episode.GUID, err.Error()))
+		return
+	}
+	defer f.Close()
+
+	info, err := mediainfo.Probe(f, fileBytes, mimeType)
+	if errors.Is(err, mediainfo.ErrUnsupported) {
+		return
+	}
+	if err != nil {
+		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to read media info of episode '%s': %s", episode.GUID, err.Error()))
+		return
+	}
+
+	err = podcasts.UpdateEpisodeMediaInfo(ctx, db, episode, info)
+	if err != nil {
+		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to save media info of episode '%s': %s", episode.GUID, err.Error()))
+	}
+}
+
+// newProgressRecorder saves the progress of an episode's download, at most once
+// every progressInterval
+func newProgressRecorder(ctx context.Context, db *gorm.DB, episode *podcasts.Episode) objectstorage.ProgressFunc {
+	lastSaved := time.Now()
+	return func(bytesDone, bytesTotal int64) {
+		if time.Since(lastSaved) < progressInterval {
+			return
+		}
+		lastSaved = time.Now()
+
+		err := podcasts.UpdateEpisodeProgress(ctx, db, episode, bytesDone, bytesTotal)
+		if err != nil {
+			framework.GetLogger(ctx).WarnContext(ctx, "failed to save download progress", "episode", episode.GUID, "error", err)
+		}
+	}
+}
+
+// CheckQuota returns a description of the storage quota which downloading an
+// episode would exceed, or an empty string when there is space for it. The
+// size of the episode is declared by its feed, so an episode of unknown size
+// only exceeds a quota which has already been reached.
+func CheckQuota(ctx context.Context, db *gorm.DB, totalQuotaBytes int64, podcast podcasts.Podcast, episode podcasts.Episode) (string, error) {
+	if podcast.QuotaBytes > 0 {
+		usage, err := podcasts.GetStorageUsage(ctx, db, podcast.GUID)
+		if err != nil {
+			return "", err
+		}
+		if exceedsQuota(usage.Bytes, episode.EnclosureBytes, podcast.QuotaBytes) {
+			return fmt.Sprintf("podcast storage quota of %s", util.FormatBytes(podcast.QuotaBytes)), nil
+		}
+	}
+
+	if totalQuotaBytes > 0 {
+		usage, err := podcasts.GetStorageUsage(ctx, db, "")
+		if err != nil {
+			return "", err
+		}
+		if exceedsQuota(usage.Bytes, episode.EnclosureBytes, totalQuotaBytes) {
+			return fmt.Sprintf("total storage quota of %s", util.FormatBytes(totalQuotaBytes)), nil
+		}
+	}
+
+	return "", nil
+}
+
+func exceedsQuota(usedBytes, episodeBytes, quotaBytes int64) bool {
+	return usedBytes >= quotaBytes || usedBytes+episodeBytes > quotaBytes
+}
+
+// ResumePausedDownloads queues paused downloads which are now within quota,
+// e.g. after episodes are deleted or a quota is raised. The number of episodes
+// requeued is returned.
+func ResumePausedDownloads(ctx context.Context, db *gorm.DB, totalQuotaBytes int64) (int, error) {
+	paused, err := podcasts.ListEpisodesByStatus(ctx, db, "", podcasts.EpisodeStatusPaused)
+	if err != nil {
+		return 0, err
+	}
+
+	pods := make(map[string]podcasts.Podcast)
+	resume := make([]podcasts.Episode, 0)
+	for _, ep := range paused {
+		pod, ok := pods[ep.PodcastGUID]
+		if !ok {
+			pod, err = podcasts.GetPodcast(ctx, db, ep.PodcastGUID)
+			if err != nil {
+				return 0, fmt.Errorf("failed to get podcast: %w", err)
+			}
+			pods[pod.GUID] = pod
+		}
+
+		// usage doesn't include other resumed episodes, which are paused again
+		// by the download worker if they no longer fit
+		exceeded, err := CheckQuota(ctx, db, totalQuotaBytes, pod, ep)
+		if err != nil {
+			return 0, err
+		}
+		if exceeded == "" {
+			resume = append(resume, ep)
+		}
+	}
+
+	return RequeueDownloads(ctx, db, resume)
+}
+
+// RequeueDownload marks an episode as pending and queues it to be downloaded
+// again, e.g. after it has failed.
+func RequeueDownload(ctx context.Context, db *gorm.DB, episode *podcasts.Episode) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := podcasts.UpdateEpisodeStatus(ctx, tx, episode, podcasts.EpisodeStatusPending, nil)
+		if err != nil {
+			return err
+		}
+		err = framework.PushQueueTask(ctx, tx, DownloadWorkerQueueName, episode.GUID)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	episode.Status = podcasts.EpisodeStatusPending
+	return nil
+}
+
+// requeueBatchSize limits the number of episodes updated and queued in each
+// transaction when requeueing many downloads
+const requeueBatchSize = 100
+
+// RequeueDownloads marks many episodes as pending and queues them to be
+// downloaded again, in batched transactions. The number of episodes requeued is
+// returned, which may be non-zero when an error occurs part way through.
+func RequeueDownloads(ctx context.Context, db *gorm.DB, episodes []podcasts.Episode) (int, error) {
+	requeued := 0
+	for batch := range slices.Chunk(episodes, requeueBatchSize) {
+		guids := make([]string, 0, len(batch))
+		data := make([]any, 0, len(batch))
+		for _, ep := range batch {
+			guids = append(guids, ep.GUID)
+			data = append(data, ep.GUID)
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			err := podcasts.UpdateEpisodesStatus(ctx, tx, guids, podcasts.EpisodeStatusPending)
+			if err != nil {
+				return err
+			}
+			err = framework.PushQueueTasks(ctx, tx, DownloadWorkerQueueName, data)
+			if err != nil {
+				return err
+			}
+			return nil
+		})
+		if err != nil {
+			return requeued, err
+		}
+
+		requeued += len(batch)
+	}
+	return requeued, nil
+}
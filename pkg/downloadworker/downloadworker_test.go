@@ -2,8 +2,13 @@ package downloadworker_test
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net/http"
 	"os"
 	"strings"
 	"testing"
@@ -11,6 +16,8 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/webbgeorge/castkeeper/pkg/downloadworker"
 	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"github.com/webbgeorge/castkeeper/pkg/mediainfo"
 	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
 	"github.com/webbgeorge/castkeeper/pkg/podcasts"
 	"gorm.io/gorm"
@@ -24,7 +31,7 @@ func TestDownloadWorker(t *testing.T) {
 	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
 		HTTPClient: fixtures.TestDataHTTPClient,
 		Root:       root,
-	}, nil)
+	}, nil, false, 0)
 
 	// valid-eps-pending.xml fixture
 	epGUID := fixtures.PodEpGUID("pending-ep-1")
@@ -36,7 +43,55 @@ func TestDownloadWorker(t *testing.T) {
 	assert.Nil(t, err)
 
 	assertEpisodeStatus(db, t, epGUID, "success")
-	assertEpisodeContent(db, root, t, epGUID, "ep1 content")
+	assertEpisodeContent(db, root, t, epGUID, "ID3 ep1 content")
+
+	// hash of "ID3 ep1 content\n" from the audio/ep1.mp3 fixture
+	ep, err := podcasts.GetEpisode(context.Background(), db, epGUID)
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, int64(16), ep.Bytes)
+	assert.Equal(t, "1b030a1018a19c37ceb8621aa7bfe8c5741a480a0afba3a04e73d4ff230b05a8", ep.SHA256)
+	assert.NotNil(t, ep.VerifiedAt)
+}
+
+func TestDownloadWorker_SizeMismatch(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
+		HTTPClient: &http.Client{Transport: shortBodyTransport{}},
+		Root:       root,
+	}, nil, false, 0)
+
+	// valid-eps-pending.xml fixture
+	epGUID := fixtures.PodEpGUID("pending-ep-1")
+
+	err := dlWorker(context.Background(), epGUID)
+
+	assert.ErrorContains(t, err, "saved file size '9' does not match expected size '100'")
+	assertEpisodeStatus(db, t, epGUID, "failed")
+
+	ep, err := podcasts.GetEpisode(context.Background(), db, epGUID)
+	if err != nil {
+		panic(err)
+	}
+	_, err = root.Stat(fmt.Sprintf("%s/%s.mp3", ep.PodcastGUID, ep.GUID))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = root.Stat(fmt.Sprintf("%s/%s.mp3.part", ep.PodcastGUID, ep.GUID))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+// responds with fewer bytes than its Content-Length
+type shortBodyTransport struct{}
+
+func (shortBodyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		ContentLength: 100,
+		Body:          io.NopCloser(strings.NewReader("ID3 short")),
+	}, nil
 }
 
 func TestDownloadWorker_PasswordProtectedFeed(t *testing.T) {
@@ -48,7 +103,7 @@ func TestDownloadWorker_PasswordProtectedFeed(t *testing.T) {
 	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
 		HTTPClient: fixtures.TestDataHTTPClient,
 		Root:       root,
-	}, encService)
+	}, encService, false, 0)
 
 	// from authenticated/feeds/valid.xml fixture
 	epGUID := fixtures.PodEpGUID("authenticated-ep-1")
@@ -60,7 +115,7 @@ func TestDownloadWorker_PasswordProtectedFeed(t *testing.T) {
 	assert.Nil(t, err)
 
 	assertEpisodeStatus(db, t, epGUID, "success")
-	assertEpisodeContent(db, root, t, epGUID, "authed ep1 content")
+	assertEpisodeContent(db, root, t, epGUID, "ID3 authed ep1 content")
 }
 
 func TestDownloadWorker_InvalidQueueData(t *testing.T) {
@@ -71,7 +126,7 @@ func TestDownloadWorker_InvalidQueueData(t *testing.T) {
 	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
 		HTTPClient: fixtures.TestDataHTTPClient,
 		Root:       root,
-	}, nil)
+	}, nil, false, 0)
 
 	err := dlWorker(context.Background(), nil)
 
@@ -86,7 +141,7 @@ func TestDownloadWorker_EpisodeNotFound(t *testing.T) {
 	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
 		HTTPClient: fixtures.TestDataHTTPClient,
 		Root:       root,
-	}, nil)
+	}, nil, false, 0)
 
 	err := dlWorker(context.Background(), "not-an-ep")
 
@@ -101,7 +156,7 @@ func TestDownloadWorker_FailedToDownload(t *testing.T) {
 	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
 		HTTPClient: fixtures.TestDataHTTPClient,
 		Root:       root,
-	}, nil)
+	}, nil, false, 0)
 
 	if err := db.Create(&podcasts.Episode{
 		GUID:        "test-download-failure",
@@ -117,6 +172,373 @@ func TestDownloadWorker_FailedToDownload(t *testing.T) {
 	err := dlWorker(context.Background(), "test-download-failure")
 
 	assert.Equal(t, "failed to download episode 'test-download-failure': failed to download file with status '500'", err.Error())
+
+	// no partial file is left behind
+	_, err = root.Stat("916ed63b-7e5e-5541-af78-e214a0c14d95/test-download-failure.mp3.part")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = root.Stat("916ed63b-7e5e-5541-af78-e214a0c14d95/test-download-failure.mp3")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestDownloadWorker_NotMedia(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
+		HTTPClient: fixtures.TestDataHTTPClient,
+		Root:       root,
+	}, nil, false, 0)
+
+	if err := db.Create(&podcasts.Episode{
+		GUID:        "test-paywall",
+		PodcastGUID: "916ed63b-7e5e-5541-af78-e214a0c14d95", // references a fixture
+		Title:       "Test",
+		DownloadURL: "http://testdata/audio/paywall.mp3",
+		MimeType:    "audio/mpeg",
+		Status:      "pending",
+	}).Error; err != nil {
+		panic(err)
+	}
+
+	err := dlWorker(context.Background(), "test-paywall")
+
+	assert.ErrorIs(t, err, podcasts.ErrNotMedia)
+	assertEpisodeStatus(db, t, "test-paywall", "failed")
+
+	_, err = root.Stat("916ed63b-7e5e-5541-af78-e214a0c14d95/test-paywall.mp3.part")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = root.Stat("916ed63b-7e5e-5541-af78-e214a0c14d95/test-paywall.mp3")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestDownloadWorker_CorrectsMIMEType(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
+		HTTPClient: fixtures.TestDataHTTPClient,
+		Root:       root,
+	}, nil, false, 0)
+
+	if err := db.Create(&podcasts.Episode{
+		GUID:        "test-actually-m4a",
+		PodcastGUID: "916ed63b-7e5e-5541-af78-e214a0c14d95", // references a fixture
+		Title:       "Test",
+		DownloadURL: "http://testdata/audio/actually-m4a.mp3",
+		MimeType:    "audio/mpeg",
+		Status:      "pending",
+	}).Error; err != nil {
+		panic(err)
+	}
+
+	err := dlWorker(context.Background(), "test-actually-m4a")
+
+	assert.Nil(t, err)
+	assertEpisodeStatus(db, t, "test-actually-m4a", "success")
+
+	ep, err := podcasts.GetEpisode(context.Background(), db, "test-actually-m4a")
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, "audio/x-m4a", ep.MimeType)
+
+	_, err = root.Stat(fmt.Sprintf("%s/%s", podcasts.BlobDir, ep.BlobSHA256))
+	assert.Nil(t, err)
+	for _, fileName := range []string{"test-actually-m4a.m4a", "test-actually-m4a.mp3"} {
+		_, err = root.Stat("916ed63b-7e5e-5541-af78-e214a0c14d95/" + fileName)
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	}
+}
+
+func TestDownloadWorker_ReadsMediaInfo(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
+		HTTPClient: fixtures.TestDataHTTPClient,
+		Root:       root,
+	}, nil, false, 0)
+
+	if err := db.Create(&podcasts.Episode{
+		GUID:         "test-media-info",
+		PodcastGUID:  "916ed63b-7e5e-5541-af78-e214a0c14d95", // references a fixture
+		Title:        "Test",
+		DownloadURL:  "http://testdata/audio/with-chapters.mp3",
+		MimeType:     "audio/mpeg",
+		DurationSecs: 60, // wrong duration in feed
+		Status:       "pending",
+	}).Error; err != nil {
+		panic(err)
+	}
+
+	err := dlWorker(context.Background(), "test-media-info")
+
+	assert.Nil(t, err)
+	assertEpisodeStatus(db, t, "test-media-info", "success")
+
+	ep, err := podcasts.GetEpisode(context.Background(), db, "test-media-info")
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, 2, ep.MediaDurationSecs)
+	assert.Equal(t, 2, ep.Duration())
+	assert.Equal(t, 8000, ep.Bitrate)
+	assert.Equal(t, 22050, ep.SampleRate)
+	assert.Equal(t, 1, ep.Channels)
+	assert.Equal(t, []mediainfo.Chapter{
+		{StartSecs: 0, Title: "Intro"},
+		{StartSecs: 1, Title: "Outro"},
+	}, ep.Chapters)
+}
+
+func TestDownloadWorker_WritesTags(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
+		HTTPClient: fixtures.TestDataHTTPClient,
+		Root:       root,
+	}, nil, true, 0)
+
+	podGUID := "916ed63b-7e5e-5541-af78-e214a0c14d95" // references a fixture
+	artwork := []byte("\xff\xd8\xff\xe0 artwork")
+	if err := root.WriteFile(fmt.Sprintf("%s/%s.jpg", podGUID, podGUID), artwork, 0640); err != nil {
+		panic(err)
+	}
+	if err := db.Create(&podcasts.Episode{
+		GUID:        "test-tags",
+		PodcastGUID: podGUID,
+		Title:       "Tagged episode",
+		Description: "<p>About <b>tags</b> &amp; more</p>",
+		DownloadURL: "http://testdata/audio/with-chapters.mp3",
+		MimeType:    "audio/mpeg",
+		Status:      "pending",
+	}).Error; err != nil {
+		panic(err)
+	}
+
+	err := dlWorker(context.Background(), "test-tags")
+
+	assert.Nil(t, err)
+	assertEpisodeStatus(db, t, "test-tags", "success")
+
+	ep, err := podcasts.GetEpisode(context.Background(), db, "test-tags")
+	if err != nil {
+		panic(err)
+	}
+	data, err := root.ReadFile(fmt.Sprintf("%s/%s", podcasts.BlobDir, ep.BlobSHA256))
+	if err != nil {
+		panic(err)
+	}
+	assert.Contains(t, string(data), "TIT2")
+	assert.Contains(t, string(data), "Tagged episode")
+	assert.Contains(t, string(data), "About tags & more")
+	assert.Contains(t, string(data), string(artwork))
+	assert.Equal(t, int64(len(data)), ep.Bytes)
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(data)), ep.SHA256)
+
+	// chapters are kept
+	assert.Equal(t, []mediainfo.Chapter{
+		{StartSecs: 0, Title: "Intro"},
+		{StartSecs: 1, Title: "Outro"},
+	}, ep.Chapters)
+}
+
+func TestDownloadWorker_DeduplicatesIdenticalFiles(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
+		HTTPClient: fixtures.TestDataHTTPClient,
+		Root:       root,
+	}, nil, false, 0)
+
+	podGUID := "916ed63b-7e5e-5541-af78-e214a0c14d95" // references a fixture
+	for _, guid := range []string{"test-original", "test-rerun"} {
+		if err := db.Create(&podcasts.Episode{
+			GUID:        guid,
+			PodcastGUID: podGUID,
+			Title:       "Test",
+			DownloadURL: "http://testdata/audio/ep1.mp3",
+			MimeType:    "audio/mpeg",
+			Status:      "pending",
+		}).Error; err != nil {
+			panic(err)
+		}
+
+		err := dlWorker(context.Background(), guid)
+
+		assert.Nil(t, err)
+		assertEpisodeContent(db, root, t, guid, "ID3 ep1 content")
+		_, err = root.Stat(fmt.Sprintf("%s/%s.mp3", podGUID, guid))
+		assert.ErrorIs(t, err, fs.ErrNotExist)
+	}
+
+	blobs, err := podcasts.ListBlobs(context.Background(), db)
+	assert.Nil(t, err)
+	assert.Len(t, blobs, 1)
+	assert.Equal(t, int64(2), blobs[0].RefCount)
+	assert.Equal(t, int64(16), blobs[0].Bytes)
+}
+
+func TestDownloadWorker_ResumesPartialDownload(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
+		HTTPClient: fixtures.TestDataHTTPClient,
+		Root:       root,
+	}, nil, false, 0)
+
+	// valid-eps-pending.xml fixture
+	epGUID := fixtures.PodEpGUID("pending-ep-1")
+	ep, err := podcasts.GetEpisode(context.Background(), db, epGUID)
+	if err != nil {
+		panic(err)
+	}
+
+	// an interrupted download, which differs from the remote file so that
+	// resuming can be distinguished from starting again
+	partPath := fmt.Sprintf("%s/%s.mp3.part", ep.PodcastGUID, ep.GUID)
+	if err := root.Mkdir(ep.PodcastGUID, 0750); err != nil && !errors.Is(err, fs.ErrExist) {
+		panic(err)
+	}
+	if err := root.WriteFile(partPath, []byte("ID3 EP1 "), 0640); err != nil {
+		panic(err)
+	}
+	writePartSourceForTest(root, partPath, ep.DownloadURL, fixtures.TestDataETag("audio/ep1.mp3"))
+
+	err = dlWorker(context.Background(), epGUID)
+
+	assert.Nil(t, err)
+
+	assertEpisodeStatus(db, t, epGUID, "success")
+	assertEpisodeContent(db, root, t, epGUID, "ID3 EP1 content")
+	_, err = root.Stat(partPath)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = root.Stat(strings.TrimSuffix(partPath, ".part") + ".source.part")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	// hash covers the resumed part of the file as well as the new part
+	ep, err = podcasts.GetEpisode(context.Background(), db, epGUID)
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("ID3 EP1 content\n"))), ep.SHA256)
+}
+
+func TestDownloadWorker_RestartsPartialDownloadOfDifferentFile(t *testing.T) {
+	testCases := map[string]struct {
+		url  string
+		etag string
+	}{
+		"different URL": {
+			url:  "http://testdata/audio/ep1-free.mp3",
+			etag: fixtures.TestDataETag("audio/ep1.mp3"),
+		},
+		"remote file changed": {
+			url:  "http://testdata/audio/ep1.mp3",
+			etag: `"changed"`,
+		},
+		"no source": {},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			db := fixtures.ConfigureDBForTestWithFixtures()
+			root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+			defer resetFS()
+
+			dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
+				HTTPClient: fixtures.TestDataHTTPClient,
+				Root:       root,
+			}, nil, false, 0)
+
+			// valid-eps-pending.xml fixture
+			epGUID := fixtures.PodEpGUID("pending-ep-1")
+			ep, err := podcasts.GetEpisode(context.Background(), db, epGUID)
+			if err != nil {
+				panic(err)
+			}
+
+			partPath := fmt.Sprintf("%s/%s.mp3.part", ep.PodcastGUID, ep.GUID)
+			if err := root.Mkdir(ep.PodcastGUID, 0750); err != nil && !errors.Is(err, fs.ErrExist) {
+				panic(err)
+			}
+			if err := root.WriteFile(partPath, []byte("ID3 EP1 "), 0640); err != nil {
+				panic(err)
+			}
+			if tc.url != "" {
+				writePartSourceForTest(root, partPath, tc.url, tc.etag)
+			}
+
+			err = dlWorker(context.Background(), epGUID)
+
+			// the partial file is not joined with a different remote file
+			assert.Nil(t, err)
+			assertEpisodeStatus(db, t, epGUID, "success")
+			assertEpisodeContent(db, root, t, epGUID, "ID3 ep1 content")
+		})
+	}
+}
+
+func TestDownloadWorker_InterruptedByShutdown(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
+		HTTPClient: fixtures.TestDataHTTPClient,
+		Root:       root,
+	}, nil, false, 0)
+
+	// valid-eps-pending.xml fixture
+	epGUID := fixtures.PodEpGUID("pending-ep-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := dlWorker(ctx, epGUID)
+
+	assert.ErrorIs(t, err, context.Canceled)
+
+	// returned to pending rather than failed, to be resumed on next start
+	assertEpisodeStatus(db, t, epGUID, "pending")
+}
+
+func TestRequeueDownloads(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	ctx := context.Background()
+
+	// from valid.xml fixture
+	eps, err := podcasts.ListEpisodes(ctx, db, fixtures.PodEpGUID("abc-123"))
+	if err != nil {
+		panic(err)
+	}
+
+	n, err := downloadworker.RequeueDownloads(ctx, db, eps)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+	for _, ep := range eps {
+		assertEpisodeStatus(db, t, ep.GUID, "pending")
+	}
+
+	queued := make([]string, 0)
+	for {
+		qt, err := framework.PopQueueTask(ctx, db, downloadworker.DownloadWorkerQueueName)
+		if err != nil {
+			break
+		}
+		queued = append(queued, qt.Data.(string))
+	}
+	assert.ElementsMatch(t, []string{eps[0].GUID, eps[1].GUID}, queued)
 }
 
 func assertEpisodeStatus(db *gorm.DB, t *testing.T, episodeGUID, expectedStatus string) {
@@ -134,7 +556,9 @@ func assertEpisodeContent(db *gorm.DB, root *os.Root, t *testing.T, episodeGUID,
 	if err != nil {
 		panic(err)
 	}
-	f, err := root.Open(fmt.Sprintf("%s/%s.mp3", ep.PodcastGUID, ep.GUID))
+	// downloaded files are stored by their content
+	assert.Equal(t, ep.SHA256, ep.BlobSHA256)
+	f, err := root.Open(fmt.Sprintf("%s/%s", podcasts.BlobDir, ep.BlobSHA256))
 	if err != nil {
 		panic(err)
 	}
@@ -144,3 +568,128 @@ func assertEpisodeContent(db *gorm.DB, root *os.Root, t *testing.T, episodeGUID,
 	}
 	assert.Equal(t, expectedContent, strings.TrimSpace(string(data)))
 }
+
+func TestDownloadWorker_PausedByPodcastQuota(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
+		HTTPClient: fixtures.TestDataHTTPClient,
+		Root:       root,
+	}, nil, false, 0)
+
+	// the feed declares the episode's size as 1001 bytes
+	setPodcastQuota(db, fixtures.PodEpGUID("pod-eps-pending"), 1000)
+	epGUID := fixtures.PodEpGUID("pending-ep-1")
+
+	err := dlWorker(context.Background(), epGUID)
+
+	assert.Nil(t, err)
+	assertEpisodeStatus(db, t, epGUID, "paused")
+	_, err = root.Stat(fmt.Sprintf("%s/%s.mp3", fixtures.PodEpGUID("pod-eps-pending"), epGUID))
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestDownloadWorker_PausedByTotalQuota(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
+		HTTPClient: fixtures.TestDataHTTPClient,
+		Root:       root,
+	}, nil, false, 2000)
+
+	// episodes of other podcasts count towards the total quota
+	setEpisodeBytes(db, fixtures.PodEpGUID("ep-1"), 1500)
+	epGUID := fixtures.PodEpGUID("pending-ep-1")
+
+	err := dlWorker(context.Background(), epGUID)
+
+	assert.Nil(t, err)
+	assertEpisodeStatus(db, t, epGUID, "paused")
+}
+
+func TestDownloadWorker_WithinQuota(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{
+		HTTPClient: fixtures.TestDataHTTPClient,
+		Root:       root,
+	}, nil, false, 5000)
+
+	setPodcastQuota(db, fixtures.PodEpGUID("pod-eps-pending"), 2000)
+	setEpisodeBytes(db, fixtures.PodEpGUID("ep-1"), 1500)
+	epGUID := fixtures.PodEpGUID("pending-ep-1")
+
+	err := dlWorker(context.Background(), epGUID)
+
+	assert.Nil(t, err)
+	assertEpisodeStatus(db, t, epGUID, "success")
+}
+
+func TestResumePausedDownloads(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	ctx := context.Background()
+	podGUID := fixtures.PodEpGUID("pod-eps-pending")
+	epGUID := fixtures.PodEpGUID("pending-ep-1")
+
+	setPodcastQuota(db, podGUID, 1000)
+	ep, err := podcasts.GetEpisode(ctx, db, epGUID)
+	if err != nil {
+		panic(err)
+	}
+	err = podcasts.UpdateEpisodeStatus(ctx, db, &ep, podcasts.EpisodeStatusPaused, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	// still exceeds the podcast's quota
+	resumed, err := downloadworker.ResumePausedDownloads(ctx, db, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, resumed)
+	assertEpisodeStatus(db, t, epGUID, "paused")
+
+	setPodcastQuota(db, podGUID, 0)
+	resumed, err = downloadworker.ResumePausedDownloads(ctx, db, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, resumed)
+	assertEpisodeStatus(db, t, epGUID, "pending")
+
+	qt, err := framework.PopQueueTask(ctx, db, downloadworker.DownloadWorkerQueueName)
+	assert.Nil(t, err)
+	assert.Equal(t, epGUID, qt.Data)
+}
+
+func setPodcastQuota(db *gorm.DB, podGUID string, quotaBytes int64) {
+	pod, err := podcasts.GetPodcast(context.Background(), db, podGUID)
+	if err != nil {
+		panic(err)
+	}
+	err = podcasts.UpdatePodcastQuota(context.Background(), db, &pod, quotaBytes)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func setEpisodeBytes(db *gorm.DB, guid string, bytes int64) {
+	err := db.Exec("UPDATE episodes SET bytes = ? WHERE guid = ?", bytes, guid).Error
+	if err != nil {
+		panic(err)
+	}
+}
+
+// writePartSourceForTest records the remote file a partial download is from,
+// as the local object storage does
+func writePartSourceForTest(root *os.Root, partPath, url, etag string) {
+	data, err := json.Marshal(map[string]string{"url": url, "etag": etag})
+	if err != nil {
+		panic(err)
+	}
+	if err := root.WriteFile(strings.TrimSuffix(partPath, ".part")+".source.part", data, 0640); err != nil {
+		panic(err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
@@ -37,7 +38,7 @@ func NewFeedWorkerQueueHandler(
 				framework.GetLogger(ctx).DebugContext(ctx, fmt.Sprintf("podcast '%s' checked too recently, skipping", pod.GUID))
 				continue
 			}
-			err := processPodcast(ctx, db, feedService, encService, pod)
+			err := RefreshPodcast(ctx, db, feedService, encService, pod)
 			if err != nil {
 				framework.GetLogger(ctx).ErrorContext(ctx, fmt.Sprintf("feedworker failed to process podcast '%s': %s", pod.GUID, err.Error()))
 				errs = append(errs, err)
@@ -52,63 +53,89 @@ func NewFeedWorkerQueueHandler(
 	}
 }
 
-func processPodcast(ctx context.Context, db *gorm.DB, feedService *podcasts.FeedService, encService *encryption.EncryptedValueService, podcast podcasts.Podcast) error {
-	creds, err := podcasts.GetCredentials(encService, podcast)
-	if err != nil {
-		return err
+// RefreshPodcast checks all feeds of a podcast for new episodes and queues
+// them for download. Private podcasts are skipped.
+func RefreshPodcast(ctx context.Context, db *gorm.DB, feedService *podcasts.FeedService, encService *encryption.EncryptedValueService, podcast podcasts.Podcast) error {
+	// private podcasts have no feed, their episodes are uploaded instead
+	if podcast.IsPrivate() {
+		framework.GetLogger(ctx).DebugContext(ctx, fmt.Sprintf("podcast '%s' is private, skipping", podcast.GUID))
+		return nil
 	}
 
-	_, episodes, err := feedService.ParseFeed(ctx, podcast.FeedURL, creds)
+	// premium feeds are listed first, so their episodes take precedence
+	feeds, err := podcasts.ListFeeds(ctx, db, podcast)
 	if err != nil {
-		if !errors.Is(err, podcasts.ParseErrors{}) {
-			return err
-		}
-		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("some episodes of podcast '%s' had parsing errors: %s", podcast.GUID, err.Error()))
-		// continue even with some episode parse failures...
+		return err
 	}
 
-	existingEpisodes, err := podcasts.ListEpisodes(ctx, db, podcast.GUID)
+	// includes deleted episodes, so that they are not downloaded again
+	existingEpisodes, err := podcasts.ListEpisodes(ctx, db.Unscoped(), podcast.GUID)
 	if err != nil {
 		return err
 	}
 
-	for _, ep := range episodes {
-		exists := false
-		for _, exEp := range existingEpisodes {
-			if exEp.GUID == ep.GUID {
-				exists = true
-				break
-			}
-		}
+	premiumFeeds := make(map[uint]bool)
+	for _, feed := range feeds {
+		premiumFeeds[feed.ID] = feed.IsPremium
+	}
 
-		if exists {
+	var lastEpisodeAt *time.Time
+	errs := make([]error, 0)
+	for _, feed := range feeds {
+		episodes, err := parseFeedEpisodes(ctx, feedService, encService, podcast, feed)
+		if err != nil {
+			errs = append(errs, err)
 			continue
 		}
 
-		ep.Status = podcasts.EpisodeStatusPending
+		if len(episodes) > 0 {
+			// feed items are sorted oldest to newest
+			feedLastEpisodeAt := episodes[len(episodes)-1].PublishedAt
+			if lastEpisodeAt == nil || feedLastEpisodeAt.After(*lastEpisodeAt) {
+				lastEpisodeAt = &feedLastEpisodeAt
+			}
+		}
 
-		err = db.Transaction(func(tx *gorm.DB) error {
-			if err := tx.Create(&ep).Error; err != nil {
-				return err
+		for _, ep := range episodes {
+			ep.PodcastGUID = podcast.GUID
+			ep.FeedID = feed.ID
+
+			dupIdx := slices.IndexFunc(existingEpisodes, func(exEp podcasts.Episode) bool {
+				return podcasts.IsDuplicateEpisode(exEp, ep)
+			})
+
+			if dupIdx == -1 {
+				err = createEpisode(ctx, db, &ep)
+				if err != nil {
+					return err
+				}
+				existingEpisodes = append(existingEpisodes, ep)
+				continue
 			}
-			err := framework.PushQueueTask(ctx, tx, downloadworker.DownloadWorkerQueueName, ep.GUID)
+
+			dup := existingEpisodes[dupIdx]
+			if dup.DeletedAt.Valid || !feed.IsPremium || premiumFeeds[dup.FeedID] {
+				continue
+			}
+
+			// prefer the premium version of an episode published in both feeds
+			err = replaceEpisodeSource(ctx, db, &dup, ep)
 			if err != nil {
 				return err
 			}
-			return nil
-		})
-		if err != nil {
-			return err
+			existingEpisodes[dupIdx] = dup
 		}
 	}
 
-	now := time.Now()
-	var lastEpisodeAt *time.Time
-	if len(episodes) > 0 {
-		// feed items are sorted oldest to newest
-		lastEpisodeAt = &episodes[len(episodes)-1].PublishedAt
+	// only give up on the podcast when none of its feeds could be read
+	if len(errs) == len(feeds) {
+		return errors.Join(errs...)
+	}
+	for _, err := range errs {
+		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to process a feed of podcast '%s': %s", podcast.GUID, err.Error()))
 	}
 
+	now := time.Now()
 	err = podcasts.UpdatePodcastTimes(ctx, db, &podcast, &now, lastEpisodeAt)
 	if err != nil {
 		return err
@@ -116,3 +143,67 @@ func processPodcast(ctx context.Context, db *gorm.DB, feedService *podcasts.Feed
 
 	return nil
 }
+
+func parseFeedEpisodes(
+	ctx context.Context,
+	feedService *podcasts.FeedService,
+	encService *encryption.EncryptedValueService,
+	podcast podcasts.Podcast,
+	feed podcasts.PodcastFeed,
+) ([]podcasts.Episode, error) {
+	creds, err := podcasts.GetFeedCredentials(encService, feed)
+	if err != nil {
+		return nil, err
+	}
+
+	_, episodes, err := feedService.ParseFeed(ctx, feed.FeedURL, creds)
+	if err != nil {
+		if !errors.Is(err, podcasts.ParseErrors{}) {
+			return nil, err
+		}
+		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("some episodes of podcast '%s' had parsing errors: %s", podcast.GUID, err.Error()))
+		// continue even with some episode parse failures...
+	}
+
+	return episodes, nil
+}
+
+func createEpisode(ctx context.Context, db *gorm.DB, ep *podcasts.Episode) error {
+	ep.Status = podcasts.EpisodeStatusPending
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(ep).Error; err != nil {
+			return err
+		}
+		err := framework.PushQueueTask(ctx, tx, downloadworker.DownloadWorkerQueueName, ep.GUID)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+func replaceEpisodeSource(ctx context.Context, db *gorm.DB, existing *podcasts.Episode, premium podcasts.Episode) error {
+	framework.GetLogger(ctx).InfoContext(ctx, fmt.Sprintf("replacing episode '%s' with premium version '%s'", existing.GUID, premium.GUID))
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := podcasts.UpdateEpisodeSource(ctx, tx, existing, premium.FeedID, premium.DownloadURL, premium.MimeType)
+		if err != nil {
+			return err
+		}
+		err = framework.PushQueueTask(ctx, tx, downloadworker.DownloadWorkerQueueName, existing.GUID)
+		if err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	existing.FeedID = premium.FeedID
+	existing.DownloadURL = premium.DownloadURL
+	existing.MimeType = premium.MimeType
+	existing.Status = podcasts.EpisodeStatusPending
+	return nil
+}
@@ -0,0 +1,86 @@
+package feedworker_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/feedworker"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+)
+
+func TestFeedWorker_PremiumFeedIsPreferred(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	feedService := &podcasts.FeedService{
+		HTTPClient: fixtures.TestDataHTTPClient,
+	}
+	evs := fixtures.ConfigureEncryptedValueServiceForTest()
+
+	// shares podcast:guid with the valid.xml fixture, which is already added
+	pod, _, err := podcasts.AddPodcast(
+		context.Background(), db, feedService, evs,
+		"http://testdata/feeds/valid-premium.xml", nil, true)
+	if err != nil {
+		panic(err)
+	}
+
+	feedWorker := feedworker.NewFeedWorkerQueueHandler(db, feedService, evs)
+	err = feedWorker(context.Background(), "")
+	assert.Nil(t, err)
+
+	eps, err := podcasts.ListEpisodes(context.Background(), db, pod.GUID)
+	if err != nil {
+		panic(err)
+	}
+	// 2 from public feed + 1 premium only, the duplicate is merged
+	assert.Len(t, eps, 3)
+
+	// episode in both feeds keeps its GUID but is sourced from the premium feed
+	ep, err := podcasts.GetEpisode(context.Background(), db, fixtures.PodEpGUID("ep-1"))
+	if err != nil {
+		panic(err)
+	}
+	assert.NotEqual(t, uint(0), ep.FeedID)
+	assert.Equal(t, "http://testdata/audio/ep1.mp3", ep.DownloadURL)
+	assert.Equal(t, podcasts.EpisodeStatusPending, ep.Status)
+
+	// episode only in public feed is unchanged
+	ep, err = podcasts.GetEpisode(context.Background(), db, fixtures.PodEpGUID("ep-2"))
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, uint(0), ep.FeedID)
+	assert.Equal(t, podcasts.EpisodeStatusSuccess, ep.Status)
+
+	// premium only episode is added to the existing podcast
+	ep, err = podcasts.GetEpisode(context.Background(), db, fixtures.PodEpGUID("premium-ep-3"))
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, pod.GUID, ep.PodcastGUID)
+	assert.Equal(t, podcasts.EpisodeStatusPending, ep.Status)
+}
+
+func TestFeedWorker_SkipsPrivatePodcasts(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	feedService := &podcasts.FeedService{
+		HTTPClient: fixtures.TestDataHTTPClient,
+	}
+	evs := fixtures.ConfigureEncryptedValueServiceForTest()
+
+	pod, err := podcasts.CreatePrivatePodcast(context.Background(), db, podcasts.PrivatePodcast{Title: "Private podcast"})
+	if err != nil {
+		panic(err)
+	}
+
+	feedWorker := feedworker.NewFeedWorkerQueueHandler(db, feedService, evs)
+	err = feedWorker(context.Background(), "")
+	assert.Nil(t, err)
+
+	pod, err = podcasts.GetPodcast(context.Background(), db, pod.GUID)
+	if err != nil {
+		panic(err)
+	}
+	assert.Nil(t, pod.LastCheckedAt)
+}
@@ -0,0 +1,460 @@
+// Package fileimport adds media files from the local file system to a
+// podcast's archive, e.g. episodes downloaded before using CastKeeper, and
+// media files uploaded as episodes of private podcasts.
+package fileimport
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gofrs/uuid/v5"
+	"github.com/webbgeorge/castkeeper/pkg/blobs"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"github.com/webbgeorge/castkeeper/pkg/mediainfo"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"github.com/webbgeorge/castkeeper/pkg/util"
+	"github.com/webbgeorge/gopodcast"
+	"gorm.io/gorm"
+)
+
+// ways in which a file is matched to an episode
+const (
+	MatchedByFileName = "filename"
+	MatchedByTitle    = "title"
+	MatchedByDate     = "date"
+	MatchedByCreated  = "created"
+)
+
+// fileDate matches a date in a file name, e.g. "2024-12-26 - Episode.mp3"
+var fileDate = regexp.MustCompile(`(\d{4})-(\d{2})-(\d{2})`)
+
+// ErrUnsupportedFile is returned for files whose extension isn't a supported
+// media type
+var ErrUnsupportedFile = errors.New("not a supported media file type")
+
+type Options struct {
+	// CreateUnmatched creates episodes for files which don't match an episode
+	// of the podcast, e.g. episodes which are no longer in its feed
+	CreateUnmatched bool
+}
+
+// FileResult is the outcome of importing a file
+type FileResult struct {
+	Path        string
+	EpisodeGUID string `json:",omitempty"`
+	// MatchedBy is how the file was matched to its episode, empty when the
+	// file was skipped
+	MatchedBy string `json:",omitempty"`
+	// Skipped is the reason the file wasn't imported
+	Skipped string `json:",omitempty"`
+}
+
+// ImportFiles copies local media files into object storage as episodes of a
+// podcast, without downloading them. Paths may be files or directories, which
+// are searched recursively. Each file is matched to an episode by its file
+// name, the title in its metadata tags, or a date in its file name, and the
+// episode is marked as downloaded. Files which can't be imported are skipped
+// with a reason, and don't stop the rest of the import.
+func ImportFiles(ctx context.Context, db *gorm.DB, objstore objectstorage.ObjectStorage, podcastGUID string, paths []string, opts Options) ([]FileResult, error) {
+	pod, err := podcasts.GetPodcast(ctx, db, podcastGUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get podcast: %w", err)
+	}
+	eps, err := podcasts.ListEpisodes(ctx, db, pod.GUID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list episodes: %w", err)
+	}
+
+	files, err := findFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	im := &importer{
+		db:       db,
+		objstore: objstore,
+		podcast:  pod,
+		episodes: eps,
+		claimed:  make(map[string]bool),
+		opts:     opts,
+	}
+	results := make([]FileResult, 0, len(files))
+	for _, f := range files {
+		result := im.importFile(ctx, f)
+		if result.Skipped != "" {
+			framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("skipped file '%s': %s", f, result.Skipped))
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// findFiles lists the files in paths, including the files in directories,
+// apart from hidden files
+func findFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		err := filepath.WalkDir(p, func(fp string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if fp != p && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.Type().IsRegular() {
+				files = append(files, fp)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read '%s': %w", p, err)
+		}
+	}
+	return files, nil
+}
+
+type importer struct {
+	db       *gorm.DB
+	objstore objectstorage.ObjectStorage
+	podcast  podcasts.Podcast
+	episodes []podcasts.Episode
+	// claimed are the episodes which files have been imported into during this
+	// import, so that two files aren't imported into the same episode
+	claimed map[string]bool
+	opts    Options
+}
+
+func (im *importer) importFile(ctx context.Context, filePath string) FileResult {
+	result := FileResult{Path: filePath}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		result.Skipped = err.Error()
+		return result
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		result.Skipped = err.Error()
+		return result
+	}
+
+	mimeType, err := detectMIMEType(f, filePath)
+	if err != nil {
+		result.Skipped = err.Error()
+		return result
+	}
+
+	info, err := mediainfo.Probe(f, stat.Size(), mimeType)
+	if err != nil && !errors.Is(err, mediainfo.ErrUnsupported) {
+		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to read media info of '%s': %s", filePath, err.Error()))
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		result.Skipped = err.Error()
+		return result
+	}
+
+	baseName := strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath))
+	date, hasDate := dateFromFileName(baseName)
+
+	ep, matchedBy, err := im.match(baseName, info.Title, date, hasDate)
+	if err != nil {
+		result.Skipped = err.Error()
+		return result
+	}
+	if ep == nil {
+		if !im.opts.CreateUnmatched {
+			result.Skipped = "no matching episode"
+			return result
+		}
+		ep = im.newEpisode(filePath, baseName, info.Title, date, hasDate, stat.ModTime())
+		matchedBy = MatchedByCreated
+	} else if ep.Status == podcasts.EpisodeStatusSuccess {
+		result.EpisodeGUID = ep.GUID
+		result.Skipped = "episode is already downloaded"
+		return result
+	}
+
+	err = im.save(ctx, ep, f, mimeType, matchedBy == MatchedByCreated)
+	if err != nil {
+		result.Skipped = err.Error()
+		return result
+	}
+	im.claimed[ep.GUID] = true
+
+	if info.DurationSecs > 0 {
+		if err := podcasts.UpdateEpisodeMediaInfo(ctx, im.db, ep, info); err != nil {
+			framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to save media info of episode '%s': %s", ep.GUID, err.Error()))
+		}
+	}
+
+	result.EpisodeGUID = ep.GUID
+	result.MatchedBy = matchedBy
+	return result
+}
+
+// detectMIMEType detects the type of a media file from its extension, checked
+// against its content
+func detectMIMEType(f io.ReadSeeker, fileName string) (string, error) {
+	declared, err := podcasts.DetectMIMEType(gopodcast.Enclosure{URL: fileName})
+	if err != nil {
+		return "", ErrUnsupportedFile
+	}
+
+	head := make([]byte, podcasts.SniffLength)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	mimeType, err := podcasts.CheckMediaContent(declared, head[:n])
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return mimeType, nil
+}
+
+// match finds the episode a file belongs to, trying each way of matching in
+// turn. A nil episode is returned when there is no match.
+func (im *importer) match(baseName, tagTitle string, date time.Time, hasDate bool) (*podcasts.Episode, string, error) {
+	matchers := []struct {
+		matchedBy string
+		matches   func(ep podcasts.Episode) bool
+	}{
+		{MatchedByFileName, func(ep podcasts.Episode) bool {
+			return matchesFileName(ep, baseName)
+		}},
+		{MatchedByTitle, func(ep podcasts.Episode) bool {
+			return tagTitle != "" && normaliseTitle(tagTitle) == normaliseTitle(ep.Title)
+		}},
+		{MatchedByDate, func(ep podcasts.Episode) bool {
+			return hasDate && !ep.PublishedAt.IsZero() && sameDay(ep.PublishedAt, date)
+		}},
+	}
+
+	for _, m := range matchers {
+		var found []*podcasts.Episode
+		for i, ep := range im.episodes {
+			if !im.claimed[ep.GUID] && m.matches(ep) {
+				found = append(found, &im.episodes[i])
+			}
+		}
+		// a date in the file name distinguishes episodes with the same title
+		if len(found) > 1 && hasDate && m.matchedBy != MatchedByDate {
+			var sameDate []*podcasts.Episode
+			for _, ep := range found {
+				if sameDay(ep.PublishedAt, date) {
+					sameDate = append(sameDate, ep)
+				}
+			}
+			found = sameDate
+		}
+		switch {
+		case len(found) == 1:
+			return found[0], m.matchedBy, nil
+		case len(found) > 1:
+			return nil, "", fmt.Errorf("matches %d episodes by %s", len(found), m.matchedBy)
+		}
+	}
+	return nil, "", nil
+}
+
+// matchesFileName reports whether a file name, without its extension, is the
+// episode's GUID, the name of the file in its download URL, or its title
+func matchesFileName(ep podcasts.Episode, baseName string) bool {
+	if baseName == ep.GUID || baseName == util.SanitiseGUID(ep.GUID) {
+		return true
+	}
+	if u, err := url.Parse(ep.DownloadURL); err == nil && ep.DownloadURL != "" {
+		urlName := path.Base(u.Path)
+		if strings.EqualFold(baseName, strings.TrimSuffix(urlName, path.Ext(urlName))) {
+			return true
+		}
+	}
+	title := normaliseTitle(titleFromFileName(baseName))
+	return title != "" && title == normaliseTitle(ep.Title)
+}
+
+func (im *importer) newEpisode(filePath, baseName, tagTitle string, date time.Time, hasDate bool, modTime time.Time) *podcasts.Episode {
+	title := tagTitle
+	if title == "" {
+		title = titleFromFileName(baseName)
+	}
+	publishedAt := modTime.UTC()
+	if hasDate {
+		publishedAt = date
+	}
+
+	// the same file imported again is given the same GUID
+	sum := sha256.Sum256([]byte(im.podcast.GUID + "/" + filepath.Base(filePath)))
+	return &podcasts.Episode{
+		GUID:        fmt.Sprintf("castkeeper-import-%x", sum[:16]),
+		PodcastGUID: im.podcast.GUID,
+		Title:       title,
+		PublishedAt: publishedAt,
+	}
+}
+
+// save copies a file into object storage and marks its episode as downloaded,
+// creating the episode when isNew is set
+func (im *importer) save(ctx context.Context, ep *podcasts.Episode, r io.Reader, mimeType string, isNew bool) error {
+	extension, err := podcasts.MIMETypeExtension(mimeType)
+	if err != nil {
+		return err
+	}
+	fileName := fmt.Sprintf("%s.%s", util.SanitiseGUID(ep.GUID), extension)
+
+	saved, err := im.objstore.Put(ctx, util.SanitiseGUID(im.podcast.GUID), fileName, r)
+	if err != nil {
+		return fmt.Errorf("failed to save file: %w", err)
+	}
+
+	if isNew {
+		ep.MimeType = mimeType
+		ep.Bytes = saved.Bytes
+		ep.SHA256 = saved.SHA256
+		ep.Status = podcasts.EpisodeStatusSuccess
+		if err := im.db.Create(ep).Error; err != nil {
+			return fmt.Errorf("failed to create episode: %w", err)
+		}
+		im.store(ctx, ep, fileName, saved)
+		im.episodes = append(im.episodes, *ep)
+		return nil
+	}
+
+	err = podcasts.UpdateEpisodeDownloaded(ctx, im.db, ep, saved.Bytes, saved.SHA256, mimeType)
+	if err != nil {
+		return fmt.Errorf("failed to update episode: %w", err)
+	}
+	im.store(ctx, ep, fileName, saved)
+	return nil
+}
+
+// store stores a saved file by its content. Failures are logged, as the file is
+// kept in the podcast's directory.
+func (im *importer) store(ctx context.Context, ep *podcasts.Episode, fileName string, saved objectstorage.SavedFile) {
+	if _, err := blobs.Store(ctx, im.db, im.objstore, ep, fileName, saved); err != nil {
+		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to store episode '%s' by its content: %s", ep.GUID, err.Error()))
+	}
+}
+
+// Upload is the metadata of a media file uploaded as a new episode
+type Upload struct {
+	FileName    string
+	Title       string
+	Description string
+	PublishedAt time.Time
+}
+
+// UploadEpisode saves an uploaded media file as a new, downloaded episode of a
+// podcast, e.g. of a private podcast hosted by CastKeeper. When not given, the
+// title is read from the file's metadata tags or name, and the episode is
+// published now.
+func UploadEpisode(ctx context.Context, db *gorm.DB, objstore objectstorage.ObjectStorage, podcastGUID string, upload Upload, f io.ReadSeeker, size int64) (podcasts.Episode, error) {
+	pod, err := podcasts.GetPodcast(ctx, db, podcastGUID)
+	if err != nil {
+		return podcasts.Episode{}, fmt.Errorf("failed to get podcast: %w", err)
+	}
+
+	mimeType, err := detectMIMEType(f, upload.FileName)
+	if err != nil {
+		return podcasts.Episode{}, err
+	}
+
+	info, err := mediainfo.Probe(f, size, mimeType)
+	if err != nil && !errors.Is(err, mediainfo.ErrUnsupported) {
+		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to read media info of '%s': %s", upload.FileName, err.Error()))
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return podcasts.Episode{}, err
+	}
+
+	guid, err := uuid.NewV4()
+	if err != nil {
+		return podcasts.Episode{}, err
+	}
+	ep := &podcasts.Episode{
+		GUID:        fmt.Sprintf("castkeeper-upload-%s", guid.String()),
+		PodcastGUID: pod.GUID,
+		Title:       upload.Title,
+		Description: upload.Description,
+		PublishedAt: upload.PublishedAt,
+	}
+	if ep.Title == "" {
+		ep.Title = info.Title
+	}
+	if ep.Title == "" {
+		ep.Title = titleFromFileName(strings.TrimSuffix(filepath.Base(upload.FileName), filepath.Ext(upload.FileName)))
+	}
+	if ep.PublishedAt.IsZero() {
+		ep.PublishedAt = time.Now().UTC()
+	}
+
+	im := &importer{db: db, objstore: objstore, podcast: pod}
+	if err := im.save(ctx, ep, f, mimeType, true); err != nil {
+		return *ep, err
+	}
+
+	if info.DurationSecs > 0 {
+		if err := podcasts.UpdateEpisodeMediaInfo(ctx, db, ep, info); err != nil {
+			framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to save media info of episode '%s': %s", ep.GUID, err.Error()))
+		}
+	}
+
+	return *ep, nil
+}
+
+func dateFromFileName(baseName string) (time.Time, bool) {
+	m := fileDate.FindString(baseName)
+	if m == "" {
+		return time.Time{}, false
+	}
+	date, err := time.Parse("2006-01-02", m)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// titleFromFileName removes a leading date from a file name, e.g. as written
+// by the mirror command
+func titleFromFileName(baseName string) string {
+	loc := fileDate.FindStringIndex(baseName)
+	if loc == nil || loc[0] != 0 {
+		return strings.TrimSpace(baseName)
+	}
+	return strings.TrimLeft(baseName[loc[1]:], " -_")
+}
+
+// normaliseTitle compares titles by their letters and digits only, as
+// punctuation is often changed in file names
+func normaliseTitle(title string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}), " ")
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.UTC().Date()
+	by, bm, bd := b.UTC().Date()
+	return ay == by && am == bm && ad == bd
+}
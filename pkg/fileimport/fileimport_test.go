@@ -0,0 +1,278 @@
+package fileimport_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/downloadworker"
+	"github.com/webbgeorge/castkeeper/pkg/fileimport"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"github.com/webbgeorge/castkeeper/pkg/mediainfo"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+)
+
+func TestImportFiles(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{Root: root}
+
+	// from valid-eps-pending.xml fixture
+	podGUID := fixtures.PodEpGUID("pod-eps-pending")
+	for guid, ep := range map[string]struct {
+		title       string
+		publishedAt time.Time
+	}{
+		"title-ep": {"The Big Interview: Part 2", time.Date(2025, 1, 5, 9, 0, 0, 0, time.UTC)},
+		"tag-ep":   {"Tagged Title", time.Date(2025, 2, 1, 9, 0, 0, 0, time.UTC)},
+		"date-ep":  {"Something else", time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)},
+	} {
+		if err := db.Create(&podcasts.Episode{
+			GUID:        guid,
+			PodcastGUID: podGUID,
+			Title:       ep.title,
+			DownloadURL: fmt.Sprintf("http://example.com/%s.mp3", guid),
+			MimeType:    "audio/mpeg",
+			PublishedAt: ep.publishedAt,
+			Status:      podcasts.EpisodeStatusFailed,
+		}).Error; err != nil {
+			panic(err)
+		}
+	}
+
+	withChapters, err := os.ReadFile("../fixtures/testdata/audio/with-chapters.mp3")
+	if err != nil {
+		panic(err)
+	}
+	var tagged bytes.Buffer
+	err = mediainfo.WriteTags(bytes.NewReader(withChapters), &tagged, "audio/mpeg", mediainfo.Tags{EpisodeTitle: "Tagged Title"})
+	if err != nil {
+		panic(err)
+	}
+
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"ep1.mp3":                                []byte("ID3 ep1 content\n"),
+		"old/The Big Interview - Part 2.mp3":     []byte("ID3 interview\n"),
+		"old/recording.mp3":                      tagged.Bytes(),
+		"old/2025-03-10 something.mp3":           []byte("ID3 dated\n"),
+		"old/2023-06-01 - Removed from feed.mp3": []byte("ID3 removed\n"),
+		"old/notes.txt":                          []byte("some notes"),
+		"old/error-page.mp3":                     []byte("<html><body>Not found</body></html>"),
+		"old/.hidden/ignored.mp3":                []byte("ID3 hidden\n"),
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(path.Join(dir, path.Dir(name)), 0750); err != nil {
+			panic(err)
+		}
+		if err := os.WriteFile(path.Join(dir, name), content, 0640); err != nil {
+			panic(err)
+		}
+	}
+
+	results, err := fileimport.ImportFiles(ctx, db, objstore, podGUID, []string{
+		path.Join(dir, "ep1.mp3"),
+		path.Join(dir, "old"),
+	}, fileimport.Options{CreateUnmatched: true})
+
+	assert.Nil(t, err)
+	byPath := make(map[string]fileimport.FileResult)
+	for _, r := range results {
+		byPath[r.Path[len(dir)+1:]] = r
+	}
+	assert.Len(t, byPath, 7)
+
+	assertImported := func(name, epGUID, matchedBy string) {
+		t.Helper()
+		r := byPath[name]
+		assert.Equal(t, "", r.Skipped, name)
+		assert.Equal(t, matchedBy, r.MatchedBy, name)
+		if epGUID != "" {
+			assert.Equal(t, epGUID, r.EpisodeGUID, name)
+		}
+
+		ep, err := podcasts.GetEpisode(ctx, db, r.EpisodeGUID)
+		if !assert.Nil(t, err, name) {
+			return
+		}
+		assert.Equal(t, podcasts.EpisodeStatusSuccess, ep.Status, name)
+		stored, err := root.ReadFile(fmt.Sprintf("%s/%s", podcasts.BlobDir, ep.BlobSHA256))
+		assert.Nil(t, err, name)
+		assert.Equal(t, files[name], stored, name)
+		assert.Equal(t, int64(len(files[name])), ep.Bytes, name)
+	}
+	// matches the file name of the episode's download URL
+	assertImported("ep1.mp3", fixtures.PodEpGUID("pending-ep-1"), fileimport.MatchedByFileName)
+	assertImported("old/The Big Interview - Part 2.mp3", "title-ep", fileimport.MatchedByFileName)
+	assertImported("old/recording.mp3", "tag-ep", fileimport.MatchedByTitle)
+	assertImported("old/2025-03-10 something.mp3", "date-ep", fileimport.MatchedByDate)
+	assertImported("old/2023-06-01 - Removed from feed.mp3", "", fileimport.MatchedByCreated)
+
+	created, err := podcasts.GetEpisode(ctx, db, byPath["old/2023-06-01 - Removed from feed.mp3"].EpisodeGUID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Removed from feed", created.Title)
+	assert.Equal(t, time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), created.PublishedAt.UTC())
+	assert.Equal(t, "", created.DownloadURL)
+
+	tagEp, err := podcasts.GetEpisode(ctx, db, "tag-ep")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, tagEp.MediaDurationSecs)
+
+	assert.Equal(t, "not a supported media file type", byPath["old/notes.txt"].Skipped)
+	assert.Contains(t, byPath["old/error-page.mp3"].Skipped, "content is not a supported media type")
+
+	// importing again doesn't create duplicate episodes
+	results, err = fileimport.ImportFiles(ctx, db, objstore, podGUID, []string{path.Join(dir, "old")}, fileimport.Options{CreateUnmatched: true})
+
+	assert.Nil(t, err)
+	for _, r := range results {
+		assert.NotEqual(t, fileimport.MatchedByCreated, r.MatchedBy, r.Path)
+	}
+	assert.Contains(t, results, fileimport.FileResult{
+		Path:        path.Join(dir, "old/2023-06-01 - Removed from feed.mp3"),
+		EpisodeGUID: created.GUID,
+		Skipped:     "episode is already downloaded",
+	})
+}
+
+func TestImportFiles_QueuedDownloadIsSkipped(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{
+		HTTPClient: fixtures.TestDataHTTPClient,
+		Root:       root,
+	}
+
+	// from valid-eps-pending.xml fixture
+	podGUID := fixtures.PodEpGUID("pod-eps-pending")
+	epGUID := fixtures.PodEpGUID("pending-ep-1")
+	err := framework.PushQueueTask(ctx, db, downloadworker.DownloadWorkerQueueName, epGUID)
+	if err != nil {
+		panic(err)
+	}
+
+	content := []byte("ID3 imported content\n")
+	filePath := path.Join(t.TempDir(), "ep1.mp3")
+	if err := os.WriteFile(filePath, content, 0640); err != nil {
+		panic(err)
+	}
+	results, err := fileimport.ImportFiles(ctx, db, objstore, podGUID, []string{filePath}, fileimport.Options{})
+	assert.Nil(t, err)
+	assert.Equal(t, epGUID, results[0].EpisodeGUID)
+
+	var task framework.QueueTask
+	if err := db.First(&task, "queue_name = ?", downloadworker.DownloadWorkerQueueName).Error; err != nil {
+		panic(err)
+	}
+	dlWorker := downloadworker.NewDownloadWorkerQueueHandler(db, objstore, nil, false, 0)
+	err = dlWorker(ctx, task.Data)
+
+	assert.Nil(t, err)
+	ep, err := podcasts.GetEpisode(ctx, db, epGUID)
+	assert.Nil(t, err)
+	assert.Equal(t, podcasts.EpisodeStatusSuccess, ep.Status)
+	assert.Equal(t, int64(len(content)), ep.Bytes)
+	stored, err := root.ReadFile(fmt.Sprintf("%s/%s", podcasts.BlobDir, ep.BlobSHA256))
+	assert.Nil(t, err)
+	assert.Equal(t, content, stored)
+}
+
+func TestImportFiles_SkipUnmatched(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, "unknown.mp3"), []byte("ID3 unknown\n"), 0640); err != nil {
+		panic(err)
+	}
+
+	results, err := fileimport.ImportFiles(
+		context.Background(), db, &objectstorage.LocalObjectStorage{Root: root},
+		fixtures.PodEpGUID("pod-eps-pending"), []string{dir}, fileimport.Options{},
+	)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []fileimport.FileResult{{
+		Path:    path.Join(dir, "unknown.mp3"),
+		Skipped: "no matching episode",
+	}}, results)
+}
+
+func TestImportFiles_PodcastNotFound(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	_, err := fileimport.ImportFiles(
+		context.Background(), db, &objectstorage.LocalObjectStorage{Root: root},
+		"not-a-podcast", []string{t.TempDir()}, fileimport.Options{},
+	)
+
+	assert.ErrorContains(t, err, "failed to get podcast")
+}
+
+func TestUploadEpisode(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	pod, err := podcasts.CreatePrivatePodcast(ctx, db, podcasts.PrivatePodcast{Title: "Team meetings"})
+	if err != nil {
+		panic(err)
+	}
+	content, err := os.ReadFile("../fixtures/testdata/audio/with-chapters.mp3")
+	if err != nil {
+		panic(err)
+	}
+
+	ep, err := fileimport.UploadEpisode(
+		ctx, db, &objectstorage.LocalObjectStorage{Root: root}, pod.GUID,
+		fileimport.Upload{FileName: "2025-04-01 - Planning meeting.mp3", Description: "Planning for Q2"},
+		bytes.NewReader(content), int64(len(content)),
+	)
+
+	assert.Nil(t, err)
+	dbEp, err := podcasts.GetEpisode(ctx, db, ep.GUID)
+	assert.Nil(t, err)
+	assert.Equal(t, pod.GUID, dbEp.PodcastGUID)
+	// read from the file's tags
+	assert.Equal(t, "Episode with chapters", dbEp.Title)
+	assert.Equal(t, "Planning for Q2", dbEp.Description)
+	assert.Equal(t, "audio/mpeg", dbEp.MimeType)
+	assert.Equal(t, podcasts.EpisodeStatusSuccess, dbEp.Status)
+	assert.Equal(t, "", dbEp.DownloadURL)
+	assert.Equal(t, 2, dbEp.MediaDurationSecs)
+	assert.False(t, dbEp.PublishedAt.IsZero())
+
+	stored, err := root.ReadFile(fmt.Sprintf("%s/%s", podcasts.BlobDir, ep.BlobSHA256))
+	assert.Nil(t, err)
+	assert.Equal(t, content, stored)
+}
+
+func TestUploadEpisode_NotMedia(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	content := []byte("<html><body>Not audio</body></html>")
+	_, err := fileimport.UploadEpisode(
+		context.Background(), db, &objectstorage.LocalObjectStorage{Root: root},
+		fixtures.PodEpGUID("pod-eps-pending"), fileimport.Upload{FileName: "episode.mp3"},
+		bytes.NewReader(content), int64(len(content)),
+	)
+
+	assert.ErrorContains(t, err, "content is not a supported media type")
+}
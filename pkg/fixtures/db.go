@@ -75,8 +75,8 @@ func podFixture(
 	feedService := &podcasts.FeedService{
 		HTTPClient: TestDataHTTPClient,
 	}
-	_, err := podcasts.AddPodcast(
-		context.Background(), db, feedService, evs, feedURL, creds)
+	_, _, err := podcasts.AddPodcast(
+		context.Background(), db, feedService, evs, feedURL, creds, false)
 	if err != nil {
 		panic(err)
 	}
@@ -1,6 +1,10 @@
 package fixtures
 
-import "github.com/webbgeorge/castkeeper/pkg/database/encryption"
+import (
+	"github.com/tink-crypto/tink-go/v2/keyset"
+	"github.com/tink-crypto/tink-go/v2/streamingaead"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+)
 
 func ConfigureEncryptedValueServiceForTest() *encryption.EncryptedValueService {
 	aead, err := encryption.DeriveAEADFromSecret("00000000")
@@ -9,3 +13,19 @@ func ConfigureEncryptedValueServiceForTest() *encryption.EncryptedValueService {
 	}
 	return encryption.NewEncryptedValueService(aead)
 }
+
+func ConfigureMediaEncryptionServiceForTest() *encryption.MediaEncryptionService {
+	handle, err := keyset.NewHandle(streamingaead.AES256GCMHKDF1MBKeyTemplate())
+	if err != nil {
+		panic(err)
+	}
+	streamingAEAD, err := streamingaead.New(handle)
+	if err != nil {
+		panic(err)
+	}
+	mes, err := encryption.NewMediaEncryptionService(streamingAEAD)
+	if err != nil {
+		panic(err)
+	}
+	return mes
+}
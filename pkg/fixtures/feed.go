@@ -1,6 +1,9 @@
 package fixtures
 
 import (
+	"crypto/sha256"
+	"fmt"
+	"io"
 	"net/http"
 	"os"
 	"path"
@@ -26,6 +29,11 @@ func (t *testDataTransport) RoundTrip(r *http.Request) (*http.Response, error) {
 		panic("unexpected testdata file path")
 	}
 
+	// behave like a real transport when the request is cancelled
+	if err := r.Context().Err(); err != nil {
+		return nil, err
+	}
+
 	// path to force and error response
 	if r.URL.Path == "/error" {
 		return &http.Response{
@@ -53,13 +61,58 @@ func (t *testDataTransport) RoundTrip(r *http.Request) (*http.Response, error) {
 	if err != nil {
 		panic(err)
 	}
+	fi, err := f.Stat()
+	if err != nil {
+		panic(err)
+	}
+
+	etag := TestDataETag(filePath)
+	header := http.Header{}
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("ETag", etag)
+
+	// supports open ended range requests, for resuming downloads, unless
+	// If-Range doesn't match
+	var offset int64
+	ifRange := r.Header.Get("If-Range")
+	if _, err := fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &offset); err == nil && (ifRange == "" || ifRange == etag) {
+		if offset >= fi.Size() {
+			_ = f.Close()
+			return &http.Response{
+				StatusCode: http.StatusRequestedRangeNotSatisfiable,
+				Body:       http.NoBody,
+			}, nil
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			panic(err)
+		}
+		header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, fi.Size()-1, fi.Size()))
+		return &http.Response{
+			StatusCode:    http.StatusPartialContent,
+			Header:        header,
+			ContentLength: fi.Size() - offset,
+			Body:          f,
+		}, nil
+	}
 
 	return &http.Response{
-		StatusCode: http.StatusOK,
-		Body:       f,
+		StatusCode:    http.StatusOK,
+		Header:        header,
+		ContentLength: fi.Size(),
+		Body:          f,
 	}, nil
 }
 
+// TestDataETag returns the ETag of a file served by TestDataHTTPClient, which
+// is based on its content
+func TestDataETag(filePath string) string {
+	data, err := os.ReadFile(path.Join(fixtureDir(), "testdata", filePath))
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf(`"%x"`, sha256.Sum256(data))
+}
+
 func fixtureDir() string {
 	_, thisFilePath, _, _ := runtime.Caller(0)
 	return path.Join(path.Dir(thisFilePath))
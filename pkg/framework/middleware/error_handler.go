@@ -15,6 +15,11 @@ func (mw ErrorHandlerMiddleware) Handler(next framework.Handler, _ framework.Mid
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		defer func() {
 			if rec := recover(); rec != nil {
+				// handlers abort responses which can't be completed, e.g. after
+				// part of a download was written, the server closes the connection
+				if rec == http.ErrAbortHandler {
+					panic(rec)
+				}
 				framework.GetLogger(ctx).ErrorContext(
 					ctx, "recovered from panic",
 					"error", fmt.Sprintf("Panic: %+v, req: %s %s", rec, r.Method, r.URL.Path),
@@ -20,6 +20,12 @@ func (lrw *loggingResponseWriter) WriteHeader(statusCode int) {
 	lrw.ResponseWriter.WriteHeader(statusCode)
 }
 
+// Unwrap allows http.ResponseController to reach the underlying writer, e.g.
+// to change its deadlines
+func (lrw *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return lrw.ResponseWriter
+}
+
 type LogMiddleware struct{}
 
 func (mw LogMiddleware) Handler(next framework.Handler, _ framework.MiddlewareConfig) framework.Handler {
@@ -49,6 +49,27 @@ func PushQueueTask(ctx context.Context, db *gorm.DB, queueName string, data any)
 	return nil
 }
 
+// PushQueueTasks pushes a task to the queue for each item of data, in a single
+// insert
+func PushQueueTasks(ctx context.Context, db *gorm.DB, queueName string, data []any) error {
+	if len(data) == 0 {
+		return nil
+	}
+	queueTasks := make([]QueueTask, 0, len(data))
+	for _, d := range data {
+		queueTasks = append(queueTasks, QueueTask{
+			QueueName:    queueName,
+			VisibleAfter: time.Now(),
+			ReceiveCount: 0,
+			Data:         d,
+		})
+	}
+	if err := db.Create(&queueTasks).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
 func PopQueueTask(ctx context.Context, db *gorm.DB, queueName string) (QueueTask, error) {
 	var queueTask QueueTask
 	err := db.Transaction(func(tx *gorm.DB) error {
@@ -99,6 +120,166 @@ func returnQueueTask(db *gorm.DB, queueTask QueueTask) error {
 	return nil
 }
 
+// releaseQueueTask makes a task visible again straight away, without counting
+// the receive, e.g. when it was interrupted by shutdown
+func releaseQueueTask(db *gorm.DB, queueTask QueueTask) error {
+	queueTask.VisibleAfter = time.Now()
+	if queueTask.ReceiveCount > 0 {
+		queueTask.ReceiveCount--
+	}
+	if err := db.Save(&queueTask).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+const (
+	QueueTaskStateReady    = "ready"
+	QueueTaskStateInFlight = "in-flight"
+	QueueTaskStateStale    = "stale"
+)
+
+// State describes whether a task is ready to be processed, in-flight (being
+// processed or waiting to be retried), or stale (it has exceeded the maximum
+// number of receives and will not be processed again)
+func (t QueueTask) State() string {
+	if t.ReceiveCount > maxReceives {
+		return QueueTaskStateStale
+	}
+	if t.VisibleAfter.After(time.Now()) {
+		return QueueTaskStateInFlight
+	}
+	return QueueTaskStateReady
+}
+
+type QueueStats struct {
+	QueueName string
+	Total     int64
+	Ready     int64
+	InFlight  int64
+	Stale     int64
+}
+
+// QueueTaskFilter selects queue tasks for inspection and maintenance. Empty
+// fields match all tasks.
+type QueueTaskFilter struct {
+	QueueName string
+	IDs       []uint
+	State     string
+}
+
+func (f QueueTaskFilter) validate() error {
+	switch f.State {
+	case "", QueueTaskStateReady, QueueTaskStateInFlight, QueueTaskStateStale:
+		return nil
+	default:
+		return fmt.Errorf("invalid queue task state '%s'", f.State)
+	}
+}
+
+func (f QueueTaskFilter) scope(db *gorm.DB) *gorm.DB {
+	if f.QueueName != "" {
+		db = db.Where("queue_name = ?", f.QueueName)
+	}
+	if len(f.IDs) > 0 {
+		db = db.Where("id IN ?", f.IDs)
+	}
+	switch f.State {
+	case QueueTaskStateReady:
+		db = db.Where("receive_count <= ? AND visible_after < ?", maxReceives, time.Now())
+	case QueueTaskStateInFlight:
+		db = db.Where("receive_count <= ? AND visible_after >= ?", maxReceives, time.Now())
+	case QueueTaskStateStale:
+		db = db.Where("receive_count > ?", maxReceives)
+	}
+	return db
+}
+
+func ListQueueTasks(ctx context.Context, db *gorm.DB, filter QueueTaskFilter) ([]QueueTask, error) {
+	if err := filter.validate(); err != nil {
+		return nil, err
+	}
+
+	var queueTasks []QueueTask
+	result := db.
+		Scopes(filter.scope).
+		Order("queue_name asc, created_at asc").
+		Find(&queueTasks)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return queueTasks, nil
+}
+
+func GetQueueStats(ctx context.Context, db *gorm.DB) ([]QueueStats, error) {
+	now := time.Now()
+	stats := make([]QueueStats, 0)
+	result := db.
+		Model(&QueueTask{}).
+		Select(
+			"queue_name, COUNT(*) AS total, "+
+				"SUM(CASE WHEN receive_count <= ? AND visible_after < ? THEN 1 ELSE 0 END) AS ready, "+
+				"SUM(CASE WHEN receive_count <= ? AND visible_after >= ? THEN 1 ELSE 0 END) AS in_flight, "+
+				"SUM(CASE WHEN receive_count > ? THEN 1 ELSE 0 END) AS stale",
+			maxReceives, now, maxReceives, now, maxReceives,
+		).
+		Group("queue_name").
+		Order("queue_name asc").
+		Scan(&stats)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return stats, nil
+}
+
+// ErrQueueTasksInFlight is returned when retrying in-flight tasks without
+// force, as they may still be being processed by a worker
+var ErrQueueTasksInFlight = errors.New("in-flight tasks may still be being processed, they can only be retried with force")
+
+// RetryQueueTasks makes the matching tasks visible immediately and resets
+// their receive count, so that stale tasks are processed again. In-flight
+// tasks are skipped unless force is set, as retrying a task which a worker is
+// still processing would process it twice at once.
+func RetryQueueTasks(ctx context.Context, db *gorm.DB, filter QueueTaskFilter, force bool) (int64, error) {
+	if err := filter.validate(); err != nil {
+		return 0, err
+	}
+	if filter.State == QueueTaskStateInFlight && !force {
+		return 0, ErrQueueTasksInFlight
+	}
+
+	query := db.
+		Model(&QueueTask{}).
+		Scopes(filter.scope)
+	if !force {
+		query = query.Where("receive_count > ? OR visible_after < ?", maxReceives, time.Now())
+	}
+
+	result := query.
+		UpdateColumns(map[string]any{
+			"visible_after": time.Now(),
+			"receive_count": 0,
+		})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+func DeleteQueueTasks(ctx context.Context, db *gorm.DB, filter QueueTaskFilter) (int64, error) {
+	if err := filter.validate(); err != nil {
+		return 0, err
+	}
+
+	result := db.
+		Scopes(filter.scope).
+		Delete(&QueueTask{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
 type QueueWorker struct {
 	DB        *gorm.DB
 	QueueName string
@@ -119,11 +300,24 @@ func (w *QueueWorker) Start(ctx context.Context) error {
 			if !errors.Is(err, gorm.ErrRecordNotFound) {
 				GetLogger(ctx).ErrorContext(ctx, fmt.Sprintf("failed to pop task from queue '%s' with err '%s'", w.QueueName, err.Error()))
 			}
-			time.Sleep(10 * time.Second) // no jobs on queue, or other error, wait before next poll
+			// no jobs on queue, or other error, wait before next poll
+			select {
+			case <-ctx.Done():
+			case <-time.After(10 * time.Second):
+			}
 			continue
 		}
 
 		err = w.HandlerFn(ctx, qt.Data)
+		if err != nil && ctx.Err() != nil {
+			// shutting down, return the task to the queue so it is picked up on next start
+			GetLogger(ctx).InfoContext(ctx, fmt.Sprintf("task '%d' of queue '%s' interrupted by shutdown, returning to queue", qt.ID, w.QueueName))
+			err = releaseQueueTask(w.DB, qt)
+			if err != nil {
+				GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to return task '%d' to queue '%s' with err '%s'", qt.ID, w.QueueName, err.Error()))
+			}
+			return ctx.Err()
+		}
 		if err != nil {
 			GetLogger(ctx).ErrorContext(ctx, fmt.Sprintf("failed to process task '%d' of queue '%s' with err '%s'", qt.ID, w.QueueName, err.Error()))
 			err = returnQueueTask(w.DB, qt)
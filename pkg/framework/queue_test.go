@@ -0,0 +1,158 @@
+package framework_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"gorm.io/gorm"
+)
+
+func TestGetQueueStats(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	createQueueTasksForTest(t, db)
+
+	stats, err := framework.GetQueueStats(context.Background(), db)
+	assert.Nil(t, err)
+
+	assert.Equal(t, []framework.QueueStats{
+		{QueueName: "queue-a", Total: 3, Ready: 1, InFlight: 1, Stale: 1},
+		{QueueName: "queue-b", Total: 1, Ready: 1},
+	}, stats)
+}
+
+func TestListQueueTasks(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	createQueueTasksForTest(t, db)
+
+	tasks, err := framework.ListQueueTasks(context.Background(), db, framework.QueueTaskFilter{
+		QueueName: "queue-a",
+		State:     framework.QueueTaskStateStale,
+	})
+	assert.Nil(t, err)
+	assert.Len(t, tasks, 1)
+	assert.Equal(t, "stale", tasks[0].Data)
+
+	_, err = framework.ListQueueTasks(context.Background(), db, framework.QueueTaskFilter{
+		State: "not-a-state",
+	})
+	assert.Equal(t, "invalid queue task state 'not-a-state'", err.Error())
+}
+
+func TestRetryQueueTasks(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	createQueueTasksForTest(t, db)
+
+	n, err := framework.RetryQueueTasks(context.Background(), db, framework.QueueTaskFilter{
+		State: framework.QueueTaskStateStale,
+	}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+
+	// the retried task is now ready, and can be popped again
+	tasks, err := framework.ListQueueTasks(context.Background(), db, framework.QueueTaskFilter{
+		QueueName: "queue-a",
+		State:     framework.QueueTaskStateReady,
+	})
+	assert.Nil(t, err)
+	assert.Len(t, tasks, 2)
+	for _, task := range tasks {
+		assert.Equal(t, uint(0), task.ReceiveCount)
+	}
+}
+
+func TestRetryQueueTasks_InFlight(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	createQueueTasksForTest(t, db)
+
+	// in-flight tasks are skipped, as they may be being processed
+	n, err := framework.RetryQueueTasks(context.Background(), db, framework.QueueTaskFilter{
+		QueueName: "queue-a",
+	}, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), n)
+
+	_, err = framework.RetryQueueTasks(context.Background(), db, framework.QueueTaskFilter{
+		State: framework.QueueTaskStateInFlight,
+	}, false)
+	assert.ErrorIs(t, err, framework.ErrQueueTasksInFlight)
+
+	tasks, err := framework.ListQueueTasks(context.Background(), db, framework.QueueTaskFilter{
+		State: framework.QueueTaskStateInFlight,
+	})
+	assert.Nil(t, err)
+	assert.Len(t, tasks, 1)
+
+	// unless forced
+	n, err = framework.RetryQueueTasks(context.Background(), db, framework.QueueTaskFilter{
+		State: framework.QueueTaskStateInFlight,
+	}, true)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), n)
+}
+
+func TestDeleteQueueTasks(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	ids := createQueueTasksForTest(t, db)
+
+	n, err := framework.DeleteQueueTasks(context.Background(), db, framework.QueueTaskFilter{
+		IDs: ids[:2],
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), n)
+
+	tasks, err := framework.ListQueueTasks(context.Background(), db, framework.QueueTaskFilter{})
+	assert.Nil(t, err)
+	assert.Len(t, tasks, 2)
+}
+
+func TestQueueWorker_ReleasesTaskOnShutdown(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	err := framework.PushQueueTask(context.Background(), db, "queue-a", "data")
+	if err != nil {
+		panic(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	worker := framework.QueueWorker{
+		DB:        db,
+		QueueName: "queue-a",
+		HandlerFn: func(ctx context.Context, data any) error {
+			// shutdown while the task is being processed
+			cancel()
+			return ctx.Err()
+		},
+	}
+
+	err = worker.Start(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	tasks, err := framework.ListQueueTasks(context.Background(), db, framework.QueueTaskFilter{
+		QueueName: "queue-a",
+	})
+	assert.Nil(t, err)
+	assert.Len(t, tasks, 1)
+	assert.Equal(t, framework.QueueTaskStateReady, tasks[0].State())
+	assert.Equal(t, uint(0), tasks[0].ReceiveCount)
+}
+
+func createQueueTasksForTest(t *testing.T, db *gorm.DB) []uint {
+	t.Helper()
+	tasks := []framework.QueueTask{
+		{QueueName: "queue-a", VisibleAfter: time.Now().Add(-time.Minute), Data: "ready"},
+		{QueueName: "queue-a", VisibleAfter: time.Now().Add(time.Hour), ReceiveCount: 1, Data: "in-flight"},
+		{QueueName: "queue-a", VisibleAfter: time.Now().Add(-time.Minute), ReceiveCount: 6, Data: "stale"},
+		{QueueName: "queue-b", VisibleAfter: time.Now().Add(-time.Minute), Data: "ready"},
+	}
+	ids := make([]uint, 0, len(tasks))
+	for _, task := range tasks {
+		if err := db.Create(&task).Error; err != nil {
+			panic(err)
+		}
+		ids = append(ids, task.ID)
+	}
+	return ids
+}
@@ -0,0 +1,108 @@
+package integrityworker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/webbgeorge/castkeeper/pkg/downloadworker"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"gorm.io/gorm"
+)
+
+const (
+	IntegrityWorkerQueueName = "integrityWorker"
+
+	// maximum number of episodes checked each time the task runs, so that large
+	// archives are audited gradually
+	auditBatchSize = 50
+)
+
+// NewIntegrityWorkerQueueHandler re-hashes the stored files of episodes which
+// have not been verified within auditInterval, and flags files which are
+// missing or don't match their recorded hash. When autoRedownload is set,
+// flagged episodes are queued to be downloaded again.
+func NewIntegrityWorkerQueueHandler(
+	db *gorm.DB,
+	os objectstorage.ObjectStorage,
+	auditInterval time.Duration,
+	autoRedownload bool,
+) func(context.Context, any) error {
+	return func(ctx context.Context, _ any) error {
+		eps, err := podcasts.ListEpisodesToVerify(ctx, db, time.Now().Add(-auditInterval), auditBatchSize)
+		if err != nil {
+			return fmt.Errorf("failed to list episodes to verify: %w", err)
+		}
+
+		errs := make([]error, 0)
+		for _, ep := range eps {
+			problem, err := VerifyEpisode(ctx, db, os, &ep)
+			if err != nil {
+				framework.GetLogger(ctx).ErrorContext(ctx, fmt.Sprintf("failed to verify episode '%s': %s", ep.GUID, err.Error()))
+				errs = append(errs, err)
+				continue
+			}
+			if problem == "" {
+				continue
+			}
+
+			framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("stored file of episode '%s' is %s", ep.GUID, problem))
+			if autoRedownload {
+				if err := downloadworker.RequeueDownload(ctx, db, &ep); err != nil {
+					errs = append(errs, fmt.Errorf("failed to requeue episode '%s': %w", ep.GUID, err))
+				}
+			}
+		}
+
+		if len(errs) > 0 {
+			return errors.Join(errs...)
+		}
+
+		return nil
+	}
+}
+
+// VerifyEpisode checks the stored file of an episode against its recorded size
+// and hash, and records the result. The problem found is returned, which is
+// empty when the file is intact. Episodes downloaded before hashes were
+// recorded have their hash recorded on first check.
+func VerifyEpisode(ctx context.Context, db *gorm.DB, os objectstorage.ObjectStorage, ep *podcasts.Episode) (string, error) {
+	dir, fileName, err := podcasts.EpisodeFile(*ep)
+	if err != nil {
+		return "", err
+	}
+
+	problem := ""
+	sha256 := ep.SHA256
+
+	f, err := os.Open(ctx, dir, fileName)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	if err != nil {
+		problem = podcasts.IntegrityProblemMissing
+	} else {
+		defer f.Close()
+		saved, err := objectstorage.HashFile(f)
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case ep.Bytes > 0 && saved.Bytes != ep.Bytes:
+			problem = podcasts.IntegrityProblemCorrupted
+		case ep.SHA256 != "" && saved.SHA256 != ep.SHA256:
+			problem = podcasts.IntegrityProblemCorrupted
+		case ep.SHA256 == "":
+			sha256 = saved.SHA256
+		}
+	}
+
+	if err := podcasts.UpdateEpisodeIntegrity(ctx, db, ep, sha256, problem); err != nil {
+		return "", err
+	}
+	return problem, nil
+}
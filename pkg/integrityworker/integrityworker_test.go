@@ -0,0 +1,136 @@
+package integrityworker_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/downloadworker"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+	"github.com/webbgeorge/castkeeper/pkg/integrityworker"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"gorm.io/gorm"
+)
+
+func TestVerifyEpisode_Intact(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{HTTPClient: fixtures.TestDataHTTPClient, Root: root}
+
+	ep := downloadEpisodeForTest(db, objstore, fixtures.PodEpGUID("pending-ep-1"))
+
+	problem, err := integrityworker.VerifyEpisode(context.Background(), db, objstore, &ep)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", problem)
+	ep = getEpisode(db, ep.GUID)
+	assert.Equal(t, "", ep.IntegrityProblem)
+	assert.NotNil(t, ep.VerifiedAt)
+}
+
+func TestVerifyEpisode_Corrupted(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{HTTPClient: fixtures.TestDataHTTPClient, Root: root}
+
+	ep := downloadEpisodeForTest(db, objstore, fixtures.PodEpGUID("pending-ep-1"))
+
+	// same size, different content
+	err := root.WriteFile(fmt.Sprintf("%s/%s", podcasts.BlobDir, ep.BlobSHA256), []byte("ID3 ep1 c0ntent\n"), 0640)
+	if err != nil {
+		panic(err)
+	}
+
+	problem, err := integrityworker.VerifyEpisode(context.Background(), db, objstore, &ep)
+
+	assert.Nil(t, err)
+	assert.Equal(t, podcasts.IntegrityProblemCorrupted, problem)
+	assert.Equal(t, podcasts.IntegrityProblemCorrupted, getEpisode(db, ep.GUID).IntegrityProblem)
+}
+
+func TestVerifyEpisode_RecordsHashOfExistingDownloads(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{HTTPClient: fixtures.TestDataHTTPClient, Root: root}
+
+	ep := downloadEpisodeForTest(db, objstore, fixtures.PodEpGUID("pending-ep-1"))
+	expectedSHA256 := ep.SHA256
+
+	// downloaded before hashes were recorded
+	if err := db.Model(&ep).Update("sha256", "").Error; err != nil {
+		panic(err)
+	}
+	ep = getEpisode(db, ep.GUID)
+
+	problem, err := integrityworker.VerifyEpisode(context.Background(), db, objstore, &ep)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", problem)
+	assert.Equal(t, expectedSHA256, getEpisode(db, ep.GUID).SHA256)
+}
+
+func TestIntegrityWorker_MissingFileIsRedownloaded(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{HTTPClient: fixtures.TestDataHTTPClient, Root: root}
+
+	ep := downloadEpisodeForTest(db, objstore, fixtures.PodEpGUID("pending-ep-1"))
+	if err := root.Remove(fmt.Sprintf("%s/%s", podcasts.BlobDir, ep.BlobSHA256)); err != nil {
+		panic(err)
+	}
+
+	worker := integrityworker.NewIntegrityWorkerQueueHandler(db, objstore, 0, true)
+	err := worker(context.Background(), nil)
+
+	assert.Nil(t, err)
+	ep = getEpisode(db, ep.GUID)
+	assert.Equal(t, podcasts.IntegrityProblemMissing, ep.IntegrityProblem)
+	assert.Equal(t, podcasts.EpisodeStatusPending, ep.Status)
+
+	// once downloaded again, the problem is cleared
+	ep = downloadEpisodeForTest(db, objstore, ep.GUID)
+	assert.Equal(t, "", ep.IntegrityProblem)
+	assert.Equal(t, podcasts.EpisodeStatusSuccess, ep.Status)
+}
+
+func TestIntegrityWorker_SkipsRecentlyVerified(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{HTTPClient: fixtures.TestDataHTTPClient, Root: root}
+
+	ep := downloadEpisodeForTest(db, objstore, fixtures.PodEpGUID("pending-ep-1"))
+	if err := root.Remove(fmt.Sprintf("%s/%s", podcasts.BlobDir, ep.BlobSHA256)); err != nil {
+		panic(err)
+	}
+
+	// verified when downloaded, which is within the audit interval
+	worker := integrityworker.NewIntegrityWorkerQueueHandler(db, objstore, time.Hour, false)
+	err := worker(context.Background(), nil)
+
+	assert.Nil(t, err)
+	assert.Equal(t, "", getEpisode(db, ep.GUID).IntegrityProblem)
+}
+
+func downloadEpisodeForTest(db *gorm.DB, objstore objectstorage.ObjectStorage, guid string) podcasts.Episode {
+	err := downloadworker.NewDownloadWorkerQueueHandler(db, objstore, nil, false, 0)(context.Background(), guid)
+	if err != nil {
+		panic(err)
+	}
+	return getEpisode(db, guid)
+}
+
+func getEpisode(db *gorm.DB, guid string) podcasts.Episode {
+	ep, err := podcasts.GetEpisode(context.Background(), db, guid)
+	if err != nil {
+		panic(err)
+	}
+	return ep
+}
@@ -0,0 +1,187 @@
+package mediainfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"slices"
+)
+
+// replacedID3Frames are frames of an existing tag which are replaced by tags,
+// or which aren't valid in ID3v2.4
+var replacedID3Frames = []string{
+	"TIT2", "TALB", "TPE1", "TDRC", "TCON", "COMM", "APIC",
+	"TYER", "TDAT", "TIME", "TRDA", "TORY", "TSIZ", "IPLS", "RVAD", "EQUA",
+}
+
+// writeID3Tags replaces the ID3v2 tag at the start of an MP3 file with an
+// ID3v2.4 tag, keeping frames of the existing tag which aren't replaced
+func writeID3Tags(br *byteReader, w io.Writer, tags Tags) error {
+	var kept []byte
+	var rest []byte
+
+	head := make([]byte, 10)
+	n, err := io.ReadFull(br, head)
+	if err != nil && n < 3 {
+		return err
+	}
+	head = head[:n]
+
+	if n == 10 && bytes.HasPrefix(head, []byte("ID3")) {
+		kept, err = readKeptID3Frames(br, head)
+		if err != nil {
+			return err
+		}
+	} else {
+		rest = head
+	}
+
+	frames := newID3Frames(tags)
+	body := append(frames, kept...)
+	header := append([]byte("ID3"), 4, 0, 0)
+	header = append(header, encodeSyncsafe(len(body))...)
+	for _, b := range [][]byte{header, body, rest} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+
+	_, err = io.Copy(w, br)
+	return err
+}
+
+func readKeptID3Frames(br *byteReader, header []byte) ([]byte, error) {
+	majorVersion := header[3]
+	flags := header[5]
+	tagSize := int64(syncsafe(header[6:10]))
+	if flags&0x10 != 0 {
+		// footer
+		tagSize += 10
+	}
+
+	// frames of large, older or unsynchronised tags are dropped
+	if tagSize > maxID3TagSize || (majorVersion != 3 && majorVersion != 4) || flags&0x80 != 0 {
+		return nil, br.skip(tagSize)
+	}
+
+	tag, err := br.readFull(int(tagSize))
+	if err != nil {
+		return nil, err
+	}
+	if flags&0x40 != 0 && len(tag) >= 4 {
+		// extended header
+		extSize := int(binary.BigEndian.Uint32(tag))
+		if majorVersion == 4 {
+			extSize = syncsafe(tag)
+		} else {
+			extSize += 4
+		}
+		if extSize > len(tag) {
+			return nil, nil
+		}
+		tag = tag[extSize:]
+	}
+
+	var kept []byte
+	for _, frame := range id3Frames(tag, majorVersion) {
+		if slices.Contains(replacedID3Frames, frame.id) {
+			continue
+		}
+		if majorVersion == 4 {
+			// v2.4 frames are kept as they are, including their flags
+			kept = append(kept, id3Frame4(frame.id, frame.flags, frame.data)...)
+			continue
+		}
+		if frame.flags[1] != 0 {
+			// compressed, encrypted or grouped v2.3 frames are dropped
+			continue
+		}
+		kept = append(kept, id3Frame4(frame.id, nil, convertID3v23Subframes(frame))...)
+	}
+	return kept, nil
+}
+
+// convertID3v23Subframes converts the sizes of frames embedded in v2.3 chapter
+// frames to v2.4 syncsafe sizes
+func convertID3v23Subframes(frame id3Frame) []byte {
+	b := frame.data
+	end := bytes.IndexByte(b, 0)
+	if end < 0 {
+		return b
+	}
+	var prefixLen int
+	switch frame.id {
+	case "CHAP":
+		// element ID, start and end times and offsets
+		prefixLen = end + 1 + 16
+	case "CTOC":
+		// element ID, flags, entry count and the child element IDs
+		if len(b) < end+3 {
+			return b
+		}
+		prefixLen = end + 3
+		for i := 0; i < int(b[end+2]) && prefixLen < len(b); i++ {
+			childEnd := bytes.IndexByte(b[prefixLen:], 0)
+			if childEnd < 0 {
+				return b
+			}
+			prefixLen += childEnd + 1
+		}
+	default:
+		return b
+	}
+	if prefixLen > len(b) {
+		return b
+	}
+
+	converted := slices.Clone(b[:prefixLen])
+	for _, sub := range id3Frames(b[prefixLen:], 3) {
+		converted = append(converted, id3Frame4(sub.id, nil, sub.data)...)
+	}
+	return converted
+}
+
+func newID3Frames(tags Tags) []byte {
+	var frames []byte
+	textFrame := func(id, text string) {
+		if text != "" {
+			frames = append(frames, id3Frame4(id, nil, append([]byte{3}, text+"\x00"...))...)
+		}
+	}
+	textFrame("TIT2", tags.EpisodeTitle)
+	textFrame("TALB", tags.PodcastTitle)
+	textFrame("TPE1", tags.Author)
+	textFrame("TCON", "Podcast")
+	if !tags.PublishedAt.IsZero() {
+		textFrame("TDRC", tags.PublishedAt.UTC().Format("2006-01-02T15:04:05"))
+	}
+	if tags.Description != "" {
+		// UTF-8, language, empty content descriptor
+		data := append([]byte{3}, "eng\x00"...)
+		data = append(data, tags.Description...)
+		frames = append(frames, id3Frame4("COMM", nil, data)...)
+	}
+	if len(tags.Artwork) > 0 {
+		// UTF-8, MIME type, front cover picture type, empty description
+		data := append([]byte{3}, tags.ArtworkMIMEType+"\x00"...)
+		data = append(data, 3, 0)
+		data = append(data, tags.Artwork...)
+		frames = append(frames, id3Frame4("APIC", nil, data)...)
+	}
+	return frames
+}
+
+func id3Frame4(id string, flags []byte, data []byte) []byte {
+	if len(flags) != 2 {
+		flags = []byte{0, 0}
+	}
+	frame := make([]byte, 0, 10+len(data))
+	frame = append(frame, id...)
+	frame = append(frame, encodeSyncsafe(len(data))...)
+	frame = append(frame, flags...)
+	return append(frame, data...)
+}
+
+func encodeSyncsafe(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7F), byte(n >> 14 & 0x7F), byte(n >> 7 & 0x7F), byte(n & 0x7F)}
+}
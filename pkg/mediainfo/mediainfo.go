@@ -0,0 +1,104 @@
+// Package mediainfo reads the duration and technical metadata of media files
+// from their headers, and writes metadata tags into them, without decoding the
+// media itself.
+package mediainfo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrUnsupported = errors.New("media info is not supported for this MIME type")
+
+type Info struct {
+	// Title is read from the file's metadata tags, empty when not tagged
+	Title        string
+	DurationSecs int
+	// Bitrate is the average bitrate in bits per second
+	Bitrate    int
+	SampleRate int
+	Channels   int
+	Chapters   []Chapter
+}
+
+type Chapter struct {
+	StartSecs int
+	Title     string
+}
+
+// Probe reads the media info of a file of the given MIME type and size. The
+// file is read sequentially, and parts of the file which aren't needed are
+// skipped with Seek when r is an io.Seeker.
+func Probe(r io.Reader, size int64, mimeType string) (Info, error) {
+	br := &byteReader{r: r}
+
+	var info Info
+	var err error
+	switch mimeType {
+	case "audio/mpeg":
+		info, err = probeMP3(br, size)
+	case "audio/x-m4a", "video/mp4", "video/x-m4v", "video/quicktime":
+		info, err = probeMP4(br, size)
+	case "audio/ogg", "audio/opus":
+		info, err = probeOgg(br, size)
+	default:
+		return Info{}, fmt.Errorf("%w: '%s'", ErrUnsupported, mimeType)
+	}
+	if err != nil {
+		return Info{}, fmt.Errorf("failed to read media info: %w", err)
+	}
+	if info.DurationSecs <= 0 {
+		return Info{}, errors.New("failed to read media info: duration not found")
+	}
+	return info, nil
+}
+
+// averageBitrate is the bitrate of a media stream of the given size in bytes
+// and duration
+func averageBitrate(bytes int64, durationSecs float64) int {
+	if bytes <= 0 || durationSecs <= 0 {
+		return 0
+	}
+	return int(float64(bytes*8) / durationSecs)
+}
+
+// byteReader tracks its position in the underlying reader, so that parts of a
+// file can be skipped
+type byteReader struct {
+	r   io.Reader
+	pos int64
+}
+
+func (b *byteReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	b.pos += int64(n)
+	return n, err
+}
+
+func (b *byteReader) readFull(n int) ([]byte, error) {
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(b, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (b *byteReader) skip(n int64) error {
+	if n <= 0 {
+		return nil
+	}
+	if s, ok := b.r.(io.Seeker); ok {
+		pos, err := s.Seek(n, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		b.pos = pos
+		return nil
+	}
+	copied, err := io.CopyN(io.Discard, b, n)
+	if err != nil && copied < n {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,273 @@
+package mediainfo_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/mediainfo"
+)
+
+func TestProbe(t *testing.T) {
+	cbrMP3 := concat(
+		id3Tag(
+			id3Frame("TIT2", concat([]byte{3}, []byte("Episode 1"))),
+			chapFrame("ch0", 0, "Intro"),
+			chapFrame("ch1", 95_500, "Main topic"),
+		),
+		// MPEG-1 layer III, 128kbit/s, 44.1kHz, stereo
+		mp3Frame([]byte{0xFF, 0xFB, 0x90, 0x00}, 417, nil),
+	)
+	vbrMP3 := mp3Frame(
+		// MPEG-1 layer III, 44.1kHz, mono
+		[]byte{0xFF, 0xFB, 0x50, 0xC0},
+		209,
+		// Xing header after 17 bytes of side information, with frames and bytes
+		concat(make([]byte, 17), []byte("Xing"), u32(0x03), u32(1000), u32(200_000)),
+	)
+	m4a := concat(
+		box("ftyp", []byte("M4A \x00\x00\x00\x00")),
+		box("mdat", make([]byte, 1000)),
+		box("moov", concat(
+			// version 0, timescale 1000, duration 65.5s
+			box("mvhd", concat(make([]byte, 12), u32(1000), u32(65_500), make([]byte, 80))),
+			box("trak", box("mdia", concat(
+				box("hdlr", concat(make([]byte, 8), []byte("soun"), make([]byte, 13))),
+				box("minf", box("stbl", box("stsd", concat(
+					u32(0), u32(1),
+					box("mp4a", concat(make([]byte, 16), u16(2), u16(16), make([]byte, 4), u32(44100<<16))),
+				)))),
+			))),
+			box("udta", concat(
+				box("chpl", concat(
+					[]byte{1, 0, 0, 0}, make([]byte, 4), []byte{2},
+					u64(0), []byte{5}, []byte("Intro"),
+					u64(30*10_000_000), []byte{4}, []byte("Main"),
+				)),
+				box("meta", concat(u32(0), box("ilst", box("\xa9nam", box("data", concat(u32(1), u32(0), []byte("Episode 2"))))))),
+			)),
+		)),
+	)
+	opus := concat(
+		oggPage(0, concat([]byte("OpusHead"), []byte{1, 2}, u16le(312), u32le(48000), []byte{0, 0, 0})),
+		oggPage(0, concat([]byte("OpusTags"), vorbisComments(
+			"CHAPTER001NAME=Main",
+			"CHAPTER001=00:01:30.500",
+			"CHAPTER000=00:00:00.000",
+			"CHAPTER000NAME=Intro",
+			"TITLE=Episode",
+		))),
+		oggPage(48000*60, make([]byte, 100)),
+		oggPage(48000*120+312, make([]byte, 100)),
+	)
+	vorbis := concat(
+		oggPage(0, concat([]byte("\x01vorbis"), u32le(0), []byte{1}, u32le(44100), make([]byte, 14))),
+		oggPage(0, concat([]byte("\x03vorbis"), vorbisComments())),
+		oggPage(44100*30, make([]byte, 100)),
+	)
+
+	testCases := map[string]struct {
+		data         []byte
+		size         int64
+		mimeType     string
+		expectedErr  string
+		expectedInfo mediainfo.Info
+	}{
+		"cbrMP3": {
+			data: cbrMP3,
+			// sized as though it has 383 frames
+			size:     int64(len(cbrMP3) + 417*382),
+			mimeType: "audio/mpeg",
+			expectedInfo: mediainfo.Info{
+				Title:        "Episode 1",
+				DurationSecs: 10,
+				Bitrate:      128000,
+				SampleRate:   44100,
+				Channels:     2,
+				Chapters: []mediainfo.Chapter{
+					{StartSecs: 0, Title: "Intro"},
+					{StartSecs: 95, Title: "Main topic"},
+				},
+			},
+		},
+		"vbrMP3": {
+			data:     vbrMP3,
+			size:     200_000,
+			mimeType: "audio/mpeg",
+			expectedInfo: mediainfo.Info{
+				DurationSecs: 26,
+				Bitrate:      61250,
+				SampleRate:   44100,
+				Channels:     1,
+			},
+		},
+		"m4aWithMoovAtEnd": {
+			data:     m4a,
+			size:     int64(len(m4a)),
+			mimeType: "audio/x-m4a",
+			expectedInfo: mediainfo.Info{
+				Title:        "Episode 2",
+				DurationSecs: 66,
+				Bitrate:      averageBitrate(len(m4a), 65.5),
+				SampleRate:   44100,
+				Channels:     2,
+				Chapters: []mediainfo.Chapter{
+					{StartSecs: 0, Title: "Intro"},
+					{StartSecs: 30, Title: "Main"},
+				},
+			},
+		},
+		"opus": {
+			data:     opus,
+			size:     int64(len(opus)),
+			mimeType: "audio/opus",
+			expectedInfo: mediainfo.Info{
+				Title:        "Episode",
+				DurationSecs: 120,
+				Bitrate:      averageBitrate(len(opus), 120),
+				SampleRate:   48000,
+				Channels:     2,
+				Chapters: []mediainfo.Chapter{
+					{StartSecs: 0, Title: "Intro"},
+					{StartSecs: 90, Title: "Main"},
+				},
+			},
+		},
+		"vorbis": {
+			data:     vorbis,
+			size:     int64(len(vorbis)),
+			mimeType: "audio/ogg",
+			expectedInfo: mediainfo.Info{
+				DurationSecs: 30,
+				Bitrate:      averageBitrate(len(vorbis), 30),
+				SampleRate:   44100,
+				Channels:     1,
+			},
+		},
+		"unsupportedType": {
+			data:        []byte("fLaC"),
+			size:        4,
+			mimeType:    "audio/flac",
+			expectedErr: "media info is not supported for this MIME type: 'audio/flac'",
+		},
+		"notMP3": {
+			data:        bytes.Repeat([]byte("not an mp3 "), 10),
+			size:        110,
+			mimeType:    "audio/mpeg",
+			expectedErr: "failed to read media info: no MPEG audio frame found",
+		},
+		"mp4WithoutMoov": {
+			data:        box("ftyp", []byte("isom")),
+			size:        12,
+			mimeType:    "video/mp4",
+			expectedErr: "failed to read media info: no moov box found",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			// both seekable and non-seekable readers are supported
+			readers := map[string]io.Reader{
+				"seeker":    bytes.NewReader(tc.data),
+				"nonSeeker": io.MultiReader(bytes.NewReader(tc.data)),
+			}
+			for rName, r := range readers {
+				info, err := mediainfo.Probe(r, tc.size, tc.mimeType)
+				if tc.expectedErr != "" {
+					assert.EqualError(t, err, tc.expectedErr, rName)
+					continue
+				}
+				assert.Nil(t, err, rName)
+				assert.Equal(t, tc.expectedInfo, info, rName)
+			}
+		})
+	}
+}
+
+func averageBitrate(size int, secs float64) int {
+	return int(float64(size*8) / secs)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func u16(v uint16) []byte {
+	return binary.BigEndian.AppendUint16(nil, v)
+}
+
+func u32(v uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func u64(v uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, v)
+}
+
+func u16le(v uint16) []byte {
+	return binary.LittleEndian.AppendUint16(nil, v)
+}
+
+func u32le(v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, v)
+}
+
+func syncsafe(v int) []byte {
+	return []byte{byte(v >> 21 & 0x7F), byte(v >> 14 & 0x7F), byte(v >> 7 & 0x7F), byte(v & 0x7F)}
+}
+
+func id3Frame(id string, data []byte) []byte {
+	return concat([]byte(id), syncsafe(len(data)), []byte{0, 0}, data)
+}
+
+func chapFrame(elementID string, startMillis uint32, title string) []byte {
+	return id3Frame("CHAP", concat(
+		[]byte(elementID), []byte{0},
+		u32(startMillis), u32(0), u32(0xFFFFFFFF), u32(0xFFFFFFFF),
+		id3Frame("TIT2", concat([]byte{3}, []byte(title))),
+	))
+}
+
+func id3Tag(frames ...[]byte) []byte {
+	body := concat(frames...)
+	return concat([]byte("ID3"), []byte{4, 0, 0}, syncsafe(len(body)), body)
+}
+
+func mp3Frame(header []byte, size int, data []byte) []byte {
+	frame := make([]byte, size)
+	copy(frame, header)
+	copy(frame[4:], data)
+	return frame
+}
+
+func box(typ string, data []byte) []byte {
+	return concat(u32(uint32(8+len(data))), []byte(typ), data)
+}
+
+func oggPage(granule uint64, body []byte) []byte {
+	var segments []byte
+	for n := len(body); ; n -= 255 {
+		if n < 255 {
+			segments = append(segments, byte(n))
+			break
+		}
+		segments = append(segments, 255)
+	}
+	header := concat(
+		[]byte("OggS"), []byte{0, 0},
+		binary.LittleEndian.AppendUint64(nil, granule),
+		u32le(1), u32le(0), u32le(0),
+		[]byte{byte(len(segments))},
+	)
+	return concat(header, segments, body)
+}
+
+func vorbisComments(comments ...string) []byte {
+	b := concat(u32le(6), []byte("vendor"), u32le(uint32(len(comments))))
+	for _, c := range comments {
+		b = concat(b, u32le(uint32(len(c))), []byte(c))
+	}
+	return b
+}
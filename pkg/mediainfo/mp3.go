@@ -0,0 +1,323 @@
+package mediainfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"unicode/utf16"
+)
+
+const (
+	// maxID3TagSize limits the size of ID3 tags which are read for chapters,
+	// larger tags (e.g. with large images) are skipped
+	maxID3TagSize = 16 << 20
+
+	// maxFrameSearch limits how far after the ID3 tag the first MPEG frame is
+	// searched for
+	maxFrameSearch = 64 << 10
+)
+
+// bitrates in kbit/s, indexed by [MPEG-1 or not][layer-1][bitrate index]
+var mp3Bitrates = [2][3][16]int{
+	{
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+	},
+	{
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+	},
+}
+
+// sample rates in Hz, indexed by MPEG version bits
+var mp3SampleRates = map[byte][3]int{
+	3: {44100, 48000, 32000}, // MPEG-1
+	2: {22050, 24000, 16000}, // MPEG-2
+	0: {11025, 12000, 8000},  // MPEG-2.5
+}
+
+type mp3Frame struct {
+	mpeg1           bool
+	layer           int
+	bitrate         int // bits per second
+	sampleRate      int
+	channels        int
+	samplesPerFrame int
+}
+
+func probeMP3(br *byteReader, size int64) (Info, error) {
+	var info Info
+
+	head, err := br.readFull(10)
+	if err != nil {
+		return Info{}, err
+	}
+	var rest []byte
+	if bytes.HasPrefix(head, []byte("ID3")) {
+		if err := readID3Tag(br, head, &info); err != nil {
+			return Info{}, err
+		}
+	} else {
+		rest = head
+	}
+
+	audioStart := br.pos - int64(len(rest))
+	buf := make([]byte, 0, maxFrameSearch)
+	buf = append(buf, rest...)
+	chunk := make([]byte, 4096)
+	var frame mp3Frame
+	var frameAt int
+	for found := false; !found; {
+		for i := 0; i+4 <= len(buf); i++ {
+			if f, ok := parseMP3FrameHeader(buf[i:]); ok {
+				frame, frameAt, found = f, i, true
+				break
+			}
+		}
+		if found {
+			break
+		}
+		if len(buf) >= maxFrameSearch {
+			return Info{}, errors.New("no MPEG audio frame found")
+		}
+		n, err := br.Read(chunk)
+		if n == 0 && err != nil {
+			return Info{}, errors.New("no MPEG audio frame found")
+		}
+		buf = append(buf, chunk[:n]...)
+	}
+	audioStart += int64(frameAt)
+
+	// the first frame may be a Xing/Info or VBRI header describing the whole
+	// stream, needed for the duration of VBR files
+	frameData := buf[frameAt:]
+	if len(frameData) < 512 {
+		more := make([]byte, 512-len(frameData))
+		n, _ := io.ReadFull(br, more)
+		frameData = append(frameData, more[:n]...)
+	}
+	frames, streamBytes := readVBRHeader(frameData, frame)
+
+	info.SampleRate = frame.sampleRate
+	info.Channels = frame.channels
+	audioBytes := size - audioStart
+	if streamBytes > 0 {
+		audioBytes = streamBytes
+	}
+
+	if frames > 0 {
+		duration := float64(frames) * float64(frame.samplesPerFrame) / float64(frame.sampleRate)
+		info.DurationSecs = int(math.Round(duration))
+		info.Bitrate = averageBitrate(audioBytes, duration)
+	} else if frame.bitrate > 0 {
+		// constant bitrate
+		info.Bitrate = frame.bitrate
+		info.DurationSecs = int(math.Round(float64(audioBytes*8) / float64(frame.bitrate)))
+	}
+	return info, nil
+}
+
+func parseMP3FrameHeader(b []byte) (mp3Frame, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return mp3Frame{}, false
+	}
+	version := (b[1] >> 3) & 0x03
+	layerBits := (b[1] >> 1) & 0x03
+	bitrateIndex := b[2] >> 4
+	sampleRateIndex := (b[2] >> 2) & 0x03
+	if version == 1 || layerBits == 0 || bitrateIndex == 0x0F || sampleRateIndex == 3 {
+		return mp3Frame{}, false
+	}
+
+	f := mp3Frame{
+		mpeg1:      version == 3,
+		layer:      4 - int(layerBits),
+		sampleRate: mp3SampleRates[version][sampleRateIndex],
+		channels:   2,
+	}
+	if b[3]>>6 == 3 {
+		f.channels = 1
+	}
+	table := 1
+	if f.mpeg1 {
+		table = 0
+	}
+	f.bitrate = mp3Bitrates[table][f.layer-1][bitrateIndex] * 1000
+
+	switch {
+	case f.layer == 1:
+		f.samplesPerFrame = 384
+	case f.layer == 3 && !f.mpeg1:
+		f.samplesPerFrame = 576
+	default:
+		f.samplesPerFrame = 1152
+	}
+	return f, true
+}
+
+// readVBRHeader reads the number of frames and bytes in the stream from a
+// Xing/Info or VBRI header, returning zeros when there is none
+func readVBRHeader(frameData []byte, f mp3Frame) (frames int64, streamBytes int64) {
+	// Xing headers follow the side information of the first frame
+	sideInfo := 17
+	switch {
+	case f.mpeg1 && f.channels == 2:
+		sideInfo = 32
+	case !f.mpeg1 && f.channels == 1:
+		sideInfo = 9
+	}
+	if off := 4 + sideInfo; len(frameData) >= off+16 {
+		tag := string(frameData[off : off+4])
+		if tag == "Xing" || tag == "Info" {
+			flags := binary.BigEndian.Uint32(frameData[off+4:])
+			p := off + 8
+			if flags&0x01 != 0 {
+				frames = int64(binary.BigEndian.Uint32(frameData[p:]))
+				p += 4
+			}
+			if flags&0x02 != 0 && len(frameData) >= p+4 {
+				streamBytes = int64(binary.BigEndian.Uint32(frameData[p:]))
+			}
+			return frames, streamBytes
+		}
+	}
+
+	if off := 4 + 32; len(frameData) >= off+18 && string(frameData[off:off+4]) == "VBRI" {
+		streamBytes = int64(binary.BigEndian.Uint32(frameData[off+10:]))
+		frames = int64(binary.BigEndian.Uint32(frameData[off+14:]))
+	}
+	return frames, streamBytes
+}
+
+// readID3Tag reads the chapters from an ID3v2 tag, whose 10 byte header has
+// already been read
+// readID3Tag reads the title and chapters from an ID3v2 tag
+func readID3Tag(br *byteReader, header []byte, info *Info) error {
+	majorVersion := header[3]
+	flags := header[5]
+	tagSize := int64(syncsafe(header[6:10]))
+	if flags&0x10 != 0 {
+		// footer
+		tagSize += 10
+	}
+
+	if tagSize > maxID3TagSize || (majorVersion != 3 && majorVersion != 4) {
+		return br.skip(tagSize)
+	}
+
+	tag, err := br.readFull(int(tagSize))
+	if err != nil {
+		return err
+	}
+	if flags&0x80 != 0 {
+		// unsynchronised tags are rare, and not worth reading chapters from
+		return nil
+	}
+
+	for _, frame := range id3Frames(tag, majorVersion) {
+		switch frame.id {
+		case "TIT2":
+			info.Title = decodeID3Text(frame.data)
+		case "CHAP":
+			if ch, ok := parseCHAPFrame(frame.data, majorVersion); ok {
+				info.Chapters = append(info.Chapters, ch)
+			}
+		}
+	}
+	return nil
+}
+
+type id3Frame struct {
+	id    string
+	flags []byte
+	data  []byte
+}
+
+func id3Frames(b []byte, majorVersion byte) []id3Frame {
+	var frames []id3Frame
+	for len(b) >= 10 && b[0] != 0 {
+		id := string(b[0:4])
+		var size int
+		if majorVersion == 4 {
+			size = syncsafe(b[4:8])
+		} else {
+			size = int(binary.BigEndian.Uint32(b[4:8]))
+		}
+		if size < 0 || 10+size > len(b) {
+			break
+		}
+		frames = append(frames, id3Frame{id: id, flags: b[8:10], data: b[10 : 10+size]})
+		b = b[10+size:]
+	}
+	return frames
+}
+
+func parseCHAPFrame(b []byte, majorVersion byte) (Chapter, bool) {
+	end := bytes.IndexByte(b, 0)
+	if end < 0 || len(b) < end+1+16 {
+		return Chapter{}, false
+	}
+	startMillis := binary.BigEndian.Uint32(b[end+1:])
+	ch := Chapter{StartSecs: int(startMillis / 1000)}
+	for _, sub := range id3Frames(b[end+1+16:], majorVersion) {
+		if sub.id == "TIT2" {
+			ch.Title = decodeID3Text(sub.data)
+		}
+	}
+	return ch, true
+}
+
+func decodeID3Text(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	encoding, text := b[0], b[1:]
+	switch encoding {
+	case 0:
+		// ISO-8859-1
+		runes := make([]rune, 0, len(text))
+		for _, c := range text {
+			if c == 0 {
+				break
+			}
+			runes = append(runes, rune(c))
+		}
+		return string(runes)
+	case 1, 2:
+		return decodeUTF16(text, encoding == 2)
+	default:
+		return string(bytes.TrimRight(text, "\x00"))
+	}
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xFE && b[1] == 0xFF:
+			order, b = binary.BigEndian, b[2:]
+		case b[0] == 0xFF && b[1] == 0xFE:
+			order, b = binary.LittleEndian, b[2:]
+		}
+	}
+	units := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		u := order.Uint16(b[i:])
+		if u == 0 {
+			break
+		}
+		units = append(units, u)
+	}
+	return string(utf16.Decode(units))
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
@@ -0,0 +1,224 @@
+package mediainfo
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+// maxMoovSize limits the size of moov boxes which are read into memory
+const maxMoovSize = 64 << 20
+
+type mp4Box struct {
+	typ  string
+	data []byte
+}
+
+func probeMP4(br *byteReader, size int64) (Info, error) {
+	// the moov box describing the file may be before or after the media data
+	// in the mdat box, which is skipped
+	for {
+		typ, bodySize, err := readMP4BoxHeader(br, size)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return Info{}, errors.New("no moov box found")
+			}
+			return Info{}, err
+		}
+		if typ != "moov" {
+			if err := br.skip(bodySize); err != nil {
+				return Info{}, err
+			}
+			continue
+		}
+		if bodySize > maxMoovSize {
+			return Info{}, errors.New("moov box too large")
+		}
+		moov, err := br.readFull(int(bodySize))
+		if err != nil {
+			return Info{}, err
+		}
+		return parseMoov(moov, size), nil
+	}
+}
+
+// readMP4BoxHeader reads the header of the next box, returning its type and
+// the size of its body
+func readMP4BoxHeader(br *byteReader, fileSize int64) (string, int64, error) {
+	start := br.pos
+	header, err := br.readFull(8)
+	if err != nil {
+		return "", 0, err
+	}
+	boxSize := int64(binary.BigEndian.Uint32(header))
+	typ := string(header[4:8])
+	switch boxSize {
+	case 0:
+		// box extends to the end of the file
+		boxSize = fileSize - start
+	case 1:
+		largeSize, err := br.readFull(8)
+		if err != nil {
+			return "", 0, err
+		}
+		boxSize = int64(binary.BigEndian.Uint64(largeSize))
+	}
+	bodySize := boxSize - (br.pos - start)
+	if bodySize < 0 {
+		return "", 0, errors.New("invalid MP4 box size")
+	}
+	return typ, bodySize, nil
+}
+
+// mp4Boxes splits the body of a box into its child boxes
+func mp4Boxes(b []byte) []mp4Box {
+	var boxes []mp4Box
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		headerSize := uint64(8)
+		if size == 1 {
+			if len(b) < 16 {
+				break
+			}
+			size = binary.BigEndian.Uint64(b[8:])
+			headerSize = 16
+		} else if size == 0 {
+			size = uint64(len(b))
+		}
+		if size < headerSize || size > uint64(len(b)) {
+			break
+		}
+		boxes = append(boxes, mp4Box{typ: string(b[4:8]), data: b[headerSize:size]})
+		b = b[size:]
+	}
+	return boxes
+}
+
+func findMP4Box(b []byte, path ...string) ([]byte, bool) {
+	for _, typ := range path {
+		found := false
+		for _, box := range mp4Boxes(b) {
+			if box.typ == typ {
+				b, found = box.data, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return b, true
+}
+
+func parseMoov(moov []byte, size int64) Info {
+	var info Info
+
+	if mvhd, ok := findMP4Box(moov, "mvhd"); ok {
+		if timescale, duration, ok := readMP4Duration(mvhd); ok && timescale > 0 {
+			secs := float64(duration) / float64(timescale)
+			info.DurationSecs = int(math.Round(secs))
+			info.Bitrate = averageBitrate(size, secs)
+		}
+	}
+
+	for _, box := range mp4Boxes(moov) {
+		if box.typ != "trak" {
+			continue
+		}
+		hdlr, ok := findMP4Box(box.data, "mdia", "hdlr")
+		if !ok || len(hdlr) < 12 || string(hdlr[8:12]) != "soun" {
+			continue
+		}
+		stsd, ok := findMP4Box(box.data, "mdia", "minf", "stbl", "stsd")
+		// stsd is a full box with an entry count, followed by sample entries
+		if !ok || len(stsd) < 8 {
+			continue
+		}
+		entries := mp4Boxes(stsd[8:])
+		// audio sample entries have 8 bytes of sample entry fields and 8
+		// reserved bytes before the channel count, sample size, 4 more bytes
+		// and the 16.16 fixed point sample rate
+		if len(entries) == 0 || len(entries[0].data) < 28 {
+			continue
+		}
+		entry := entries[0].data
+		info.Channels = int(binary.BigEndian.Uint16(entry[16:]))
+		info.SampleRate = int(binary.BigEndian.Uint32(entry[24:]) >> 16)
+		break
+	}
+
+	if chpl, ok := findMP4Box(moov, "udta", "chpl"); ok {
+		info.Chapters = parseChpl(chpl)
+	}
+
+	if meta, ok := findMP4Box(moov, "udta", "meta"); ok {
+		info.Title = readMP4Title(meta)
+	}
+
+	return info
+}
+
+// readMP4Title reads the title item from the iTunes metadata in a meta box
+func readMP4Title(meta []byte) string {
+	// meta is usually a full box, with version and flags before its children
+	if len(meta) >= 8 && string(meta[4:8]) != "hdlr" {
+		meta = meta[4:]
+	}
+	data, ok := findMP4Box(meta, "ilst", "\xa9nam", "data")
+	// type and locale before the value
+	if !ok || len(data) < 8 || binary.BigEndian.Uint32(data) != mp4DataTypeUTF8 {
+		return ""
+	}
+	return string(data[8:])
+}
+
+// readMP4Duration reads the timescale and duration from a mvhd or mdhd box
+func readMP4Duration(b []byte) (timescale uint32, duration uint64, ok bool) {
+	if len(b) < 1 {
+		return 0, 0, false
+	}
+	if b[0] == 1 {
+		if len(b) < 32 {
+			return 0, 0, false
+		}
+		return binary.BigEndian.Uint32(b[20:]), binary.BigEndian.Uint64(b[24:]), true
+	}
+	if len(b) < 20 {
+		return 0, 0, false
+	}
+	return binary.BigEndian.Uint32(b[12:]), uint64(binary.BigEndian.Uint32(b[16:])), true
+}
+
+// parseChpl reads Nero style chapters, which have start times in units of
+// 100 nanoseconds
+func parseChpl(b []byte) []Chapter {
+	if len(b) < 5 {
+		return nil
+	}
+	p := 4
+	if b[0] == 1 {
+		p += 4
+	}
+	if len(b) < p+1 {
+		return nil
+	}
+	count := int(b[p])
+	p++
+
+	var chapters []Chapter
+	for i := 0; i < count && len(b) >= p+9; i++ {
+		start := binary.BigEndian.Uint64(b[p:])
+		titleLen := int(b[p+8])
+		p += 9
+		if len(b) < p+titleLen {
+			break
+		}
+		chapters = append(chapters, Chapter{
+			StartSecs: int(start / 10_000_000),
+			Title:     string(b[p : p+titleLen]),
+		})
+		p += titleLen
+	}
+	return chapters
+}
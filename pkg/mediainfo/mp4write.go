@@ -0,0 +1,257 @@
+package mediainfo
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"slices"
+)
+
+// data types of iTunes metadata items
+const (
+	mp4DataTypeUTF8 = 1
+	mp4DataTypeJPEG = 13
+	mp4DataTypePNG  = 14
+)
+
+// maxMP4ShortDescription is the length limit of the desc item, the full
+// description is also written to the ldes item
+const maxMP4ShortDescription = 255
+
+// writeMP4Tags replaces the iTunes metadata items in the moov box of an MP4
+// file, keeping items which aren't replaced. Other boxes are copied unchanged,
+// apart from chunk offsets which are adjusted when the moov box changes size
+// and is before the media data.
+func writeMP4Tags(br *byteReader, w io.Writer, tags Tags) error {
+	seenMdat := false
+	for {
+		start := br.pos
+		header, err := br.readFull(8)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header))
+		typ := string(header[4:8])
+		if boxSize == 1 {
+			largeSize, err := br.readFull(8)
+			if err != nil {
+				return err
+			}
+			header = append(header, largeSize...)
+			boxSize = int64(binary.BigEndian.Uint64(largeSize))
+		}
+
+		if typ != "moov" {
+			if typ == "mdat" {
+				seenMdat = true
+			}
+			if _, err := w.Write(header); err != nil {
+				return err
+			}
+			if boxSize == 0 {
+				// box extends to the end of the file
+				_, err = io.Copy(w, br)
+				return err
+			}
+			if _, err := io.CopyN(w, br, boxSize-(br.pos-start)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if boxSize == 0 || boxSize-(br.pos-start) > maxMoovSize {
+			return errors.New("moov box too large")
+		}
+		moov, err := br.readFull(int(boxSize - (br.pos - start)))
+		if err != nil {
+			return err
+		}
+		newMoov, err := tagMoov(moov, tags)
+		if err != nil {
+			return err
+		}
+		if delta := int64(len(newMoov)) - boxSize; delta != 0 && !seenMdat {
+			if err := adjustChunkOffsets(newMoov[8:], delta); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(newMoov); err != nil {
+			return err
+		}
+	}
+}
+
+// tagMoov returns a new moov box, with the metadata items in moov/udta/meta/ilst
+// replaced
+func tagMoov(moov []byte, tags Tags) ([]byte, error) {
+	var body []byte
+	foundUdta := false
+	for _, box := range mp4Boxes(moov) {
+		switch box.typ {
+		case "mvex":
+			return nil, errors.New("fragmented MP4 files are not supported")
+		case "udta":
+			foundUdta = true
+			body = append(body, encodeMP4Box("udta", tagUdta(box.data, tags))...)
+		default:
+			body = append(body, encodeMP4Box(box.typ, box.data)...)
+		}
+	}
+	if !foundUdta {
+		body = append(body, encodeMP4Box("udta", tagUdta(nil, tags))...)
+	}
+	return encodeMP4Box("moov", body), nil
+}
+
+func tagUdta(udta []byte, tags Tags) []byte {
+	var body []byte
+	foundMeta := false
+	for _, box := range mp4Boxes(udta) {
+		if box.typ == "meta" {
+			foundMeta = true
+			body = append(body, encodeMP4Box("meta", tagMeta(box.data, tags))...)
+			continue
+		}
+		body = append(body, encodeMP4Box(box.typ, box.data)...)
+	}
+	if !foundMeta {
+		body = append(body, encodeMP4Box("meta", tagMeta(nil, tags))...)
+	}
+	return body
+}
+
+func tagMeta(meta []byte, tags Tags) []byte {
+	if meta == nil {
+		// version and flags, and a metadata handler
+		hdlr := slices.Concat(make([]byte, 8), []byte("mdirappl"), make([]byte, 9))
+		return slices.Concat(make([]byte, 4), encodeMP4Box("hdlr", hdlr), encodeMP4Box("ilst", tagIlst(nil, tags)))
+	}
+
+	// meta is usually a full box, with version and flags before its children
+	var prefix []byte
+	if len(meta) >= 8 && string(meta[4:8]) != "hdlr" {
+		prefix, meta = meta[:4], meta[4:]
+	}
+
+	body := slices.Clone(prefix)
+	foundIlst := false
+	for _, box := range mp4Boxes(meta) {
+		if box.typ == "ilst" {
+			foundIlst = true
+			body = append(body, encodeMP4Box("ilst", tagIlst(box.data, tags))...)
+			continue
+		}
+		body = append(body, encodeMP4Box(box.typ, box.data)...)
+	}
+	if !foundIlst {
+		body = append(body, encodeMP4Box("ilst", tagIlst(nil, tags))...)
+	}
+	return body
+}
+
+func tagIlst(ilst []byte, tags Tags) []byte {
+	type item struct {
+		key      string
+		dataType uint32
+		value    []byte
+	}
+	var items []item
+	text := func(key, value string) {
+		if value != "" {
+			items = append(items, item{key, mp4DataTypeUTF8, []byte(value)})
+		}
+	}
+	text("\xa9nam", tags.EpisodeTitle)
+	text("\xa9alb", tags.PodcastTitle)
+	text("\xa9ART", tags.Author)
+	text("\xa9gen", "Podcast")
+	if !tags.PublishedAt.IsZero() {
+		text("\xa9day", tags.PublishedAt.UTC().Format("2006-01-02T15:04:05Z"))
+	}
+	text("desc", truncateUTF8(tags.Description, maxMP4ShortDescription))
+	text("ldes", tags.Description)
+	if len(tags.Artwork) > 0 {
+		dataType := uint32(mp4DataTypeJPEG)
+		if tags.ArtworkMIMEType == "image/png" {
+			dataType = mp4DataTypePNG
+		}
+		items = append(items, item{"covr", dataType, tags.Artwork})
+	}
+
+	var body []byte
+	for _, box := range mp4Boxes(ilst) {
+		replaced := slices.ContainsFunc(items, func(i item) bool {
+			return i.key == box.typ
+		})
+		if !replaced {
+			body = append(body, encodeMP4Box(box.typ, box.data)...)
+		}
+	}
+	for _, i := range items {
+		data := slices.Concat(binary.BigEndian.AppendUint32(nil, i.dataType), make([]byte, 4), i.value)
+		body = append(body, encodeMP4Box(i.key, encodeMP4Box("data", data))...)
+	}
+	return body
+}
+
+// adjustChunkOffsets moves the chunk offsets of every track in a moov box by
+// delta, as the media data they point to has moved
+func adjustChunkOffsets(moov []byte, delta int64) error {
+	for _, trak := range mp4Boxes(moov) {
+		if trak.typ != "trak" {
+			continue
+		}
+		stbl, ok := findMP4Box(trak.data, "mdia", "minf", "stbl")
+		if !ok {
+			continue
+		}
+		for _, box := range mp4Boxes(stbl) {
+			// full boxes with an entry count followed by the offsets, which
+			// are modified in place
+			if len(box.data) < 8 || (box.typ != "stco" && box.typ != "co64") {
+				continue
+			}
+			count := int(binary.BigEndian.Uint32(box.data[4:]))
+			offsets := box.data[8:]
+			for i := 0; i < count; i++ {
+				if box.typ == "stco" {
+					if len(offsets) < (i+1)*4 {
+						break
+					}
+					offset := int64(binary.BigEndian.Uint32(offsets[i*4:])) + delta
+					if offset < 0 || offset > math.MaxUint32 {
+						return errors.New("chunk offset out of range")
+					}
+					binary.BigEndian.PutUint32(offsets[i*4:], uint32(offset))
+				} else {
+					if len(offsets) < (i+1)*8 {
+						break
+					}
+					offset := int64(binary.BigEndian.Uint64(offsets[i*8:])) + delta
+					binary.BigEndian.PutUint64(offsets[i*8:], uint64(offset))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func encodeMP4Box(typ string, data []byte) []byte {
+	return slices.Concat(binary.BigEndian.AppendUint32(nil, uint32(8+len(data))), []byte(typ), data)
+}
+
+func truncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	runes := []rune(s[:maxBytes])
+	// drop a partial rune at the end
+	if runes[len(runes)-1] == 0xFFFD {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes)
+}
@@ -0,0 +1,235 @@
+package mediainfo
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxOggHeaderPacketSize limits the size of the identification and comment
+	// packets which are read, comments may contain large images
+	maxOggHeaderPacketSize = 1 << 20
+
+	// opus granule positions are always at 48kHz, regardless of the input
+	// sample rate
+	opusGranuleRate = 48000
+)
+
+type oggPage struct {
+	granule int64
+	serial  uint32
+	// segments are the lacing values of the page's segment table
+	segments []byte
+}
+
+func readOggPageHeader(br *byteReader) (oggPage, error) {
+	header, err := br.readFull(27)
+	if err != nil {
+		return oggPage{}, err
+	}
+	if string(header[0:4]) != "OggS" {
+		return oggPage{}, errors.New("invalid Ogg page")
+	}
+	segments, err := br.readFull(int(header[26]))
+	if err != nil {
+		return oggPage{}, err
+	}
+	return oggPage{
+		granule:  int64(binary.LittleEndian.Uint64(header[6:])),
+		serial:   binary.LittleEndian.Uint32(header[14:]),
+		segments: segments,
+	}, nil
+}
+
+func (p oggPage) bodySize() int64 {
+	var size int64
+	for _, s := range p.segments {
+		size += int64(s)
+	}
+	return size
+}
+
+func probeOgg(br *byteReader, size int64) (Info, error) {
+	var info Info
+
+	// the first two packets of the first stream are its identification and
+	// comment headers, which may span several pages
+	var serial uint32
+	var packets [][]byte
+	var packet []byte
+	var lastGranule int64
+	first := true
+	for {
+		page, err := readOggPageHeader(br)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Info{}, err
+		}
+		if first {
+			serial, first = page.serial, false
+		}
+		if page.serial != serial {
+			if err := br.skip(page.bodySize()); err != nil {
+				return Info{}, err
+			}
+			continue
+		}
+		if page.granule >= 0 {
+			lastGranule = page.granule
+		}
+
+		if len(packets) >= 2 {
+			if err := br.skip(page.bodySize()); err != nil {
+				return Info{}, err
+			}
+			continue
+		}
+
+		body, err := br.readFull(int(page.bodySize()))
+		if err != nil {
+			return Info{}, err
+		}
+		for _, s := range page.segments {
+			if len(packets) >= 2 {
+				break
+			}
+			if len(packet) < maxOggHeaderPacketSize {
+				packet = append(packet, body[:s]...)
+			}
+			body = body[s:]
+			if s < 255 {
+				packets = append(packets, packet)
+				packet = nil
+			}
+		}
+	}
+
+	if len(packets) == 0 {
+		return Info{}, errors.New("no Ogg packets found")
+	}
+
+	var granuleRate, preSkip int64
+	id := packets[0]
+	switch {
+	case bytes.HasPrefix(id, []byte("\x01vorbis")) && len(id) >= 16:
+		info.Channels = int(id[11])
+		info.SampleRate = int(binary.LittleEndian.Uint32(id[12:]))
+		granuleRate = int64(info.SampleRate)
+	case bytes.HasPrefix(id, []byte("OpusHead")) && len(id) >= 16:
+		info.Channels = int(id[9])
+		preSkip = int64(binary.LittleEndian.Uint16(id[10:]))
+		info.SampleRate = int(binary.LittleEndian.Uint32(id[12:]))
+		granuleRate = opusGranuleRate
+	default:
+		return Info{}, errors.New("unsupported Ogg codec")
+	}
+
+	if granuleRate > 0 && lastGranule > preSkip {
+		secs := float64(lastGranule-preSkip) / float64(granuleRate)
+		info.DurationSecs = int(math.Round(secs))
+		info.Bitrate = averageBitrate(size, secs)
+	}
+
+	if len(packets) > 1 {
+		comments := packets[1]
+		switch {
+		case bytes.HasPrefix(comments, []byte("\x03vorbis")):
+			info.Title, info.Chapters = parseVorbisComments(comments[7:])
+		case bytes.HasPrefix(comments, []byte("OpusTags")):
+			info.Title, info.Chapters = parseVorbisComments(comments[8:])
+		}
+	}
+
+	return info, nil
+}
+
+var vorbisChapterKey = regexp.MustCompile(`^CHAPTER(\d+)(NAME)?$`)
+
+// parseVorbisComments reads the title and chapters from Vorbis comments, with
+// chapters in the form CHAPTER001=00:00:00.000 and CHAPTER001NAME=Title
+func parseVorbisComments(b []byte) (string, []Chapter) {
+	comments := readVorbisComments(b)
+
+	var title string
+	byNum := map[int]*Chapter{}
+	for _, c := range comments {
+		key, value, ok := strings.Cut(c, "=")
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(key, "TITLE") {
+			title = value
+			continue
+		}
+		m := vorbisChapterKey.FindStringSubmatch(strings.ToUpper(key))
+		if m == nil {
+			continue
+		}
+		num, _ := strconv.Atoi(m[1])
+		ch, ok := byNum[num]
+		if !ok {
+			ch = &Chapter{StartSecs: -1}
+			byNum[num] = ch
+		}
+		if m[2] == "NAME" {
+			ch.Title = value
+		} else if secs, ok := parseChapterTime(value); ok {
+			ch.StartSecs = secs
+		}
+	}
+
+	var chapters []Chapter
+	for _, ch := range byNum {
+		if ch.StartSecs >= 0 {
+			chapters = append(chapters, *ch)
+		}
+	}
+	slices.SortFunc(chapters, func(a, b Chapter) int {
+		return a.StartSecs - b.StartSecs
+	})
+	return title, chapters
+}
+
+func readVorbisComments(b []byte) []string {
+	if len(b) < 4 {
+		return nil
+	}
+	vendorLen := int(binary.LittleEndian.Uint32(b))
+	if len(b) < 4+vendorLen+4 {
+		return nil
+	}
+	b = b[4+vendorLen:]
+	count := int(binary.LittleEndian.Uint32(b))
+	b = b[4:]
+
+	var comments []string
+	for i := 0; i < count && len(b) >= 4; i++ {
+		l := int(binary.LittleEndian.Uint32(b))
+		if l < 0 || len(b) < 4+l {
+			break
+		}
+		comments = append(comments, string(b[4:4+l]))
+		b = b[4+l:]
+	}
+	return comments
+}
+
+// parseChapterTime parses a time in the form HH:MM:SS.sss
+func parseChapterTime(s string) (int, bool) {
+	t, err := time.Parse("15:04:05.999999999", s)
+	if err != nil {
+		return 0, false
+	}
+	dur := t.Sub(time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC))
+	return int(dur.Seconds()), true
+}
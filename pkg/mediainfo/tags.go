@@ -0,0 +1,50 @@
+package mediainfo
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Tags is the metadata written into media files
+type Tags struct {
+	PodcastTitle string
+	Author       string
+	EpisodeTitle string
+	Description  string
+	PublishedAt  time.Time
+	// Artwork is a JPEG or PNG image, which is omitted when empty
+	Artwork         []byte
+	ArtworkMIMEType string
+}
+
+// CanWriteTags reports whether tags can be written into files of the given
+// MIME type
+func CanWriteTags(mimeType string) bool {
+	switch mimeType {
+	case "audio/mpeg", "audio/x-m4a", "video/mp4", "video/x-m4v":
+		return true
+	}
+	return false
+}
+
+// WriteTags copies a media file of the given MIME type from r to w, replacing
+// its metadata with tags. Other metadata in the file, e.g. chapters, is kept,
+// and the media itself is copied unchanged.
+func WriteTags(r io.Reader, w io.Writer, mimeType string, tags Tags) error {
+	br := &byteReader{r: r}
+
+	var err error
+	switch mimeType {
+	case "audio/mpeg":
+		err = writeID3Tags(br, w, tags)
+	case "audio/x-m4a", "video/mp4", "video/x-m4v":
+		err = writeMP4Tags(br, w, tags)
+	default:
+		return fmt.Errorf("%w: '%s'", ErrUnsupported, mimeType)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write tags: %w", err)
+	}
+	return nil
+}
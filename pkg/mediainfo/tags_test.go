@@ -0,0 +1,129 @@
+package mediainfo_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/mediainfo"
+)
+
+var testTags = mediainfo.Tags{
+	PodcastTitle:    "My Podcast",
+	Author:          "Dr Tester",
+	EpisodeTitle:    "Episode 1",
+	Description:     "All about testing",
+	PublishedAt:     time.Date(2024, 12, 26, 11, 12, 13, 0, time.UTC),
+	Artwork:         []byte("\xff\xd8\xff\xe0 not really a jpeg"),
+	ArtworkMIMEType: "image/jpeg",
+}
+
+func TestWriteTags_MP3(t *testing.T) {
+	audio := mp3Frame([]byte{0xFF, 0xFB, 0x90, 0x00}, 417, nil)
+	src := concat(
+		id3Tag(
+			id3Frame("TIT2", concat([]byte{3}, []byte("Old title"))),
+			id3Frame("TXXX", concat([]byte{3}, []byte("kept\x00value"))),
+			chapFrame("ch0", 0, "Intro"),
+		),
+		audio,
+	)
+
+	var out bytes.Buffer
+	err := mediainfo.WriteTags(bytes.NewReader(src), &out, "audio/mpeg", testTags)
+
+	assert.Nil(t, err)
+	assert.True(t, bytes.HasPrefix(out.Bytes(), []byte("ID3\x04\x00\x00")))
+	assert.True(t, bytes.HasSuffix(out.Bytes(), audio), "audio is copied unchanged")
+	assert.True(t, bytes.Contains(out.Bytes(), id3Frame("TIT2", []byte("\x03Episode 1\x00"))))
+	assert.True(t, bytes.Contains(out.Bytes(), id3Frame("TALB", []byte("\x03My Podcast\x00"))))
+	assert.True(t, bytes.Contains(out.Bytes(), id3Frame("TPE1", []byte("\x03Dr Tester\x00"))))
+	assert.True(t, bytes.Contains(out.Bytes(), id3Frame("TDRC", []byte("\x032024-12-26T11:12:13\x00"))))
+	assert.True(t, bytes.Contains(out.Bytes(), id3Frame("COMM", []byte("\x03eng\x00All about testing"))))
+	assert.True(t, bytes.Contains(out.Bytes(), id3Frame("APIC", concat([]byte("\x03image/jpeg\x00\x03\x00"), testTags.Artwork))))
+	assert.False(t, bytes.Contains(out.Bytes(), []byte("Old title")))
+	assert.True(t, bytes.Contains(out.Bytes(), []byte("kept\x00value")))
+
+	// chapters are kept, and the file can still be read, sized as though it
+	// has 383 frames
+	info, err := mediainfo.Probe(bytes.NewReader(out.Bytes()), int64(out.Len()+417*382), "audio/mpeg")
+	assert.Nil(t, err)
+	assert.Equal(t, []mediainfo.Chapter{{StartSecs: 0, Title: "Intro"}}, info.Chapters)
+}
+
+func TestWriteTags_MP3WithoutTag(t *testing.T) {
+	audio := mp3Frame([]byte{0xFF, 0xFB, 0x90, 0x00}, 417, nil)
+
+	var out bytes.Buffer
+	err := mediainfo.WriteTags(bytes.NewReader(audio), &out, "audio/mpeg", mediainfo.Tags{EpisodeTitle: "Episode 1"})
+
+	assert.Nil(t, err)
+	expectedTag := id3Tag(
+		id3Frame("TIT2", []byte("\x03Episode 1\x00")),
+		id3Frame("TCON", []byte("\x03Podcast\x00")),
+	)
+	assert.Equal(t, concat(expectedTag, audio), out.Bytes())
+}
+
+func TestWriteTags_MP4(t *testing.T) {
+	media := []byte("media data")
+	ilst := box("ilst", concat(
+		box("\xa9nam", box("data", concat(u32(1), u32(0), []byte("Old title")))),
+		box("\xa9too", box("data", concat(u32(1), u32(0), []byte("kept encoder")))),
+	))
+	moov := func(chunkOffset uint32) []byte {
+		return box("moov", concat(
+			box("mvhd", concat(make([]byte, 12), u32(1000), u32(65_500), make([]byte, 80))),
+			box("trak", box("mdia", box("minf", box("stbl", box("stco", concat(u32(0), u32(1), u32(chunkOffset))))))),
+			box("udta", box("meta", concat(u32(0), box("hdlr", concat(make([]byte, 8), []byte("mdirappl"), make([]byte, 9))), ilst))),
+		))
+	}
+	ftyp := box("ftyp", []byte("M4A \x00\x00\x00\x00"))
+
+	testCases := map[string]struct {
+		src []byte
+	}{
+		"moovBeforeMdat": {
+			src: concat(ftyp, moov(uint32(len(ftyp)+len(moov(0))+8)), box("mdat", media)),
+		},
+		"moovAfterMdat": {
+			src: concat(ftyp, box("mdat", media), moov(uint32(len(ftyp)+8))),
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := mediainfo.WriteTags(bytes.NewReader(tc.src), &out, "audio/x-m4a", testTags)
+
+			assert.Nil(t, err)
+			result := out.Bytes()
+			assert.True(t, bytes.Contains(result, box("\xa9nam", box("data", concat(u32(1), u32(0), []byte("Episode 1"))))))
+			assert.True(t, bytes.Contains(result, box("\xa9alb", box("data", concat(u32(1), u32(0), []byte("My Podcast"))))))
+			assert.True(t, bytes.Contains(result, box("\xa9ART", box("data", concat(u32(1), u32(0), []byte("Dr Tester"))))))
+			assert.True(t, bytes.Contains(result, box("\xa9day", box("data", concat(u32(1), u32(0), []byte("2024-12-26T11:12:13Z"))))))
+			assert.True(t, bytes.Contains(result, box("ldes", box("data", concat(u32(1), u32(0), []byte("All about testing"))))))
+			assert.True(t, bytes.Contains(result, box("covr", box("data", concat(u32(13), u32(0), testTags.Artwork)))))
+			assert.True(t, bytes.Contains(result, []byte("kept encoder")))
+			assert.False(t, bytes.Contains(result, []byte("Old title")))
+
+			// the chunk offset still points at the media data
+			stco := bytes.Index(result, []byte("stco"))
+			offset := binary.BigEndian.Uint32(result[stco+12:])
+			assert.Equal(t, media, result[offset:int(offset)+len(media)])
+
+			info, err := mediainfo.Probe(bytes.NewReader(result), int64(len(result)), "audio/x-m4a")
+			assert.Nil(t, err)
+			assert.Equal(t, 66, info.DurationSecs)
+		})
+	}
+}
+
+func TestWriteTags_Unsupported(t *testing.T) {
+	var out bytes.Buffer
+	err := mediainfo.WriteTags(bytes.NewReader([]byte("OggS")), &out, "audio/ogg", testTags)
+
+	assert.ErrorIs(t, err, mediainfo.ErrUnsupported)
+}
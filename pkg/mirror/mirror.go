@@ -0,0 +1,420 @@
+// Package mirror copies the archive into a directory with a human readable
+// layout, which can be browsed directly or indexed by media servers such as
+// Plex and Jellyfin.
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"github.com/webbgeorge/castkeeper/pkg/util"
+	"gorm.io/gorm"
+)
+
+const (
+	artworkFileName = "folder.jpg"
+	showNFOFileName = "tvshow.nfo"
+
+	// maxNameBytes limits the length of podcast and episode titles in file
+	// names, leaving room for a date prefix, a suffix and an extension
+	maxNameBytes = 150
+)
+
+type Options struct {
+	// NFO writes NFO sidecar files describing each podcast and episode
+	NFO bool
+	// Prune removes files from the directory which aren't part of the mirror,
+	// e.g. episodes which have since been deleted
+	Prune bool
+}
+
+type Result struct {
+	Copied    int
+	Unchanged int
+	Removed   int
+	Failed    int
+}
+
+// Mirror copies downloaded episodes into dir, laid out as
+// "Podcast Title/YYYY-MM-DD - Episode Title.ext", with the podcast's artwork as
+// folder.jpg. Files which are already up to date are not copied again, so it
+// can be run repeatedly to keep dir in sync with the archive. Episodes which
+// fail to copy are logged and counted, and don't stop the rest of the mirror.
+func Mirror(ctx context.Context, db *gorm.DB, objstore objectstorage.ObjectStorage, dir string, opts Options) (Result, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return Result{}, fmt.Errorf("failed to create mirror directory: %w", err)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to open mirror directory: %w", err)
+	}
+	defer root.Close()
+
+	pods, err := podcasts.ListPodcasts(ctx, db)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to list podcasts: %w", err)
+	}
+	slices.SortFunc(pods, func(a, b podcasts.Podcast) int {
+		return strings.Compare(a.GUID, b.GUID)
+	})
+
+	m := &mirror{
+		objstore: objstore,
+		root:     root,
+		opts:     opts,
+		written:  make(map[string]bool),
+	}
+	podDirs := newNamer()
+	for _, pod := range pods {
+		eps, err := podcasts.ListEpisodesByStatus(ctx, db, pod.GUID, podcasts.EpisodeStatusSuccess)
+		if err != nil {
+			return m.result, fmt.Errorf("failed to list episodes of podcast '%s': %w", pod.GUID, err)
+		}
+		if len(eps) == 0 {
+			continue
+		}
+		podDir := podDirs.name(safeName(pod.Title))
+		if err := m.mirrorPodcast(ctx, pod, eps, podDir); err != nil {
+			return m.result, err
+		}
+	}
+
+	if opts.Prune {
+		if err := m.prune(); err != nil {
+			return m.result, fmt.Errorf("failed to prune mirror directory: %w", err)
+		}
+	}
+
+	return m.result, nil
+}
+
+type mirror struct {
+	objstore objectstorage.ObjectStorage
+	root     *os.Root
+	opts     Options
+	// written are the paths which are part of the mirror, which are kept when
+	// pruning
+	written map[string]bool
+	result  Result
+}
+
+func (m *mirror) mirrorPodcast(ctx context.Context, pod podcasts.Podcast, eps []podcasts.Episode, podDir string) error {
+	if err := m.root.Mkdir(podDir, 0750); err != nil && !errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("failed to create directory for podcast '%s': %w", pod.GUID, err)
+	}
+	m.written[podDir] = true
+
+	err := m.mirrorArtwork(ctx, pod, path.Join(podDir, artworkFileName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to mirror artwork of podcast '%s': %s", pod.GUID, err.Error()))
+	}
+
+	if m.opts.NFO {
+		m.writeNFO(ctx, path.Join(podDir, showNFOFileName), newShowNFO(pod))
+	}
+
+	// oldest first, so that episodes keep their names as new episodes are added
+	slices.SortStableFunc(eps, func(a, b podcasts.Episode) int {
+		if c := a.PublishedAt.Compare(b.PublishedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.GUID, b.GUID)
+	})
+
+	epNames := newNamer()
+	for _, ep := range eps {
+		extension, err := podcasts.MIMETypeExtension(ep.MimeType)
+		if err != nil {
+			m.fail(ctx, ep, err)
+			continue
+		}
+		baseName := epNames.name(episodeName(ep))
+
+		dir, fileName, err := podcasts.EpisodeFile(ep)
+		if err != nil {
+			m.fail(ctx, ep, err)
+			continue
+		}
+		dest := path.Join(podDir, fmt.Sprintf("%s.%s", baseName, extension))
+		err = m.copyObject(ctx, dir, fileName, dest, ep.Bytes, ep.PublishedAt)
+		if err != nil {
+			m.fail(ctx, ep, err)
+			continue
+		}
+
+		if m.opts.NFO {
+			m.writeNFO(ctx, path.Join(podDir, baseName+".nfo"), newEpisodeNFO(pod, ep))
+		}
+	}
+	return nil
+}
+
+func (m *mirror) fail(ctx context.Context, ep podcasts.Episode, err error) {
+	framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to mirror episode '%s': %s", ep.GUID, err.Error()))
+	m.result.Failed++
+}
+
+func (m *mirror) mirrorArtwork(ctx context.Context, pod podcasts.Podcast, dest string) error {
+	// an existing copy is kept if the artwork can't be read
+	m.written[dest] = true
+
+	fileName := fmt.Sprintf("%s.%s", util.SanitiseGUID(pod.GUID), "jpg")
+	f, err := m.objstore.Open(ctx, util.SanitiseGUID(pod.GUID), fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	artwork, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	return m.writeIfChanged(dest, artwork)
+}
+
+// copyObject copies a stored file to dest, unless dest already has the
+// expected size and modification time, which is set to modTime
+func (m *mirror) copyObject(ctx context.Context, podcastGUID, fileName, dest string, size int64, modTime time.Time) error {
+	// an existing copy is kept if the stored file can't be read
+	m.written[dest] = true
+
+	if info, err := m.root.Stat(dest); err == nil && size > 0 {
+		if info.Size() == size && info.ModTime().Equal(modTime) {
+			m.result.Unchanged++
+			return nil
+		}
+	}
+
+	src, err := m.objstore.Open(ctx, podcastGUID, fileName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if err := m.writeFile(dest, src); err != nil {
+		return err
+	}
+	if err := m.root.Chtimes(dest, modTime, modTime); err != nil {
+		return err
+	}
+	m.result.Copied++
+	return nil
+}
+
+// writeFile writes to a temporary file which replaces dest once complete, so
+// that media servers never see a partially written file
+func (m *mirror) writeFile(dest string, r io.Reader) error {
+	tmp := path.Join(path.Dir(dest), "."+path.Base(dest)+".tmp")
+	f, err := m.root.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		_ = m.root.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		_ = m.root.Remove(tmp)
+		return err
+	}
+	if err := m.root.Rename(tmp, dest); err != nil {
+		_ = m.root.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func (m *mirror) writeIfChanged(dest string, content []byte) error {
+	if existing, err := m.root.ReadFile(dest); err == nil && bytes.Equal(existing, content) {
+		return nil
+	}
+	return m.writeFile(dest, bytes.NewReader(content))
+}
+
+// writeNFO writes an NFO file when its content has changed. Failures are
+// logged, as the mirrored media is still usable without it.
+func (m *mirror) writeNFO(ctx context.Context, dest string, nfo any) {
+	content, err := xml.MarshalIndent(nfo, "", "  ")
+	if err != nil {
+		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to encode '%s': %s", dest, err.Error()))
+		return
+	}
+	content = append([]byte(xml.Header), content...)
+
+	m.written[dest] = true
+	if err := m.writeIfChanged(dest, content); err != nil {
+		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to write '%s': %s", dest, err.Error()))
+	}
+}
+
+// prune removes files and directories which weren't written or kept by this
+// mirror
+func (m *mirror) prune() error {
+	var files, dirs []string
+	err := fs.WalkDir(m.root.FS(), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == "." || m.written[p] {
+			return nil
+		}
+		if d.IsDir() {
+			dirs = append(dirs, p)
+		} else {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range files {
+		if err := m.root.Remove(f); err != nil {
+			return err
+		}
+		m.result.Removed++
+	}
+	// deepest first, so that directories are empty when removed
+	slices.Reverse(dirs)
+	for _, d := range dirs {
+		if err := m.root.RemoveAll(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func episodeName(ep podcasts.Episode) string {
+	if ep.PublishedAt.IsZero() {
+		return safeName(ep.Title)
+	}
+	return fmt.Sprintf("%s - %s", ep.PublishedAt.UTC().Format("2006-01-02"), safeName(ep.Title))
+}
+
+// namer makes names unique, by adding a number to names which have already
+// been used
+type namer struct {
+	used map[string]bool
+}
+
+func newNamer() *namer {
+	return &namer{used: make(map[string]bool)}
+}
+
+func (n *namer) name(name string) string {
+	unique := name
+	for i := 2; n.used[strings.ToLower(unique)]; i++ {
+		unique = fmt.Sprintf("%s (%d)", name, i)
+	}
+	// case insensitive, as file systems often are
+	n.used[strings.ToLower(unique)] = true
+	return unique
+}
+
+// safeName makes a title safe to use as a file or directory name on common
+// file systems
+func safeName(title string) string {
+	var b strings.Builder
+	for _, r := range title {
+		switch {
+		case strings.ContainsRune(`/\:*?"<>|`, r):
+			b.WriteRune('-')
+		case unicode.IsControl(r) || unicode.IsSpace(r):
+			b.WriteRune(' ')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	name := strings.Join(strings.Fields(b.String()), " ")
+
+	if len(name) > maxNameBytes {
+		name = name[:maxNameBytes]
+		for !utf8.ValidString(name) {
+			name = name[:len(name)-1]
+		}
+	}
+
+	// leading dots hide files, and trailing dots and spaces are dropped by
+	// Windows
+	name = strings.TrimRight(strings.TrimLeft(name, ". "), ". ")
+	if name == "" {
+		return "Untitled"
+	}
+	return name
+}
+
+type showNFO struct {
+	XMLName xml.Name `xml:"tvshow"`
+	Title   string   `xml:"title"`
+	Plot    string   `xml:"plot,omitempty"`
+	Studio  string   `xml:"studio,omitempty"`
+	Genres  []string `xml:"genre"`
+}
+
+func newShowNFO(pod podcasts.Podcast) showNFO {
+	nfo := showNFO{
+		Title:  pod.Title,
+		Plot:   plainText(pod.Description),
+		Studio: pod.Author,
+	}
+	for _, c := range pod.Categories {
+		nfo.Genres = append(nfo.Genres, c.Name)
+	}
+	return nfo
+}
+
+type episodeNFO struct {
+	XMLName   xml.Name `xml:"episodedetails"`
+	Title     string   `xml:"title"`
+	ShowTitle string   `xml:"showtitle"`
+	Plot      string   `xml:"plot,omitempty"`
+	Aired     string   `xml:"aired,omitempty"`
+	// Runtime is in minutes
+	Runtime  int         `xml:"runtime,omitempty"`
+	UniqueID nfoUniqueID `xml:"uniqueid"`
+}
+
+type nfoUniqueID struct {
+	Type  string `xml:"type,attr"`
+	Value string `xml:",chardata"`
+}
+
+func newEpisodeNFO(pod podcasts.Podcast, ep podcasts.Episode) episodeNFO {
+	nfo := episodeNFO{
+		Title:     ep.Title,
+		ShowTitle: pod.Title,
+		Plot:      plainText(ep.Description),
+		Runtime:   (ep.Duration() + 59) / 60,
+		UniqueID:  nfoUniqueID{Type: "castkeeper", Value: ep.GUID},
+	}
+	if !ep.PublishedAt.IsZero() {
+		nfo.Aired = ep.PublishedAt.UTC().Format("2006-01-02")
+	}
+	return nfo
+}
+
+// plainText strips the HTML used in podcast descriptions
+func plainText(s string) string {
+	return strings.TrimSpace(html.UnescapeString(bluemonday.StrictPolicy().Sanitize(s)))
+}
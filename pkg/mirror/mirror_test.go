@@ -0,0 +1,127 @@
+package mirror_test
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+	"github.com/webbgeorge/castkeeper/pkg/mirror"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+)
+
+// from valid.xml fixture
+const (
+	podGUID = "916ed63b-7e5e-5541-af78-e214a0c14d95"
+	podDir  = "Test podcast 916ed63b-7e5e-5541-af78-e214a0c14d95"
+	ep1File = "2024-12-26 - Test episode c8998fa5-8083-56a6-8d3c-7b98d031b3d8"
+)
+
+func TestMirror(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{Root: root}
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	// matches the size of the fixture file
+	ep, err := podcasts.GetEpisode(ctx, db, "c8998fa5-8083-56a6-8d3c-7b98d031b3d8")
+	if err != nil {
+		panic(err)
+	}
+	if err := db.Model(&ep).Update("bytes", 14).Error; err != nil {
+		panic(err)
+	}
+
+	// an episode with a title which isn't a valid file name, on the same day as
+	// another episode with the same title
+	for _, guid := range []string{"awkward-1", "awkward-2"} {
+		if err := db.Create(&podcasts.Episode{
+			GUID:        guid,
+			PodcastGUID: podGUID,
+			Title:       `What? A "quoted": title/part 2.`,
+			Description: "<p>Awkward &amp; <b>bold</b></p>",
+			DownloadURL: "http://testdata/audio/ep1.mp3",
+			MimeType:    "audio/mpeg",
+			PublishedAt: time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC),
+			Status:      podcasts.EpisodeStatusSuccess,
+		}).Error; err != nil {
+			panic(err)
+		}
+		if err := root.WriteFile(path.Join(podGUID, guid+".mp3"), []byte(guid), 0640); err != nil {
+			panic(err)
+		}
+	}
+
+	result, err := mirror.Mirror(ctx, db, objstore, dir, mirror.Options{NFO: true})
+
+	assert.Nil(t, err)
+	// ep-2 has no stored file
+	assert.Equal(t, mirror.Result{Copied: 3, Failed: 1}, result)
+	assertFile(t, dir, path.Join(podDir, "folder.jpg"), "Not a real JPG")
+	assertFile(t, dir, path.Join(podDir, ep1File+".mp3"), "Not a real MP3")
+	assertFile(t, dir, path.Join(podDir, "2025-01-02 - What- A -quoted-- title-part 2.mp3"), "awkward-1")
+	assertFile(t, dir, path.Join(podDir, "2025-01-02 - What- A -quoted-- title-part 2 (2).mp3"), "awkward-2")
+
+	info, err := os.Stat(path.Join(dir, podDir, ep1File+".mp3"))
+	assert.Nil(t, err)
+	assert.True(t, info.ModTime().Equal(time.Date(2024, 12, 26, 11, 12, 13, 0, time.UTC)))
+
+	show, err := os.ReadFile(path.Join(dir, podDir, "tvshow.nfo"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(show), "<tvshow>")
+	assert.Contains(t, string(show), "<title>Test podcast 916ed63b-7e5e-5541-af78-e214a0c14d95</title>")
+
+	epNFO, err := os.ReadFile(path.Join(dir, podDir, "2025-01-02 - What- A -quoted-- title-part 2.nfo"))
+	assert.Nil(t, err)
+	assert.Contains(t, string(epNFO), "<title>What? A &#34;quoted&#34;: title/part 2.</title>")
+	assert.Contains(t, string(epNFO), "<plot>Awkward &amp; bold</plot>")
+	assert.Contains(t, string(epNFO), "<aired>2025-01-02</aired>")
+	assert.Contains(t, string(epNFO), `<uniqueid type="castkeeper">awkward-1</uniqueid>`)
+
+	// files which are up to date aren't copied again
+	result, err = mirror.Mirror(ctx, db, objstore, dir, mirror.Options{NFO: true})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.Unchanged)
+	assert.Equal(t, 1, result.Failed)
+}
+
+func TestMirror_Prune(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{Root: root}
+	dir := t.TempDir()
+
+	for _, p := range []string{"Removed podcast/old.mp3", path.Join(podDir, "old.mp3")} {
+		if err := os.MkdirAll(path.Join(dir, path.Dir(p)), 0750); err != nil {
+			panic(err)
+		}
+		if err := os.WriteFile(path.Join(dir, p), []byte("old"), 0640); err != nil {
+			panic(err)
+		}
+	}
+
+	result, err := mirror.Mirror(context.Background(), db, objstore, dir, mirror.Options{Prune: true})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result.Removed)
+	assert.NoDirExists(t, path.Join(dir, "Removed podcast"))
+	assert.NoFileExists(t, path.Join(dir, podDir, "old.mp3"))
+	assertFile(t, dir, path.Join(podDir, ep1File+".mp3"), "Not a real MP3")
+	assertFile(t, dir, path.Join(podDir, "folder.jpg"), "Not a real JPG")
+}
+
+func assertFile(t *testing.T, dir, name, expectedContent string) {
+	t.Helper()
+	content, err := os.ReadFile(path.Join(dir, name))
+	if assert.Nil(t, err) {
+		assert.Equal(t, expectedContent, string(content))
+	}
+}
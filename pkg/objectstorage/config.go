@@ -3,18 +3,47 @@ package objectstorage
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net/http"
+	"os"
 	"path"
 	"time"
 
 	awsConfig "github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/webbgeorge/castkeeper/pkg/config"
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
 	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"golang.org/x/crypto/ssh"
 )
 
-func ConfigureObjectStorage(ctx context.Context, cfg config.Config) (ObjectStorage, error) {
+// ConfigureObjectStorage configures the storage driver of cfg. When media
+// encryption is enabled, the media key is loaded with encService.
+func ConfigureObjectStorage(
+	ctx context.Context,
+	cfg config.Config,
+	encService *encryption.EncryptedValueService,
+) (ObjectStorage, error) {
 	httpClient := framework.NewHTTPClient(time.Minute * 15)
 
+	objstore, err := configureDriver(ctx, cfg, httpClient)
+	if err != nil || !cfg.Encryption.EncryptMedia {
+		return objstore, err
+	}
+
+	mediaService, err := encryption.ConfigureMediaEncryptionService(cfg, encService)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure media encryption: %w", err)
+	}
+
+	return &EncryptedObjectStorage{
+		HTTPClient: httpClient,
+		Storage:    objstore,
+		Encryption: mediaService,
+	}, nil
+}
+
+func configureDriver(ctx context.Context, cfg config.Config, httpClient *http.Client) (ObjectStorage, error) {
 	switch cfg.ObjectStorage.Driver {
 	case config.ObjectStorageDriverLocal:
 		return &LocalObjectStorage{
@@ -41,7 +70,63 @@ func ConfigureObjectStorage(ctx context.Context, cfg config.Config) (ObjectStora
 			Prefix:     cfg.ObjectStorage.S3Prefix,
 		}, nil
 
+	case config.ObjectStorageDriverWebDAV:
+		return &WebDAVObjectStorage{
+			HTTPClient: httpClient,
+			// no timeout, as files are streamed to listeners for as long as
+			// they take to listen
+			WebDAVClient: &http.Client{Transport: framework.NewHTTPTransport(http.DefaultTransport)},
+			BaseURL:      cfg.ObjectStorage.WebDAVURL,
+			Username:     cfg.ObjectStorage.WebDAVUsername,
+			Password:     cfg.ObjectStorage.WebDAVPassword,
+		}, nil
+
+	case config.ObjectStorageDriverSFTP:
+		sshConfig, err := sftpSSHConfig(cfg.ObjectStorage)
+		if err != nil {
+			return nil, err
+		}
+
+		return &SFTPObjectStorage{
+			HTTPClient: httpClient,
+			Address:    cfg.ObjectStorage.SFTPAddress,
+			SSHConfig:  sshConfig,
+			Root:       cfg.ObjectStorage.SFTPRoot,
+		}, nil
+
 	default:
 		return nil, errors.New("unknown objectstorage driver")
 	}
 }
+
+// sftpSSHConfig authenticates with a private key and/or password, and only
+// connects to a server with the configured host key
+func sftpSSHConfig(cfg config.ObjectStorageConfig) (*ssh.ClientConfig, error) {
+	hostKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(cfg.SFTPHostKey))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SFTPHostKey: %w", err)
+	}
+
+	auth := make([]ssh.AuthMethod, 0)
+	if cfg.SFTPPrivateKeyPath != "" {
+		key, err := os.ReadFile(cfg.SFTPPrivateKeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SFTPPrivateKeyPath: %w", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SFTPPrivateKeyPath: %w", err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if cfg.SFTPPassword != "" {
+		auth = append(auth, ssh.Password(cfg.SFTPPassword))
+	}
+
+	return &ssh.ClientConfig{
+		User:            cfg.SFTPUsername,
+		Auth:            auth,
+		HostKeyCallback: ssh.FixedHostKey(hostKey),
+		Timeout:         30 * time.Second,
+	}, nil
+}
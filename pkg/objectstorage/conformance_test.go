@@ -0,0 +1,384 @@
+package objectstorage_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	awsConfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+)
+
+// conformance tests are run against every object storage driver, to check
+// that they behave the same
+
+func TestLocalObjectStorage(t *testing.T) {
+	runConformanceTests(t, newLocalObjectStorage)
+}
+
+func TestEncryptedObjectStorage(t *testing.T) {
+	runConformanceTests(t, func(t *testing.T) objectstorage.ObjectStorage {
+		return newEncryptedObjectStorage(newLocalObjectStorage(t))
+	})
+}
+
+func TestWebDAVObjectStorage(t *testing.T) {
+	runConformanceTests(t, func(t *testing.T) objectstorage.ObjectStorage {
+		return &objectstorage.WebDAVObjectStorage{
+			HTTPClient:   fixtures.TestDataHTTPClient,
+			WebDAVClient: http.DefaultClient,
+			BaseURL:      startWebDAVServer(t),
+			Username:     testServerUsername,
+			Password:     testServerPassword,
+		}
+	})
+}
+
+func TestSFTPObjectStorage(t *testing.T) {
+	runConformanceTests(t, func(t *testing.T) objectstorage.ObjectStorage {
+		server := startSFTPServer(t)
+		objstore := &objectstorage.SFTPObjectStorage{
+			HTTPClient: fixtures.TestDataHTTPClient,
+			Address:    server.addr,
+			SSHConfig:  server.clientConfig(),
+			Root:       t.TempDir(),
+		}
+		t.Cleanup(func() { _ = objstore.Close() })
+		return objstore
+	})
+}
+
+func TestSFTPObjectStorage_Reconnects(t *testing.T) {
+	server := startSFTPServer(t)
+	objstore := &objectstorage.SFTPObjectStorage{
+		Address:   server.addr,
+		SSHConfig: server.clientConfig(),
+		Root:      t.TempDir(),
+	}
+	defer objstore.Close()
+	putObject(t, objstore, "pod-1", "ep-1.mp3", "content")
+
+	server.closeConns()
+
+	assert.Eventually(t, func() bool {
+		_, err := objstore.Stat(context.Background(), "pod-1", "ep-1.mp3")
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+// TestS3ObjectStorage runs against an S3 compatible service, e.g. localstack
+// from docker-compose.yml, configured with the standard AWS environment
+// variables and CASTKEEPER_TEST_S3_BUCKET. See `make test_s3`.
+func TestS3ObjectStorage(t *testing.T) {
+	bucket := os.Getenv("CASTKEEPER_TEST_S3_BUCKET")
+	if bucket == "" {
+		t.Skip("CASTKEEPER_TEST_S3_BUCKET is not set")
+	}
+
+	awsCfg, err := awsConfig.LoadDefaultConfig(context.Background())
+	if err != nil {
+		panic(err)
+	}
+	s3Client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		o.UsePathStyle = true
+	})
+
+	runConformanceTests(t, func(t *testing.T) objectstorage.ObjectStorage {
+		// each test has its own prefix, so tests don't see each other's files
+		objstore := &objectstorage.S3ObjectStorage{
+			HTTPClient: fixtures.TestDataHTTPClient,
+			S3Client:   s3Client,
+			BucketName: bucket,
+			Prefix:     fmt.Sprintf("conformance-%d/", time.Now().UnixNano()),
+		}
+		t.Cleanup(func() {
+			objects, err := objstore.List(context.Background(), "")
+			if err != nil {
+				return
+			}
+			for _, obj := range objects {
+				_ = objstore.Delete(context.Background(), obj.PodcastGUID, obj.FileName)
+			}
+		})
+		return objstore
+	})
+}
+
+func runConformanceTests(t *testing.T, newObjectStorage func(t *testing.T) objectstorage.ObjectStorage) {
+	tests := map[string]func(t *testing.T, objstore objectstorage.ObjectStorage){
+		"PutAndOpen":        testPutAndOpen,
+		"PutReplaces":       testPutReplaces,
+		"OpenSeek":          testOpenSeek,
+		"OpenNotFound":      testOpenNotFound,
+		"Stat":              testStat,
+		"StatNotFound":      testStatNotFound,
+		"List":              testList,
+		"Delete":            testDelete,
+		"DeleteNotFound":    testDeleteNotFound,
+		"ServeFileRange":    testServeFileRange,
+		"ServeFileNotFound": testServeFileNotFound,
+		"SaveRemoteFile":    testSaveRemoteFile,
+		"SaveRemoteFileErr": testSaveRemoteFileErr,
+		"Move":              testMove,
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			test(t, newObjectStorage(t))
+		})
+	}
+}
+
+func testPutAndOpen(t *testing.T, objstore objectstorage.ObjectStorage) {
+	ctx := context.Background()
+	content := []byte("some file content")
+
+	saved, err := objstore.Put(ctx, "pod-1", "ep-1.mp3", bytes.NewReader(content))
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(content)), saved.Bytes)
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(content)), saved.SHA256)
+	assert.Equal(t, content, readObject(t, objstore, "pod-1", "ep-1.mp3"))
+}
+
+func testPutReplaces(t *testing.T, objstore objectstorage.ObjectStorage) {
+	ctx := context.Background()
+	putObject(t, objstore, "pod-1", "ep-1.mp3", "old content")
+
+	_, err := objstore.Put(ctx, "pod-1", "ep-1.mp3", bytes.NewReader([]byte("new")))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), readObject(t, objstore, "pod-1", "ep-1.mp3"))
+}
+
+func testOpenSeek(t *testing.T, objstore objectstorage.ObjectStorage) {
+	ctx := context.Background()
+	putObject(t, objstore, "pod-1", "ep-1.mp3", "0123456789")
+
+	f, err := objstore.Open(ctx, "pod-1", "ep-1.mp3")
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer f.Close()
+
+	b := make([]byte, 2)
+	_, err = io.ReadFull(f, b)
+	assert.Nil(t, err)
+	assert.Equal(t, "01", string(b))
+
+	pos, err := f.Seek(6, io.SeekStart)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(6), pos)
+	rest, err := io.ReadAll(f)
+	assert.Nil(t, err)
+	assert.Equal(t, "6789", string(rest))
+
+	pos, err = f.Seek(-3, io.SeekEnd)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), pos)
+	_, err = io.ReadFull(f, b)
+	assert.Nil(t, err)
+	assert.Equal(t, "78", string(b))
+
+	pos, err = f.Seek(-5, io.SeekCurrent)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), pos)
+	_, err = io.ReadFull(f, b)
+	assert.Nil(t, err)
+	assert.Equal(t, "45", string(b))
+}
+
+func testOpenNotFound(t *testing.T, objstore objectstorage.ObjectStorage) {
+	_, err := objstore.Open(context.Background(), "pod-1", "missing.mp3")
+
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func testStat(t *testing.T, objstore objectstorage.ObjectStorage) {
+	putObject(t, objstore, "pod-1", "ep-1.mp3", "some file content")
+
+	info, err := objstore.Stat(context.Background(), "pod-1", "ep-1.mp3")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "pod-1", info.PodcastGUID)
+	assert.Equal(t, "ep-1.mp3", info.FileName)
+	assert.Equal(t, "pod-1/ep-1.mp3", info.Path())
+	assert.Equal(t, int64(17), info.Bytes)
+	assert.WithinDuration(t, time.Now(), info.ModTime, time.Minute)
+}
+
+func testStatNotFound(t *testing.T, objstore objectstorage.ObjectStorage) {
+	_, err := objstore.Stat(context.Background(), "pod-1", "missing.mp3")
+
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func testList(t *testing.T, objstore objectstorage.ObjectStorage) {
+	ctx := context.Background()
+	putObject(t, objstore, "pod-1", "ep-1.mp3", "1")
+	putObject(t, objstore, "pod-1", "ep-2.mp3", "22")
+	putObject(t, objstore, "pod-10", "ep-1.mp3", "333")
+
+	all, err := objstore.List(ctx, "")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"pod-1/ep-1.mp3", "pod-1/ep-2.mp3", "pod-10/ep-1.mp3"}, objectPaths(all))
+	for _, obj := range all {
+		if obj.Path() == "pod-10/ep-1.mp3" {
+			assert.Equal(t, "pod-10", obj.PodcastGUID)
+			assert.Equal(t, "ep-1.mp3", obj.FileName)
+			assert.Equal(t, int64(3), obj.Bytes)
+			assert.False(t, obj.ModTime.IsZero())
+		}
+	}
+
+	dir, err := objstore.List(ctx, "pod-1/")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"pod-1/ep-1.mp3", "pod-1/ep-2.mp3"}, objectPaths(dir))
+
+	partial, err := objstore.List(ctx, "pod-1")
+	assert.Nil(t, err)
+	assert.Len(t, partial, 3)
+
+	file, err := objstore.List(ctx, "pod-1/ep-2")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"pod-1/ep-2.mp3"}, objectPaths(file))
+
+	none, err := objstore.List(ctx, "missing/")
+	assert.Nil(t, err)
+	assert.Len(t, none, 0)
+}
+
+func testDelete(t *testing.T, objstore objectstorage.ObjectStorage) {
+	ctx := context.Background()
+	putObject(t, objstore, "pod-1", "ep-1.mp3", "1")
+	putObject(t, objstore, "pod-1", "ep-2.mp3", "2")
+
+	err := objstore.Delete(ctx, "pod-1", "ep-1.mp3")
+
+	assert.Nil(t, err)
+	_, err = objstore.Stat(ctx, "pod-1", "ep-1.mp3")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	_, err = objstore.Stat(ctx, "pod-1", "ep-2.mp3")
+	assert.Nil(t, err)
+}
+
+func testDeleteNotFound(t *testing.T, objstore objectstorage.ObjectStorage) {
+	err := objstore.Delete(context.Background(), "pod-1", "missing.mp3")
+
+	assert.Nil(t, err)
+}
+
+func testServeFileRange(t *testing.T, objstore objectstorage.ObjectStorage) {
+	putObject(t, objstore, "pod-1", "ep-1.mp3", "0123456789")
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	w := httptest.NewRecorder()
+	err := objstore.ServeFile(context.Background(), req, w, "pod-1", "ep-1.mp3")
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "bytes 2-4/10", w.Header().Get("Content-Range"))
+	assert.Equal(t, "234", w.Body.String())
+}
+
+func testServeFileNotFound(t *testing.T, objstore objectstorage.ObjectStorage) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	err := objstore.ServeFile(context.Background(), req, w, "pod-1", "missing.mp3")
+
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func testSaveRemoteFile(t *testing.T, objstore objectstorage.ObjectStorage) {
+	content, err := os.ReadFile("../fixtures/testdata/audio/ep1.mp3")
+	if err != nil {
+		panic(err)
+	}
+
+	var progressDone int64
+	saved, err := objstore.SaveRemoteFile(context.Background(), nil, "http://testdata/audio/ep1.mp3", "pod-1", "ep-1.mp3", objectstorage.SaveOptions{
+		Inspect: func(head []byte) (string, error) {
+			return "renamed.mp3", nil
+		},
+		Progress: func(bytesDone, bytesTotal int64) {
+			progressDone = bytesDone
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(content)), saved.Bytes)
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(content)), saved.SHA256)
+	assert.Equal(t, int64(len(content)), progressDone)
+	assert.Equal(t, content, readObject(t, objstore, "pod-1", "renamed.mp3"))
+	all, err := objstore.List(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"pod-1/renamed.mp3"}, objectPaths(all))
+}
+
+func testSaveRemoteFileErr(t *testing.T, objstore objectstorage.ObjectStorage) {
+	_, err := objstore.SaveRemoteFile(context.Background(), nil, "http://testdata/error", "pod-1", "ep-1.mp3", objectstorage.SaveOptions{})
+
+	assert.ErrorContains(t, err, "failed to download file with status '500'")
+	all, err := objstore.List(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, all, 0)
+}
+
+func testMove(t *testing.T, objstore objectstorage.ObjectStorage) {
+	ctx := context.Background()
+	putObject(t, objstore, "pod-1", "ep-1.mp3", "content")
+	putObject(t, objstore, ".blobs", "abc", "old content")
+
+	err := objectstorage.Move(ctx, objstore, "pod-1", "ep-1.mp3", ".blobs", "abc")
+
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("content"), readObject(t, objstore, ".blobs", "abc"))
+	_, err = objstore.Stat(ctx, "pod-1", "ep-1.mp3")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	err = objectstorage.Move(ctx, objstore, "pod-1", "missing.mp3", ".blobs", "def")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func putObject(t *testing.T, objstore objectstorage.ObjectStorage, podcastGUID, fileName, content string) {
+	t.Helper()
+	_, err := objstore.Put(context.Background(), podcastGUID, fileName, bytes.NewReader([]byte(content)))
+	if err != nil {
+		panic(err)
+	}
+}
+
+func readObject(t *testing.T, objstore objectstorage.ObjectStorage, podcastGUID, fileName string) []byte {
+	t.Helper()
+	f, err := objstore.Open(context.Background(), podcastGUID, fileName)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func objectPaths(objects []objectstorage.ObjectInfo) []string {
+	paths := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		paths = append(paths, obj.Path())
+	}
+	slices.Sort(paths)
+	return paths
+}
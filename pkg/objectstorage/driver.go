@@ -2,12 +2,71 @@ package objectstorage
 
 import (
 	"context"
+	"io"
 	"net/http"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/webbgeorge/castkeeper/pkg/podcasts"
 )
 
 type ObjectStorage interface {
-	SaveRemoteFile(ctx context.Context, creds *podcasts.PodcastCredentials, remoteLocation, podcastGUID, fileName string) (int64, error)
+	SaveRemoteFile(ctx context.Context, creds *podcasts.PodcastCredentials, remoteLocation, podcastGUID, fileName string, opts SaveOptions) (SavedFile, error)
 	ServeFile(ctx context.Context, r *http.Request, w http.ResponseWriter, podcastGUID, fileName string) error
+	// Open reads a stored file, returning an error wrapping fs.ErrNotExist when
+	// the file does not exist
+	Open(ctx context.Context, podcastGUID, fileName string) (io.ReadSeekCloser, error)
+	// Put saves the contents of r as a file, replacing any existing file only
+	// once r has been read successfully
+	Put(ctx context.Context, podcastGUID, fileName string, r io.Reader) (SavedFile, error)
+	// Delete removes a stored file. Deleting a file which does not exist is not
+	// an error.
+	Delete(ctx context.Context, podcastGUID, fileName string) error
+	// Stat describes a stored file, returning an error wrapping fs.ErrNotExist
+	// when the file does not exist
+	Stat(ctx context.Context, podcastGUID, fileName string) (ObjectInfo, error)
+	// List describes every stored file whose path, "<podcastGUID>/<fileName>",
+	// starts with prefix. An empty prefix lists all files.
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
 }
+
+// ObjectInfo describes a stored file
+type ObjectInfo struct {
+	PodcastGUID string
+	FileName    string
+	Bytes       int64
+	ModTime     time.Time
+}
+
+// Path is the path of the file within object storage
+func (oi ObjectInfo) Path() string {
+	return path.Join(oi.PodcastGUID, oi.FileName)
+}
+
+// objectInfoFromPath creates the ObjectInfo of a file from its path within
+// object storage
+func objectInfoFromPath(p string, bytes int64, modTime time.Time) ObjectInfo {
+	podcastGUID, fileName, ok := strings.Cut(p, "/")
+	if !ok {
+		podcastGUID, fileName = "", p
+	}
+	return ObjectInfo{
+		PodcastGUID: podcastGUID,
+		FileName:    fileName,
+		Bytes:       bytes,
+		ModTime:     modTime,
+	}
+}
+
+// SaveOptions are optional hooks used while saving a remote file
+type SaveOptions struct {
+	// Progress is called as the file is saved
+	Progress ProgressFunc
+	// Inspect is called with up to the first podcasts.SniffLength bytes of the
+	// file, before it is saved. It returns the file name to save the file as,
+	// or an error to reject the file.
+	Inspect InspectFunc
+}
+
+type InspectFunc func(head []byte) (fileName string, err error)
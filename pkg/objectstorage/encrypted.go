@@ -0,0 +1,145 @@
+package objectstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+)
+
+// encryptedFileSuffix is added to the names of encrypted files, so that files
+// saved before media encryption was enabled can still be told apart and read
+const encryptedFileSuffix = ".enc"
+
+// EncryptedObjectStorage encrypts files before they are saved to Storage, and
+// decrypts them as they are read. Files are listed and described by their
+// decrypted names and sizes. Files which were saved unencrypted, before media
+// encryption was enabled, are read as they are.
+type EncryptedObjectStorage struct {
+	HTTPClient *http.Client
+	Storage    ObjectStorage
+	Encryption *encryption.MediaEncryptionService
+}
+
+// SaveRemoteFile downloads the file through the encryption, so interrupted
+// downloads are not resumed
+func (s *EncryptedObjectStorage) SaveRemoteFile(ctx context.Context, creds *podcasts.PodcastCredentials, remoteLocation, podcastGUID, fileName string, opts SaveOptions) (SavedFile, error) {
+	return streamRemoteFile(
+		ctx,
+		s.HTTPClient,
+		creds,
+		remoteLocation,
+		fileName,
+		opts,
+		func(fileName string, r io.Reader) error {
+			return s.put(ctx, podcastGUID, fileName, r)
+		},
+		func(fileName string) error {
+			return s.Delete(ctx, podcastGUID, fileName)
+		},
+	)
+}
+
+func (s *EncryptedObjectStorage) Put(ctx context.Context, podcastGUID, fileName string, r io.Reader) (SavedFile, error) {
+	hr := newHashingReader(r)
+	if err := s.put(ctx, podcastGUID, fileName, hr); err != nil {
+		return SavedFile{}, err
+	}
+	return hr.savedFile(), nil
+}
+
+// put saves the encrypted contents of r, and removes any unencrypted file it
+// replaces
+func (s *EncryptedObjectStorage) put(ctx context.Context, podcastGUID, fileName string, r io.Reader) error {
+	_, err := s.Storage.Put(ctx, podcastGUID, fileName+encryptedFileSuffix, s.Encryption.EncryptReader(r))
+	if err != nil {
+		return err
+	}
+	return s.Storage.Delete(ctx, podcastGUID, fileName)
+}
+
+func (s *EncryptedObjectStorage) Open(ctx context.Context, podcastGUID, fileName string) (io.ReadSeekCloser, error) {
+	f, err := s.Storage.Open(ctx, podcastGUID, fileName+encryptedFileSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s.Storage.Open(ctx, podcastGUID, fileName)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	r, err := s.Encryption.DecryptReader(f, size)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return &decryptedFile{ReadSeeker: r, Closer: f}, nil
+}
+
+type decryptedFile struct {
+	io.ReadSeeker
+	io.Closer
+}
+
+func (s *EncryptedObjectStorage) ServeFile(ctx context.Context, r *http.Request, w http.ResponseWriter, podcastGUID, fileName string) error {
+	f, err := s.Open(ctx, podcastGUID, fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	http.ServeContent(w, r, "", time.Time{}, f)
+	return nil
+}
+
+func (s *EncryptedObjectStorage) Delete(ctx context.Context, podcastGUID, fileName string) error {
+	if err := s.Storage.Delete(ctx, podcastGUID, fileName+encryptedFileSuffix); err != nil {
+		return err
+	}
+	return s.Storage.Delete(ctx, podcastGUID, fileName)
+}
+
+func (s *EncryptedObjectStorage) Stat(ctx context.Context, podcastGUID, fileName string) (ObjectInfo, error) {
+	info, err := s.Storage.Stat(ctx, podcastGUID, fileName+encryptedFileSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s.Storage.Stat(ctx, podcastGUID, fileName)
+	}
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	return decryptedObjectInfo(info), nil
+}
+
+func (s *EncryptedObjectStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects, err := s.Storage.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i, obj := range objects {
+		if strings.HasSuffix(obj.FileName, encryptedFileSuffix) {
+			objects[i] = decryptedObjectInfo(obj)
+		}
+	}
+	return objects, nil
+}
+
+// decryptedObjectInfo describes an encrypted file by its name and size once
+// decrypted. Files which are too small to be valid are described by their
+// encrypted size, and fail to decrypt when read.
+func decryptedObjectInfo(info ObjectInfo) ObjectInfo {
+	info.FileName = strings.TrimSuffix(info.FileName, encryptedFileSuffix)
+	if size, err := encryption.MediaPlaintextSize(info.Bytes); err == nil {
+		info.Bytes = size
+	}
+	return info
+}
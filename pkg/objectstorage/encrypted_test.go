@@ -0,0 +1,98 @@
+package objectstorage_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+)
+
+func TestEncryptedObjectStorage_EncryptsFiles(t *testing.T) {
+	ctx := context.Background()
+	storage := newLocalObjectStorage(t)
+	objstore := newEncryptedObjectStorage(storage)
+
+	putObject(t, objstore, "pod-1", "ep-1.mp3", "some file content")
+
+	_, err := storage.Stat(ctx, "pod-1", "ep-1.mp3")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+	encrypted := readObject(t, storage, "pod-1", "ep-1.mp3.enc")
+	assert.NotContains(t, string(encrypted), "some file content")
+	assert.Equal(t, []byte("some file content"), readObject(t, objstore, "pod-1", "ep-1.mp3"))
+}
+
+func TestEncryptedObjectStorage_ReadsUnencryptedFiles(t *testing.T) {
+	ctx := context.Background()
+	storage := newLocalObjectStorage(t)
+	objstore := newEncryptedObjectStorage(storage)
+
+	// saved before media encryption was enabled
+	putObject(t, storage, "pod-1", "ep-1.mp3", "plaintext")
+
+	assert.Equal(t, []byte("plaintext"), readObject(t, objstore, "pod-1", "ep-1.mp3"))
+	info, err := objstore.Stat(ctx, "pod-1", "ep-1.mp3")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(9), info.Bytes)
+
+	// replacing the file encrypts it
+	putObject(t, objstore, "pod-1", "ep-1.mp3", "new content")
+
+	assert.Equal(t, []byte("new content"), readObject(t, objstore, "pod-1", "ep-1.mp3"))
+	all, err := storage.List(ctx, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"pod-1/ep-1.mp3.enc"}, objectPaths(all))
+}
+
+func TestEncryptedObjectStorage_MoveEncryptsUnencryptedFiles(t *testing.T) {
+	ctx := context.Background()
+	storage := newLocalObjectStorage(t)
+	objstore := newEncryptedObjectStorage(storage)
+	putObject(t, storage, "pod-1", "ep-1.mp3", "plaintext")
+	putObject(t, objstore, "pod-1", "ep-2.mp3", "encrypted")
+
+	assert.Nil(t, objectstorage.Move(ctx, objstore, "pod-1", "ep-1.mp3", ".blobs", "abc"))
+	assert.Nil(t, objectstorage.Move(ctx, objstore, "pod-1", "ep-2.mp3", ".blobs", "def"))
+
+	assert.Equal(t, []byte("plaintext"), readObject(t, objstore, ".blobs", "abc"))
+	assert.Equal(t, []byte("encrypted"), readObject(t, objstore, ".blobs", "def"))
+	all, err := storage.List(ctx, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{".blobs/abc.enc", ".blobs/def.enc"}, objectPaths(all))
+}
+
+func TestEncryptedObjectStorage_ServeFileRangeAcrossChunks(t *testing.T) {
+	objstore := newEncryptedObjectStorage(newLocalObjectStorage(t))
+	content := make([]byte, 600*1024)
+	_, _ = rand.Read(content)
+	_, err := objstore.Put(context.Background(), "pod-1", "ep-1.mp3", bytes.NewReader(content))
+	if err != nil {
+		panic(err)
+	}
+
+	start, end := 256*1024-10, 512*1024+10
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	w := httptest.NewRecorder()
+	err = objstore.ServeFile(context.Background(), req, w, "pod-1", "ep-1.mp3")
+
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)), w.Header().Get("Content-Range"))
+	assert.Equal(t, content[start:end+1], w.Body.Bytes())
+}
+
+func newEncryptedObjectStorage(storage objectstorage.ObjectStorage) objectstorage.ObjectStorage {
+	return &objectstorage.EncryptedObjectStorage{
+		HTTPClient: fixtures.TestDataHTTPClient,
+		Storage:    storage,
+		Encryption: fixtures.ConfigureMediaEncryptionServiceForTest(),
+	}
+}
@@ -0,0 +1,102 @@
+package objectstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrMediaEncryptionNotEnabled = errors.New("media encryption is not enabled")
+
+type EncryptProblem struct {
+	Path    string
+	Problem string
+}
+
+type EncryptResult struct {
+	Encrypted      int
+	EncryptedBytes int64
+	Failed         int
+	Problems       []EncryptProblem
+}
+
+// EncryptExistingFiles encrypts the files which were saved before media
+// encryption was enabled, in place. Each encrypted file is read back and its
+// hash compared with the unencrypted file, which is only deleted if they
+// match. Partial downloads are skipped. Files which fail are recorded as
+// problems, and don't stop the rest.
+func EncryptExistingFiles(ctx context.Context, os ObjectStorage) (EncryptResult, error) {
+	s, ok := os.(*EncryptedObjectStorage)
+	if !ok {
+		return EncryptResult{}, ErrMediaEncryptionNotEnabled
+	}
+
+	objects, err := s.Storage.List(ctx, "")
+	if err != nil {
+		return EncryptResult{}, fmt.Errorf("failed to list files: %w", err)
+	}
+	objects = filterPartFiles(objects)
+
+	result := EncryptResult{Problems: make([]EncryptProblem, 0)}
+	for _, obj := range objects {
+		if strings.HasSuffix(obj.FileName, encryptedFileSuffix) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		if err := s.encryptExistingFile(ctx, obj); err != nil {
+			result.Failed++
+			result.Problems = append(result.Problems, EncryptProblem{Path: obj.Path(), Problem: err.Error()})
+			continue
+		}
+		result.Encrypted++
+		result.EncryptedBytes += obj.Bytes
+	}
+
+	return result, nil
+}
+
+func (s *EncryptedObjectStorage) encryptExistingFile(ctx context.Context, obj ObjectInfo) error {
+	src, err := s.Storage.Open(ctx, obj.PodcastGUID, obj.FileName)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer src.Close()
+
+	hr := newHashingReader(src)
+	_, err = s.Storage.Put(ctx, obj.PodcastGUID, obj.FileName+encryptedFileSuffix, s.Encryption.EncryptReader(hr))
+	if err != nil {
+		return fmt.Errorf("failed to save encrypted file: %w", err)
+	}
+	source := hr.savedFile()
+	_ = src.Close()
+
+	if err := s.checkEncryptedFile(ctx, obj, source); err != nil {
+		_ = s.Storage.Delete(ctx, obj.PodcastGUID, obj.FileName+encryptedFileSuffix)
+		return err
+	}
+
+	return s.Storage.Delete(ctx, obj.PodcastGUID, obj.FileName)
+}
+
+// checkEncryptedFile checks that the encrypted file decrypts to the file it
+// was encrypted from
+func (s *EncryptedObjectStorage) checkEncryptedFile(ctx context.Context, obj ObjectInfo, source SavedFile) error {
+	f, err := s.Open(ctx, obj.PodcastGUID, obj.FileName)
+	if err != nil {
+		return fmt.Errorf("failed to open encrypted file: %w", err)
+	}
+	defer f.Close()
+
+	decrypted, err := HashFile(f)
+	if err != nil {
+		return fmt.Errorf("failed to read encrypted file: %w", err)
+	}
+	if decrypted != source {
+		return errors.New("encrypted file does not match the unencrypted file")
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package objectstorage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+)
+
+func TestEncryptExistingFiles(t *testing.T) {
+	ctx := context.Background()
+	storage := newLocalObjectStorage(t)
+	objstore := newEncryptedObjectStorage(storage)
+
+	// saved before media encryption was enabled
+	putObject(t, storage, "pod-1", "ep-1.mp3", "plaintext 1")
+	putObject(t, storage, ".blobs", "abc", "plaintext 2")
+	putObject(t, storage, "pod-1", "ep-3.mp3.part", "partial")
+	putObject(t, objstore, "pod-1", "ep-2.mp3", "encrypted")
+
+	result, err := objectstorage.EncryptExistingFiles(ctx, objstore)
+
+	assert.Nil(t, err)
+	assert.Equal(t, objectstorage.EncryptResult{
+		Encrypted:      2,
+		EncryptedBytes: 22,
+		Problems:       []objectstorage.EncryptProblem{},
+	}, result)
+	all, err := storage.List(ctx, "")
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{
+		".blobs/abc.enc", "pod-1/ep-1.mp3.enc", "pod-1/ep-2.mp3.enc", "pod-1/ep-3.mp3.part",
+	}, objectPaths(all))
+	assert.Equal(t, []byte("plaintext 1"), readObject(t, objstore, "pod-1", "ep-1.mp3"))
+	assert.Equal(t, []byte("plaintext 2"), readObject(t, objstore, ".blobs", "abc"))
+	assert.Equal(t, []byte("encrypted"), readObject(t, objstore, "pod-1", "ep-2.mp3"))
+
+	// nothing is left to encrypt when run again
+	result, err = objectstorage.EncryptExistingFiles(ctx, objstore)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result.Encrypted)
+}
+
+func TestEncryptExistingFiles_NotEncrypted(t *testing.T) {
+	_, err := objectstorage.EncryptExistingFiles(context.Background(), newLocalObjectStorage(t))
+	assert.ErrorIs(t, err, objectstorage.ErrMediaEncryptionNotEnabled)
+}
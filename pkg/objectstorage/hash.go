@@ -0,0 +1,82 @@
+package objectstorage
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+)
+
+// SavedFile describes a file saved to object storage
+type SavedFile struct {
+	Bytes  int64
+	SHA256 string // hex encoded
+}
+
+// HashFile reads r to the end, returning its size and SHA-256 hash
+func HashFile(r io.Reader) (SavedFile, error) {
+	hr := newHashingReader(r)
+	if _, err := io.Copy(io.Discard, hr); err != nil {
+		return SavedFile{}, err
+	}
+	return hr.savedFile(), nil
+}
+
+// peekHead returns up to the first podcasts.SniffLength bytes of r, and a
+// reader which reads all of r including those bytes
+func peekHead(r io.Reader) ([]byte, io.Reader, error) {
+	br := bufio.NewReaderSize(r, podcasts.SniffLength)
+	head, err := br.Peek(podcasts.SniffLength)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, nil, err
+	}
+	return head, br, nil
+}
+
+// hashingReader computes the size and SHA-256 hash of a file as it is read
+type hashingReader struct {
+	r io.Reader
+	h hash.Hash
+	n int64
+}
+
+func newHashingReader(r io.Reader) *hashingReader {
+	return &hashingReader{r: r, h: sha256.New()}
+}
+
+func (hr *hashingReader) Read(b []byte) (int, error) {
+	n, err := hr.r.Read(b)
+	if n > 0 {
+		hr.h.Write(b[:n])
+		hr.n += int64(n)
+	}
+	return n, err
+}
+
+// prefill adds data which was saved previously, e.g. when resuming a download
+func (hr *hashingReader) prefill(r io.Reader) error {
+	n, err := io.Copy(hr.h, r)
+	hr.n += n
+	return err
+}
+
+func (hr *hashingReader) savedFile() SavedFile {
+	return SavedFile{
+		Bytes:  hr.n,
+		SHA256: hex.EncodeToString(hr.h.Sum(nil)),
+	}
+}
+
+// verifySize checks the size of a saved file against the expected size, which
+// is -1 when unknown, e.g. when the response has no Content-Length
+func verifySize(saved SavedFile, expected int64) error {
+	if expected >= 0 && saved.Bytes != expected {
+		return fmt.Errorf("saved file size '%d' does not match expected size '%d'", saved.Bytes, expected)
+	}
+	return nil
+}
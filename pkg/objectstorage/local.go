@@ -2,6 +2,7 @@ package objectstorage
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -9,61 +10,350 @@ import (
 	"net/http"
 	"os"
 	"path"
+	"strings"
 	"time"
 
 	"github.com/webbgeorge/castkeeper/pkg/podcasts"
 	"github.com/webbgeorge/castkeeper/pkg/util"
 )
 
+// partFileSuffix is added to the name of files while they are downloaded
+const partFileSuffix = ".part"
+
+// partSourceSuffix is added to the name of files to record where their partial
+// download is from. It ends with partFileSuffix so that it is handled like the
+// partial file it describes.
+const partSourceSuffix = ".source" + partFileSuffix
+
+// partSource identifies the remote file which a partial download is from, so
+// that the download is only resumed from the same version of the same file
+type partSource struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+}
+
+func partSourceFromResponse(remoteLocation string, resp *http.Response) partSource {
+	return partSource{
+		URL:          remoteLocation,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+}
+
+// ifRange returns the validator for the If-Range header, which can't be a
+// weak ETag
+func (ps partSource) ifRange() string {
+	if ps.ETag != "" && !strings.HasPrefix(ps.ETag, "W/") {
+		return ps.ETag
+	}
+	return ps.LastModified
+}
+
 type LocalObjectStorage struct {
 	HTTPClient *http.Client
 	Root       *os.Root
 }
 
-func (s *LocalObjectStorage) SaveRemoteFile(ctx context.Context, creds *podcasts.PodcastCredentials, remoteLocation, podcastGUID, fileName string) (int64, error) {
+// SaveRemoteFile downloads to a partial file, which is renamed to fileName once
+// the download has completed. When a download is interrupted and the remote
+// server supports range requests, the partial file is kept so that the next
+// attempt resumes where it left off, otherwise the partial file is removed.
+// Downloads are only resumed from the same URL, and with If-Range, so that a
+// partial file isn't joined with a different or changed remote file.
+func (s *LocalObjectStorage) SaveRemoteFile(ctx context.Context, creds *podcasts.PodcastCredentials, remoteLocation, podcastGUID, fileName string, opts SaveOptions) (SavedFile, error) {
 	err := util.ValidateExtURL(remoteLocation)
 	if err != nil {
-		return -1, fmt.Errorf("invalid remoteLocation '%s': %w", remoteLocation, err)
+		return SavedFile{}, fmt.Errorf("invalid remoteLocation '%s': %w", remoteLocation, err)
 	}
 
 	err = mkdirIfNotExists(s.Root, podcastGUID)
 	if err != nil {
-		return -1, err
+		return SavedFile{}, err
 	}
 
 	localPath := path.Join(podcastGUID, fileName)
-	f, err := s.Root.Create(localPath)
+	partPath := localPath + partFileSuffix
+
+	var offset int64
+	source, ok := s.readPartSource(partPath)
+	if ok && source.URL == remoteLocation && source.ifRange() != "" {
+		if fi, err := s.Root.Stat(partPath); err == nil {
+			offset = fi.Size()
+		}
+	} else {
+		// from a different URL, e.g. when the premium version of an episode
+		// replaced the free version, or can't be validated
+		s.removePart(partPath)
+	}
+
+	resp, err := s.requestRemoteFile(ctx, creds, remoteLocation, offset, source.ifRange())
+	if err != nil {
+		return SavedFile{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		// partial file is not usable, e.g. it is larger than the remote file
+		_ = resp.Body.Close()
+		offset = 0
+		resp, err = s.requestRemoteFile(ctx, creds, remoteLocation, offset, "")
+		if err != nil {
+			return SavedFile{}, err
+		}
+		defer resp.Body.Close()
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		s.removePart(partPath)
+		return SavedFile{}, fmt.Errorf("failed to download file with status '%d'", resp.StatusCode)
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if offset > 0 && resp.StatusCode == http.StatusPartialContent {
+		if !strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)) {
+			s.removePart(partPath)
+			return SavedFile{}, fmt.Errorf("unexpected Content-Range '%s' when resuming download", resp.Header.Get("Content-Range"))
+		}
+		// in case the remote server ignored If-Range
+		if etag := resp.Header.Get("ETag"); etag != "" && source.ETag != "" && etag != source.ETag {
+			s.removePart(partPath)
+			return SavedFile{}, errors.New("remote file changed since the download was interrupted")
+		}
+		flags = os.O_WRONLY | os.O_APPEND
+	} else {
+		// remote server doesn't support range requests, or the remote file
+		// changed, start from the beginning
+		offset = 0
+		if err := s.writePartSource(partPath, partSourceFromResponse(remoteLocation, resp)); err != nil {
+			return SavedFile{}, err
+		}
+	}
+
+	body := io.Reader(resp.Body)
+	if opts.Inspect != nil {
+		var head []byte
+		if offset > 0 {
+			head, err = s.readHead(partPath)
+		} else {
+			head, body, err = peekHead(resp.Body)
+		}
+		if err != nil {
+			return SavedFile{}, err
+		}
+		name, err := opts.Inspect(head)
+		if err != nil {
+			s.removePart(partPath)
+			return SavedFile{}, err
+		}
+		localPath = path.Join(podcastGUID, name)
+	}
+
+	hr := newHashingReader(body)
+	if offset > 0 {
+		if err := s.prefillHash(hr, partPath); err != nil {
+			return SavedFile{}, err
+		}
+	}
+
+	f, err := s.Root.OpenFile(partPath, flags, 0640)
 	if err != nil {
-		return -1, err
+		return SavedFile{}, err
 	}
 	defer f.Close()
 
+	total := int64(-1)
+	if resp.ContentLength >= 0 {
+		total = offset + resp.ContentLength
+	}
+
+	n, err := io.Copy(f, withProgress(hr, offset, total, opts.Progress))
+	if err != nil {
+		if n == 0 || !supportsRanges(resp) {
+			_ = f.Close()
+			s.removePart(partPath)
+		}
+		return SavedFile{}, err
+	}
+
+	if err := f.Close(); err != nil {
+		s.removePart(partPath)
+		return SavedFile{}, err
+	}
+
+	saved := hr.savedFile()
+	if err := verifySize(saved, total); err != nil {
+		s.removePart(partPath)
+		return SavedFile{}, err
+	}
+
+	if err := s.Root.Rename(partPath, localPath); err != nil {
+		s.removePart(partPath)
+		return SavedFile{}, err
+	}
+	_ = s.Root.Remove(partSourcePath(partPath))
+
+	return saved, nil
+}
+
+func partSourcePath(partPath string) string {
+	return strings.TrimSuffix(partPath, partFileSuffix) + partSourceSuffix
+}
+
+// readPartSource reads where a partial download is from, if it was recorded
+func (s *LocalObjectStorage) readPartSource(partPath string) (partSource, bool) {
+	data, err := s.Root.ReadFile(partSourcePath(partPath))
+	if err != nil {
+		return partSource{}, false
+	}
+	var source partSource
+	if err := json.Unmarshal(data, &source); err != nil {
+		return partSource{}, false
+	}
+	return source, true
+}
+
+func (s *LocalObjectStorage) writePartSource(partPath string, source partSource) error {
+	data, err := json.Marshal(source)
+	if err != nil {
+		return err
+	}
+	return s.Root.WriteFile(partSourcePath(partPath), data, 0640)
+}
+
+// removePart removes a partial download, and its source
+func (s *LocalObjectStorage) removePart(partPath string) {
+	_ = s.Root.Remove(partPath)
+	_ = s.Root.Remove(partSourcePath(partPath))
+}
+
+func (s *LocalObjectStorage) readHead(partPath string) ([]byte, error) {
+	pf, err := s.Root.Open(partPath)
+	if err != nil {
+		return nil, err
+	}
+	defer pf.Close()
+	head := make([]byte, podcasts.SniffLength)
+	n, err := io.ReadFull(pf, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+func (s *LocalObjectStorage) prefillHash(hr *hashingReader, partPath string) error {
+	pf, err := s.Root.Open(partPath)
+	if err != nil {
+		return err
+	}
+	defer pf.Close()
+	return hr.prefill(pf)
+}
+
+func (s *LocalObjectStorage) requestRemoteFile(ctx context.Context, creds *podcasts.PodcastCredentials, remoteLocation string, offset int64, ifRange string) (*http.Response, error) {
 	req, err := http.NewRequest(http.MethodGet, remoteLocation, nil)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
 	req = req.WithContext(ctx)
 
 	if creds != nil {
 		req.SetBasicAuth(creds.Username, creds.Password)
 	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		// the whole file is returned instead if it changed
+		req.Header.Set("If-Range", ifRange)
+	}
+
+	return s.HTTPClient.Do(req)
+}
+
+func (s *LocalObjectStorage) Open(ctx context.Context, podcastGUID, fileName string) (io.ReadSeekCloser, error) {
+	return s.Root.Open(path.Join(podcastGUID, fileName))
+}
 
-	resp, err := s.HTTPClient.Do(req)
+func (s *LocalObjectStorage) Delete(ctx context.Context, podcastGUID, fileName string) error {
+	err := s.Root.Remove(path.Join(podcastGUID, fileName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *LocalObjectStorage) Stat(ctx context.Context, podcastGUID, fileName string) (ObjectInfo, error) {
+	filePath := path.Join(podcastGUID, fileName)
+	fi, err := s.Root.Stat(filePath)
 	if err != nil {
-		return -1, err
+		return ObjectInfo{}, err
 	}
-	defer resp.Body.Close()
+	if !fi.Mode().IsRegular() {
+		return ObjectInfo{}, fmt.Errorf("'%s' is not a file: %w", filePath, fs.ErrNotExist)
+	}
+	return objectInfoFromPath(filePath, fi.Size(), fi.ModTime()), nil
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return -1, fmt.Errorf("failed to download file with status '%d'", resp.StatusCode)
+// List walks the directories which can contain files matching prefix
+func (s *LocalObjectStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := make([]ObjectInfo, 0)
+	err := fs.WalkDir(s.Root.FS(), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != "." && !strings.HasPrefix(p+"/", prefix) && !strings.HasPrefix(prefix, p+"/") {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || !strings.HasPrefix(p, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, objectInfoFromPath(p, fi.Size(), fi.ModTime()))
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
+	return objects, nil
+}
 
-	n, err := io.Copy(f, resp.Body)
+func (s *LocalObjectStorage) Put(ctx context.Context, podcastGUID, fileName string, r io.Reader) (SavedFile, error) {
+	err := mkdirIfNotExists(s.Root, podcastGUID)
 	if err != nil {
-		return -1, err
+		return SavedFile{}, err
 	}
 
-	return n, nil
+	localPath := path.Join(podcastGUID, fileName)
+	partPath := localPath + partFileSuffix
+
+	f, err := s.Root.OpenFile(partPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return SavedFile{}, err
+	}
+	defer f.Close()
+
+	hr := newHashingReader(r)
+	if _, err := io.Copy(f, hr); err != nil {
+		_ = f.Close()
+		_ = s.Root.Remove(partPath)
+		return SavedFile{}, err
+	}
+	if err := f.Close(); err != nil {
+		_ = s.Root.Remove(partPath)
+		return SavedFile{}, err
+	}
+
+	if err := s.Root.Rename(partPath, localPath); err != nil {
+		_ = s.Root.Remove(partPath)
+		return SavedFile{}, err
+	}
+
+	return hr.savedFile(), nil
 }
 
 func (s *LocalObjectStorage) ServeFile(ctx context.Context, r *http.Request, w http.ResponseWriter, podcastGUID, fileName string) error {
@@ -88,3 +378,8 @@ func mkdirIfNotExists(root *os.Root, dir string) error {
 	}
 	return nil
 }
+
+func supportsRanges(resp *http.Response) bool {
+	return resp.StatusCode == http.StatusPartialContent ||
+		resp.Header.Get("Accept-Ranges") == "bytes"
+}
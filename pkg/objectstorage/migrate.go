@@ -0,0 +1,189 @@
+package objectstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+const (
+	MigrateActionCopied         = "copied"
+	MigrateActionAlreadyPresent = "already present"
+	MigrateActionVerified       = "verified"
+	MigrateActionFailed         = "failed"
+)
+
+type MigrateOptions struct {
+	// VerifyOnly compares every file with the destination, by size and hash,
+	// without copying anything
+	VerifyOnly bool
+	// Progress is called after each file is migrated or verified
+	Progress func(MigrateProgress)
+}
+
+// MigrateProgress describes a file which has been migrated or verified, and
+// how far through the migration it was
+type MigrateProgress struct {
+	Done   int
+	Total  int
+	Object ObjectInfo
+	Action string
+	Err    error
+}
+
+type MigrateProblem struct {
+	Path    string
+	Problem string
+}
+
+type MigrateResult struct {
+	Copied         int
+	CopiedBytes    int64
+	AlreadyPresent int
+	Verified       int
+	Failed         int
+	Problems       []MigrateProblem
+}
+
+// Migrate copies every file from one object storage to another, e.g. when
+// switching object storage drivers. Each copied file is read back from the
+// destination and its hash compared with the source. Files which are already
+// in the destination with the same size are skipped, so an interrupted
+// migration can be resumed by running it again. Partial downloads are not
+// migrated. Files which fail are recorded as problems, and don't stop the
+// rest of the migration.
+func Migrate(ctx context.Context, from, to ObjectStorage, opts MigrateOptions) (MigrateResult, error) {
+	objects, err := from.List(ctx, "")
+	if err != nil {
+		return MigrateResult{}, fmt.Errorf("failed to list files: %w", err)
+	}
+	objects = filterPartFiles(objects)
+
+	result := MigrateResult{Problems: make([]MigrateProblem, 0)}
+	for i, obj := range objects {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		var action string
+		var err error
+		if opts.VerifyOnly {
+			action, err = verifyObject(ctx, from, to, obj)
+		} else {
+			action, err = migrateObject(ctx, from, to, obj)
+		}
+
+		switch action {
+		case MigrateActionCopied:
+			result.Copied++
+			result.CopiedBytes += obj.Bytes
+		case MigrateActionAlreadyPresent:
+			result.AlreadyPresent++
+		case MigrateActionVerified:
+			result.Verified++
+		}
+		if err != nil {
+			action = MigrateActionFailed
+			result.Failed++
+			result.Problems = append(result.Problems, MigrateProblem{Path: obj.Path(), Problem: err.Error()})
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(MigrateProgress{
+				Done:   i + 1,
+				Total:  len(objects),
+				Object: obj,
+				Action: action,
+				Err:    err,
+			})
+		}
+	}
+
+	return result, nil
+}
+
+func filterPartFiles(objects []ObjectInfo) []ObjectInfo {
+	filtered := make([]ObjectInfo, 0, len(objects))
+	for _, obj := range objects {
+		if !strings.HasSuffix(obj.FileName, partFileSuffix) {
+			filtered = append(filtered, obj)
+		}
+	}
+	return filtered
+}
+
+func migrateObject(ctx context.Context, from, to ObjectStorage, obj ObjectInfo) (string, error) {
+	existing, err := to.Stat(ctx, obj.PodcastGUID, obj.FileName)
+	if err == nil && existing.Bytes == obj.Bytes {
+		// files are replaced atomically, so a file of the right size was
+		// copied completely, e.g. before the migration was interrupted
+		return MigrateActionAlreadyPresent, nil
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("failed to check destination: %w", err)
+	}
+
+	src, err := from.Open(ctx, obj.PodcastGUID, obj.FileName)
+	if err != nil {
+		return "", fmt.Errorf("failed to open source: %w", err)
+	}
+	defer src.Close()
+
+	hr := newHashingReader(src)
+	saved, err := to.Put(ctx, obj.PodcastGUID, obj.FileName, hr)
+	if err != nil {
+		return "", fmt.Errorf("failed to save to destination: %w", err)
+	}
+	source := hr.savedFile()
+	if saved != source {
+		return "", errors.New("saved file does not match source")
+	}
+
+	copied, err := hashObject(ctx, to, obj)
+	if err != nil {
+		return "", fmt.Errorf("failed to read back from destination: %w", err)
+	}
+	if copied != source {
+		return "", errors.New("file read back from destination does not match source")
+	}
+
+	return MigrateActionCopied, nil
+}
+
+func verifyObject(ctx context.Context, from, to ObjectStorage, obj ObjectInfo) (string, error) {
+	existing, err := to.Stat(ctx, obj.PodcastGUID, obj.FileName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", errors.New("missing from destination")
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to check destination: %w", err)
+	}
+	if existing.Bytes != obj.Bytes {
+		return "", fmt.Errorf("size '%d' in destination does not match source size '%d'", existing.Bytes, obj.Bytes)
+	}
+
+	source, err := hashObject(ctx, from, obj)
+	if err != nil {
+		return "", fmt.Errorf("failed to read source: %w", err)
+	}
+	dest, err := hashObject(ctx, to, obj)
+	if err != nil {
+		return "", fmt.Errorf("failed to read destination: %w", err)
+	}
+	if source != dest {
+		return "", errors.New("hash in destination does not match source")
+	}
+
+	return MigrateActionVerified, nil
+}
+
+func hashObject(ctx context.Context, os ObjectStorage, obj ObjectInfo) (SavedFile, error) {
+	f, err := os.Open(ctx, obj.PodcastGUID, obj.FileName)
+	if err != nil {
+		return SavedFile{}, err
+	}
+	defer f.Close()
+	return HashFile(f)
+}
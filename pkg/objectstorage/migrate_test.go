@@ -0,0 +1,106 @@
+package objectstorage_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+)
+
+func TestMigrate(t *testing.T) {
+	from := newLocalObjectStorage(t)
+	to := newLocalObjectStorage(t)
+	putObject(t, from, "pod-1", "pod-1.jpg", "artwork")
+	putObject(t, from, "pod-1", "ep-1.mp3", "episode 1")
+	putObject(t, from, "pod-2", "ep-2.m4a", "episode 2")
+	// partial downloads aren't migrated
+	putObject(t, from, "pod-2", "ep-3.mp3.part", "episode")
+
+	progress := make([]objectstorage.MigrateProgress, 0)
+	result, err := objectstorage.Migrate(context.Background(), from, to, objectstorage.MigrateOptions{
+		Progress: func(p objectstorage.MigrateProgress) {
+			progress = append(progress, p)
+		},
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, result.Copied)
+	assert.Equal(t, int64(25), result.CopiedBytes)
+	assert.Equal(t, 0, result.Failed)
+	assert.Equal(t, []byte("artwork"), readObject(t, to, "pod-1", "pod-1.jpg"))
+	assert.Equal(t, []byte("episode 1"), readObject(t, to, "pod-1", "ep-1.mp3"))
+	assert.Equal(t, []byte("episode 2"), readObject(t, to, "pod-2", "ep-2.m4a"))
+
+	all, err := to.List(context.Background(), "")
+	assert.Nil(t, err)
+	assert.Len(t, all, 3)
+
+	assert.Len(t, progress, 3)
+	for i, p := range progress {
+		assert.Equal(t, i+1, p.Done)
+		assert.Equal(t, 3, p.Total)
+		assert.Equal(t, objectstorage.MigrateActionCopied, p.Action)
+		assert.Nil(t, p.Err)
+	}
+
+	result, err = objectstorage.Migrate(context.Background(), from, to, objectstorage.MigrateOptions{VerifyOnly: true})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, result.Verified)
+	assert.Equal(t, 0, result.Failed)
+}
+
+func TestMigrate_Resume(t *testing.T) {
+	from := newLocalObjectStorage(t)
+	to := newLocalObjectStorage(t)
+	putObject(t, from, "pod-1", "ep-1.mp3", "episode 1")
+	putObject(t, from, "pod-1", "ep-2.mp3", "episode 2")
+	putObject(t, to, "pod-1", "ep-1.mp3", "episode 1")
+	putObject(t, to, "pod-1", "ep-2.mp3", "episode")
+
+	result, err := objectstorage.Migrate(context.Background(), from, to, objectstorage.MigrateOptions{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.AlreadyPresent)
+	assert.Equal(t, 1, result.Copied)
+	assert.Equal(t, []byte("episode 2"), readObject(t, to, "pod-1", "ep-2.mp3"))
+}
+
+func TestMigrate_VerifyProblems(t *testing.T) {
+	from := newLocalObjectStorage(t)
+	to := newLocalObjectStorage(t)
+	putObject(t, from, "pod-1", "ep-1.mp3", "episode 1")
+	putObject(t, from, "pod-1", "ep-2.mp3", "episode 2")
+	putObject(t, from, "pod-1", "ep-3.mp3", "episode 3")
+	putObject(t, from, "pod-1", "ep-4.mp3", "episode 4")
+	putObject(t, to, "pod-1", "ep-1.mp3", "episode 1")
+	putObject(t, to, "pod-1", "ep-2.mp3", "episode")
+	putObject(t, to, "pod-1", "ep-3.mp3", "episode X")
+
+	result, err := objectstorage.Migrate(context.Background(), from, to, objectstorage.MigrateOptions{VerifyOnly: true})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.Verified)
+	assert.Equal(t, 3, result.Failed)
+	assert.ElementsMatch(t, []objectstorage.MigrateProblem{
+		{Path: "pod-1/ep-2.mp3", Problem: "size '7' in destination does not match source size '9'"},
+		{Path: "pod-1/ep-3.mp3", Problem: "hash in destination does not match source"},
+		{Path: "pod-1/ep-4.mp3", Problem: "missing from destination"},
+	}, result.Problems)
+
+	// nothing is copied
+	_, err = to.Stat(context.Background(), "pod-1", "ep-4.mp3")
+	assert.NotNil(t, err)
+}
+
+func newLocalObjectStorage(t *testing.T) objectstorage.ObjectStorage {
+	root, err := os.OpenRoot(t.TempDir())
+	if err != nil {
+		panic(err)
+	}
+	t.Cleanup(func() { _ = root.Close() })
+	return &objectstorage.LocalObjectStorage{HTTPClient: fixtures.TestDataHTTPClient, Root: root}
+}
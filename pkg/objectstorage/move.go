@@ -0,0 +1,65 @@
+package objectstorage
+
+import (
+	"context"
+	"fmt"
+	"path"
+)
+
+// renamer is implemented by object storage which can move a file without
+// copying it. It returns false if the file can't be renamed, so that it is
+// copied instead.
+type renamer interface {
+	rename(ctx context.Context, fromDir, fromName, toDir, toName string) (bool, error)
+}
+
+// Move moves a stored file, replacing any file at the destination. Files are
+// renamed when the object storage supports it, otherwise they are copied and
+// the source is deleted once the copy is saved.
+func Move(ctx context.Context, os ObjectStorage, fromDir, fromName, toDir, toName string) error {
+	if r, ok := os.(renamer); ok {
+		renamed, err := r.rename(ctx, fromDir, fromName, toDir, toName)
+		if err != nil {
+			return err
+		}
+		if renamed {
+			return nil
+		}
+	}
+
+	src, err := os.Open(ctx, fromDir, fromName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	if _, err := os.Put(ctx, toDir, toName, src); err != nil {
+		return fmt.Errorf("failed to copy '%s': %w", path.Join(fromDir, fromName), err)
+	}
+	_ = src.Close()
+
+	return os.Delete(ctx, fromDir, fromName)
+}
+
+func (s *LocalObjectStorage) rename(ctx context.Context, fromDir, fromName, toDir, toName string) (bool, error) {
+	if err := mkdirIfNotExists(s.Root, toDir); err != nil {
+		return false, err
+	}
+	if err := s.Root.Rename(path.Join(fromDir, fromName), path.Join(toDir, toName)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// rename renames encrypted files in the underlying object storage. Files saved
+// before media encryption was enabled are copied, so that they are encrypted.
+func (s *EncryptedObjectStorage) rename(ctx context.Context, fromDir, fromName, toDir, toName string) (bool, error) {
+	r, ok := s.Storage.(renamer)
+	if !ok {
+		return false, nil
+	}
+	if _, err := s.Storage.Stat(ctx, fromDir, fromName+encryptedFileSuffix); err != nil {
+		return false, nil
+	}
+	return r.rename(ctx, fromDir, fromName+encryptedFileSuffix, toDir, toName+encryptedFileSuffix)
+}
@@ -0,0 +1,33 @@
+package objectstorage
+
+import "io"
+
+// ProgressFunc is called as a remote file is saved, with the number of bytes
+// saved so far and the total size of the file, which is -1 when the size is
+// unknown
+type ProgressFunc func(bytesDone, bytesTotal int64)
+
+type progressReader struct {
+	r        io.Reader
+	done     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.done += int64(n)
+		p.progress(p.done, p.total)
+	}
+	return n, err
+}
+
+// withProgress reports progress of reading r, where done is the number of bytes
+// already saved, e.g. when resuming a download
+func withProgress(r io.Reader, done, total int64, progress ProgressFunc) io.Reader {
+	if progress == nil {
+		return r
+	}
+	return &progressReader{r: r, done: done, total: total, progress: progress}
+}
@@ -0,0 +1,70 @@
+package objectstorage
+
+import (
+	"context"
+	"errors"
+	"io"
+)
+
+// rangedReader reads a remote file from its current offset, starting a new
+// ranged request after each seek, so that it can be read from any offset
+// without downloading the whole file
+type rangedReader struct {
+	ctx    context.Context
+	size   int64
+	offset int64
+	body   io.ReadCloser
+	// openAt requests the file from offset to the end
+	openAt func(ctx context.Context, offset int64) (io.ReadCloser, error)
+}
+
+func (r *rangedReader) Read(b []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		body, err := r.openAt(r.ctx, r.offset)
+		if err != nil {
+			return 0, err
+		}
+		r.body = body
+	}
+	n, err := r.body.Read(b)
+	r.offset += int64(n)
+	if errors.Is(err, io.EOF) && r.offset < r.size {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *rangedReader) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = r.offset + offset
+	case io.SeekEnd:
+		abs = r.size + offset
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if abs < 0 {
+		return 0, errors.New("negative position")
+	}
+	if abs != r.offset && r.body != nil {
+		_ = r.body.Close()
+		r.body = nil
+	}
+	r.offset = abs
+	return abs, nil
+}
+
+func (r *rangedReader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
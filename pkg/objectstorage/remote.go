@@ -0,0 +1,79 @@
+package objectstorage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"github.com/webbgeorge/castkeeper/pkg/util"
+)
+
+// streamRemoteFile downloads a remote file and streams it to upload, for
+// drivers which don't resume interrupted downloads. upload must only create
+// the file once it has read the whole stream. When the downloaded file is not
+// the expected size, the uploaded file is removed again.
+func streamRemoteFile(
+	ctx context.Context,
+	httpClient *http.Client,
+	creds *podcasts.PodcastCredentials,
+	remoteLocation, fileName string,
+	opts SaveOptions,
+	upload func(fileName string, r io.Reader) error,
+	remove func(fileName string) error,
+) (SavedFile, error) {
+	err := util.ValidateExtURL(remoteLocation)
+	if err != nil {
+		return SavedFile{}, fmt.Errorf("invalid remoteLocation '%s': %w", remoteLocation, err)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, remoteLocation, nil)
+	if err != nil {
+		return SavedFile{}, err
+	}
+	req = req.WithContext(ctx)
+
+	if creds != nil {
+		req.SetBasicAuth(creds.Username, creds.Password)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return SavedFile{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return SavedFile{}, fmt.Errorf("failed to download file with status '%d'", resp.StatusCode)
+	}
+
+	body := io.Reader(resp.Body)
+	if opts.Inspect != nil {
+		var head []byte
+		head, body, err = peekHead(resp.Body)
+		if err != nil {
+			return SavedFile{}, err
+		}
+		fileName, err = opts.Inspect(head)
+		if err != nil {
+			return SavedFile{}, err
+		}
+	}
+
+	hr := newHashingReader(body)
+	err = upload(fileName, withProgress(hr, 0, resp.ContentLength, opts.Progress))
+	if err != nil {
+		return SavedFile{}, err
+	}
+
+	saved := hr.savedFile()
+	if err := verifySize(saved, resp.ContentLength); err != nil {
+		if rmErr := remove(fileName); rmErr != nil {
+			return SavedFile{}, fmt.Errorf("%w, and failed to delete file: %w", err, rmErr)
+		}
+		return SavedFile{}, err
+	}
+
+	return saved, nil
+}
@@ -2,15 +2,21 @@ package objectstorage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"net/http"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 	"github.com/webbgeorge/castkeeper/pkg/podcasts"
-	"github.com/webbgeorge/castkeeper/pkg/util"
 )
 
 type S3ObjectStorage struct {
@@ -20,59 +26,139 @@ type S3ObjectStorage struct {
 	Prefix     string
 }
 
-func (s *S3ObjectStorage) SaveRemoteFile(ctx context.Context, creds *podcasts.PodcastCredentials, remoteLocation, podcastGUID, fileName string) (int64, error) {
-	err := util.ValidateExtURL(remoteLocation)
-	if err != nil {
-		return -1, fmt.Errorf("invalid remoteLocation '%s': %w", remoteLocation, err)
-	}
-
-	s3Key := fmt.Sprintf("%s/%s", podcastGUID, fileName)
+// SaveRemoteFile streams the remote file to S3. The object is only created once
+// the upload completes, and incomplete multipart uploads are aborted, so failed
+// downloads don't leave partial objects behind. Interrupted downloads are not
+// resumed.
+func (s *S3ObjectStorage) SaveRemoteFile(ctx context.Context, creds *podcasts.PodcastCredentials, remoteLocation, podcastGUID, fileName string, opts SaveOptions) (SavedFile, error) {
+	return streamRemoteFile(ctx, s.HTTPClient, creds, remoteLocation, fileName, opts,
+		func(fileName string, r io.Reader) error {
+			uploader := manager.NewUploader(s.S3Client)
+			_, err := uploader.Upload(ctx, &s3.PutObjectInput{
+				Bucket: aws.String(s.BucketName),
+				Key:    aws.String(s.key(podcastGUID, fileName)),
+				Body:   r,
+			})
+			return err
+		},
+		func(fileName string) error {
+			return s.Delete(ctx, podcastGUID, fileName)
+		},
+	)
+}
 
-	req, err := http.NewRequest(http.MethodGet, remoteLocation, nil)
+// Open reads an object with ranged requests, so that it can be read from any
+// offset without downloading the whole object
+func (s *S3ObjectStorage) Open(ctx context.Context, podcastGUID, fileName string) (io.ReadSeekCloser, error) {
+	info, err := s.Stat(ctx, podcastGUID, fileName)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
-	req = req.WithContext(ctx)
+	s3Key := s.key(podcastGUID, fileName)
+	return &rangedReader{
+		ctx:  ctx,
+		size: info.Bytes,
+		openAt: func(ctx context.Context, offset int64) (io.ReadCloser, error) {
+			res, err := s.S3Client.GetObject(ctx, &s3.GetObjectInput{
+				Bucket: aws.String(s.BucketName),
+				Key:    aws.String(s3Key),
+				Range:  aws.String(fmt.Sprintf("bytes=%d-", offset)),
+			})
+			if err != nil {
+				return nil, err
+			}
+			return res.Body, nil
+		},
+	}, nil
+}
 
-	if creds != nil {
-		req.SetBasicAuth(creds.Username, creds.Password)
-	}
+func (s *S3ObjectStorage) Delete(ctx context.Context, podcastGUID, fileName string) error {
+	_, err := s.S3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(s.key(podcastGUID, fileName)),
+	})
+	return err
+}
 
-	resp, err := s.HTTPClient.Do(req)
+func (s *S3ObjectStorage) Stat(ctx context.Context, podcastGUID, fileName string) (ObjectInfo, error) {
+	res, err := s.S3Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.BucketName),
+		Key:    aws.String(s.key(podcastGUID, fileName)),
+	})
 	if err != nil {
-		return -1, err
+		if isS3NotFound(err) {
+			return ObjectInfo{}, fmt.Errorf("object '%s/%s' not found: %w", podcastGUID, fileName, fs.ErrNotExist)
+		}
+		return ObjectInfo{}, err
 	}
-	defer resp.Body.Close()
+	return objectInfoFromPath(
+		path.Join(podcastGUID, fileName),
+		aws.ToInt64(res.ContentLength),
+		aws.ToTime(res.LastModified),
+	), nil
+}
 
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return -1, fmt.Errorf("failed to download file with status '%d'", resp.StatusCode)
+func (s *S3ObjectStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := make([]ObjectInfo, 0)
+	paginator := s3.NewListObjectsV2Paginator(s.S3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.BucketName),
+		Prefix: aws.String(s.Prefix + prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, objectInfoFromPath(
+				strings.TrimPrefix(aws.ToString(obj.Key), s.Prefix),
+				aws.ToInt64(obj.Size),
+				aws.ToTime(obj.LastModified),
+			))
+		}
 	}
+	return objects, nil
+}
 
+// Put uploads the contents of r, the object is only replaced once the upload
+// completes
+func (s *S3ObjectStorage) Put(ctx context.Context, podcastGUID, fileName string, r io.Reader) (SavedFile, error) {
+	s3Key := s.key(podcastGUID, fileName)
+
+	hr := newHashingReader(r)
 	uploader := manager.NewUploader(s.S3Client)
-	_, err = uploader.Upload(ctx, &s3.PutObjectInput{
+	_, err := uploader.Upload(ctx, &s3.PutObjectInput{
 		Bucket: aws.String(s.BucketName),
-		Key:    aws.String(s.Prefix + s3Key),
-		Body:   resp.Body,
+		Key:    aws.String(s3Key),
+		Body:   hr,
 	})
 	if err != nil {
-		return -1, err
+		return SavedFile{}, err
 	}
 
-	return resp.ContentLength, nil
+	return hr.savedFile(), nil
 }
 
 func (s *S3ObjectStorage) ServeFile(ctx context.Context, r *http.Request, w http.ResponseWriter, podcastGUID, fileName string) error {
-	s3Key := fmt.Sprintf("%s/%s", podcastGUID, fileName)
-
-	res, err := s.S3Client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(s.BucketName),
-		Key:    aws.String(s.Prefix + s3Key),
-	})
+	f, err := s.Open(ctx, podcastGUID, fileName)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 
-	// TODO support range requests/chunked downloads
-	_, err = io.Copy(w, res.Body)
-	return err
+	http.ServeContent(w, r, "", time.Time{}, f)
+	return nil
+}
+
+func (s *S3ObjectStorage) key(podcastGUID, fileName string) string {
+	return fmt.Sprintf("%s%s/%s", s.Prefix, podcastGUID, fileName)
+}
+
+func isS3NotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &noSuchKey) ||
+		errors.As(err, &notFound) ||
+		(errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound)
 }
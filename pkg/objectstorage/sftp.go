@@ -0,0 +1,239 @@
+package objectstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"golang.org/x/crypto/ssh"
+)
+
+type SFTPObjectStorage struct {
+	// HTTPClient downloads remote files
+	HTTPClient *http.Client
+	// Address of the SFTP server, as host:port
+	Address   string
+	SSHConfig *ssh.ClientConfig
+	// Root is the directory which files are stored in, relative to the user's
+	// home directory unless absolute
+	Root string
+
+	mu     sync.Mutex
+	client *sftp.Client
+}
+
+// SaveRemoteFile streams the remote file to the SFTP server, uploading to a
+// partial file which is renamed to fileName once the upload completes.
+// Interrupted downloads are not resumed.
+func (s *SFTPObjectStorage) SaveRemoteFile(ctx context.Context, creds *podcasts.PodcastCredentials, remoteLocation, podcastGUID, fileName string, opts SaveOptions) (SavedFile, error) {
+	return streamRemoteFile(ctx, s.HTTPClient, creds, remoteLocation, fileName, opts,
+		func(fileName string, r io.Reader) error {
+			_, err := s.Put(ctx, podcastGUID, fileName, r)
+			return err
+		},
+		func(fileName string) error {
+			return s.Delete(ctx, podcastGUID, fileName)
+		},
+	)
+}
+
+func (s *SFTPObjectStorage) Open(ctx context.Context, podcastGUID, fileName string) (io.ReadSeekCloser, error) {
+	client, err := s.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.Open(s.path(podcastGUID, fileName))
+}
+
+// Put uploads the contents of r to a partial file, which replaces the file once
+// the upload completes
+func (s *SFTPObjectStorage) Put(ctx context.Context, podcastGUID, fileName string, r io.Reader) (SavedFile, error) {
+	client, err := s.sftpClient()
+	if err != nil {
+		return SavedFile{}, err
+	}
+
+	if err := client.MkdirAll(s.path(podcastGUID)); err != nil {
+		return SavedFile{}, err
+	}
+
+	remotePath := s.path(podcastGUID, fileName)
+	partPath := remotePath + partFileSuffix
+
+	f, err := client.Create(partPath)
+	if err != nil {
+		return SavedFile{}, err
+	}
+	defer f.Close()
+
+	hr := newHashingReader(r)
+	if _, err := f.ReadFrom(hr); err != nil {
+		_ = f.Close()
+		_ = client.Remove(partPath)
+		return SavedFile{}, err
+	}
+	if err := f.Close(); err != nil {
+		_ = client.Remove(partPath)
+		return SavedFile{}, err
+	}
+
+	if err := rename(client, partPath, remotePath); err != nil {
+		_ = client.Remove(partPath)
+		return SavedFile{}, err
+	}
+
+	return hr.savedFile(), nil
+}
+
+// rename replaces newPath with oldPath. Standard SFTP renames fail when newPath
+// exists, so the OpenSSH extension is used when the server supports it.
+func rename(client *sftp.Client, oldPath, newPath string) error {
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); ok {
+		return client.PosixRename(oldPath, newPath)
+	}
+	if err := client.Remove(newPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return client.Rename(oldPath, newPath)
+}
+
+func (s *SFTPObjectStorage) Delete(ctx context.Context, podcastGUID, fileName string) error {
+	client, err := s.sftpClient()
+	if err != nil {
+		return err
+	}
+	err = client.Remove(s.path(podcastGUID, fileName))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (s *SFTPObjectStorage) Stat(ctx context.Context, podcastGUID, fileName string) (ObjectInfo, error) {
+	client, err := s.sftpClient()
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	filePath := path.Join(podcastGUID, fileName)
+	fi, err := client.Stat(s.path(podcastGUID, fileName))
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if !fi.Mode().IsRegular() {
+		return ObjectInfo{}, fmt.Errorf("'%s' is not a file: %w", filePath, fs.ErrNotExist)
+	}
+	return objectInfoFromPath(filePath, fi.Size(), fi.ModTime()), nil
+}
+
+// List walks the directories which can contain files matching prefix
+func (s *SFTPObjectStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	client, err := s.sftpClient()
+	if err != nil {
+		return nil, err
+	}
+
+	root := s.path()
+	objects := make([]ObjectInfo, 0)
+	walker := client.Walk(root)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if walker.Path() == root && errors.Is(err, fs.ErrNotExist) {
+				// nothing has been stored yet
+				return objects, nil
+			}
+			return nil, err
+		}
+
+		p := ""
+		if walker.Path() != root {
+			p = strings.TrimPrefix(walker.Path(), root+"/")
+		}
+		fi := walker.Stat()
+		if fi.IsDir() {
+			if p != "" && !strings.HasPrefix(p+"/", prefix) && !strings.HasPrefix(prefix, p+"/") {
+				walker.SkipDir()
+			}
+			continue
+		}
+		if !fi.Mode().IsRegular() || !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		objects = append(objects, objectInfoFromPath(p, fi.Size(), fi.ModTime()))
+	}
+
+	return objects, nil
+}
+
+func (s *SFTPObjectStorage) ServeFile(ctx context.Context, r *http.Request, w http.ResponseWriter, podcastGUID, fileName string) error {
+	f, err := s.Open(ctx, podcastGUID, fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	http.ServeContent(w, r, "", time.Time{}, f)
+	return nil
+}
+
+// Close closes the connection to the SFTP server
+func (s *SFTPObjectStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == nil {
+		return nil
+	}
+	err := s.client.Close()
+	s.client = nil
+	return err
+}
+
+// sftpClient connects to the SFTP server on first use, and again whenever the
+// connection has been lost, e.g. when the server restarts
+func (s *SFTPObjectStorage) sftpClient() (*sftp.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	conn, err := ssh.Dial("tcp", s.Address, s.SSHConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SFTP server: %w", err)
+	}
+	client, err := sftp.NewClient(conn, sftp.UseConcurrentWrites(true))
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to start SFTP session: %w", err)
+	}
+
+	go func() {
+		_ = client.Wait()
+		_ = conn.Close()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.client == client {
+			s.client = nil
+		}
+	}()
+
+	s.client = client
+	return client, nil
+}
+
+// path is the path on the server of a path within Root
+func (s *SFTPObjectStorage) path(elem ...string) string {
+	root := s.Root
+	if root == "" {
+		root = "."
+	}
+	return path.Join(append([]string{root}, elem...)...)
+}
@@ -0,0 +1,162 @@
+package objectstorage_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/webdav"
+)
+
+const (
+	testServerUsername = "castkeeper"
+	testServerPassword = "password"
+)
+
+// startWebDAVServer starts an in-memory WebDAV server, requiring basic auth,
+// and returns its URL
+func startWebDAVServer(t *testing.T) string {
+	handler := &webdav.Handler{
+		Prefix:     "/dav",
+		FileSystem: webdav.NewMemFS(),
+		LockSystem: webdav.NewMemLS(),
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, p, ok := r.BasicAuth()
+		if !ok || u != testServerUsername || p != testServerPassword {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/dav"
+}
+
+// sftpTestServer serves SFTP over SSH on a local port, with password auth, and
+// the local file system as its root
+type sftpTestServer struct {
+	addr    string
+	hostKey ssh.PublicKey
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func startSFTPServer(t *testing.T) *sftpTestServer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		panic(err)
+	}
+
+	cfg := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() != testServerUsername || string(pass) != testServerPassword {
+				return nil, errors.New("access denied")
+			}
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(signer)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	s := &sftpTestServer{addr: l.Addr().String(), hostKey: signer.PublicKey()}
+	t.Cleanup(func() {
+		_ = l.Close()
+		s.closeConns()
+	})
+
+	go func() {
+		for {
+			nc, err := l.Accept()
+			if err != nil {
+				return
+			}
+			s.mu.Lock()
+			s.conns = append(s.conns, nc)
+			s.mu.Unlock()
+			go serveSFTPConn(nc, cfg)
+		}
+	}()
+
+	return s
+}
+
+func (s *sftpTestServer) clientConfig() *ssh.ClientConfig {
+	return &ssh.ClientConfig{
+		User:            testServerUsername,
+		Auth:            []ssh.AuthMethod{ssh.Password(testServerPassword)},
+		HostKeyCallback: ssh.FixedHostKey(s.hostKey),
+	}
+}
+
+// closeConns drops every connection, e.g. to simulate the server restarting
+func (s *sftpTestServer) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, nc := range s.conns {
+		_ = nc.Close()
+	}
+	s.conns = nil
+}
+
+func serveSFTPConn(nc net.Conn, cfg *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(nc, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			_ = newCh.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		ch, requests, err := newCh.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && isSFTPSubsystem(req.Payload)
+				_ = req.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+				go func() {
+					server, err := sftp.NewServer(ch)
+					if err != nil {
+						return
+					}
+					_ = server.Serve()
+					_ = server.Close()
+				}()
+			}
+		}()
+	}
+}
+
+// the payload of a subsystem request is the subsystem name as an SSH string
+func isSFTPSubsystem(payload []byte) bool {
+	if len(payload) < 4 {
+		return false
+	}
+	n := binary.BigEndian.Uint32(payload)
+	return int(n) == len(payload)-4 && string(payload[4:]) == "sftp"
+}
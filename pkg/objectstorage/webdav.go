@@ -0,0 +1,349 @@
+package objectstorage
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+)
+
+// propfindBody requests only the properties used to describe files
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>` +
+	`<propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/><getlastmodified/></prop></propfind>`
+
+type WebDAVObjectStorage struct {
+	// HTTPClient downloads remote files
+	HTTPClient *http.Client
+	// WebDAVClient makes requests to the WebDAV server
+	WebDAVClient *http.Client
+	// BaseURL is the URL of the collection which files are stored in
+	BaseURL  string
+	Username string
+	Password string
+}
+
+// SaveRemoteFile streams the remote file to the WebDAV server, uploading to a
+// partial file which is moved to fileName once the upload completes.
+// Interrupted downloads are not resumed.
+func (s *WebDAVObjectStorage) SaveRemoteFile(ctx context.Context, creds *podcasts.PodcastCredentials, remoteLocation, podcastGUID, fileName string, opts SaveOptions) (SavedFile, error) {
+	return streamRemoteFile(ctx, s.HTTPClient, creds, remoteLocation, fileName, opts,
+		func(fileName string, r io.Reader) error {
+			_, err := s.Put(ctx, podcastGUID, fileName, r)
+			return err
+		},
+		func(fileName string) error {
+			return s.Delete(ctx, podcastGUID, fileName)
+		},
+	)
+}
+
+// Open reads a file with ranged requests, so that it can be read from any
+// offset without downloading the whole file
+func (s *WebDAVObjectStorage) Open(ctx context.Context, podcastGUID, fileName string) (io.ReadSeekCloser, error) {
+	info, err := s.Stat(ctx, podcastGUID, fileName)
+	if err != nil {
+		return nil, err
+	}
+	fileURL := s.url(podcastGUID, fileName)
+	return &rangedReader{
+		ctx:  ctx,
+		size: info.Bytes,
+		openAt: func(ctx context.Context, offset int64) (io.ReadCloser, error) {
+			return s.get(ctx, fileURL, offset)
+		},
+	}, nil
+}
+
+func (s *WebDAVObjectStorage) get(ctx context.Context, fileURL string, offset int64) (io.ReadCloser, error) {
+	headers := map[string]string{}
+	if offset > 0 {
+		headers["Range"] = fmt.Sprintf("bytes=%d-", offset)
+	}
+	resp, err := s.do(ctx, http.MethodGet, fileURL, nil, headers)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		return resp.Body, nil
+	case http.StatusOK:
+		// the server doesn't support range requests, skip to the offset
+		if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+			_ = resp.Body.Close()
+			return nil, err
+		}
+		return resp.Body, nil
+	default:
+		_ = resp.Body.Close()
+		return nil, webDAVStatusError(http.MethodGet, resp)
+	}
+}
+
+// Put uploads the contents of r to a partial file, which replaces the file once
+// the upload completes
+func (s *WebDAVObjectStorage) Put(ctx context.Context, podcastGUID, fileName string, r io.Reader) (SavedFile, error) {
+	if err := s.mkcol(ctx, podcastGUID); err != nil {
+		return SavedFile{}, err
+	}
+
+	fileURL := s.url(podcastGUID, fileName)
+	partURL := s.url(podcastGUID, fileName+partFileSuffix)
+
+	hr := newHashingReader(r)
+	resp, err := s.do(ctx, http.MethodPut, partURL, hr, nil)
+	if err != nil {
+		_ = s.delete(ctx, partURL)
+		return SavedFile{}, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		_ = s.delete(ctx, partURL)
+		return SavedFile{}, webDAVStatusError(http.MethodPut, resp)
+	}
+
+	resp, err = s.do(ctx, "MOVE", partURL, nil, map[string]string{
+		"Destination": fileURL,
+		"Overwrite":   "T",
+	})
+	if err != nil {
+		_ = s.delete(ctx, partURL)
+		return SavedFile{}, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		_ = s.delete(ctx, partURL)
+		return SavedFile{}, webDAVStatusError("MOVE", resp)
+	}
+
+	return hr.savedFile(), nil
+}
+
+func (s *WebDAVObjectStorage) Delete(ctx context.Context, podcastGUID, fileName string) error {
+	return s.delete(ctx, s.url(podcastGUID, fileName))
+}
+
+func (s *WebDAVObjectStorage) delete(ctx context.Context, fileURL string) error {
+	resp, err := s.do(ctx, http.MethodDelete, fileURL, nil, nil)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return webDAVStatusError(http.MethodDelete, resp)
+	}
+	return nil
+}
+
+func (s *WebDAVObjectStorage) Stat(ctx context.Context, podcastGUID, fileName string) (ObjectInfo, error) {
+	filePath := path.Join(podcastGUID, fileName)
+	entries, err := s.propfind(ctx, s.url(podcastGUID, fileName), "0")
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	if len(entries) != 1 || entries[0].isDir {
+		return ObjectInfo{}, fmt.Errorf("'%s' is not a file: %w", filePath, fs.ErrNotExist)
+	}
+	return objectInfoFromPath(filePath, entries[0].bytes, entries[0].modTime), nil
+}
+
+// List lists the collections which can contain files matching prefix, as many
+// servers don't allow listing a whole tree in one request
+func (s *WebDAVObjectStorage) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	objects := make([]ObjectInfo, 0)
+
+	dirs, err := s.propfind(ctx, s.url(), "1")
+	if errors.Is(err, fs.ErrNotExist) {
+		// nothing has been stored yet
+		return objects, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range dirs {
+		if !dir.isDir || dir.path == "" {
+			continue
+		}
+		if !strings.HasPrefix(dir.path+"/", prefix) && !strings.HasPrefix(prefix, dir.path+"/") {
+			continue
+		}
+
+		files, err := s.propfind(ctx, s.url(dir.path)+"/", "1")
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range files {
+			if f.isDir || !strings.HasPrefix(f.path, prefix) {
+				continue
+			}
+			objects = append(objects, objectInfoFromPath(f.path, f.bytes, f.modTime))
+		}
+	}
+
+	return objects, nil
+}
+
+func (s *WebDAVObjectStorage) ServeFile(ctx context.Context, r *http.Request, w http.ResponseWriter, podcastGUID, fileName string) error {
+	f, err := s.Open(ctx, podcastGUID, fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	http.ServeContent(w, r, "", time.Time{}, f)
+	return nil
+}
+
+func (s *WebDAVObjectStorage) mkcol(ctx context.Context, podcastGUID string) error {
+	resp, err := s.do(ctx, "MKCOL", s.url(podcastGUID)+"/", nil, nil)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	// 405 is returned when the collection already exists
+	if resp.StatusCode == http.StatusMethodNotAllowed {
+		return nil
+	}
+	if resp.StatusCode == http.StatusConflict {
+		return fmt.Errorf("WebDAV collection '%s' does not exist", s.BaseURL)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return webDAVStatusError("MKCOL", resp)
+	}
+	return nil
+}
+
+// url is the URL of a path within BaseURL
+func (s *WebDAVObjectStorage) url(segments ...string) string {
+	escaped := make([]string, 0, len(segments))
+	for _, seg := range segments {
+		escaped = append(escaped, url.PathEscape(seg))
+	}
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + strings.Join(escaped, "/")
+}
+
+func (s *WebDAVObjectStorage) do(ctx context.Context, method, reqURL string, body io.Reader, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, reqURL, body)
+	if err != nil {
+		return nil, err
+	}
+	if s.Username != "" {
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	return s.WebDAVClient.Do(req)
+}
+
+type webDAVEntry struct {
+	// path relative to BaseURL, without a trailing slash
+	path    string
+	isDir   bool
+	bytes   int64
+	modTime time.Time
+}
+
+type webDAVMultistatus struct {
+	Responses []struct {
+		Href      string `xml:"DAV: href"`
+		Propstats []struct {
+			Status string `xml:"DAV: status"`
+			Prop   struct {
+				ResourceType struct {
+					Collection *struct{} `xml:"DAV: collection"`
+				} `xml:"DAV: resourcetype"`
+				ContentLength string `xml:"DAV: getcontentlength"`
+				LastModified  string `xml:"DAV: getlastmodified"`
+			} `xml:"DAV: prop"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// propfind describes the resource at reqURL, and with depth "1" its children.
+// Returns an error wrapping fs.ErrNotExist when the resource does not exist.
+func (s *WebDAVObjectStorage) propfind(ctx context.Context, reqURL, depth string) ([]webDAVEntry, error) {
+	resp, err := s.do(ctx, "PROPFIND", reqURL, strings.NewReader(propfindBody), map[string]string{
+		"Depth":        depth,
+		"Content-Type": "application/xml; charset=utf-8",
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("'%s' not found: %w", reqURL, fs.ErrNotExist)
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, webDAVStatusError("PROPFIND", resp)
+	}
+
+	var ms webDAVMultistatus
+	if err := xml.NewDecoder(resp.Body).Decode(&ms); err != nil {
+		return nil, fmt.Errorf("failed to parse PROPFIND response: %w", err)
+	}
+
+	basePath, err := urlPath(s.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]webDAVEntry, 0, len(ms.Responses))
+	for _, r := range ms.Responses {
+		hrefPath, err := urlPath(r.Href)
+		if err != nil {
+			return nil, err
+		}
+		entry := webDAVEntry{
+			path: strings.Trim(strings.TrimPrefix(hrefPath, basePath), "/"),
+		}
+		for _, ps := range r.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			entry.isDir = ps.Prop.ResourceType.Collection != nil
+			if ps.Prop.ContentLength != "" {
+				entry.bytes, err = strconv.ParseInt(ps.Prop.ContentLength, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid content length of '%s': %w", r.Href, err)
+				}
+			}
+			if ps.Prop.LastModified != "" {
+				entry.modTime, _ = http.ParseTime(ps.Prop.LastModified)
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// urlPath is the unescaped path of a URL, which may be relative to the server
+func urlPath(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(u.Path, "/"), nil
+}
+
+func webDAVStatusError(method string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("WebDAV %s failed with status '%d': %w", method, resp.StatusCode, fs.ErrNotExist)
+	}
+	return fmt.Errorf("WebDAV %s failed with status '%d'", method, resp.StatusCode)
+}
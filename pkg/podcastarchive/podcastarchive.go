@@ -0,0 +1,322 @@
+// Package podcastarchive exports a podcast and its downloaded episodes as a
+// portable zip file, and imports them into another CastKeeper instance.
+package podcastarchive
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/webbgeorge/castkeeper/pkg/blobs"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"github.com/webbgeorge/castkeeper/pkg/util"
+	"gorm.io/gorm"
+)
+
+const (
+	manifestVersion = 1
+
+	manifestFileName = "manifest.json"
+	feedFileName     = "feed.xml"
+	artworkFileName  = "artwork.jpg"
+	episodesDir      = "episodes"
+)
+
+// Manifest describes the podcast in an archive. Feed credentials aren't
+// exported, as they are encrypted with the exporting instance's key.
+type Manifest struct {
+	Version    int
+	ExportedAt time.Time
+	Podcast    podcasts.Podcast
+	Feeds      []podcasts.PodcastFeed
+	Episodes   []ManifestEpisode
+	// Artwork is the path of the podcast's image in the archive, empty when not
+	// included
+	Artwork string
+}
+
+type ManifestEpisode struct {
+	podcasts.Episode
+	// File is the path of the episode's media file in the archive, empty when
+	// not included
+	File string
+}
+
+// Export writes a zip archive of a podcast to w, containing its downloaded
+// episodes and artwork, a feed which links to them by relative path, and a
+// manifest of the podcast's metadata. Files are stored uncompressed, as media
+// doesn't compress well. Episodes whose files can't be read are logged and
+// left out of the archive.
+func Export(ctx context.Context, db *gorm.DB, objstore objectstorage.ObjectStorage, podcastGUID string, w io.Writer) error {
+	pod, err := podcasts.GetPodcast(ctx, db, podcastGUID)
+	if err != nil {
+		return fmt.Errorf("failed to get podcast: %w", err)
+	}
+	feeds, err := podcasts.ListFeeds(ctx, db, pod)
+	if err != nil {
+		return fmt.Errorf("failed to list podcast feeds: %w", err)
+	}
+	eps, err := podcasts.ListEpisodes(ctx, db, pod.GUID)
+	if err != nil {
+		return fmt.Errorf("failed to list episodes: %w", err)
+	}
+
+	zw := zip.NewWriter(w)
+	manifest := Manifest{
+		Version:    manifestVersion,
+		ExportedAt: time.Now().UTC(),
+		Podcast:    pod,
+		Feeds:      feeds,
+		Episodes:   make([]ManifestEpisode, 0, len(eps)),
+	}
+
+	artwork := fmt.Sprintf("%s.%s", util.SanitiseGUID(pod.GUID), "jpg")
+	err = copyToZip(ctx, zw, objstore, util.SanitiseGUID(pod.GUID), artwork, artworkFileName, manifest.ExportedAt)
+	if err == nil {
+		manifest.Artwork = artworkFileName
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to export artwork of podcast '%s': %s", pod.GUID, err.Error()))
+	}
+
+	for _, ep := range eps {
+		mEp := ManifestEpisode{Episode: ep}
+		if ep.Status == podcasts.EpisodeStatusSuccess {
+			mEp.File, err = exportEpisode(ctx, zw, objstore, ep)
+			if err != nil {
+				framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to export episode '%s': %s", ep.GUID, err.Error()))
+			}
+		}
+		manifest.Episodes = append(manifest.Episodes, mEp)
+	}
+
+	if err := writeFeed(zw, manifest); err != nil {
+		return fmt.Errorf("failed to write feed: %w", err)
+	}
+
+	f, err := zw.Create(manifestFileName)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	return zw.Close()
+}
+
+func exportEpisode(ctx context.Context, zw *zip.Writer, objstore objectstorage.ObjectStorage, ep podcasts.Episode) (string, error) {
+	extension, err := podcasts.MIMETypeExtension(ep.MimeType)
+	if err != nil {
+		return "", err
+	}
+	dir, fileName, err := podcasts.EpisodeFile(ep)
+	if err != nil {
+		return "", err
+	}
+	zipPath := path.Join(episodesDir, fmt.Sprintf("%s.%s", util.SanitiseGUID(ep.GUID), extension))
+
+	err = copyToZip(ctx, zw, objstore, dir, fileName, zipPath, ep.PublishedAt)
+	if err != nil {
+		return "", err
+	}
+	return zipPath, nil
+}
+
+// copyToZip copies a stored file into the archive. The file is opened before
+// its entry is created, so that files which don't exist are left out.
+func copyToZip(ctx context.Context, zw *zip.Writer, objstore objectstorage.ObjectStorage, podcastGUID, fileName, zipPath string, modified time.Time) error {
+	f, err := objstore.Open(ctx, podcastGUID, fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zf, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     zipPath,
+		Method:   zip.Store,
+		Modified: modified,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(zf, f)
+	return err
+}
+
+func writeFeed(zw *zip.Writer, manifest Manifest) error {
+	eps := make([]podcasts.Episode, 0, len(manifest.Episodes))
+	files := make(map[string]string)
+	for _, mEp := range manifest.Episodes {
+		// only episodes included in the archive are in its feed
+		if mEp.File == "" {
+			continue
+		}
+		eps = append(eps, mEp.Episode)
+		files[mEp.GUID] = mEp.File
+	}
+
+	feed, err := podcasts.GenerateArchiveFeed(manifest.Podcast, eps, feedFileName, manifest.Artwork, func(ep podcasts.Episode) string {
+		return files[ep.GUID]
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := zw.Create(feedFileName)
+	if err != nil {
+		return err
+	}
+	return feed.WriteFeedXML(f)
+}
+
+// Import restores a podcast from an archive created by Export, saving its
+// files to object storage before adding the podcast to the database. When the
+// import fails, the files saved so far are deleted again. Episodes
+// which were downloaded but aren't in the archive are marked as failed, so that
+// they can be downloaded again. Feed credentials aren't included in archives,
+// so password protected feeds must be added to the podcast again.
+func Import(ctx context.Context, db *gorm.DB, objstore objectstorage.ObjectStorage, r io.ReaderAt, size int64) (pod podcasts.Podcast, err error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return podcasts.Podcast{}, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	manifest, err := readManifest(zr)
+	if err != nil {
+		return podcasts.Podcast{}, err
+	}
+	pod = manifest.Podcast
+
+	if _, err := podcasts.GetPodcast(ctx, db, pod.GUID); err == nil {
+		return pod, fmt.Errorf("podcast '%s' already exists: %w", pod.GUID, gorm.ErrDuplicatedKey)
+	}
+
+	// files of a failed import would never be used, and reconciling storage
+	// doesn't find them, as their podcast isn't in the database
+	podDir := util.SanitiseGUID(pod.GUID)
+	saved := make([]string, 0, len(manifest.Episodes)+1)
+	defer func() {
+		if err != nil {
+			deleteImportedFiles(ctx, objstore, podDir, saved)
+		}
+	}()
+
+	if manifest.Artwork != "" {
+		artwork := fmt.Sprintf("%s.%s", podDir, "jpg")
+		if _, err := importFile(ctx, zr, objstore, manifest.Artwork, podDir, artwork); err != nil {
+			return pod, fmt.Errorf("failed to import artwork: %w", err)
+		}
+		saved = append(saved, artwork)
+	}
+
+	eps := make([]podcasts.Episode, 0, len(manifest.Episodes))
+	files := make(map[string]importedFile)
+	for _, mEp := range manifest.Episodes {
+		ep := mEp.Episode
+		ep.PodcastGUID = pod.GUID
+		ep.DownloadedBytes = 0
+		ep.DownloadTotalBytes = 0
+
+		if mEp.File == "" {
+			if ep.Status != podcasts.EpisodeStatusFailed {
+				ep.Status = podcasts.EpisodeStatusFailed
+				ep.SHA256 = ""
+				ep.VerifiedAt = nil
+				ep.IntegrityProblem = ""
+			}
+			eps = append(eps, ep)
+			continue
+		}
+
+		extension, err := podcasts.MIMETypeExtension(ep.MimeType)
+		if err != nil {
+			return pod, fmt.Errorf("failed to import episode '%s': %w", ep.GUID, err)
+		}
+		fileName := fmt.Sprintf("%s.%s", util.SanitiseGUID(ep.GUID), extension)
+		savedFile, err := importFile(ctx, zr, objstore, mEp.File, podDir, fileName)
+		if err != nil {
+			return pod, fmt.Errorf("failed to import episode '%s': %w", ep.GUID, err)
+		}
+		saved = append(saved, fileName)
+		if ep.SHA256 != "" && ep.SHA256 != savedFile.SHA256 {
+			return pod, fmt.Errorf("failed to import episode '%s': file does not match its recorded hash", ep.GUID)
+		}
+
+		ep.Status = podcasts.EpisodeStatusSuccess
+		ep.Bytes = savedFile.Bytes
+		ep.SHA256 = savedFile.SHA256
+		ep.VerifiedAt = nil
+		ep.IntegrityProblem = ""
+		eps = append(eps, ep)
+		files[ep.GUID] = importedFile{fileName: fileName, saved: savedFile}
+	}
+
+	err = podcasts.ImportPodcast(ctx, db, pod, manifest.Feeds, eps)
+	if err != nil {
+		return pod, fmt.Errorf("failed to import podcast: %w", err)
+	}
+
+	// files are stored by their content once their episodes exist. Failures are
+	// logged, as the files are kept in the podcast's directory.
+	for _, ep := range eps {
+		file, ok := files[ep.GUID]
+		if !ok {
+			continue
+		}
+		if _, err := blobs.Store(ctx, db, objstore, &ep, file.fileName, file.saved); err != nil {
+			framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to store episode '%s' by its content: %s", ep.GUID, err.Error()))
+		}
+	}
+
+	return pod, nil
+}
+
+type importedFile struct {
+	fileName string
+	saved    objectstorage.SavedFile
+}
+
+func deleteImportedFiles(ctx context.Context, objstore objectstorage.ObjectStorage, podDir string, fileNames []string) {
+	for _, fileName := range fileNames {
+		if err := objstore.Delete(ctx, podDir, fileName); err != nil {
+			framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("failed to delete imported file '%s/%s': %s", podDir, fileName, err.Error()))
+		}
+	}
+}
+
+func readManifest(zr *zip.Reader) (Manifest, error) {
+	f, err := zr.Open(manifestFileName)
+	if err != nil {
+		return Manifest{}, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	defer f.Close()
+
+	var manifest Manifest
+	if err := json.NewDecoder(f).Decode(&manifest); err != nil {
+		return Manifest{}, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if manifest.Version != manifestVersion {
+		return Manifest{}, fmt.Errorf("unsupported archive version '%d'", manifest.Version)
+	}
+	return manifest, nil
+}
+
+func importFile(ctx context.Context, zr *zip.Reader, objstore objectstorage.ObjectStorage, zipPath, podcastGUID, fileName string) (objectstorage.SavedFile, error) {
+	f, err := zr.Open(zipPath)
+	if err != nil {
+		return objectstorage.SavedFile{}, err
+	}
+	defer f.Close()
+
+	return objstore.Put(ctx, podcastGUID, fileName, f)
+}
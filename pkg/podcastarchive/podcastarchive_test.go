@@ -0,0 +1,204 @@
+package podcastarchive_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcastarchive"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"gorm.io/gorm"
+)
+
+// from valid.xml fixture
+const (
+	podGUID = "916ed63b-7e5e-5541-af78-e214a0c14d95"
+	ep1GUID = "c8998fa5-8083-56a6-8d3c-7b98d031b3d8"
+	ep2GUID = "3864ebe7-7a8f-5532-841f-0bacd0a0cc6c"
+)
+
+func TestExport(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	var buf bytes.Buffer
+	err := podcastarchive.Export(context.Background(), db, &objectstorage.LocalObjectStorage{Root: root}, podGUID, &buf)
+
+	assert.Nil(t, err)
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		panic(err)
+	}
+
+	assert.Equal(t, "Not a real JPG", readZipFile(zr, "artwork.jpg"))
+	assert.Equal(t, "Not a real MP3", readZipFile(zr, fmt.Sprintf("episodes/%s.mp3", ep1GUID)))
+
+	feed := readZipFile(zr, "feed.xml")
+	assert.Contains(t, feed, fmt.Sprintf(`url="episodes/%s.mp3"`, ep1GUID))
+	assert.Contains(t, feed, `<itunes:image href="artwork.jpg"></itunes:image>`)
+	// ep-2 has no stored file, so isn't in the archive's feed
+	assert.NotContains(t, feed, ep2GUID)
+
+	var manifest podcastarchive.Manifest
+	if err := json.Unmarshal([]byte(readZipFile(zr, "manifest.json")), &manifest); err != nil {
+		panic(err)
+	}
+	assert.Equal(t, 1, manifest.Version)
+	assert.Equal(t, podGUID, manifest.Podcast.GUID)
+	assert.Equal(t, "artwork.jpg", manifest.Artwork)
+	assert.Len(t, manifest.Episodes, 2)
+	for _, ep := range manifest.Episodes {
+		if ep.GUID == ep1GUID {
+			assert.Equal(t, fmt.Sprintf("episodes/%s.mp3", ep1GUID), ep.File)
+		} else {
+			assert.Equal(t, "", ep.File)
+		}
+	}
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	var buf bytes.Buffer
+	err := podcastarchive.Export(ctx, db, &objectstorage.LocalObjectStorage{Root: root}, podGUID, &buf)
+	if err != nil {
+		panic(err)
+	}
+
+	// restored into a different instance, where the podcast has been removed
+	if err := podcasts.DeletePodcast(ctx, db, podGUID); err != nil {
+		panic(err)
+	}
+	newRoot, resetNewFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetNewFS()
+	if err := newRoot.RemoveAll(podGUID); err != nil {
+		panic(err)
+	}
+
+	pod, err := podcastarchive.Import(ctx, db, &objectstorage.LocalObjectStorage{Root: newRoot}, bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+
+	assert.Nil(t, err)
+	assert.Equal(t, podGUID, pod.GUID)
+
+	dbPod, err := podcasts.GetPodcast(ctx, db, podGUID)
+	assert.Nil(t, err)
+	feeds, err := podcasts.ListFeeds(ctx, db, dbPod)
+	assert.Nil(t, err)
+	assert.Len(t, feeds, 1)
+
+	ep1, err := podcasts.GetEpisode(ctx, db, ep1GUID)
+	assert.Nil(t, err)
+	assert.Equal(t, podcasts.EpisodeStatusSuccess, ep1.Status)
+	assert.Equal(t, int64(14), ep1.Bytes)
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("Not a real MP3"))), ep1.SHA256)
+
+	// downloaded, but wasn't in the archive
+	ep2, err := podcasts.GetEpisode(ctx, db, ep2GUID)
+	assert.Nil(t, err)
+	assert.Equal(t, podcasts.EpisodeStatusFailed, ep2.Status)
+
+	image, err := newRoot.ReadFile(fmt.Sprintf("%s/%s.jpg", podGUID, podGUID))
+	assert.Nil(t, err)
+	assert.Equal(t, "Not a real JPG", string(image))
+	mp3, err := newRoot.ReadFile(fmt.Sprintf("%s/%s", podcasts.BlobDir, ep1.BlobSHA256))
+	assert.Nil(t, err)
+	assert.Equal(t, "Not a real MP3", string(mp3))
+}
+
+func TestImport_AlreadyExists(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{Root: root}
+
+	var buf bytes.Buffer
+	err := podcastarchive.Export(ctx, db, objstore, podGUID, &buf)
+	if err != nil {
+		panic(err)
+	}
+
+	_, err = podcastarchive.Import(ctx, db, objstore, bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+
+	assert.ErrorIs(t, err, gorm.ErrDuplicatedKey)
+}
+
+func TestImport_FailureDeletesFiles(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	var buf bytes.Buffer
+	err := podcastarchive.Export(ctx, db, &objectstorage.LocalObjectStorage{Root: root}, podGUID, &buf)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := podcasts.DeletePodcast(ctx, db, podGUID); err != nil {
+		panic(err)
+	}
+	// an episode in the archive now belongs to a different podcast
+	if err := db.Unscoped().Delete(&podcasts.Episode{}, "guid = ?", ep1GUID).Error; err != nil {
+		panic(err)
+	}
+	if err := db.Create(&podcasts.Episode{
+		GUID:        ep1GUID,
+		PodcastGUID: fixtures.PodEpGUID("pod-eps-pending"),
+		Title:       "Moved episode",
+		DownloadURL: "http://testdata/audio/ep1.mp3",
+		MimeType:    "audio/mpeg",
+		Status:      podcasts.EpisodeStatusPending,
+	}).Error; err != nil {
+		panic(err)
+	}
+	newRoot, resetNewFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetNewFS()
+	if err := newRoot.RemoveAll(podGUID); err != nil {
+		panic(err)
+	}
+
+	_, err = podcastarchive.Import(ctx, db, &objectstorage.LocalObjectStorage{Root: newRoot}, bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+
+	assert.ErrorContains(t, err, "failed to import podcast")
+	entries, err := newRoot.FS().(fs.ReadDirFS).ReadDir(podGUID)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 0)
+}
+
+func TestImport_NotAnArchive(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	data := []byte("not a zip file")
+	_, err := podcastarchive.Import(context.Background(), db, &objectstorage.LocalObjectStorage{Root: root}, bytes.NewReader(data), int64(len(data)))
+
+	assert.ErrorContains(t, err, "failed to read archive")
+}
+
+func readZipFile(zr *zip.Reader, name string) string {
+	f, err := zr.Open(name)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,142 @@
+package podcasts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/webbgeorge/castkeeper/pkg/util"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BlobDir is the object storage directory of content-addressed episode files,
+// which are named by their SHA-256 hash. It can't clash with the directory of
+// a podcast, as sanitised GUIDs don't contain dots.
+const BlobDir = ".blobs"
+
+// Blob is an episode file stored once by its content, and shared by every
+// episode with identical content. RefCount is the number of episodes which
+// use it, and a blob is only removed from object storage once it reaches 0.
+type Blob struct {
+	SHA256    string `gorm:"primaryKey" validate:"required,len=64,hexadecimal"`
+	Bytes     int64  `validate:"gte=0"`
+	RefCount  int64  `validate:"gte=0"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (b *Blob) BeforeSave(tx *gorm.DB) error {
+	err := validate.Struct(b)
+	if err != nil {
+		return fmt.Errorf("blob not valid: %w", err)
+	}
+	return nil
+}
+
+// EpisodeFile is the location of an episode's file in object storage. Files
+// downloaded before content-addressed storage are stored in the podcast's
+// directory, named by the episode's GUID.
+func EpisodeFile(ep Episode) (dir, fileName string, err error) {
+	if ep.BlobSHA256 != "" {
+		return BlobDir, ep.BlobSHA256, nil
+	}
+	extension, err := MIMETypeExtension(ep.MimeType)
+	if err != nil {
+		return "", "", err
+	}
+	return util.SanitiseGUID(ep.PodcastGUID), fmt.Sprintf("%s.%s", util.SanitiseGUID(ep.GUID), extension), nil
+}
+
+// ReserveBlob adds a reference to the blob with the given hash, creating it if
+// it doesn't exist, so that it isn't removed while an episode's file is being
+// stored as it. The reference is either given to an episode with
+// SetEpisodeBlob, or released with ReleaseBlob.
+func ReserveBlob(ctx context.Context, db *gorm.DB, sha256 string, bytes int64) error {
+	blob := Blob{SHA256: sha256, Bytes: bytes, RefCount: 1}
+	return db.
+		Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "sha256"}},
+			DoUpdates: clause.Assignments(map[string]any{
+				"ref_count":  gorm.Expr("ref_count + 1"),
+				"updated_at": time.Now(),
+			}),
+		}).
+		Create(&blob).Error
+}
+
+// ReleaseBlob removes a reference to the blob with the given hash
+func ReleaseBlob(ctx context.Context, db *gorm.DB, sha256 string) error {
+	return db.
+		Model(&Blob{}).
+		Where("sha256 = ? AND ref_count > 0", sha256).
+		// skips the hooks, which would validate the empty model
+		UpdateColumns(map[string]any{
+			"ref_count":  gorm.Expr("ref_count - 1"),
+			"updated_at": time.Now(),
+		}).Error
+}
+
+// SetEpisodeBlob gives a reference reserved with ReserveBlob to an episode,
+// and releases the blob the episode used before, if any. An empty sha256
+// returns the episode to the file in its podcast's directory.
+func SetEpisodeBlob(ctx context.Context, db *gorm.DB, episode *Episode, sha256 string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if episode.BlobSHA256 != "" {
+			if err := ReleaseBlob(ctx, tx, episode.BlobSHA256); err != nil {
+				return err
+			}
+		}
+		return tx.
+			Model(episode).
+			Select("BlobSHA256").
+			Updates(Episode{BlobSHA256: sha256}).Error
+	})
+}
+
+// ListEpisodesWithoutBlobs lists downloaded episodes whose files are stored in
+// their podcast's directory, from before content-addressed storage
+func ListEpisodesWithoutBlobs(ctx context.Context, db *gorm.DB) ([]Episode, error) {
+	var episodes []Episode
+	result := db.
+		Where("status = ? AND sha256 <> '' AND blob_sha256 = ''", EpisodeStatusSuccess).
+		Order("published_at asc").
+		Find(&episodes)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return episodes, nil
+}
+
+func ListBlobs(ctx context.Context, db *gorm.DB) ([]Blob, error) {
+	var blobs []Blob
+	result := db.Order("sha256 asc").Find(&blobs)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return blobs, nil
+}
+
+var ErrBlobInUse = errors.New("blob is in use")
+
+// DeleteUnusedBlob removes the blob with the given hash if no episode uses it,
+// calling deleteFile to remove its file from object storage. The file is
+// deleted while the blob's row is locked, so that an episode can't start using
+// the blob until its file is gone. ErrBlobInUse is returned if the blob is
+// used.
+func DeleteUnusedBlob(ctx context.Context, db *gorm.DB, sha256 string, deleteFile func() error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("sha256 = ? AND ref_count = 0", sha256).Delete(&Blob{}).Error; err != nil {
+			return err
+		}
+		var used int64
+		if err := tx.Model(&Blob{}).Where("sha256 = ?", sha256).Count(&used).Error; err != nil {
+			return err
+		}
+		if used > 0 {
+			return ErrBlobInUse
+		}
+		return deleteFile()
+	})
+}
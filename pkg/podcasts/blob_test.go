@@ -0,0 +1,121 @@
+package podcasts_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"gorm.io/gorm"
+)
+
+var (
+	blobSHA1 = strings.Repeat("a", 64)
+	blobSHA2 = strings.Repeat("b", 64)
+)
+
+func TestEpisodeFile(t *testing.T) {
+	ep := podcasts.Episode{GUID: "ep/1", PodcastGUID: "pod.1", MimeType: "audio/mpeg"}
+
+	dir, fileName, err := podcasts.EpisodeFile(ep)
+	assert.Nil(t, err)
+	assert.Equal(t, "pod-1", dir)
+	assert.Equal(t, "ep-1.mp3", fileName)
+
+	ep.BlobSHA256 = blobSHA1
+	dir, fileName, err = podcasts.EpisodeFile(ep)
+	assert.Nil(t, err)
+	assert.Equal(t, podcasts.BlobDir, dir)
+	assert.Equal(t, blobSHA1, fileName)
+}
+
+func TestEpisodeBlobReferences(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	ctx := context.Background()
+	ep1 := getEpisode(db, fixtures.PodEpGUID("ep-1"))
+	ep2 := getEpisode(db, fixtures.PodEpGUID("ep-2"))
+
+	for _, ep := range []*podcasts.Episode{&ep1, &ep2} {
+		assert.Nil(t, podcasts.ReserveBlob(ctx, db, blobSHA1, 100))
+		assert.Nil(t, podcasts.SetEpisodeBlob(ctx, db, ep, blobSHA1))
+	}
+	assert.Equal(t, int64(2), getBlob(db, blobSHA1).RefCount)
+	assert.Equal(t, blobSHA1, getEpisode(db, ep1.GUID).BlobSHA256)
+
+	// moving an episode to another blob releases the one it used before
+	assert.Nil(t, podcasts.ReserveBlob(ctx, db, blobSHA2, 200))
+	assert.Nil(t, podcasts.SetEpisodeBlob(ctx, db, &ep2, blobSHA2))
+	assert.Equal(t, int64(1), getBlob(db, blobSHA1).RefCount)
+	assert.Equal(t, int64(1), getBlob(db, blobSHA2).RefCount)
+
+	// storing an episode as the blob it already uses doesn't add a reference
+	assert.Nil(t, podcasts.ReserveBlob(ctx, db, blobSHA2, 200))
+	assert.Nil(t, podcasts.SetEpisodeBlob(ctx, db, &ep2, blobSHA2))
+	assert.Equal(t, int64(1), getBlob(db, blobSHA2).RefCount)
+
+	assert.Nil(t, podcasts.DeleteEpisode(ctx, db, ep1.GUID))
+	assert.Equal(t, int64(0), getBlob(db, blobSHA1).RefCount)
+	assert.Nil(t, podcasts.DeletePodcast(ctx, db, fixtures.PodEpGUID("abc-123")))
+	assert.Equal(t, int64(0), getBlob(db, blobSHA2).RefCount)
+
+	// references are never negative
+	assert.Nil(t, podcasts.ReleaseBlob(ctx, db, blobSHA1))
+	assert.Equal(t, int64(0), getBlob(db, blobSHA1).RefCount)
+
+	blobs, err := podcasts.ListBlobs(ctx, db)
+	assert.Nil(t, err)
+	assert.Len(t, blobs, 2)
+	assert.Equal(t, int64(200), blobs[1].Bytes)
+}
+
+func TestDeleteUnusedBlob(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	ctx := context.Background()
+	ep := getEpisode(db, fixtures.PodEpGUID("ep-1"))
+	assert.Nil(t, podcasts.ReserveBlob(ctx, db, blobSHA1, 100))
+	assert.Nil(t, podcasts.SetEpisodeBlob(ctx, db, &ep, blobSHA1))
+
+	deleted := false
+	deleteFile := func() error {
+		deleted = true
+		return nil
+	}
+
+	err := podcasts.DeleteUnusedBlob(ctx, db, blobSHA1, deleteFile)
+	assert.ErrorIs(t, err, podcasts.ErrBlobInUse)
+	assert.False(t, deleted)
+
+	// the blob is kept if its file can't be deleted
+	assert.Nil(t, podcasts.DeleteEpisode(ctx, db, ep.GUID))
+	err = podcasts.DeleteUnusedBlob(ctx, db, blobSHA1, func() error {
+		return errors.New("failed to delete")
+	})
+	assert.ErrorContains(t, err, "failed to delete")
+	assert.Equal(t, int64(0), getBlob(db, blobSHA1).RefCount)
+
+	err = podcasts.DeleteUnusedBlob(ctx, db, blobSHA1, deleteFile)
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+	blobs, err := podcasts.ListBlobs(ctx, db)
+	assert.Nil(t, err)
+	assert.Len(t, blobs, 0)
+}
+
+func getEpisode(db *gorm.DB, guid string) podcasts.Episode {
+	ep, err := podcasts.GetEpisode(context.Background(), db, guid)
+	if err != nil {
+		panic(err)
+	}
+	return ep
+}
+
+func getBlob(db *gorm.DB, sha256 string) podcasts.Blob {
+	var blob podcasts.Blob
+	if err := db.First(&blob, "sha256 = ?", sha256).Error; err != nil {
+		panic(err)
+	}
+	return blob
+}
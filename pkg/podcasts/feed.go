@@ -124,14 +124,15 @@ func episodesFromFeed(feed *gopodcast.Podcast, podcastGUID string) ([]Episode, e
 		}
 
 		episode := Episode{
-			GUID:         episodeGUID(item),
-			PodcastGUID:  podcastGUID,
-			Title:        truncate(item.Title, 500),
-			Description:  truncate(desc, 10000),
-			DownloadURL:  item.Enclosure.URL,
-			MimeType:     mimeType,
-			DurationSecs: parseDuration(item),
-			PublishedAt:  pub,
+			GUID:           episodeGUID(item),
+			PodcastGUID:    podcastGUID,
+			Title:          truncate(item.Title, 500),
+			Description:    truncate(desc, 10000),
+			DownloadURL:    item.Enclosure.URL,
+			EnclosureBytes: max(item.Enclosure.Length, 0),
+			MimeType:       mimeType,
+			DurationSecs:   parseDuration(item),
+			PublishedAt:    pub,
 		}
 
 		episodes = append(episodes, episode)
@@ -251,10 +252,34 @@ func GenerateFeed(ctx context.Context, baseURL string, db *gorm.DB, podcastGuid
 		return nil, err
 	}
 
-	return feedFromPodcast(baseURL, pod, eps)
+	return feedFromPodcast(pod, eps, feedLinks{
+		self:  fmt.Sprintf("%s/feeds/%s", baseURL, pod.GUID),
+		image: fmt.Sprintf("%s/feeds/%s/image", baseURL, pod.GUID),
+		episode: func(ep Episode) string {
+			return fmt.Sprintf("%s/feeds/episodes/%s/download", baseURL, ep.GUID)
+		},
+	})
+}
+
+// GenerateArchiveFeed generates a feed for an exported archive of a podcast,
+// linking to the archive's copies of the image and episode files by their
+// relative paths
+func GenerateArchiveFeed(pod Podcast, eps []Episode, feedPath, imagePath string, episodePath func(Episode) string) (*gopodcast.Podcast, error) {
+	return feedFromPodcast(pod, eps, feedLinks{
+		self:    feedPath,
+		image:   imagePath,
+		episode: episodePath,
+	})
 }
 
-func feedFromPodcast(baseURL string, pod Podcast, eps []Episode) (*gopodcast.Podcast, error) {
+// feedLinks are the URLs of a generated feed, its image and episode files
+type feedLinks struct {
+	self    string
+	image   string
+	episode func(Episode) string
+}
+
+func feedFromPodcast(pod Podcast, eps []Episode, links feedLinks) (*gopodcast.Podcast, error) {
 	categories := make([]gopodcast.ITunesCategory, 0)
 	for _, cat := range pod.Categories {
 		if cat.Name == "" {
@@ -273,7 +298,7 @@ func feedFromPodcast(baseURL string, pod Podcast, eps []Episode) (*gopodcast.Pod
 
 	feed := &gopodcast.Podcast{
 		AtomLink: gopodcast.AtomLink{
-			Href: fmt.Sprintf("%s/feeds/%s", baseURL, pod.GUID),
+			Href: links.self,
 			Rel:  "self",
 			Type: "application/rss+xml",
 		},
@@ -283,7 +308,7 @@ func feedFromPodcast(baseURL string, pod Podcast, eps []Episode) (*gopodcast.Pod
 		Language:       pod.Language,
 		ITunesCategory: categories,
 		ITunesExplicit: gopodcast.Bool(pod.IsExplicit),
-		ITunesImage:    gopodcast.ITunesImage{Href: fmt.Sprintf("%s/feeds/%s/image", baseURL, pod.GUID)},
+		ITunesImage:    gopodcast.ITunesImage{Href: links.image},
 	}
 
 	for _, ep := range eps {
@@ -291,6 +316,11 @@ func feedFromPodcast(baseURL string, pod Podcast, eps []Episode) (*gopodcast.Pod
 			continue
 		}
 
+		duration := ""
+		if ep.Duration() > 0 {
+			duration = strconv.Itoa(ep.Duration())
+		}
+
 		pubDate := gopodcast.Time(ep.PublishedAt)
 		feed.Items = append(feed.Items, &gopodcast.Item{
 			Title:       ep.Title,
@@ -298,10 +328,11 @@ func feedFromPodcast(baseURL string, pod Podcast, eps []Episode) (*gopodcast.Pod
 			Enclosure: gopodcast.Enclosure{
 				Length: ep.Bytes,
 				Type:   ep.MimeType,
-				URL:    fmt.Sprintf("%s/feeds/episodes/%s/download", baseURL, ep.GUID),
+				URL:    links.episode(ep),
 			},
-			GUID:    gopodcast.ItemGUID{Text: ep.GUID},
-			PubDate: &pubDate,
+			GUID:           gopodcast.ItemGUID{Text: ep.GUID},
+			PubDate:        &pubDate,
+			ITunesDuration: duration,
 		})
 	}
 
@@ -213,13 +213,14 @@ func fakePodcast(feedURL, guid string, latestEpPubAt *time.Time) podcasts.Podcas
 
 func fakeEpisode(guid, podGuid string, pubAt time.Time) podcasts.Episode {
 	return podcasts.Episode{
-		GUID:         guid,
-		PodcastGUID:  podGuid,
-		Title:        fmt.Sprintf("Test episode %s", guid),
-		Description:  "Episode test description",
-		DownloadURL:  fmt.Sprintf("http://www.example.com/episode-%s.mp3", guid),
-		MimeType:     "audio/mpeg",
-		DurationSecs: 1234,
-		PublishedAt:  pubAt,
+		GUID:           guid,
+		PodcastGUID:    podGuid,
+		Title:          fmt.Sprintf("Test episode %s", guid),
+		Description:    "Episode test description",
+		DownloadURL:    fmt.Sprintf("http://www.example.com/episode-%s.mp3", guid),
+		EnclosureBytes: 1001,
+		MimeType:       "audio/mpeg",
+		DurationSecs:   1234,
+		PublishedAt:    pubAt,
 	}
 }
@@ -2,36 +2,143 @@ package podcasts
 
 import (
 	"fmt"
+	"mime"
 	"strings"
 
+	"github.com/go-playground/validator/v10"
 	"github.com/webbgeorge/gopodcast"
 )
 
-var mimeToExt = map[string]string{
-	"audio/mpeg":      "mp3",
-	"audio/x-m4a":     "m4a",
-	"video/mp4":       "mp4",
-	"video/quicktime": "mov",
+// MediaType is a media format supported by CastKeeper
+type MediaType struct {
+	// MIMEType is the canonical MIME type, which episodes are stored with
+	MIMEType string
+	// Extension is the file extension used when saving files of this type
+	Extension string
+	// Aliases are other MIME types which are used for this format by feeds
+	Aliases []string
+	// OtherExtensions are other file extensions used for this format, which
+	// are recognised in enclosure URLs
+	OtherExtensions []string
+	// Container is the file format used to store the media, formats with the
+	// same container can't always be told apart by their content
+	Container string
 }
 
-var extToMIME = map[string]string{
-	"mp3": "audio/mpeg",
-	"m4a": "audio/x-m4a",
-	"mp4": "video/mp4",
-	"mov": "video/quicktime",
+var mediaTypes = []MediaType{
+	{
+		MIMEType:  "audio/mpeg",
+		Extension: "mp3",
+		Aliases:   []string{"audio/mp3", "audio/mpeg3", "audio/x-mp3", "audio/x-mpeg"},
+		Container: "mpeg",
+	},
+	{
+		MIMEType:        "audio/x-m4a",
+		Extension:       "m4a",
+		Aliases:         []string{"audio/mp4", "audio/m4a", "audio/x-mp4"},
+		OtherExtensions: []string{"m4b"},
+		Container:       "mp4",
+	},
+	{
+		MIMEType:  "audio/aac",
+		Extension: "aac",
+		Aliases:   []string{"audio/x-aac", "audio/aacp"},
+		Container: "adts",
+	},
+	{
+		MIMEType:        "audio/ogg",
+		Extension:       "ogg",
+		Aliases:         []string{"application/ogg", "audio/x-ogg", "audio/vorbis", "audio/ogg; codecs=vorbis"},
+		OtherExtensions: []string{"oga"},
+		Container:       "ogg",
+	},
+	{
+		MIMEType:  "audio/opus",
+		Extension: "opus",
+		Aliases:   []string{"audio/ogg; codecs=opus", "audio/x-opus"},
+		Container: "ogg",
+	},
+	{
+		MIMEType:  "audio/flac",
+		Extension: "flac",
+		Aliases:   []string{"audio/x-flac"},
+		Container: "flac",
+	},
+	{
+		MIMEType:  "video/mp4",
+		Extension: "mp4",
+		Container: "mp4",
+	},
+	{
+		MIMEType:  "video/x-m4v",
+		Extension: "m4v",
+		Aliases:   []string{"video/m4v"},
+		Container: "mp4",
+	},
+	{
+		MIMEType:  "video/quicktime",
+		Extension: "mov",
+		Container: "mp4",
+	},
+	{
+		MIMEType:  "video/webm",
+		Extension: "webm",
+		Aliases:   []string{"audio/webm"},
+		Container: "webm",
+	},
+}
+
+// mimeToMediaType maps canonical MIME types and their aliases to media types
+var mimeToMediaType = map[string]MediaType{}
+
+// extToMediaType maps file extensions to media types
+var extToMediaType = map[string]MediaType{}
+
+func init() {
+	for _, mt := range mediaTypes {
+		mimeToMediaType[mt.MIMEType] = mt
+		for _, alias := range mt.Aliases {
+			mimeToMediaType[alias] = mt
+		}
+		extToMediaType[mt.Extension] = mt
+		for _, ext := range mt.OtherExtensions {
+			extToMediaType[ext] = mt
+		}
+	}
+}
+
+// MediaTypes returns all supported media types
+func MediaTypes() []MediaType {
+	return mediaTypes
+}
+
+// LookupMediaType finds the media type of a MIME type, which may be an alias,
+// and may include parameters, e.g. "audio/ogg; codecs=opus"
+func LookupMediaType(mimeType string) (MediaType, bool) {
+	mediaType, params, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		return MediaType{}, false
+	}
+	if codecs, ok := params["codecs"]; ok {
+		if mt, ok := mimeToMediaType[fmt.Sprintf("%s; codecs=%s", mediaType, strings.ToLower(codecs))]; ok {
+			return mt, true
+		}
+	}
+	mt, ok := mimeToMediaType[mediaType]
+	return mt, ok
 }
 
 func DetectMIMEType(enclosure gopodcast.Enclosure) (string, error) {
 	// use enclosure type by default
-	if _, ok := mimeToExt[enclosure.Type]; ok {
-		return enclosure.Type, nil
+	if mt, ok := LookupMediaType(enclosure.Type); ok {
+		return mt.MIMEType, nil
 	}
 
 	// fallback to file extension
 	strParts := strings.Split(enclosure.URL, ".")
-	extension := strParts[len(strParts)-1]
-	if mimeType, ok := extToMIME[extension]; ok {
-		return mimeType, nil
+	extension := strings.ToLower(strParts[len(strParts)-1])
+	if mt, ok := extToMediaType[extension]; ok {
+		return mt.MIMEType, nil
 	}
 
 	return "", fmt.Errorf(
@@ -41,10 +148,18 @@ func DetectMIMEType(enclosure gopodcast.Enclosure) (string, error) {
 	)
 }
 
+// MIMETypeExtension returns the file extension for a canonical MIME type
 func MIMETypeExtension(mimeType string) (string, error) {
-	extension, ok := mimeToExt[mimeType]
-	if !ok {
+	mt, ok := mimeToMediaType[mimeType]
+	if !ok || mt.MIMEType != mimeType {
 		return "", fmt.Errorf("unsupported MIME type '%s'", mimeType)
 	}
-	return extension, nil
+	return mt.Extension, nil
+}
+
+// validateMediaType is a validator for fields which must be a canonical MIME
+// type of a supported media type
+func validateMediaType(fl validator.FieldLevel) bool {
+	_, err := MIMETypeExtension(fl.Field().String())
+	return err == nil
 }
@@ -38,6 +38,38 @@ func TestDetectMIMEType(t *testing.T) {
 			expectedErr:      false,
 			expectedMIMEType: "audio/x-m4a",
 		},
+		"normalisesAlias": {
+			enclosure: gopodcast.Enclosure{
+				Type: "audio/mp4",
+				URL:  "http://example.com/podcast1.m4a",
+			},
+			expectedErr:      false,
+			expectedMIMEType: "audio/x-m4a",
+		},
+		"usesCodecsParameter": {
+			enclosure: gopodcast.Enclosure{
+				Type: "audio/ogg; codecs=opus",
+				URL:  "http://example.com/podcast1.ogg",
+			},
+			expectedErr:      false,
+			expectedMIMEType: "audio/opus",
+		},
+		"ignoresUnknownParameters": {
+			enclosure: gopodcast.Enclosure{
+				Type: "audio/ogg; codecs=vorbis",
+				URL:  "http://example.com/podcast1",
+			},
+			expectedErr:      false,
+			expectedMIMEType: "audio/ogg",
+		},
+		"fallsBackToOtherURLExt": {
+			enclosure: gopodcast.Enclosure{
+				Type: "",
+				URL:  "http://example.com/podcast1.oga",
+			},
+			expectedErr:      false,
+			expectedMIMEType: "audio/ogg",
+		},
 		"errorWhenNeitherPresent": {
 			enclosure: gopodcast.Enclosure{
 				Type: "",
@@ -91,6 +123,41 @@ func TestMIMETypeExtension(t *testing.T) {
 			expectedErr:       false,
 			expectedExtension: "mov",
 		},
+		"aac": {
+			mimeType:          "audio/aac",
+			expectedErr:       false,
+			expectedExtension: "aac",
+		},
+		"ogg": {
+			mimeType:          "audio/ogg",
+			expectedErr:       false,
+			expectedExtension: "ogg",
+		},
+		"opus": {
+			mimeType:          "audio/opus",
+			expectedErr:       false,
+			expectedExtension: "opus",
+		},
+		"flac": {
+			mimeType:          "audio/flac",
+			expectedErr:       false,
+			expectedExtension: "flac",
+		},
+		"webm": {
+			mimeType:          "video/webm",
+			expectedErr:       false,
+			expectedExtension: "webm",
+		},
+		"m4v": {
+			mimeType:          "video/x-m4v",
+			expectedErr:       false,
+			expectedExtension: "m4v",
+		},
+		"aliasNotCanonical": {
+			mimeType:          "audio/mp4",
+			expectedErr:       true,
+			expectedExtension: "",
+		},
 		"invalid": {
 			mimeType:          "text/plain",
 			expectedErr:       true,
@@ -105,3 +172,74 @@ func TestMIMETypeExtension(t *testing.T) {
 		})
 	}
 }
+
+func TestLookupMediaType(t *testing.T) {
+	testCases := map[string]struct {
+		mimeType         string
+		expectedOK       bool
+		expectedMIMEType string
+	}{
+		"canonical": {
+			mimeType:         "audio/flac",
+			expectedOK:       true,
+			expectedMIMEType: "audio/flac",
+		},
+		"alias": {
+			mimeType:         "audio/x-flac",
+			expectedOK:       true,
+			expectedMIMEType: "audio/flac",
+		},
+		"caseInsensitive": {
+			mimeType:         "Audio/MPEG",
+			expectedOK:       true,
+			expectedMIMEType: "audio/mpeg",
+		},
+		"codecs": {
+			mimeType:         "audio/ogg; codecs=\"opus\"",
+			expectedOK:       true,
+			expectedMIMEType: "audio/opus",
+		},
+		"unsupported": {
+			mimeType:         "text/html",
+			expectedOK:       false,
+			expectedMIMEType: "",
+		},
+		"malformed": {
+			mimeType:         "audio/ogg;;",
+			expectedOK:       false,
+			expectedMIMEType: "",
+		},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mt, ok := podcasts.LookupMediaType(tc.mimeType)
+			assert.Equal(t, tc.expectedOK, ok)
+			assert.Equal(t, tc.expectedMIMEType, mt.MIMEType)
+		})
+	}
+}
+
+func TestEpisodeMimeTypeValidation(t *testing.T) {
+	testCases := map[string]struct {
+		mimeType    string
+		expectedErr bool
+	}{
+		"canonical":   {mimeType: "audio/opus", expectedErr: false},
+		"alias":       {mimeType: "audio/mp4", expectedErr: true},
+		"unsupported": {mimeType: "text/html", expectedErr: true},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			ep := podcasts.Episode{
+				GUID:        "ep-1",
+				PodcastGUID: "abc-123",
+				Title:       "Test",
+				DownloadURL: "http://example.com/ep-1",
+				MimeType:    tc.mimeType,
+				Status:      podcasts.EpisodeStatusPending,
+			}
+			err := ep.BeforeSave(nil)
+			assert.Equal(t, tc.expectedErr, err != nil)
+		})
+	}
+}
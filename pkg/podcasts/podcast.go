@@ -5,18 +5,29 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
+	"github.com/gofrs/uuid/v5"
+	"github.com/webbgeorge/castkeeper/pkg/config"
 	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
 	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"github.com/webbgeorge/castkeeper/pkg/mediainfo"
 	"gorm.io/gorm"
 )
 
 const (
-	EpisodeStatusPending = "pending"
-	EpisodeStatusSuccess = "success"
-	EpisodeStatusFailed  = "failed"
+	EpisodeStatusPending    = "pending"
+	EpisodeStatusInProgress = "in-progress"
+	EpisodeStatusSuccess    = "success"
+	EpisodeStatusFailed     = "failed"
+	// downloading the episode would exceed a storage quota
+	EpisodeStatusPaused = "paused"
+
+	IntegrityProblemMissing   = "missing"
+	IntegrityProblemCorrupted = "corrupted"
 )
 
 type Podcast struct {
@@ -29,15 +40,30 @@ type Podcast struct {
 	Categories    []Category `gorm:"serializer:json" validate:"lte=25"`
 	IsExplicit    bool
 	ImageURL      string `validate:"lte=1000"`
-	FeedURL       string `validate:"required,http_url,lte=1000"`
+	FeedURL       string `validate:"omitempty,http_url,lte=1000"` // empty for private podcasts hosted by CastKeeper
+	IsPremium     bool
+	QuotaBytes    int64 `validate:"gte=0"` // limits storage used by downloaded episodes, 0 is unlimited
 	LastCheckedAt *time.Time
 	LastEpisodeAt *time.Time
-	Credentials   *encryption.EncryptedValue `validate:"-" gorm:"embedded"`
+	Credentials   *encryption.EncryptedValue `validate:"-" gorm:"embedded" json:"-"`
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	DeletedAt     gorm.DeletedAt `gorm:"index"`
 }
 
+// PodcastFeed is an additional feed that episodes of a podcast are sourced
+// from, e.g. the premium feed of a show already subscribed to via its public
+// feed. The podcast's own FeedURL is its primary feed, which has ID 0.
+type PodcastFeed struct {
+	ID          uint                       `gorm:"primaryKey"`
+	PodcastGUID string                     `gorm:"uniqueIndex:idx_podcast_feed_url" validate:"required"`
+	FeedURL     string                     `gorm:"uniqueIndex:idx_podcast_feed_url" validate:"required,http_url,lte=1000"`
+	Credentials *encryption.EncryptedValue `validate:"-" gorm:"embedded" json:"-"`
+	IsPremium   bool
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
 type Category struct {
 	Name        string `validate:"required,gte=1,lte=100"`
 	SubCategory *Category
@@ -49,23 +75,64 @@ type PodcastCredentials struct {
 }
 
 type Episode struct {
-	GUID         string  `gorm:"primaryKey" validate:"required,gte=1,lte=1000"`
-	PodcastGUID  string  `validate:"required"`
-	Podcast      Podcast `validate:"-" gorm:"foreignKey:PodcastGUID"`
-	Title        string  `validate:"required,gte=1,lte=1000"`
-	Description  string  `validate:"lte=10000"`
-	DownloadURL  string  `validate:"required,http_url,lte=1000"`
-	Bytes        int64
-	MimeType     string `validate:"required,oneof=audio/mpeg audio/x-m4a video/mp4 video/quicktime"`
-	DurationSecs int    `validate:"gte=0"`
-	PublishedAt  time.Time
-	Status       string `validate:"required,oneof=pending failed success"`
-	CreatedAt    time.Time
-	UpdatedAt    time.Time
-	DeletedAt    gorm.DeletedAt `gorm:"index"`
-}
-
-var validate = validator.New(validator.WithRequiredStructEnabled())
+	GUID        string  `gorm:"primaryKey" validate:"required,gte=1,lte=1000"`
+	PodcastGUID string  `validate:"required"`
+	Podcast     Podcast `validate:"-" gorm:"foreignKey:PodcastGUID" json:"-"`
+	FeedID      uint    // 0 is the podcast's primary feed
+	Title       string  `validate:"required,gte=1,lte=1000"`
+	Description string  `validate:"lte=10000"`
+	DownloadURL string  `validate:"omitempty,http_url,lte=1000"` // empty for episodes imported from local files
+	Bytes       int64
+	// size declared by the feed's enclosure, 0 when unknown
+	EnclosureBytes int64  `validate:"gte=0"`
+	MimeType       string `validate:"required,mediatype"`
+	DurationSecs   int    `validate:"gte=0"`
+	PublishedAt    time.Time
+	Status         string `validate:"required,oneof=pending in-progress failed success paused"`
+	// progress of an in-progress download, total is -1 when unknown
+	DownloadedBytes    int64
+	DownloadTotalBytes int64
+	SHA256             string     `validate:"omitempty,len=64,hexadecimal"`
+	VerifiedAt         *time.Time // when the stored file was last checked against SHA256
+	IntegrityProblem   string     `validate:"omitempty,oneof=missing corrupted"`
+	// the blob the file is stored as, empty for files stored in the podcast's
+	// directory before content-addressed storage
+	BlobSHA256 string `gorm:"index" validate:"omitempty,len=64,hexadecimal" json:"-"`
+	// read from the downloaded file, zero when unknown
+	MediaDurationSecs int                 `validate:"gte=0"`
+	Bitrate           int                 `validate:"gte=0"` // bits per second
+	SampleRate        int                 `validate:"gte=0"`
+	Channels          int                 `validate:"gte=0"`
+	Chapters          []mediainfo.Chapter `gorm:"serializer:json" validate:"lte=1000"`
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	DeletedAt         gorm.DeletedAt `gorm:"index"`
+}
+
+// Duration is the duration of the episode in seconds, read from its
+// downloaded file when known, otherwise from its feed
+func (e Episode) Duration() int {
+	if e.MediaDurationSecs > 0 {
+		return e.MediaDurationSecs
+	}
+	return e.DurationSecs
+}
+
+// IsPrivate reports whether the podcast is hosted by CastKeeper, with
+// episodes uploaded rather than downloaded from a feed
+func (p Podcast) IsPrivate() bool {
+	return p.FeedURL == ""
+}
+
+var validate = newValidator()
+
+func newValidator() *validator.Validate {
+	v := validator.New(validator.WithRequiredStructEnabled())
+	if err := v.RegisterValidation("mediatype", validateMediaType); err != nil {
+		panic(err)
+	}
+	return v
+}
 
 func (p *Podcast) BeforeSave(tx *gorm.DB) error {
 	err := validate.Struct(p)
@@ -75,6 +142,14 @@ func (p *Podcast) BeforeSave(tx *gorm.DB) error {
 	return nil
 }
 
+func (f *PodcastFeed) BeforeSave(tx *gorm.DB) error {
+	err := validate.Struct(f)
+	if err != nil {
+		return fmt.Errorf("podcast feed not valid: %w", err)
+	}
+	return nil
+}
+
 func (e *Episode) BeforeSave(tx *gorm.DB) error {
 	err := validate.Struct(e)
 	if err != nil {
@@ -91,6 +166,8 @@ func (pc PodcastCredentials) Validate() error {
 	return nil
 }
 
+// AddPodcast adds the podcast of a feed. When a podcast with the same GUID
+// already exists, the feed is added to it instead, and addedToExisting is true.
 func AddPodcast(
 	ctx context.Context,
 	db *gorm.DB,
@@ -98,50 +175,192 @@ func AddPodcast(
 	encService *encryption.EncryptedValueService,
 	feedURL string,
 	creds *PodcastCredentials,
-) (Podcast, error) {
+	isPremium bool,
+) (Podcast, bool, error) {
 	podcast, _, err := feedService.ParseFeed(ctx, feedURL, creds)
 	if err != nil {
 		if !errors.Is(err, ParseErrors{}) {
 			framework.GetLogger(ctx).ErrorContext(ctx, "error parsing feed", "error", err)
-			return podcast, err
+			return podcast, false, err
 		}
 		framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("some episodes of podcast '%s' had parsing errors: %s", podcast.GUID, err.Error()))
 		// continue even with some episode parse failures...
 	}
 
-	if creds != nil {
-		if err := creds.Validate(); err != nil {
-			return podcast, err
-		}
+	encCreds, err := encryptCredentials(encService, podcast.FeedURL, creds)
+	if err != nil {
+		return podcast, false, err
+	}
+	podcast.Credentials = encCreds
+	podcast.IsPremium = isPremium || creds != nil
 
-		credsData, err := json.Marshal(creds)
-		if err != nil {
-			return podcast, err
-		}
+	// a podcast with the same GUID from a different feed, e.g. the premium
+	// feed of a show which is already added, becomes an additional feed
+	existing, err := GetPodcast(ctx, db, podcast.GUID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return podcast, false, err
+	}
+	if err == nil && existing.FeedURL != podcast.FeedURL {
+		_, err = addPodcastFeed(ctx, db, existing, podcast.FeedURL, encCreds, podcast.IsPremium)
+		return existing, err == nil, err
+	}
 
-		ev, err := encService.Encrypt(
-			credsData,
-			[]byte(podcast.FeedURL),
-		)
-		if err != nil {
-			return podcast, err
-		}
-		podcast.Credentials = &ev
+	// a previously removed podcast is purged so that it can be added again
+	if err = purgeDeletedPodcast(db, podcast.GUID); err != nil {
+		return podcast, false, err
 	}
 
 	if err = db.Create(&podcast).Error; err != nil {
+		return podcast, false, err
+	}
+
+	return podcast, false, nil
+}
+
+// PrivatePodcast is the metadata of a podcast created in CastKeeper
+type PrivatePodcast struct {
+	Title       string
+	Author      string
+	Description string
+}
+
+// CreatePrivatePodcast creates a podcast with no upstream feed, whose episodes
+// are uploaded to CastKeeper and served only through its CastKeeper feed.
+func CreatePrivatePodcast(ctx context.Context, db *gorm.DB, details PrivatePodcast) (Podcast, error) {
+	guid, err := uuid.NewV4()
+	if err != nil {
+		return Podcast{}, err
+	}
+
+	author := "unknown"
+	if details.Author != "" {
+		author = details.Author
+	}
+
+	podcast := Podcast{
+		GUID:        guid.String(),
+		Title:       details.Title,
+		Author:      author,
+		Description: details.Description,
+	}
+	if err := db.Create(&podcast).Error; err != nil {
 		return podcast, err
 	}
 
+	framework.GetLogger(ctx).InfoContext(ctx, fmt.Sprintf("created private podcast '%s'", podcast.GUID))
 	return podcast, nil
 }
 
+func addPodcastFeed(
+	ctx context.Context,
+	db *gorm.DB,
+	podcast Podcast,
+	feedURL string,
+	encCreds *encryption.EncryptedValue,
+	isPremium bool,
+) (PodcastFeed, error) {
+	feed := PodcastFeed{
+		PodcastGUID: podcast.GUID,
+		FeedURL:     feedURL,
+		Credentials: encCreds,
+		IsPremium:   isPremium,
+	}
+	if err := db.Create(&feed).Error; err != nil {
+		return feed, err
+	}
+
+	framework.GetLogger(ctx).InfoContext(ctx, fmt.Sprintf("added feed '%s' to existing podcast '%s'", feedURL, podcast.GUID))
+	return feed, nil
+}
+
+// importBatchSize limits the number of episodes created in each statement
+// when importing a podcast
+const importBatchSize = 100
+
+// ImportPodcast adds a podcast exported from another CastKeeper instance, with
+// its additional feeds and episodes. Feeds are given new IDs, which the
+// episodes' FeedIDs are updated to match. gorm.ErrDuplicatedKey is returned if
+// the podcast already exists.
+func ImportPodcast(ctx context.Context, db *gorm.DB, podcast Podcast, feeds []PodcastFeed, episodes []Episode) error {
+	if _, err := GetPodcast(ctx, db, podcast.GUID); err == nil {
+		return gorm.ErrDuplicatedKey
+	}
+
+	// a previously removed podcast is purged so that it can be imported
+	if err := purgeDeletedPodcast(db, podcast.GUID); err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&podcast).Error; err != nil {
+			return err
+		}
+
+		feedIDs := map[uint]uint{0: 0}
+		for _, feed := range feeds {
+			// the primary feed is stored on the podcast itself
+			if feed.ID == 0 {
+				continue
+			}
+			oldID := feed.ID
+			feed.ID = 0
+			feed.PodcastGUID = podcast.GUID
+			if err := tx.Create(&feed).Error; err != nil {
+				return err
+			}
+			feedIDs[oldID] = feed.ID
+		}
+
+		if len(episodes) == 0 {
+			return nil
+		}
+		episodes = slices.Clone(episodes)
+		for i := range episodes {
+			episodes[i].PodcastGUID = podcast.GUID
+			episodes[i].FeedID = feedIDs[episodes[i].FeedID]
+		}
+		return tx.CreateInBatches(episodes, importBatchSize).Error
+	})
+}
+
+func encryptCredentials(
+	encService *encryption.EncryptedValueService,
+	feedURL string,
+	creds *PodcastCredentials,
+) (*encryption.EncryptedValue, error) {
+	if creds == nil {
+		return nil, nil
+	}
+
+	if err := creds.Validate(); err != nil {
+		return nil, err
+	}
+
+	credsData, err := json.Marshal(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	ev, err := encService.Encrypt(
+		credsData,
+		[]byte(feedURL),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &ev, nil
+}
+
 func GetCredentials(encService *encryption.EncryptedValueService, podcast Podcast) (*PodcastCredentials, error) {
-	if podcast.Credentials == nil || len(podcast.Credentials.EncryptedData) == 0 {
+	return GetFeedCredentials(encService, PrimaryFeed(podcast))
+}
+
+func GetFeedCredentials(encService *encryption.EncryptedValueService, feed PodcastFeed) (*PodcastCredentials, error) {
+	if feed.Credentials == nil || len(feed.Credentials.EncryptedData) == 0 {
 		return nil, nil
 	}
 
-	data, err := encService.Decrypt(*podcast.Credentials, []byte(podcast.FeedURL))
+	data, err := encService.Decrypt(*feed.Credentials, []byte(feed.FeedURL))
 	if err != nil {
 		return nil, err
 	}
@@ -155,6 +374,207 @@ func GetCredentials(encService *encryption.EncryptedValueService, podcast Podcas
 	return &creds, nil
 }
 
+// ReencryptCredentials re-encrypts the credentials of every podcast and feed,
+// including removed podcasts, with the primary key of encService's DEK, e.g.
+// after the DEK is rotated. Nothing is changed unless every value is
+// re-encrypted. It returns the number of values re-encrypted.
+func ReencryptCredentials(ctx context.Context, db *gorm.DB, encService *encryption.EncryptedValueService) (int, error) {
+	count := 0
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var pods []Podcast
+		if err := tx.Unscoped().Where("encrypted_data IS NOT NULL").Find(&pods).Error; err != nil {
+			return err
+		}
+		for _, pod := range pods {
+			ev, err := reencryptCredentials(encService, pod.FeedURL, pod.Credentials)
+			if err != nil {
+				return fmt.Errorf("failed to re-encrypt credentials of podcast '%s': %w", pod.GUID, err)
+			}
+			if ev == nil {
+				continue
+			}
+			result := tx.Unscoped().
+				Model(&Podcast{}).
+				Where("guid = ?", pod.GUID).
+				UpdateColumn("encrypted_data", ev.EncryptedData)
+			if result.Error != nil {
+				return result.Error
+			}
+			count++
+		}
+
+		var feeds []PodcastFeed
+		if err := tx.Where("encrypted_data IS NOT NULL").Find(&feeds).Error; err != nil {
+			return err
+		}
+		for _, feed := range feeds {
+			ev, err := reencryptCredentials(encService, feed.FeedURL, feed.Credentials)
+			if err != nil {
+				return fmt.Errorf("failed to re-encrypt credentials of feed '%s': %w", feed.FeedURL, err)
+			}
+			if ev == nil {
+				continue
+			}
+			result := tx.
+				Model(&PodcastFeed{}).
+				Where("id = ?", feed.ID).
+				UpdateColumn("encrypted_data", ev.EncryptedData)
+			if result.Error != nil {
+				return result.Error
+			}
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// HasEncryptedCredentials reports whether any podcast or feed, including
+// removed podcasts, has encrypted credentials
+func HasEncryptedCredentials(ctx context.Context, db *gorm.DB) (bool, error) {
+	var podCount int64
+	result := db.WithContext(ctx).
+		Unscoped().
+		Model(&Podcast{}).
+		Where("encrypted_data IS NOT NULL AND length(encrypted_data) > 0").
+		Count(&podCount)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	var feedCount int64
+	result = db.WithContext(ctx).
+		Model(&PodcastFeed{}).
+		Where("encrypted_data IS NOT NULL AND length(encrypted_data) > 0").
+		Count(&feedCount)
+	if result.Error != nil {
+		return false, result.Error
+	}
+
+	return podCount+feedCount > 0, nil
+}
+
+// CheckDEKExists returns an error wrapping encryption.ErrDEKMissing if
+// encryption is configured, but the DEK is missing while encrypted credentials
+// exist, e.g. because dek.json was lost or DataPath was changed. A new DEK
+// would otherwise be created, which can't decrypt them.
+func CheckDEKExists(ctx context.Context, db *gorm.DB, cfg config.Config) error {
+	if cfg.Encryption.Driver == "" {
+		return nil
+	}
+
+	exists, err := encryption.DEKExists(cfg)
+	if err != nil || exists {
+		return err
+	}
+
+	hasCreds, err := HasEncryptedCredentials(ctx, db)
+	if err != nil {
+		return fmt.Errorf("failed to check for encrypted credentials: %w", err)
+	}
+	if hasCreds {
+		return fmt.Errorf(
+			"%w from DataPath '%s', but podcast credentials encrypted with it exist in the database, restore dek.json from a backup",
+			encryption.ErrDEKMissing, cfg.DataPath,
+		)
+	}
+	return nil
+}
+
+func reencryptCredentials(encService *encryption.EncryptedValueService, feedURL string, ev *encryption.EncryptedValue) (*encryption.EncryptedValue, error) {
+	if ev == nil || len(ev.EncryptedData) == 0 {
+		return nil, nil
+	}
+	data, err := encService.Decrypt(*ev, []byte(feedURL))
+	if err != nil {
+		return nil, err
+	}
+	reencrypted, err := encService.Encrypt(data, []byte(feedURL))
+	if err != nil {
+		return nil, err
+	}
+	return &reencrypted, nil
+}
+
+// PrimaryFeed returns the feed stored on the podcast itself, as a PodcastFeed
+func PrimaryFeed(podcast Podcast) PodcastFeed {
+	return PodcastFeed{
+		ID:          0,
+		PodcastGUID: podcast.GUID,
+		FeedURL:     podcast.FeedURL,
+		Credentials: podcast.Credentials,
+		IsPremium:   podcast.IsPremium,
+	}
+}
+
+// ListFeeds returns every feed of a podcast, including the primary feed unless
+// the podcast is private, with premium feeds first so that they are preferred
+// when episodes are duplicated.
+func ListFeeds(ctx context.Context, db *gorm.DB, podcast Podcast) ([]PodcastFeed, error) {
+	var additional []PodcastFeed
+	result := db.
+		Order("id asc").
+		Find(&additional, "podcast_guid = ?", podcast.GUID)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	feeds := additional
+	if !podcast.IsPrivate() {
+		feeds = append([]PodcastFeed{PrimaryFeed(podcast)}, additional...)
+	}
+	slices.SortStableFunc(feeds, func(a, b PodcastFeed) int {
+		if a.IsPremium == b.IsPremium {
+			return 0
+		}
+		if a.IsPremium {
+			return -1
+		}
+		return 1
+	})
+	return feeds, nil
+}
+
+func GetFeed(ctx context.Context, db *gorm.DB, podcast Podcast, feedID uint) (PodcastFeed, error) {
+	if feedID == 0 {
+		return PrimaryFeed(podcast), nil
+	}
+
+	var feed PodcastFeed
+	result := db.First(&feed, "id = ? AND podcast_guid = ?", feedID, podcast.GUID)
+	if result.Error != nil {
+		return feed, result.Error
+	}
+	return feed, nil
+}
+
+// IsDuplicateEpisode reports whether two episodes are the same episode, e.g.
+// published in both the public and premium feeds of a podcast. Episodes match
+// on GUID, or when they are from different feeds, on enclosure URL, or title
+// and publish date. Within one feed, items with the same title on the same day
+// (e.g. "Bonus") are different episodes.
+func IsDuplicateEpisode(a, b Episode) bool {
+	if a.GUID == b.GUID {
+		return true
+	}
+	if a.FeedID == b.FeedID {
+		return false
+	}
+	if a.DownloadURL != "" && a.DownloadURL == b.DownloadURL {
+		return true
+	}
+	if a.PublishedAt.IsZero() || b.PublishedAt.IsZero() {
+		return false
+	}
+	ay, am, ad := a.PublishedAt.UTC().Date()
+	by, bm, bd := b.PublishedAt.UTC().Date()
+	return ay == by && am == bm && ad == bd &&
+		strings.EqualFold(strings.TrimSpace(a.Title), strings.TrimSpace(b.Title))
+}
+
 func ListPodcasts(ctx context.Context, db *gorm.DB) ([]Podcast, error) {
 	var podcasts []Podcast
 	result := db.Find(&podcasts)
@@ -175,6 +595,79 @@ func ListEpisodes(ctx context.Context, db *gorm.DB, podcastGUID string) ([]Episo
 	return episodes, nil
 }
 
+// ListEpisodesByStatus lists episodes with the given status. When podcastGUID
+// is empty, episodes of all podcasts are listed.
+func ListEpisodesByStatus(ctx context.Context, db *gorm.DB, podcastGUID, status string) ([]Episode, error) {
+	query := db.Where("status = ?", status)
+	if podcastGUID != "" {
+		query = query.Where("podcast_guid = ?", podcastGUID)
+	}
+
+	var episodes []Episode
+	result := query.
+		Order("published_at desc").
+		Find(&episodes)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return episodes, nil
+}
+
+func CountEpisodesByStatus(ctx context.Context, db *gorm.DB, status string) (int64, error) {
+	var count int64
+	result := db.
+		Model(&Episode{}).
+		Where("status = ?", status).
+		Count(&count)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return count, nil
+}
+
+// DeletePodcast removes a podcast, its episodes and its additional feeds.
+// Downloaded files are left in object storage.
+func DeletePodcast(ctx context.Context, db *gorm.DB, guid string) error {
+	podcast, err := GetPodcast(ctx, db, guid)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&PodcastFeed{}, "podcast_guid = ?", podcast.GUID).Error; err != nil {
+			return err
+		}
+		if err := releaseEpisodeBlobs(ctx, tx, "podcast_guid = ?", podcast.GUID); err != nil {
+			return err
+		}
+		if err := tx.Delete(&Episode{}, "podcast_guid = ?", podcast.GUID).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&podcast).Error; err != nil {
+			return err
+		}
+		return nil
+	})
+}
+
+func purgeDeletedPodcast(db *gorm.DB, guid string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().
+			Where("podcast_guid = ? AND deleted_at IS NOT NULL", guid).
+			Delete(&Episode{})
+		if result.Error != nil {
+			return result.Error
+		}
+		result = tx.Unscoped().
+			Where("guid = ? AND deleted_at IS NOT NULL", guid).
+			Delete(&Podcast{})
+		if result.Error != nil {
+			return result.Error
+		}
+		return nil
+	})
+}
+
 func UpdatePodcastTimes(ctx context.Context, db *gorm.DB, podcast *Podcast, lastCheckedAt, lastEpisodeAt *time.Time) error {
 	result := db.
 		Model(podcast).
@@ -204,6 +697,41 @@ func GetEpisode(ctx context.Context, db *gorm.DB, guid string) (Episode, error)
 	return episode, nil
 }
 
+// DeleteEpisode removes an episode from the archive. The episode is kept as
+// deleted, so that it is not downloaded again when its feed is next checked.
+func DeleteEpisode(ctx context.Context, db *gorm.DB, guid string) error {
+	episode, err := GetEpisode(ctx, db, guid)
+	if err != nil {
+		return err
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := releaseEpisodeBlobs(ctx, tx, "guid = ?", episode.GUID); err != nil {
+			return err
+		}
+		return tx.Delete(&episode).Error
+	})
+}
+
+// releaseEpisodeBlobs releases the blobs of the episodes matching the query,
+// once for each episode, before they are deleted
+func releaseEpisodeBlobs(ctx context.Context, tx *gorm.DB, query string, args ...any) error {
+	var shas []string
+	result := tx.
+		Model(&Episode{}).
+		Where(query, args...).
+		Where("blob_sha256 <> ''").
+		Pluck("blob_sha256", &shas)
+	if result.Error != nil {
+		return result.Error
+	}
+	for _, sha := range shas {
+		if err := ReleaseBlob(ctx, tx, sha); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func UpdateEpisodeStatus(ctx context.Context, db *gorm.DB, episode *Episode, status string, fileBytes *int64) error {
 	fields := []string{"Status"}
 	epUpdate := Episode{Status: status}
@@ -221,3 +749,129 @@ func UpdateEpisodeStatus(ctx context.Context, db *gorm.DB, episode *Episode, sta
 	}
 	return nil
 }
+
+// UpdateEpisodeDownloaded marks an episode as successfully downloaded, with the
+// size, hash and detected MIME type of the saved file
+func UpdateEpisodeDownloaded(ctx context.Context, db *gorm.DB, episode *Episode, fileBytes int64, sha256, mimeType string) error {
+	now := time.Now()
+	result := db.
+		Model(episode).
+		Select("Status", "Bytes", "MimeType", "SHA256", "VerifiedAt", "IntegrityProblem").
+		Updates(Episode{
+			Status:           EpisodeStatusSuccess,
+			Bytes:            fileBytes,
+			MimeType:         mimeType,
+			SHA256:           sha256,
+			VerifiedAt:       &now,
+			IntegrityProblem: "",
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// UpdateEpisodeMediaInfo records the duration and technical metadata read
+// from an episode's downloaded file
+func UpdateEpisodeMediaInfo(ctx context.Context, db *gorm.DB, episode *Episode, info mediainfo.Info) error {
+	result := db.
+		Model(episode).
+		Select("MediaDurationSecs", "Bitrate", "SampleRate", "Channels", "Chapters").
+		Updates(Episode{
+			MediaDurationSecs: info.DurationSecs,
+			Bitrate:           info.Bitrate,
+			SampleRate:        info.SampleRate,
+			Channels:          info.Channels,
+			Chapters:          info.Chapters,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// UpdateEpisodeIntegrity records the result of checking an episode's stored
+// file, where problem is empty if the file is intact
+func UpdateEpisodeIntegrity(ctx context.Context, db *gorm.DB, episode *Episode, sha256, problem string) error {
+	now := time.Now()
+	result := db.
+		Model(episode).
+		Select("SHA256", "VerifiedAt", "IntegrityProblem").
+		Updates(Episode{
+			SHA256:           sha256,
+			VerifiedAt:       &now,
+			IntegrityProblem: problem,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// ListEpisodesToVerify lists successfully downloaded episodes which have not
+// been verified since the given time, oldest first
+func ListEpisodesToVerify(ctx context.Context, db *gorm.DB, verifiedBefore time.Time, limit int) ([]Episode, error) {
+	var episodes []Episode
+	result := db.
+		Where("status = ?", EpisodeStatusSuccess).
+		Where("verified_at IS NULL OR verified_at < ?", verifiedBefore).
+		Order("verified_at asc").
+		Limit(limit).
+		Find(&episodes)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return episodes, nil
+}
+
+// UpdateEpisodeProgress records the progress of an episode's download, and
+// marks it as in progress
+func UpdateEpisodeProgress(ctx context.Context, db *gorm.DB, episode *Episode, downloadedBytes, totalBytes int64) error {
+	result := db.
+		Model(episode).
+		Select("Status", "DownloadedBytes", "DownloadTotalBytes").
+		Updates(Episode{
+			Status:             EpisodeStatusInProgress,
+			DownloadedBytes:    downloadedBytes,
+			DownloadTotalBytes: totalBytes,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// UpdateEpisodesStatus sets the status of many episodes in a single update
+func UpdateEpisodesStatus(ctx context.Context, db *gorm.DB, guids []string, status string) error {
+	if !slices.Contains([]string{EpisodeStatusPending, EpisodeStatusInProgress, EpisodeStatusSuccess, EpisodeStatusFailed, EpisodeStatusPaused}, status) {
+		return fmt.Errorf("invalid episode status '%s'", status)
+	}
+	// hooks are skipped, as BeforeSave would validate an empty Episode
+	result := db.
+		Session(&gorm.Session{SkipHooks: true}).
+		Model(&Episode{}).
+		Where("guid IN ?", guids).
+		Update("status", status)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// UpdateEpisodeSource points an episode at a different feed's copy of it, e.g.
+// the premium version, and marks it pending so that it is downloaded again.
+func UpdateEpisodeSource(ctx context.Context, db *gorm.DB, episode *Episode, feedID uint, downloadURL, mimeType string) error {
+	result := db.
+		Model(episode).
+		Select("FeedID", "DownloadURL", "MimeType", "Status").
+		Updates(Episode{
+			FeedID:      feedID,
+			DownloadURL: downloadURL,
+			MimeType:    mimeType,
+			Status:      EpisodeStatusPending,
+		})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
@@ -2,22 +2,29 @@ package podcasts_test
 
 import (
 	"context"
+	"encoding/json"
+	"os"
+	"path"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/config"
 	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
 	"github.com/webbgeorge/castkeeper/pkg/fixtures"
 	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"gorm.io/gorm"
 )
 
 func TestAddPodcast_Success(t *testing.T) {
 	db := fixtures.ConfigureDBForTestWithFixtures()
 
 	feedURL := "http://testdata/feeds/valid-not-added.xml"
-	pod, err := podcasts.AddPodcast(
-		context.Background(), db, feedService(), evs(), feedURL, nil)
+	pod, addedToExisting, err := podcasts.AddPodcast(
+		context.Background(), db, feedService(), evs(), feedURL, nil, false)
 
 	assert.Nil(t, err)
+	assert.False(t, addedToExisting)
 	assert.Equal(t, "Test podcast 2", pod.Title)
 
 	// assert pod was saved to DB
@@ -30,9 +37,9 @@ func TestAddPodcast_SuccessAuthenticatedFeed(t *testing.T) {
 	db := fixtures.ConfigureDBForTestWithFixtures()
 
 	feedURL := "http://testdata/authenticated/feeds/valid-not-added.xml"
-	pod, err := podcasts.AddPodcast(
+	pod, _, err := podcasts.AddPodcast(
 		context.Background(), db, feedService(),
-		evs(), feedURL, &fixtures.AuthenticatedFeedCreds)
+		evs(), feedURL, &fixtures.AuthenticatedFeedCreds, false)
 
 	assert.Nil(t, err)
 	assert.Equal(t, "Test authenticated podcast 2", pod.Title)
@@ -47,8 +54,8 @@ func TestAddPodcast_InvalidFeed(t *testing.T) {
 	db := fixtures.ConfigureDBForTestWithFixtures()
 
 	feedURL := "http://testdata/feeds/invalid.xml"
-	_, err := podcasts.AddPodcast(
-		context.Background(), db, feedService(), evs(), feedURL, nil)
+	_, _, err := podcasts.AddPodcast(
+		context.Background(), db, feedService(), evs(), feedURL, nil, false)
 
 	assert.Equal(t, "failed to parse feed: EOF", err.Error())
 }
@@ -61,13 +68,189 @@ func TestAddPodcast_InvalidCredentials(t *testing.T) {
 		Username: "invalid",
 		Password: "invalid",
 	}
-	_, err := podcasts.AddPodcast(
+	_, _, err := podcasts.AddPodcast(
 		context.Background(), db, feedService(),
-		evs(), feedURL, &invalidCreds)
+		evs(), feedURL, &invalidCreds, false)
 
 	assert.Equal(t, "failed to parse feed: non-200 http response '401'", err.Error())
 }
 
+func TestAddPodcast_GetExistingError(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	if err := db.Migrator().DropTable(&podcasts.Podcast{}); err != nil {
+		panic(err)
+	}
+
+	feedURL := "http://testdata/feeds/valid-not-added.xml"
+	_, _, err := podcasts.AddPodcast(
+		context.Background(), db, feedService(), evs(), feedURL, nil, false)
+
+	assert.ErrorContains(t, err, "no such table: podcasts")
+}
+
+func TestAddPodcast_AdditionalFeedForExistingPodcast(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+
+	// shares podcast:guid with the valid.xml fixture, which is already added
+	feedURL := "http://testdata/feeds/valid-premium.xml"
+	pod, addedToExisting, err := podcasts.AddPodcast(
+		context.Background(), db, feedService(), evs(), feedURL, nil, true)
+
+	assert.Nil(t, err)
+	assert.True(t, addedToExisting)
+	assert.Equal(t, fixtures.PodEpGUID("abc-123"), pod.GUID)
+	assert.Equal(t, "http://testdata/feeds/valid.xml", pod.FeedURL)
+
+	feeds, err := podcasts.ListFeeds(context.Background(), db, pod)
+	assert.Nil(t, err)
+	assert.Len(t, feeds, 2)
+	// premium feed is listed first
+	assert.Equal(t, feedURL, feeds[0].FeedURL)
+	assert.True(t, feeds[0].IsPremium)
+	assert.Equal(t, "http://testdata/feeds/valid.xml", feeds[1].FeedURL)
+	assert.Equal(t, uint(0), feeds[1].ID)
+}
+
+func TestAddPodcast_AlreadyAddedFeed(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+
+	feedURL := "http://testdata/feeds/valid-premium.xml"
+	_, _, err := podcasts.AddPodcast(
+		context.Background(), db, feedService(), evs(), feedURL, nil, true)
+	assert.Nil(t, err)
+
+	_, _, err = podcasts.AddPodcast(
+		context.Background(), db, feedService(), evs(), feedURL, nil, true)
+	assert.ErrorIs(t, err, gorm.ErrDuplicatedKey)
+
+	_, _, err = podcasts.AddPodcast(
+		context.Background(), db, feedService(), evs(), "http://testdata/feeds/valid.xml", nil, false)
+	assert.ErrorIs(t, err, gorm.ErrDuplicatedKey)
+}
+
+func TestCreatePrivatePodcast(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+
+	pod, err := podcasts.CreatePrivatePodcast(context.Background(), db, podcasts.PrivatePodcast{
+		Title:       "Team meetings",
+		Description: "Recordings of team meetings",
+	})
+
+	assert.Nil(t, err)
+	assert.True(t, pod.IsPrivate())
+
+	dbPod, err := podcasts.GetPodcast(context.Background(), db, pod.GUID)
+	assert.Nil(t, err)
+	assert.Equal(t, "Team meetings", dbPod.Title)
+	assert.Equal(t, "unknown", dbPod.Author)
+	assert.Equal(t, "", dbPod.FeedURL)
+}
+
+func TestCreatePrivatePodcast_Invalid(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+
+	_, err := podcasts.CreatePrivatePodcast(context.Background(), db, podcasts.PrivatePodcast{})
+
+	assert.ErrorContains(t, err, "podcast not valid")
+}
+
+func TestDeletePodcast(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	podGUID := fixtures.PodEpGUID("abc-123")
+
+	err := podcasts.DeletePodcast(context.Background(), db, podGUID)
+	assert.Nil(t, err)
+
+	_, err = podcasts.GetPodcast(context.Background(), db, podGUID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	eps, err := podcasts.ListEpisodes(context.Background(), db, podGUID)
+	assert.Nil(t, err)
+	assert.Len(t, eps, 0)
+
+	// can be added again after being deleted
+	pod, _, err := podcasts.AddPodcast(
+		context.Background(), db, feedService(), evs(), "http://testdata/feeds/valid.xml", nil, false)
+	assert.Nil(t, err)
+	assert.Equal(t, podGUID, pod.GUID)
+}
+
+func TestDeletePodcast_NotFound(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+
+	err := podcasts.DeletePodcast(context.Background(), db, "not-a-pod")
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestDeleteEpisode(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	epGUID := fixtures.PodEpGUID("ep-1")
+
+	err := podcasts.DeleteEpisode(context.Background(), db, epGUID)
+	assert.Nil(t, err)
+
+	_, err = podcasts.GetEpisode(context.Background(), db, epGUID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	// deleted episodes are still listed when including deleted
+	eps, err := podcasts.ListEpisodes(context.Background(), db.Unscoped(), fixtures.PodEpGUID("abc-123"))
+	assert.Nil(t, err)
+	assert.Len(t, eps, 2)
+}
+
+func TestIsDuplicateEpisode(t *testing.T) {
+	pubAt := time.Date(2024, 12, 26, 11, 12, 13, 0, time.UTC)
+	ep := podcasts.Episode{
+		GUID:        "ep-1",
+		Title:       "Episode one",
+		DownloadURL: "http://example.com/ep-1.mp3",
+		PublishedAt: pubAt,
+	}
+
+	testCases := map[string]struct {
+		other    podcasts.Episode
+		expected bool
+	}{
+		"same GUID": {
+			other:    podcasts.Episode{GUID: "ep-1"},
+			expected: true,
+		},
+		"same enclosure URL": {
+			other:    podcasts.Episode{GUID: "premium-ep-1", FeedID: 1, DownloadURL: "http://example.com/ep-1.mp3"},
+			expected: true,
+		},
+		"same title and date": {
+			other:    podcasts.Episode{GUID: "premium-ep-1", FeedID: 1, Title: " episode ONE", PublishedAt: pubAt.Add(time.Hour * 6)},
+			expected: true,
+		},
+		"same title different date": {
+			other:    podcasts.Episode{GUID: "premium-ep-1", FeedID: 1, Title: "Episode one", PublishedAt: pubAt.Add(time.Hour * 24)},
+			expected: false,
+		},
+		"same title no date": {
+			other:    podcasts.Episode{GUID: "premium-ep-1", FeedID: 1, Title: "Episode one"},
+			expected: false,
+		},
+		"same feed same title and date": {
+			other:    podcasts.Episode{GUID: "ep-1-bonus", Title: "Episode one", PublishedAt: pubAt},
+			expected: false,
+		},
+		"same feed same enclosure URL": {
+			other:    podcasts.Episode{GUID: "ep-1-rerun", DownloadURL: "http://example.com/ep-1.mp3"},
+			expected: false,
+		},
+		"different episode": {
+			other:    podcasts.Episode{GUID: "ep-2", FeedID: 1, Title: "Episode two", DownloadURL: "http://example.com/ep-2.mp3", PublishedAt: pubAt},
+			expected: false,
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, podcasts.IsDuplicateEpisode(ep, tc.other))
+		})
+	}
+}
+
 func TestGetCredentials(t *testing.T) {
 	feedURL := "http://example.com/feed"
 	encCreds, err := evs().Encrypt(
@@ -114,6 +297,123 @@ func TestGetCredentials_FailedToDecrypt(t *testing.T) {
 	assert.Equal(t, "aes_gcm_siv: message authentication failure", err.Error())
 }
 
+func TestReencryptCredentials(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	pod := getAuthenticatedPodcast(db)
+	feedURL := "http://testdata/authenticated/feeds/valid-not-added.xml"
+	credsData, err := json.Marshal(fixtures.AuthenticatedFeedCreds)
+	if err != nil {
+		panic(err)
+	}
+	encCreds, err := evs().Encrypt(credsData, []byte(feedURL))
+	if err != nil {
+		panic(err)
+	}
+	feed := podcasts.PodcastFeed{
+		PodcastGUID: pod.GUID,
+		FeedURL:     feedURL,
+		Credentials: &encCreds,
+	}
+	if err := db.Create(&feed).Error; err != nil {
+		panic(err)
+	}
+
+	count, err := podcasts.ReencryptCredentials(ctx, db, evs())
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+
+	updatedPod := getAuthenticatedPodcast(db)
+	assert.NotEqual(t, pod.Credentials.EncryptedData, updatedPod.Credentials.EncryptedData)
+	creds, err := podcasts.GetCredentials(evs(), updatedPod)
+	assert.Nil(t, err)
+	assert.Equal(t, fixtures.AuthenticatedFeedCreds, *creds)
+
+	var updatedFeed podcasts.PodcastFeed
+	if err := db.First(&updatedFeed, feed.ID).Error; err != nil {
+		panic(err)
+	}
+	assert.NotEqual(t, feed.Credentials.EncryptedData, updatedFeed.Credentials.EncryptedData)
+	creds, err = podcasts.GetFeedCredentials(evs(), updatedFeed)
+	assert.Nil(t, err)
+	assert.Equal(t, fixtures.AuthenticatedFeedCreds, *creds)
+}
+
+func TestReencryptCredentials_FailedToDecrypt(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	pod := getAuthenticatedPodcast(db)
+	otherAEAD, err := encryption.DeriveAEADFromSecret("11111111")
+	if err != nil {
+		panic(err)
+	}
+
+	count, err := podcasts.ReencryptCredentials(
+		context.Background(), db, encryption.NewEncryptedValueService(otherAEAD))
+
+	assert.Contains(t, err.Error(), "failed to re-encrypt credentials of podcast")
+	assert.Equal(t, 0, count)
+	assert.Equal(t, pod.Credentials.EncryptedData, getAuthenticatedPodcast(db).Credentials.EncryptedData)
+}
+
+func TestHasEncryptedCredentials(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+
+	hasCreds, err := podcasts.HasEncryptedCredentials(context.Background(), db)
+	assert.Nil(t, err)
+	assert.True(t, hasCreds)
+
+	// removed podcasts still have credentials
+	if err := podcasts.DeletePodcast(context.Background(), db, getAuthenticatedPodcast(db).GUID); err != nil {
+		panic(err)
+	}
+	hasCreds, err = podcasts.HasEncryptedCredentials(context.Background(), db)
+	assert.Nil(t, err)
+	assert.True(t, hasCreds)
+
+	if err := db.Exec("UPDATE podcasts SET encrypted_data = NULL").Error; err != nil {
+		panic(err)
+	}
+	hasCreds, err = podcasts.HasEncryptedCredentials(context.Background(), db)
+	assert.Nil(t, err)
+	assert.False(t, hasCreds)
+}
+
+func TestCheckDEKExists(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	cfg := config.Config{
+		DataPath: path.Join(os.TempDir(), "castkeepertest", fixtures.RandomHex()),
+		Encryption: config.EncryptionConfig{
+			Driver:    config.EncryptionDriverSecretKey,
+			SecretKey: "secretKeyForTest111",
+		},
+	}
+
+	// credentials exist, but the DEK doesn't
+	err := podcasts.CheckDEKExists(ctx, db, cfg)
+	assert.ErrorIs(t, err, encryption.ErrDEKMissing)
+
+	if _, err := encryption.ConfigureEncryptedValueService(cfg); err != nil {
+		panic(err)
+	}
+	assert.Nil(t, podcasts.CheckDEKExists(ctx, db, cfg))
+
+	// encryption isn't configured, so a DEK would never be created
+	cfg.Encryption = config.EncryptionConfig{}
+	cfg.DataPath = path.Join(os.TempDir(), "castkeepertest", fixtures.RandomHex())
+	assert.Nil(t, podcasts.CheckDEKExists(ctx, db, cfg))
+}
+
+func getAuthenticatedPodcast(db *gorm.DB) podcasts.Podcast {
+	var pod podcasts.Podcast
+	err := db.First(&pod, "feed_url = ?", "http://testdata/authenticated/feeds/valid.xml").Error
+	if err != nil {
+		panic(err)
+	}
+	return pod
+}
+
 func evs() *encryption.EncryptedValueService {
 	return fixtures.ConfigureEncryptedValueServiceForTest()
 }
@@ -0,0 +1,86 @@
+package podcasts
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// SniffLength is the number of bytes from the start of a file needed to sniff
+// its MIME type
+const SniffLength = 512
+
+var ErrNotMedia = errors.New("content is not a supported media type")
+
+// SniffMIMEType detects the MIME type of a file from its first bytes. Media
+// formats are detected from their signatures, other content falls back to
+// http.DetectContentType, e.g. "text/html; charset=utf-8".
+func SniffMIMEType(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("ID3")):
+		return "audio/mpeg"
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0:
+		// MPEG audio frame sync, where layer bits of 0 indicate AAC (ADTS)
+		if head[1]&0x06 == 0 {
+			return "audio/aac"
+		}
+		return "audio/mpeg"
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		switch string(head[8:12]) {
+		case "M4A ", "M4B ", "M4P ":
+			return "audio/x-m4a"
+		case "M4V ", "M4VH", "M4VP":
+			return "video/x-m4v"
+		case "qt  ":
+			return "video/quicktime"
+		default:
+			return "video/mp4"
+		}
+	case len(head) >= 8 && slices.Contains([]string{"moov", "mdat", "wide", "free", "skip"}, string(head[4:8])):
+		// QuickTime files without a ftyp box
+		return "video/quicktime"
+	case bytes.HasPrefix(head, []byte("OggS")):
+		// the first page of an Ogg Opus stream starts with its OpusHead packet
+		if len(head) >= 36 && string(head[28:36]) == "OpusHead" {
+			return "audio/opus"
+		}
+		return "audio/ogg"
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		return "video/webm"
+	default:
+		return http.DetectContentType(head)
+	}
+}
+
+// CheckMediaContent checks the first bytes of a downloaded file against the
+// MIME type it was declared as, e.g. by its feed enclosure. It returns the MIME
+// type the file should be stored as, which differs from declared when the file
+// is a different supported format. ErrNotMedia is returned when the file is
+// not a supported media format, e.g. an HTML error page. Unrecognised binary
+// content is assumed to be the declared type.
+func CheckMediaContent(declared string, head []byte) (string, error) {
+	sniffed := SniffMIMEType(head)
+
+	if sniffed == declared || sameContainer(declared, sniffed) {
+		return declared, nil
+	}
+	if _, err := MIMETypeExtension(sniffed); err == nil {
+		return sniffed, nil
+	}
+	if sniffed == "application/octet-stream" {
+		return declared, nil
+	}
+	return "", fmt.Errorf("%w: detected '%s', expected '%s'", ErrNotMedia, sniffed, declared)
+}
+
+// sameContainer reports whether two MIME types are supported media types which
+// are stored in the same container format, e.g. M4A and MP4
+func sameContainer(a, b string) bool {
+	mtA, okA := LookupMediaType(a)
+	mtB, okB := LookupMediaType(b)
+	return okA && okB && mtA.Container == mtB.Container
+}
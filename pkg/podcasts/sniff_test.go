@@ -0,0 +1,148 @@
+package podcasts_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+)
+
+func TestSniffMIMEType(t *testing.T) {
+	testCases := map[string]struct {
+		head             []byte
+		expectedMIMEType string
+	}{
+		"mp3WithID3": {
+			head:             []byte("ID3\x04\x00\x00\x00\x00\x00\x00"),
+			expectedMIMEType: "audio/mpeg",
+		},
+		"mp3FrameSync": {
+			head:             []byte{0xFF, 0xFB, 0x90, 0x64},
+			expectedMIMEType: "audio/mpeg",
+		},
+		"aacADTS": {
+			head:             []byte{0xFF, 0xF1, 0x50, 0x80},
+			expectedMIMEType: "audio/aac",
+		},
+		"m4a": {
+			head:             []byte("\x00\x00\x00\x18ftypM4A \x00\x00\x00\x00"),
+			expectedMIMEType: "audio/x-m4a",
+		},
+		"mp4": {
+			head:             []byte("\x00\x00\x00\x18ftypisom\x00\x00\x00\x00"),
+			expectedMIMEType: "video/mp4",
+		},
+		"quicktime": {
+			head:             []byte("\x00\x00\x00\x14ftypqt  \x00\x00\x00\x00"),
+			expectedMIMEType: "video/quicktime",
+		},
+		"quicktimeWithoutFtyp": {
+			head:             []byte("\x00\x00\x00\x08wide\x00\x00\x00\x00"),
+			expectedMIMEType: "video/quicktime",
+		},
+		"m4v": {
+			head:             []byte("\x00\x00\x00\x18ftypM4V \x00\x00\x00\x00"),
+			expectedMIMEType: "video/x-m4v",
+		},
+		"ogg": {
+			head:             []byte("OggS\x00\x02\x00\x00"),
+			expectedMIMEType: "audio/ogg",
+		},
+		"opus": {
+			head:             append([]byte("OggS"), append(make([]byte, 24), []byte("OpusHead")...)...),
+			expectedMIMEType: "audio/opus",
+		},
+		"flac": {
+			head:             []byte("fLaC\x00\x00\x00\x22"),
+			expectedMIMEType: "audio/flac",
+		},
+		"webm": {
+			head:             []byte{0x1A, 0x45, 0xDF, 0xA3, 0x01, 0x00},
+			expectedMIMEType: "video/webm",
+		},
+		"html": {
+			head:             []byte("<!DOCTYPE html><html><body>Subscribe</body></html>"),
+			expectedMIMEType: "text/html; charset=utf-8",
+		},
+		"empty": {
+			head:             []byte{},
+			expectedMIMEType: "text/plain; charset=utf-8",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expectedMIMEType, podcasts.SniffMIMEType(tc.head))
+		})
+	}
+}
+
+func TestCheckMediaContent(t *testing.T) {
+	testCases := map[string]struct {
+		declared         string
+		head             []byte
+		expectedErr      error
+		expectedMIMEType string
+	}{
+		"matchesDeclared": {
+			declared:         "audio/mpeg",
+			head:             []byte("ID3\x04\x00"),
+			expectedErr:      nil,
+			expectedMIMEType: "audio/mpeg",
+		},
+		"keepsDeclaredWithinMP4Family": {
+			declared:         "video/mp4",
+			head:             []byte("\x00\x00\x00\x18ftypM4A \x00\x00\x00\x00"),
+			expectedErr:      nil,
+			expectedMIMEType: "video/mp4",
+		},
+		"keepsDeclaredWithinOggContainer": {
+			declared:         "audio/ogg",
+			head:             append([]byte("OggS"), append(make([]byte, 24), []byte("OpusHead")...)...),
+			expectedErr:      nil,
+			expectedMIMEType: "audio/ogg",
+		},
+		"correctsToFLAC": {
+			declared:         "audio/mpeg",
+			head:             []byte("fLaC\x00\x00\x00\x22"),
+			expectedErr:      nil,
+			expectedMIMEType: "audio/flac",
+		},
+		"correctsToSupportedType": {
+			declared:         "audio/mpeg",
+			head:             []byte("\x00\x00\x00\x18ftypM4A \x00\x00\x00\x00"),
+			expectedErr:      nil,
+			expectedMIMEType: "audio/x-m4a",
+		},
+		"keepsDeclaredForUnknownBinary": {
+			declared:         "audio/mpeg",
+			head:             []byte{0x00, 0x01, 0x02, 0x03, 0x04},
+			expectedErr:      nil,
+			expectedMIMEType: "audio/mpeg",
+		},
+		"rejectsHTML": {
+			declared:         "audio/mpeg",
+			head:             []byte("<html><body>Please log in</body></html>"),
+			expectedErr:      podcasts.ErrNotMedia,
+			expectedMIMEType: "",
+		},
+		"rejectsText": {
+			declared:         "audio/mpeg",
+			head:             []byte("Not found"),
+			expectedErr:      podcasts.ErrNotMedia,
+			expectedMIMEType: "",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			mimeType, err := podcasts.CheckMediaContent(tc.declared, tc.head)
+			if tc.expectedErr != nil {
+				assert.ErrorIs(t, err, tc.expectedErr)
+			} else {
+				assert.Nil(t, err)
+			}
+			assert.Equal(t, tc.expectedMIMEType, mimeType)
+		})
+	}
+}
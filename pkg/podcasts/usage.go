@@ -0,0 +1,94 @@
+package podcasts
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// StorageUsage is the storage used by the downloaded episodes of a podcast, or
+// of all podcasts when PodcastGUID is empty
+type StorageUsage struct {
+	PodcastGUID string
+	Episodes    int64
+	Bytes       int64
+}
+
+// GetStorageUsage totals the size of the downloaded episodes of a podcast, or
+// of all podcasts when podcastGUID is empty. A file shared by episodes of
+// different podcasts counts towards each of them, but only once towards the
+// total of all podcasts, as it is only stored once.
+func GetStorageUsage(ctx context.Context, db *gorm.DB, podcastGUID string) (StorageUsage, error) {
+	if podcastGUID == "" {
+		return getTotalStorageUsage(ctx, db)
+	}
+
+	var usage StorageUsage
+	result := db.
+		Model(&Episode{}).
+		Select("COUNT(*) AS episodes, COALESCE(SUM(bytes), 0) AS bytes").
+		Where("status = ? AND podcast_guid = ?", EpisodeStatusSuccess, podcastGUID).
+		Scan(&usage)
+	if result.Error != nil {
+		return usage, result.Error
+	}
+	usage.PodcastGUID = podcastGUID
+	return usage, nil
+}
+
+// getTotalStorageUsage totals the size of blobs which are in use, and of
+// downloaded episodes which aren't stored as blobs
+func getTotalStorageUsage(ctx context.Context, db *gorm.DB) (StorageUsage, error) {
+	var usage StorageUsage
+	result := db.
+		Model(&Episode{}).
+		Select("COUNT(*) AS episodes, COALESCE(SUM(CASE WHEN COALESCE(blob_sha256, '') = '' THEN bytes ELSE 0 END), 0) AS bytes").
+		Where("status = ?", EpisodeStatusSuccess).
+		Scan(&usage)
+	if result.Error != nil {
+		return usage, result.Error
+	}
+
+	var blobBytes int64
+	result = db.
+		Model(&Blob{}).
+		Select("COALESCE(SUM(bytes), 0)").
+		Where("ref_count > ?", 0).
+		Scan(&blobBytes)
+	if result.Error != nil {
+		return usage, result.Error
+	}
+	usage.Bytes += blobBytes
+	return usage, nil
+}
+
+// ListStorageUsage totals the size of the downloaded episodes of each podcast,
+// where files shared by episodes count towards each of their podcasts.
+// Podcasts with no downloaded episodes are not listed.
+func ListStorageUsage(ctx context.Context, db *gorm.DB) ([]StorageUsage, error) {
+	var usage []StorageUsage
+	result := db.
+		Model(&Episode{}).
+		Select("podcast_guid, COUNT(*) AS episodes, COALESCE(SUM(bytes), 0) AS bytes").
+		Where("status = ?", EpisodeStatusSuccess).
+		Group("podcast_guid").
+		Order("bytes desc").
+		Scan(&usage)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return usage, nil
+}
+
+// UpdatePodcastQuota limits the storage used by a podcast's downloaded
+// episodes, where 0 is unlimited
+func UpdatePodcastQuota(ctx context.Context, db *gorm.DB, podcast *Podcast, quotaBytes int64) error {
+	result := db.
+		Model(podcast).
+		Select("QuotaBytes").
+		Updates(Podcast{QuotaBytes: quotaBytes})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
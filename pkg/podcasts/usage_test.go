@@ -0,0 +1,116 @@
+package podcasts_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"gorm.io/gorm"
+)
+
+func TestGetStorageUsage(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	setEpisodeBytes(db, fixtures.PodEpGUID("ep-1"), 100)
+	setEpisodeBytes(db, fixtures.PodEpGUID("ep-2"), 250)
+	// pending episodes are not counted
+	setEpisodeBytes(db, fixtures.PodEpGUID("pending-ep-1"), 1000)
+
+	usage, err := podcasts.GetStorageUsage(context.Background(), db, fixtures.PodEpGUID("abc-123"))
+	assert.Nil(t, err)
+	assert.Equal(t, podcasts.StorageUsage{PodcastGUID: fixtures.PodEpGUID("abc-123"), Episodes: 2, Bytes: 350}, usage)
+
+	usage, err = podcasts.GetStorageUsage(context.Background(), db, fixtures.PodEpGUID("pod-eps-pending"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), usage.Episodes)
+	assert.Equal(t, int64(0), usage.Bytes)
+
+	usage, err = podcasts.GetStorageUsage(context.Background(), db, "")
+	assert.Nil(t, err)
+	assert.Equal(t, podcasts.StorageUsage{Episodes: 2, Bytes: 350}, usage)
+
+	// deleted episodes are not counted
+	err = podcasts.DeleteEpisode(context.Background(), db, fixtures.PodEpGUID("ep-2"))
+	assert.Nil(t, err)
+	usage, err = podcasts.GetStorageUsage(context.Background(), db, "")
+	assert.Nil(t, err)
+	assert.Equal(t, podcasts.StorageUsage{Episodes: 1, Bytes: 100}, usage)
+}
+
+func TestGetStorageUsage_SharedBlobs(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	sha := strings.Repeat("a", 64)
+	// the same file in two podcasts, e.g. from twin feeds
+	for _, guid := range []string{fixtures.PodEpGUID("ep-1"), fixtures.PodEpGUID("pending-ep-1")} {
+		ep, err := podcasts.GetEpisode(ctx, db, guid)
+		if err != nil {
+			panic(err)
+		}
+		if err := podcasts.ReserveBlob(ctx, db, sha, 100); err != nil {
+			panic(err)
+		}
+		if err := podcasts.SetEpisodeBlob(ctx, db, &ep, sha); err != nil {
+			panic(err)
+		}
+		setEpisodeBytes(db, guid, 100)
+	}
+	if err := db.Exec("UPDATE episodes SET status = ? WHERE guid = ?", podcasts.EpisodeStatusSuccess, fixtures.PodEpGUID("pending-ep-1")).Error; err != nil {
+		panic(err)
+	}
+	setEpisodeBytes(db, fixtures.PodEpGUID("ep-2"), 250)
+
+	// counted towards each podcast
+	usage, err := podcasts.GetStorageUsage(ctx, db, fixtures.PodEpGUID("abc-123"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(350), usage.Bytes)
+	usage, err = podcasts.GetStorageUsage(ctx, db, fixtures.PodEpGUID("pod-eps-pending"))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(100), usage.Bytes)
+
+	// but only once towards the total
+	usage, err = podcasts.GetStorageUsage(ctx, db, "")
+	assert.Nil(t, err)
+	assert.Equal(t, podcasts.StorageUsage{Episodes: 3, Bytes: 350}, usage)
+}
+
+func TestListStorageUsage(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	setEpisodeBytes(db, fixtures.PodEpGUID("ep-1"), 100)
+	setEpisodeBytes(db, fixtures.PodEpGUID("ep-2"), 250)
+
+	usage, err := podcasts.ListStorageUsage(context.Background(), db)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []podcasts.StorageUsage{
+		{PodcastGUID: fixtures.PodEpGUID("abc-123"), Episodes: 2, Bytes: 350},
+	}, usage)
+}
+
+func TestUpdatePodcastQuota(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	pod, err := podcasts.GetPodcast(context.Background(), db, fixtures.PodEpGUID("abc-123"))
+	assert.Nil(t, err)
+
+	err = podcasts.UpdatePodcastQuota(context.Background(), db, &pod, 5000)
+	assert.Nil(t, err)
+	pod, err = podcasts.GetPodcast(context.Background(), db, pod.GUID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5000), pod.QuotaBytes)
+
+	// 0 removes the quota
+	err = podcasts.UpdatePodcastQuota(context.Background(), db, &pod, 0)
+	assert.Nil(t, err)
+	pod, err = podcasts.GetPodcast(context.Background(), db, pod.GUID)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), pod.QuotaBytes)
+}
+
+func setEpisodeBytes(db *gorm.DB, guid string, bytes int64) {
+	err := db.Exec("UPDATE episodes SET bytes = ? WHERE guid = ?", bytes, guid).Error
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,309 @@
+// Package reconcile compares the files in object storage with the podcasts,
+// episodes and blobs in the database, to find orphaned files which can be
+// deleted and downloaded episodes whose files are missing.
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/webbgeorge/castkeeper/pkg/downloadworker"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"github.com/webbgeorge/castkeeper/pkg/util"
+	"gorm.io/gorm"
+)
+
+const (
+	ReconcileWorkerQueueName = "reconcileWorker"
+
+	// files modified more recently than this are never treated as orphans, as
+	// they may belong to a download or upload which hasn't been recorded yet
+	orphanMinAge = time.Hour
+
+	partFileSuffix = ".part"
+)
+
+const (
+	OrphanReasonDeletedPodcast = "podcast deleted"
+	OrphanReasonDeletedEpisode = "episode deleted"
+	OrphanReasonUnknown        = "no matching episode"
+	OrphanReasonSuperseded     = "not the episode's current file"
+	OrphanReasonPartial        = "partial download of a downloaded episode"
+	OrphanReasonFailedPartial  = "partial download of a failed episode"
+	OrphanReasonUnreferenced   = "blob not used by any episode"
+)
+
+type Options struct {
+	// DeleteOrphans deletes orphaned files from object storage
+	DeleteOrphans bool
+	// RequeueMissing queues downloaded episodes whose files are missing to be
+	// downloaded again
+	RequeueMissing bool
+}
+
+type Orphan struct {
+	objectstorage.ObjectInfo
+	Reason  string
+	Deleted bool
+}
+
+type Missing struct {
+	PodcastGUID string
+	EpisodeGUID string
+	// Dir is the podcast's directory, or the blob directory for episodes stored
+	// by their content
+	Dir      string
+	FileName string
+	Requeued bool
+}
+
+type Result struct {
+	Orphans []Orphan
+	Missing []Missing
+	Failed  int
+}
+
+// Reconcile lists the files under each podcast's prefix in object storage, and
+// compares them with the podcast's episodes. Files of deleted podcasts and
+// episodes, or which don't belong to any episode, are reported as orphans, and
+// downloaded episodes without a file are reported as missing. Blobs which no
+// episode uses are also reported as orphans. Nothing is changed unless opts are
+// set. Failures to delete or requeue are logged and counted, and don't stop the
+// rest of the reconciliation.
+func Reconcile(ctx context.Context, db *gorm.DB, objstore objectstorage.ObjectStorage, opts Options) (Result, error) {
+	// includes deleted podcasts, as their files are kept
+	pods, err := podcasts.ListPodcasts(ctx, db.Unscoped())
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to list podcasts: %w", err)
+	}
+	blobObjects, err := objstore.List(ctx, podcasts.BlobDir+"/")
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to list blob files: %w", err)
+	}
+	blobsFound := make(map[string]bool, len(blobObjects))
+	for _, obj := range blobObjects {
+		blobsFound[obj.FileName] = true
+	}
+
+	result := Result{
+		Orphans: make([]Orphan, 0),
+		Missing: make([]Missing, 0),
+	}
+	for _, pod := range pods {
+		if err := reconcilePodcast(ctx, db, objstore, pod, blobsFound, opts, &result); err != nil {
+			return result, fmt.Errorf("failed to reconcile podcast '%s': %w", pod.GUID, err)
+		}
+	}
+	if err := reconcileBlobs(ctx, db, objstore, blobObjects, opts, &result); err != nil {
+		return result, fmt.Errorf("failed to reconcile blobs: %w", err)
+	}
+
+	return result, nil
+}
+
+func reconcilePodcast(
+	ctx context.Context,
+	db *gorm.DB,
+	objstore objectstorage.ObjectStorage,
+	pod podcasts.Podcast,
+	blobsFound map[string]bool,
+	opts Options,
+	result *Result,
+) error {
+	podDir := util.SanitiseGUID(pod.GUID)
+	objects, err := objstore.List(ctx, podDir+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
+	}
+
+	eps, err := podcasts.ListEpisodes(ctx, db.Unscoped(), pod.GUID)
+	if err != nil {
+		return fmt.Errorf("failed to list episodes: %w", err)
+	}
+	epsByFileGUID := make(map[string]podcasts.Episode, len(eps))
+	for _, ep := range eps {
+		epsByFileGUID[util.SanitiseGUID(ep.GUID)] = ep
+	}
+
+	found := make(map[string]bool, len(objects))
+	for _, obj := range objects {
+		found[obj.FileName] = true
+		reason := orphanReason(pod, podDir, epsByFileGUID, obj.FileName)
+		if reason == "" || time.Since(obj.ModTime) < orphanMinAge {
+			continue
+		}
+
+		orphan := Orphan{ObjectInfo: obj, Reason: reason}
+		if opts.DeleteOrphans {
+			if err := objstore.Delete(ctx, obj.PodcastGUID, obj.FileName); err != nil {
+				framework.GetLogger(ctx).ErrorContext(ctx, fmt.Sprintf("failed to delete orphaned file '%s': %s", obj.Path(), err.Error()))
+				result.Failed++
+			} else {
+				orphan.Deleted = true
+				framework.GetLogger(ctx).InfoContext(ctx, fmt.Sprintf("deleted orphaned file '%s' (%s)", obj.Path(), reason))
+			}
+		}
+		result.Orphans = append(result.Orphans, orphan)
+	}
+
+	if pod.DeletedAt.Valid {
+		return nil
+	}
+	for _, ep := range eps {
+		if ep.DeletedAt.Valid || ep.Status != podcasts.EpisodeStatusSuccess {
+			continue
+		}
+		dir, fileName, err := podcasts.EpisodeFile(ep)
+		if err != nil {
+			return fmt.Errorf("episode '%s': %w", ep.GUID, err)
+		}
+		if (dir == podcasts.BlobDir && blobsFound[fileName]) || (dir == podDir && found[fileName]) {
+			continue
+		}
+
+		missing := Missing{PodcastGUID: pod.GUID, EpisodeGUID: ep.GUID, Dir: dir, FileName: fileName}
+		if opts.RequeueMissing {
+			if err := requeueMissing(ctx, db, ep); err != nil {
+				framework.GetLogger(ctx).ErrorContext(ctx, fmt.Sprintf("failed to requeue episode '%s': %s", ep.GUID, err.Error()))
+				result.Failed++
+			} else {
+				missing.Requeued = true
+			}
+		}
+		result.Missing = append(result.Missing, missing)
+	}
+
+	return nil
+}
+
+// orphanReason returns why the file is an orphan, or empty when the file
+// belongs to the podcast or one of its episodes
+func orphanReason(pod podcasts.Podcast, podDir string, epsByFileGUID map[string]podcasts.Episode, fileName string) string {
+	if pod.DeletedAt.Valid {
+		return OrphanReasonDeletedPodcast
+	}
+	if fileName == podDir+".jpg" {
+		return ""
+	}
+
+	isPart := strings.HasSuffix(fileName, partFileSuffix)
+	ep, ok := episodeForFile(epsByFileGUID, strings.TrimSuffix(fileName, partFileSuffix))
+	switch {
+	case !ok:
+		return OrphanReasonUnknown
+	case ep.DeletedAt.Valid:
+		return OrphanReasonDeletedEpisode
+	case ep.Status == podcasts.EpisodeStatusFailed && isPart:
+		// failed downloads aren't retried until they are requeued, and only
+		// files older than orphanMinAge are collected
+		return OrphanReasonFailedPartial
+	case ep.Status != podcasts.EpisodeStatusSuccess:
+		// partial downloads are resumed, and existing files are replaced when
+		// the download completes
+		return ""
+	case isPart:
+		return OrphanReasonPartial
+	}
+
+	// includes files of episodes which are now stored as blobs
+	dir, currentFileName, err := podcasts.EpisodeFile(ep)
+	if err != nil || dir != podDir || currentFileName != fileName {
+		return OrphanReasonSuperseded
+	}
+	return ""
+}
+
+// episodeForFile finds the episode of a file named "<sanitised guid>.<ext>".
+// Sanitised GUIDs don't contain dots, so the GUID is everything before the
+// first one.
+func episodeForFile(epsByFileGUID map[string]podcasts.Episode, fileName string) (podcasts.Episode, bool) {
+	fileGUID, _, _ := strings.Cut(fileName, ".")
+	ep, ok := epsByFileGUID[fileGUID]
+	return ep, ok
+}
+
+// reconcileBlobs finds blob files which no episode uses. They are deleted with
+// their blobs, so that an episode can't start using a blob while its file is
+// being deleted.
+func reconcileBlobs(
+	ctx context.Context,
+	db *gorm.DB,
+	objstore objectstorage.ObjectStorage,
+	objects []objectstorage.ObjectInfo,
+	opts Options,
+	result *Result,
+) error {
+	blobs, err := podcasts.ListBlobs(ctx, db)
+	if err != nil {
+		return fmt.Errorf("failed to list blobs: %w", err)
+	}
+	refCounts := make(map[string]int64, len(blobs))
+	for _, blob := range blobs {
+		refCounts[blob.SHA256] = blob.RefCount
+	}
+
+	for _, obj := range objects {
+		if refCounts[obj.FileName] > 0 || time.Since(obj.ModTime) < orphanMinAge {
+			continue
+		}
+
+		orphan := Orphan{ObjectInfo: obj, Reason: OrphanReasonUnreferenced}
+		if opts.DeleteOrphans {
+			err := podcasts.DeleteUnusedBlob(ctx, db, obj.FileName, func() error {
+				return objstore.Delete(ctx, obj.PodcastGUID, obj.FileName)
+			})
+			switch {
+			case errors.Is(err, podcasts.ErrBlobInUse):
+				// used by an episode since it was listed
+				continue
+			case err != nil:
+				framework.GetLogger(ctx).ErrorContext(ctx, fmt.Sprintf("failed to delete orphaned file '%s': %s", obj.Path(), err.Error()))
+				result.Failed++
+			default:
+				orphan.Deleted = true
+				framework.GetLogger(ctx).InfoContext(ctx, fmt.Sprintf("deleted orphaned file '%s' (%s)", obj.Path(), orphan.Reason))
+			}
+		}
+		result.Orphans = append(result.Orphans, orphan)
+	}
+
+	return nil
+}
+
+func requeueMissing(ctx context.Context, db *gorm.DB, ep podcasts.Episode) error {
+	if ep.DownloadURL == "" {
+		return errors.New("episode has no download URL, e.g. it was uploaded or imported")
+	}
+	return downloadworker.RequeueDownload(ctx, db, &ep)
+}
+
+// NewReconcileWorkerQueueHandler reconciles object storage with the database
+// on a schedule. Orphans and missing files are logged, and are only deleted or
+// requeued when set in opts.
+func NewReconcileWorkerQueueHandler(db *gorm.DB, objstore objectstorage.ObjectStorage, opts Options) func(context.Context, any) error {
+	return func(ctx context.Context, _ any) error {
+		result, err := Reconcile(ctx, db, objstore, opts)
+		if err != nil {
+			return err
+		}
+
+		for _, orphan := range result.Orphans {
+			if !orphan.Deleted {
+				framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("found orphaned file '%s' (%s)", orphan.Path(), orphan.Reason))
+			}
+		}
+		for _, missing := range result.Missing {
+			framework.GetLogger(ctx).WarnContext(ctx, fmt.Sprintf("file of downloaded episode '%s' is missing", missing.EpisodeGUID))
+		}
+
+		if result.Failed > 0 {
+			return fmt.Errorf("failed to delete or requeue %d items", result.Failed)
+		}
+		return nil
+	}
+}
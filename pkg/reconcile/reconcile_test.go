@@ -0,0 +1,312 @@
+package reconcile_test
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/downloadworker"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"github.com/webbgeorge/castkeeper/pkg/reconcile"
+	"gorm.io/gorm"
+)
+
+func TestReconcile_DryRun(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{Root: root}
+	setupOrphans(db, root)
+
+	result, err := reconcile.Reconcile(ctx, db, objstore, reconcile.Options{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result.Failed)
+	assert.ElementsMatch(t, expectedOrphans(false), orphanReasons(result))
+	assert.Equal(t, []reconcile.Missing{{
+		PodcastGUID: fixtures.PodEpGUID("abc-123"),
+		EpisodeGUID: fixtures.PodEpGUID("ep-2"),
+		Dir:         fixtures.PodEpGUID("abc-123"),
+		FileName:    fixtures.PodEpGUID("ep-2") + ".mp3",
+	}}, result.Missing)
+
+	// nothing is changed
+	for _, orphan := range result.Orphans {
+		_, err := root.Stat(orphan.Path())
+		assert.Nil(t, err, orphan.Path())
+	}
+	ep, err := podcasts.GetEpisode(ctx, db, fixtures.PodEpGUID("ep-2"))
+	assert.Nil(t, err)
+	assert.Equal(t, podcasts.EpisodeStatusSuccess, ep.Status)
+}
+
+func TestReconcile_DeleteAndRequeue(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{Root: root}
+	setupOrphans(db, root)
+
+	result, err := reconcile.Reconcile(ctx, db, objstore, reconcile.Options{
+		DeleteOrphans:  true,
+		RequeueMissing: true,
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result.Failed)
+	assert.ElementsMatch(t, expectedOrphans(true), orphanReasons(result))
+	for _, orphan := range result.Orphans {
+		_, err := root.Stat(orphan.Path())
+		assert.ErrorIs(t, err, fs.ErrNotExist, orphan.Path())
+	}
+
+	// files which belong to episodes, and recent files, are kept
+	podGUID := fixtures.PodEpGUID("abc-123")
+	for _, fileName := range []string{
+		podGUID + ".jpg",
+		fixtures.PodEpGUID("ep-1") + ".mp3",
+		"recent.mp3",
+	} {
+		_, err := root.Stat(fmt.Sprintf("%s/%s", podGUID, fileName))
+		assert.Nil(t, err, fileName)
+	}
+
+	assert.Len(t, result.Missing, 1)
+	assert.True(t, result.Missing[0].Requeued)
+	ep, err := podcasts.GetEpisode(ctx, db, fixtures.PodEpGUID("ep-2"))
+	assert.Nil(t, err)
+	assert.Equal(t, podcasts.EpisodeStatusPending, ep.Status)
+	var taskCount int64
+	db.Table("queue_tasks").Where("queue_name = ?", downloadworker.DownloadWorkerQueueName).Count(&taskCount)
+	assert.Equal(t, int64(1), taskCount)
+}
+
+func TestReconcile_MissingUploadNotRequeued(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+
+	// e.g. an uploaded episode
+	err := db.Exec("UPDATE episodes SET download_url = '' WHERE guid = ?", fixtures.PodEpGUID("ep-2")).Error
+	if err != nil {
+		panic(err)
+	}
+
+	result, err := reconcile.Reconcile(ctx, db, &objectstorage.LocalObjectStorage{Root: root}, reconcile.Options{RequeueMissing: true})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, result.Failed)
+	assert.Len(t, result.Missing, 1)
+	assert.False(t, result.Missing[0].Requeued)
+}
+
+func TestReconcileWorkerQueueHandler(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	setupOrphans(db, root)
+
+	handler := reconcile.NewReconcileWorkerQueueHandler(db, &objectstorage.LocalObjectStorage{Root: root}, reconcile.Options{})
+	err := handler(context.Background(), nil)
+
+	assert.Nil(t, err)
+	_, err = root.Stat(fmt.Sprintf("%s/unknown.mp3", fixtures.PodEpGUID("abc-123")))
+	assert.Nil(t, err)
+}
+
+func TestReconcile_FailedDownloadPartial(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	podGUID := fixtures.PodEpGUID("abc-123")
+
+	for guid, status := range map[string]string{
+		"failed-ep":        podcasts.EpisodeStatusFailed,
+		"recent-failed-ep": podcasts.EpisodeStatusFailed,
+		"pending-ep":       podcasts.EpisodeStatusPending,
+	} {
+		err := db.Create(&podcasts.Episode{
+			GUID:        guid,
+			PodcastGUID: podGUID,
+			Title:       guid,
+			DownloadURL: fmt.Sprintf("http://example.com/%s.mp3", guid),
+			MimeType:    "audio/mpeg",
+			Status:      status,
+		}).Error
+		if err != nil {
+			panic(err)
+		}
+	}
+	writeOldFile(root, fmt.Sprintf("%s/failed-ep.mp3.part", podGUID))
+	writeOldFile(root, fmt.Sprintf("%s/failed-ep.mp3.source.part", podGUID))
+	writeOldFile(root, fmt.Sprintf("%s/pending-ep.mp3.part", podGUID))
+	// the download may have only just failed
+	err := root.WriteFile(fmt.Sprintf("%s/recent-failed-ep.mp3.part", podGUID), []byte("recent"), 0640)
+	if err != nil {
+		panic(err)
+	}
+
+	result, err := reconcile.Reconcile(ctx, db, &objectstorage.LocalObjectStorage{Root: root}, reconcile.Options{DeleteOrphans: true})
+
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []orphanReason{
+		{fmt.Sprintf("%s/failed-ep.mp3.part", podGUID), reconcile.OrphanReasonFailedPartial, true},
+		{fmt.Sprintf("%s/failed-ep.mp3.source.part", podGUID), reconcile.OrphanReasonFailedPartial, true},
+	}, orphanReasons(result))
+	for _, fileName := range []string{"pending-ep.mp3.part", "recent-failed-ep.mp3.part"} {
+		_, err := root.Stat(fmt.Sprintf("%s/%s", podGUID, fileName))
+		assert.Nil(t, err, fileName)
+	}
+}
+
+// setupOrphans adds files which don't belong to an episode to object storage
+func setupOrphans(db *gorm.DB, root *os.Root) {
+	podGUID := fixtures.PodEpGUID("abc-123")
+	ep1GUID := fixtures.PodEpGUID("ep-1")
+
+	err := db.Create(&podcasts.Episode{
+		GUID:        "deleted-ep",
+		PodcastGUID: podGUID,
+		Title:       "Deleted episode",
+		DownloadURL: "http://example.com/deleted-ep.mp3",
+		MimeType:    "audio/mpeg",
+		Status:      podcasts.EpisodeStatusSuccess,
+	}).Error
+	if err != nil {
+		panic(err)
+	}
+	if err := podcasts.DeleteEpisode(context.Background(), db, "deleted-ep"); err != nil {
+		panic(err)
+	}
+
+	pendingPodGUID := fixtures.PodEpGUID("pod-eps-pending")
+	if err := podcasts.DeletePodcast(context.Background(), db, pendingPodGUID); err != nil {
+		panic(err)
+	}
+	if err := root.Mkdir(pendingPodGUID, 0750); err != nil {
+		panic(err)
+	}
+
+	writeOldFile(root, fmt.Sprintf("%s/unknown.mp3", podGUID))
+	writeOldFile(root, fmt.Sprintf("%s/deleted-ep.mp3", podGUID))
+	writeOldFile(root, fmt.Sprintf("%s/%s.m4a", podGUID, ep1GUID))
+	writeOldFile(root, fmt.Sprintf("%s/%s.mp3.part", podGUID, ep1GUID))
+	writeOldFile(root, fmt.Sprintf("%s/%s.jpg", pendingPodGUID, pendingPodGUID))
+
+	// may be an upload in progress
+	err = root.WriteFile(fmt.Sprintf("%s/recent.mp3", podGUID), []byte("recent"), 0640)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func TestReconcile_Blobs(t *testing.T) {
+	ctx := context.Background()
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	root, resetFS := fixtures.ConfigureFSForTestWithFixtures()
+	defer resetFS()
+	objstore := &objectstorage.LocalObjectStorage{Root: root}
+	podGUID := fixtures.PodEpGUID("abc-123")
+	ep1GUID := fixtures.PodEpGUID("ep-1")
+	used, released, unknown, missing := strings.Repeat("a", 64), strings.Repeat("b", 64), strings.Repeat("c", 64), strings.Repeat("d", 64)
+
+	setEpisodeBlob(db, ep1GUID, used)
+	setEpisodeBlob(db, fixtures.PodEpGUID("ep-2"), missing)
+	if err := podcasts.ReserveBlob(ctx, db, released, 6); err != nil {
+		panic(err)
+	}
+	if err := podcasts.ReleaseBlob(ctx, db, released); err != nil {
+		panic(err)
+	}
+	if err := root.Mkdir(podcasts.BlobDir, 0750); err != nil {
+		panic(err)
+	}
+	for _, sha := range []string{used, released, unknown} {
+		writeOldFile(root, fmt.Sprintf("%s/%s", podcasts.BlobDir, sha))
+	}
+	// stored in the podcast's directory before the episode was stored as a blob
+	writeOldFile(root, fmt.Sprintf("%s/%s.mp3", podGUID, ep1GUID))
+
+	result, err := reconcile.Reconcile(ctx, db, objstore, reconcile.Options{DeleteOrphans: true})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, result.Failed)
+	assert.ElementsMatch(t, []orphanReason{
+		{fmt.Sprintf("%s/%s", podcasts.BlobDir, released), reconcile.OrphanReasonUnreferenced, true},
+		{fmt.Sprintf("%s/%s", podcasts.BlobDir, unknown), reconcile.OrphanReasonUnreferenced, true},
+		{fmt.Sprintf("%s/%s.mp3", podGUID, ep1GUID), reconcile.OrphanReasonSuperseded, true},
+	}, orphanReasons(result))
+	assert.Equal(t, []reconcile.Missing{{
+		PodcastGUID: podGUID,
+		EpisodeGUID: fixtures.PodEpGUID("ep-2"),
+		Dir:         podcasts.BlobDir,
+		FileName:    missing,
+	}}, result.Missing)
+
+	_, err = root.Stat(fmt.Sprintf("%s/%s", podcasts.BlobDir, used))
+	assert.Nil(t, err)
+	blobs, err := podcasts.ListBlobs(ctx, db)
+	assert.Nil(t, err)
+	assert.Len(t, blobs, 2)
+}
+
+func setEpisodeBlob(db *gorm.DB, guid, sha256 string) {
+	ep, err := podcasts.GetEpisode(context.Background(), db, guid)
+	if err != nil {
+		panic(err)
+	}
+	if err := podcasts.ReserveBlob(context.Background(), db, sha256, 6); err != nil {
+		panic(err)
+	}
+	if err := podcasts.SetEpisodeBlob(context.Background(), db, &ep, sha256); err != nil {
+		panic(err)
+	}
+}
+
+func writeOldFile(root *os.Root, name string) {
+	if err := root.WriteFile(name, []byte("orphan"), 0640); err != nil {
+		panic(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := root.Chtimes(name, old, old); err != nil {
+		panic(err)
+	}
+}
+
+type orphanReason struct {
+	Path    string
+	Reason  string
+	Deleted bool
+}
+
+func expectedOrphans(deleted bool) []orphanReason {
+	podGUID := fixtures.PodEpGUID("abc-123")
+	ep1GUID := fixtures.PodEpGUID("ep-1")
+	pendingPodGUID := fixtures.PodEpGUID("pod-eps-pending")
+	return []orphanReason{
+		{fmt.Sprintf("%s/unknown.mp3", podGUID), reconcile.OrphanReasonUnknown, deleted},
+		{fmt.Sprintf("%s/deleted-ep.mp3", podGUID), reconcile.OrphanReasonDeletedEpisode, deleted},
+		{fmt.Sprintf("%s/%s.m4a", podGUID, ep1GUID), reconcile.OrphanReasonSuperseded, deleted},
+		{fmt.Sprintf("%s/%s.mp3.part", podGUID, ep1GUID), reconcile.OrphanReasonPartial, deleted},
+		{fmt.Sprintf("%s/%s.jpg", pendingPodGUID, pendingPodGUID), reconcile.OrphanReasonDeletedPodcast, deleted},
+	}
+}
+
+func orphanReasons(result reconcile.Result) []orphanReason {
+	reasons := make([]orphanReason, 0, len(result.Orphans))
+	for _, orphan := range result.Orphans {
+		reasons = append(reasons, orphanReason{orphan.Path(), orphan.Reason, orphan.Deleted})
+	}
+	return reasons
+}
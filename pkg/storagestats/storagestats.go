@@ -0,0 +1,136 @@
+// Package storagestats records the storage used by downloaded episodes over
+// time, and resumes downloads which were paused by a storage quota once there
+// is space for them.
+package storagestats
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/webbgeorge/castkeeper/pkg/downloadworker"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"gorm.io/gorm"
+)
+
+const StorageStatsWorkerQueueName = "storageStatsWorker"
+
+// Snapshot is the storage used by a podcast on a day, or by all podcasts when
+// PodcastGUID is empty
+type Snapshot struct {
+	ID          uint      `gorm:"primaryKey"`
+	Date        time.Time `gorm:"index" validate:"required"`
+	PodcastGUID string    `gorm:"index"`
+	Episodes    int64     `validate:"gte=0"`
+	Bytes       int64     `validate:"gte=0"`
+	CreatedAt   time.Time
+}
+
+var validate = validator.New(validator.WithRequiredStructEnabled())
+
+func (s *Snapshot) BeforeSave(tx *gorm.DB) error {
+	err := validate.Struct(s)
+	if err != nil {
+		return fmt.Errorf("storage snapshot not valid: %w", err)
+	}
+	return nil
+}
+
+// NewStorageStatsWorkerQueueHandler records the day's storage snapshot, if it
+// hasn't been recorded yet, and resumes paused downloads which are now within
+// quota
+func NewStorageStatsWorkerQueueHandler(db *gorm.DB, totalQuotaBytes int64) func(context.Context, any) error {
+	return func(ctx context.Context, _ any) error {
+		if err := RecordSnapshot(ctx, db, time.Now()); err != nil {
+			return fmt.Errorf("failed to record storage snapshot: %w", err)
+		}
+
+		resumed, err := downloadworker.ResumePausedDownloads(ctx, db, totalQuotaBytes)
+		if err != nil {
+			return fmt.Errorf("failed to resume paused downloads: %w", err)
+		}
+		if resumed > 0 {
+			framework.GetLogger(ctx).InfoContext(ctx, fmt.Sprintf("resumed %d paused downloads", resumed))
+		}
+
+		return nil
+	}
+}
+
+// RecordSnapshot records the storage used by each podcast, and in total, on the
+// day of now. Nothing is recorded if the day's snapshot already exists.
+func RecordSnapshot(ctx context.Context, db *gorm.DB, now time.Time) error {
+	date := snapshotDate(now)
+
+	var count int64
+	result := db.
+		Model(&Snapshot{}).
+		Where("date = ?", date).
+		Count(&count)
+	if result.Error != nil {
+		return result.Error
+	}
+	if count > 0 {
+		return nil
+	}
+
+	usage, err := podcasts.ListStorageUsage(ctx, db)
+	if err != nil {
+		return err
+	}
+	total, err := podcasts.GetStorageUsage(ctx, db, "")
+	if err != nil {
+		return err
+	}
+
+	snapshots := make([]Snapshot, 0, len(usage)+1)
+	for _, u := range append(usage, total) {
+		snapshots = append(snapshots, Snapshot{
+			Date:        date,
+			PodcastGUID: u.PodcastGUID,
+			Episodes:    u.Episodes,
+			Bytes:       u.Bytes,
+		})
+	}
+	return db.Create(&snapshots).Error
+}
+
+// ListSnapshots lists the daily snapshots of a podcast's storage, or of all
+// podcasts when podcastGUID is empty, since the given time, oldest first
+func ListSnapshots(ctx context.Context, db *gorm.DB, podcastGUID string, since time.Time) ([]Snapshot, error) {
+	var snapshots []Snapshot
+	result := db.
+		Where("podcast_guid = ? AND date >= ?", podcastGUID, snapshotDate(since)).
+		Order("date asc").
+		Find(&snapshots)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return snapshots, nil
+}
+
+// ListPodcastSnapshots lists the snapshots of every podcast on the day of date,
+// by podcast GUID. The total is not included.
+func ListPodcastSnapshots(ctx context.Context, db *gorm.DB, date time.Time) (map[string]Snapshot, error) {
+	var snapshots []Snapshot
+	result := db.
+		Where("date = ? AND podcast_guid != ''", snapshotDate(date)).
+		Find(&snapshots)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	byPodcast := make(map[string]Snapshot, len(snapshots))
+	for _, s := range snapshots {
+		byPodcast[s.PodcastGUID] = s
+	}
+	return byPodcast, nil
+}
+
+// snapshotDate is the start of the UTC day of t
+func snapshotDate(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
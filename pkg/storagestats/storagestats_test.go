@@ -0,0 +1,93 @@
+package storagestats_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/downloadworker"
+	"github.com/webbgeorge/castkeeper/pkg/fixtures"
+	"github.com/webbgeorge/castkeeper/pkg/framework"
+	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"github.com/webbgeorge/castkeeper/pkg/storagestats"
+	"gorm.io/gorm"
+)
+
+func TestRecordSnapshot(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	ctx := context.Background()
+	podGUID := fixtures.PodEpGUID("abc-123")
+	day1 := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2025, 3, 2, 10, 0, 0, 0, time.UTC)
+
+	setEpisodeBytes(db, fixtures.PodEpGUID("ep-1"), 100)
+	err := storagestats.RecordSnapshot(ctx, db, day1)
+	assert.Nil(t, err)
+
+	// only one snapshot is recorded each day
+	setEpisodeBytes(db, fixtures.PodEpGUID("ep-2"), 250)
+	err = storagestats.RecordSnapshot(ctx, db, day1.Add(time.Hour))
+	assert.Nil(t, err)
+	err = storagestats.RecordSnapshot(ctx, db, day2)
+	assert.Nil(t, err)
+
+	totals, err := storagestats.ListSnapshots(ctx, db, "", day1)
+	assert.Nil(t, err)
+	assert.Len(t, totals, 2)
+	// only ep-1 had been downloaded on day 1
+	assert.Equal(t, int64(100), totals[0].Bytes)
+	assert.Equal(t, int64(350), totals[1].Bytes)
+	assert.Equal(t, int64(2), totals[1].Episodes)
+
+	pod, err := storagestats.ListSnapshots(ctx, db, podGUID, day2)
+	assert.Nil(t, err)
+	assert.Len(t, pod, 1)
+	assert.Equal(t, int64(350), pod[0].Bytes)
+
+	byPodcast, err := storagestats.ListPodcastSnapshots(ctx, db, day1)
+	assert.Nil(t, err)
+	assert.Len(t, byPodcast, 1)
+	assert.Equal(t, int64(100), byPodcast[podGUID].Bytes)
+
+	byPodcast, err = storagestats.ListPodcastSnapshots(ctx, db, day1.Add(-time.Hour*24))
+	assert.Nil(t, err)
+	assert.Len(t, byPodcast, 0)
+}
+
+func TestStorageStatsWorkerQueueHandler(t *testing.T) {
+	db := fixtures.ConfigureDBForTestWithFixtures()
+	ctx := context.Background()
+	epGUID := fixtures.PodEpGUID("pending-ep-1")
+
+	ep, err := podcasts.GetEpisode(ctx, db, epGUID)
+	if err != nil {
+		panic(err)
+	}
+	err = podcasts.UpdateEpisodeStatus(ctx, db, &ep, podcasts.EpisodeStatusPaused, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	err = storagestats.NewStorageStatsWorkerQueueHandler(db, 0)(ctx, nil)
+
+	assert.Nil(t, err)
+	totals, err := storagestats.ListSnapshots(ctx, db, "", time.Now())
+	assert.Nil(t, err)
+	assert.Len(t, totals, 1)
+
+	// no quota, so the paused download is resumed
+	ep, err = podcasts.GetEpisode(ctx, db, epGUID)
+	assert.Nil(t, err)
+	assert.Equal(t, podcasts.EpisodeStatusPending, ep.Status)
+	qt, err := framework.PopQueueTask(ctx, db, downloadworker.DownloadWorkerQueueName)
+	assert.Nil(t, err)
+	assert.Equal(t, epGUID, qt.Data)
+}
+
+func setEpisodeBytes(db *gorm.DB, guid string, bytes int64) {
+	err := db.Exec("UPDATE episodes SET bytes = ? WHERE guid = ?", bytes, guid).Error
+	if err != nil {
+		panic(err)
+	}
+}
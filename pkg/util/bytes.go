@@ -0,0 +1,20 @@
+package util
+
+import "fmt"
+
+// BytesPerMB is the number of bytes in a megabyte, as used by FormatBytes
+const BytesPerMB = 1000 * 1000
+
+// FormatBytes formats a number of bytes with SI units, e.g. 1.5 MB
+func FormatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
@@ -0,0 +1,23 @@
+package util_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/webbgeorge/castkeeper/pkg/util"
+)
+
+func TestFormatBytes(t *testing.T) {
+	testCases := map[int64]string{
+		0:             "0 B",
+		999:           "999 B",
+		1000:          "1.0 kB",
+		1500000:       "1.5 MB",
+		2000000000:    "2.0 GB",
+		3100000000000: "3.1 TB",
+	}
+
+	for n, expected := range testCases {
+		assert.Equal(t, expected, util.FormatBytes(n))
+	}
+}
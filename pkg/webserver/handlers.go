@@ -1,13 +1,18 @@
 package webserver
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-playground/locales/en"
 	ut "github.com/go-playground/universal-translator"
@@ -20,10 +25,13 @@ import (
 	"github.com/webbgeorge/castkeeper/pkg/database/encryption"
 	"github.com/webbgeorge/castkeeper/pkg/downloadworker"
 	"github.com/webbgeorge/castkeeper/pkg/feedworker"
+	"github.com/webbgeorge/castkeeper/pkg/fileimport"
 	"github.com/webbgeorge/castkeeper/pkg/framework"
 	"github.com/webbgeorge/castkeeper/pkg/itunes"
 	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
+	"github.com/webbgeorge/castkeeper/pkg/podcastarchive"
 	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"github.com/webbgeorge/castkeeper/pkg/storagestats"
 	"github.com/webbgeorge/castkeeper/pkg/util"
 	"gorm.io/gorm"
 )
@@ -40,7 +48,7 @@ func init() {
 	_ = en_translations.RegisterDefaultTranslations(validate, enTrans)
 }
 
-func NewHomeHandler(db *gorm.DB) framework.Handler {
+func NewHomeHandler(db *gorm.DB, totalQuotaBytes int64) framework.Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		if r.URL.Path != "/" {
 			// handle fallback on home route
@@ -51,7 +59,128 @@ func NewHomeHandler(db *gorm.DB) framework.Handler {
 		if err != nil {
 			return err
 		}
-		return framework.Render(ctx, w, 200, pages.Home(pods))
+		failedCount, err := podcasts.CountEpisodesByStatus(ctx, db, podcasts.EpisodeStatusFailed)
+		if err != nil {
+			return err
+		}
+		storage, err := storageSummary(ctx, db, totalQuotaBytes)
+		if err != nil {
+			return err
+		}
+		return framework.Render(ctx, w, 200, pages.Home(pods, failedCount, storage))
+	}
+}
+
+// storageTrendDays is the period over which changes in storage are shown
+const storageTrendDays = 30
+
+func NewStorageHandler(db *gorm.DB, totalQuotaBytes int64) framework.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		pods, err := podcasts.ListPodcasts(ctx, db)
+		if err != nil {
+			return err
+		}
+		storage, err := storageSummary(ctx, db, totalQuotaBytes)
+		if err != nil {
+			return err
+		}
+		history, err := storagestats.ListSnapshots(ctx, db, "", time.Now().AddDate(0, 0, -storageTrendDays))
+		if err != nil {
+			return err
+		}
+
+		// changes are relative to the oldest snapshot in the trend period
+		var baseline map[string]storagestats.Snapshot
+		if len(history) > 0 {
+			baseline, err = storagestats.ListPodcastSnapshots(ctx, db, history[0].Date)
+			if err != nil {
+				return err
+			}
+		}
+
+		podStorage := make([]pages.PodcastStorage, 0, len(pods))
+		for _, pod := range pods {
+			ps := pages.PodcastStorage{Podcast: pod, Usage: storage.ByPodcast[pod.GUID]}
+			if baseline != nil {
+				change := ps.Usage.Bytes - baseline[pod.GUID].Bytes
+				ps.Change = &change
+			}
+			podStorage = append(podStorage, ps)
+		}
+		slices.SortStableFunc(podStorage, func(a, b pages.PodcastStorage) int {
+			return cmp.Compare(b.Usage.Bytes, a.Usage.Bytes)
+		})
+
+		return framework.Render(ctx, w, 200, pages.Storage(pages.StoragePageData{
+			Total:           storage.Total,
+			TotalQuotaBytes: totalQuotaBytes,
+			Podcasts:        podStorage,
+			History:         history,
+			TrendDays:       storageTrendDays,
+		}))
+	}
+}
+
+func storageSummary(ctx context.Context, db *gorm.DB, totalQuotaBytes int64) (pages.StorageSummary, error) {
+	total, err := podcasts.GetStorageUsage(ctx, db, "")
+	if err != nil {
+		return pages.StorageSummary{}, err
+	}
+	usage, err := podcasts.ListStorageUsage(ctx, db)
+	if err != nil {
+		return pages.StorageSummary{}, err
+	}
+
+	byPodcast := make(map[string]podcasts.StorageUsage, len(usage))
+	for _, u := range usage {
+		byPodcast[u.PodcastGUID] = u
+	}
+	return pages.StorageSummary{
+		Total:           total,
+		TotalQuotaBytes: totalQuotaBytes,
+		ByPodcast:       byPodcast,
+	}, nil
+}
+
+func NewUpdatePodcastQuotaHandler(db *gorm.DB, totalQuotaBytes int64) framework.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		pod, err := podcasts.GetPodcast(ctx, db, r.PathValue("guid"))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return framework.HttpNotFound()
+			}
+			return err
+		}
+
+		// an empty quota is unlimited
+		var quotaMB int64
+		if v := r.PostFormValue("quotaMB"); v != "" {
+			quotaMB, err = strconv.ParseInt(v, 10, 64)
+			if err != nil || quotaMB < 0 || quotaMB > math.MaxInt64/util.BytesPerMB {
+				setShowMessageHeader(w, "Quota must be a whole number of MB", "error")
+				w.WriteHeader(http.StatusOK)
+				return nil
+			}
+		}
+
+		err = podcasts.UpdatePodcastQuota(ctx, db, &pod, quotaMB*util.BytesPerMB)
+		if err != nil {
+			return err
+		}
+
+		// the quota may have been raised or removed
+		n, err := downloadworker.ResumePausedDownloads(ctx, db, totalQuotaBytes)
+		if err != nil {
+			framework.GetLogger(ctx).ErrorContext(ctx, "failed to resume paused downloads", "error", err)
+		}
+
+		message := "Quota updated"
+		if n > 0 {
+			message = fmt.Sprintf("Quota updated, resumed %d paused downloads", n)
+		}
+		setShowMessageHeader(w, message, "success")
+		w.WriteHeader(http.StatusOK)
+		return nil
 	}
 }
 
@@ -101,24 +230,28 @@ func NewAddPodcastHandler(
 			}
 		}
 
-		podcast, err := podcasts.AddPodcast(ctx, db, feedService, encService, formData.FeedURL, creds)
+		podcast, addedToExisting, err := podcasts.AddPodcast(ctx, db, feedService, encService, formData.FeedURL, creds, formData.FeedPremium)
 		if err != nil {
 			if errors.Is(err, gorm.ErrDuplicatedKey) {
 				return framework.Render(ctx, w, 200, partials.AddPodcast("This podcast is already added"))
 			}
 			if errors.Is(err, encryption.ErrEncryptionNotConfigured) {
-				return framework.Render(ctx, w, 200, partials.AddPodcast("Encryption must be configured to subscribe to password protected feeds"))
+				return framework.Render(ctx, w, 200, partials.AddPodcast("Encryption must be configured to subscribe to password protected feeds, see the castkeeper encryption enable CLI command"))
 			}
 			framework.GetLogger(ctx).ErrorContext(ctx, "failed to add podcast", "error", err)
 			// TODO better error message for users
 			return framework.Render(ctx, w, 200, partials.AddPodcast("Invalid feed"))
 		}
 
-		// TODO detect filetype
-		fileName := fmt.Sprintf("%s.%s", util.SanitiseGUID(podcast.GUID), "jpg")
-		_, err = os.SaveRemoteFile(ctx, creds, podcast.ImageURL, util.SanitiseGUID(podcast.GUID), fileName)
-		if err != nil {
-			framework.GetLogger(ctx).WarnContext(ctx, "failed to download image, continuing without", "error", err)
+		// when the podcast already existed, the feed is added to it instead
+		// and the podcast's existing image is kept
+		if !addedToExisting {
+			// TODO detect filetype
+			fileName := fmt.Sprintf("%s.%s", util.SanitiseGUID(podcast.GUID), "jpg")
+			_, err = os.SaveRemoteFile(ctx, creds, podcast.ImageURL, util.SanitiseGUID(podcast.GUID), fileName, objectstorage.SaveOptions{})
+			if err != nil {
+				framework.GetLogger(ctx).WarnContext(ctx, "failed to download image, continuing without", "error", err)
+			}
 		}
 
 		err = framework.PushQueueTask(ctx, db, feedworker.FeedWorkerQueueName, "")
@@ -126,10 +259,168 @@ func NewAddPodcastHandler(
 			framework.GetLogger(ctx).WarnContext(ctx, "failed to queue feed worker, continuing without", "error", err)
 		}
 
+		if addedToExisting {
+			return framework.Render(ctx, w, 200, partials.AddedPodcastFeed(podcast.Title))
+		}
 		return framework.Render(ctx, w, 200, partials.AddPodcast(""))
 	}
 }
 
+// maxArtworkBytes limits the size of uploaded podcast artwork
+const maxArtworkBytes = 5 * 1024 * 1024
+
+func NewCreatePrivatePodcastHandler(db *gorm.DB, os objectstorage.ObjectStorage) framework.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		var formData partials.CreatePrivatePodcastFormData
+		err := parseMultipartFormData(r, &formData)
+		if err != nil {
+			return framework.Render(ctx, w, 200, partials.ErrorAlert("Invalid request"))
+		}
+
+		err = validate.Struct(formData)
+		if err != nil {
+			if errorText, ok := translateValidationErrs(err); ok {
+				return framework.Render(ctx, w, 200, partials.ErrorAlert(errorText))
+			}
+			return framework.Render(ctx, w, 200, partials.ErrorAlert("Invalid request"))
+		}
+
+		image, errorText := readUploadedImage(r)
+		if errorText != "" {
+			return framework.Render(ctx, w, 200, partials.ErrorAlert(errorText))
+		}
+
+		podcast, err := podcasts.CreatePrivatePodcast(ctx, db, podcasts.PrivatePodcast{
+			Title:       formData.Title,
+			Author:      formData.Author,
+			Description: formData.Description,
+		})
+		if err != nil {
+			framework.GetLogger(ctx).ErrorContext(ctx, "failed to create private podcast", "error", err)
+			return framework.Render(ctx, w, 200, partials.ErrorAlert("Failed to create podcast"))
+		}
+
+		if image != nil {
+			fileName := fmt.Sprintf("%s.%s", util.SanitiseGUID(podcast.GUID), "jpg")
+			_, err = os.Put(ctx, util.SanitiseGUID(podcast.GUID), fileName, bytes.NewReader(image))
+			if err != nil {
+				framework.GetLogger(ctx).WarnContext(ctx, "failed to save image, continuing without", "error", err)
+			}
+		}
+
+		w.Header().Set("HX-Redirect", fmt.Sprintf("/podcasts/%s", podcast.GUID))
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+}
+
+// readUploadedImage reads the optional image file of a form, returning nil when
+// no image was uploaded, or the error to show to the user when it isn't valid
+func readUploadedImage(r *http.Request) ([]byte, string) {
+	f, _, err := r.FormFile("image")
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, ""
+	}
+	if err != nil {
+		return nil, "Invalid image"
+	}
+	defer f.Close()
+
+	image, err := io.ReadAll(io.LimitReader(f, maxArtworkBytes+1))
+	if err != nil {
+		return nil, "Invalid image"
+	}
+	if len(image) == 0 {
+		return nil, ""
+	}
+	if len(image) > maxArtworkBytes {
+		return nil, "Image must be 5MB or smaller"
+	}
+	contentType := http.DetectContentType(image)
+	if contentType != "image/jpeg" && contentType != "image/png" {
+		return nil, "Image must be a JPEG or PNG"
+	}
+	return image, ""
+}
+
+// NewUploadEpisodeHandler accepts episode files up to maxUploadBytes, or of any
+// size when it is 0
+func NewUploadEpisodeHandler(db *gorm.DB, os objectstorage.ObjectStorage, maxUploadBytes int64) framework.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		pod, err := podcasts.GetPodcast(ctx, db, r.PathValue("guid"))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return framework.HttpNotFound()
+			}
+			return err
+		}
+
+		// episode files take longer to upload than the server's read and
+		// write timeouts, which both start when the request is read
+		rc := http.NewResponseController(w)
+		if err := rc.SetReadDeadline(time.Time{}); err != nil {
+			framework.GetLogger(ctx).WarnContext(ctx, "failed to clear read deadline", "error", err)
+		}
+		if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+			framework.GetLogger(ctx).WarnContext(ctx, "failed to clear write deadline", "error", err)
+		}
+		if maxUploadBytes > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+		}
+
+		var formData partials.UploadEpisodeFormData
+		err = parseMultipartFormData(r, &formData)
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				return framework.Render(ctx, w, 200, partials.ErrorAlert(
+					fmt.Sprintf("The file is too large, the maximum upload size is %s", util.FormatBytes(maxUploadBytes)),
+				))
+			}
+			return framework.Render(ctx, w, 200, partials.ErrorAlert("Invalid request"))
+		}
+
+		err = validate.Struct(formData)
+		if err != nil {
+			if errorText, ok := translateValidationErrs(err); ok {
+				return framework.Render(ctx, w, 200, partials.ErrorAlert(errorText))
+			}
+			return framework.Render(ctx, w, 200, partials.ErrorAlert("Invalid request"))
+		}
+
+		var publishedAt time.Time
+		if formData.PublishedAt != "" {
+			// already validated as a date
+			publishedAt, _ = time.Parse("2006-01-02", formData.PublishedAt)
+		}
+
+		f, header, err := r.FormFile("file")
+		if err != nil {
+			return framework.Render(ctx, w, 200, partials.ErrorAlert("A media file is required"))
+		}
+		defer f.Close()
+
+		ep, err := fileimport.UploadEpisode(ctx, db, os, pod.GUID, fileimport.Upload{
+			FileName:    header.Filename,
+			Title:       formData.Title,
+			Description: formData.Description,
+			PublishedAt: publishedAt,
+		}, f, header.Size)
+		if err != nil {
+			if errors.Is(err, fileimport.ErrUnsupportedFile) || errors.Is(err, podcasts.ErrNotMedia) {
+				return framework.Render(ctx, w, 200, partials.ErrorAlert("Not a supported media file"))
+			}
+			framework.GetLogger(ctx).ErrorContext(ctx, "failed to upload episode", "error", err)
+			return framework.Render(ctx, w, 200, partials.ErrorAlert("Failed to upload episode"))
+		}
+
+		framework.GetLogger(ctx).InfoContext(ctx, fmt.Sprintf("uploaded episode '%s' to podcast '%s'", ep.GUID, pod.GUID))
+		w.Header().Set("HX-Refresh", "true")
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+}
+
 func NewViewPodcastHandler(baseURL string, db *gorm.DB) framework.Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		pod, err := podcasts.GetPodcast(ctx, db, r.PathValue("guid"))
@@ -163,6 +454,20 @@ func NewViewEpisodeHandler(db *gorm.DB) framework.Handler {
 	}
 }
 
+func NewEpisodeStatusHandler(db *gorm.DB) framework.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		episode, err := podcasts.GetEpisode(ctx, db, r.PathValue("guid"))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return framework.HttpNotFound()
+			}
+			return err
+		}
+
+		return framework.Render(ctx, w, 200, partials.EpisodeStatusBadge(episode))
+	}
+}
+
 func NewDownloadEpisodeHandler(db *gorm.DB, os objectstorage.ObjectStorage) framework.Handler {
 	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
 		ep, err := podcasts.GetEpisode(ctx, db, r.PathValue("guid"))
@@ -184,8 +489,11 @@ func NewDownloadEpisodeHandler(db *gorm.DB, os objectstorage.ObjectStorage) fram
 		)
 		w.Header().Set("Content-Type", ep.MimeType)
 
-		fileName := fmt.Sprintf("%s.%s", util.SanitiseGUID(ep.GUID), extension)
-		return os.ServeFile(ctx, r, w, util.SanitiseGUID(ep.PodcastGUID), fileName)
+		dir, fileName, err := podcasts.EpisodeFile(ep)
+		if err != nil {
+			return err
+		}
+		return os.ServeFile(ctx, r, w, dir, fileName)
 	}
 }
 
@@ -199,23 +507,109 @@ func NewRequeueDownloadHandler(db *gorm.DB) framework.Handler {
 			return err
 		}
 
-		err = db.Transaction(func(tx *gorm.DB) error {
-			err := podcasts.UpdateEpisodeStatus(ctx, tx, &ep, podcasts.EpisodeStatusPending, nil)
-			if err != nil {
-				return err
+		err = downloadworker.RequeueDownload(ctx, db, &ep)
+		if err != nil {
+			return err
+		}
+
+		return framework.Render(ctx, w, 200, partials.EpisodeListItem(ep))
+	}
+}
+
+func NewRequeueFailedDownloadsHandler(db *gorm.DB) framework.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		eps, err := podcasts.ListEpisodesByStatus(ctx, db, "", podcasts.EpisodeStatusFailed)
+		if err != nil {
+			return err
+		}
+
+		n, err := downloadworker.RequeueDownloads(ctx, db, eps)
+		if err != nil {
+			framework.GetLogger(ctx).ErrorContext(ctx, "failed to requeue downloads", "requeued", n, "error", err)
+			setShowMessageHeader(w, fmt.Sprintf("Failed to requeue downloads, %d of %d requeued", n, len(eps)), "error")
+			w.WriteHeader(http.StatusOK)
+			return nil
+		}
+
+		setShowMessageHeader(w, fmt.Sprintf("Requeued %d failed downloads", n), "success")
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+}
+
+func NewRequeuePodcastDownloadsHandler(db *gorm.DB, selectedOnly bool) framework.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		pod, err := podcasts.GetPodcast(ctx, db, r.PathValue("guid"))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return framework.HttpNotFound()
 			}
-			err = framework.PushQueueTask(ctx, tx, downloadworker.DownloadWorkerQueueName, ep.GUID)
-			if err != nil {
-				return err
+			return err
+		}
+
+		failedEps, err := podcasts.ListEpisodesByStatus(ctx, db, pod.GUID, podcasts.EpisodeStatusFailed)
+		if err != nil {
+			return err
+		}
+
+		if selectedOnly {
+			if err := r.ParseForm(); err != nil {
+				return framework.HttpBadRequest("invalid form data")
 			}
-			return nil
-		})
+			selected := r.PostForm["episodes"]
+			failedEps = slices.DeleteFunc(failedEps, func(ep podcasts.Episode) bool {
+				return !slices.Contains(selected, ep.GUID)
+			})
+		}
+
+		n, err := downloadworker.RequeueDownloads(ctx, db, failedEps)
+		if err != nil {
+			framework.GetLogger(ctx).ErrorContext(ctx, "failed to requeue downloads", "requeued", n, "error", err)
+			setShowMessageHeader(w, fmt.Sprintf("Failed to requeue downloads, %d of %d requeued", n, len(failedEps)), "error")
+		} else {
+			setShowMessageHeader(w, fmt.Sprintf("Requeued %d failed downloads", n), "success")
+		}
+
+		eps, err := podcasts.ListEpisodes(ctx, db, pod.GUID)
 		if err != nil {
 			return err
 		}
 
-		ep.Status = podcasts.EpisodeStatusPending
-		return framework.Render(ctx, w, 200, partials.EpisodeListItem(ep))
+		return framework.Render(ctx, w, 200, partials.EpisodeList(pod.GUID, eps))
+	}
+}
+
+func NewExportPodcastHandler(db *gorm.DB, os objectstorage.ObjectStorage) framework.Handler {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+		pod, err := podcasts.GetPodcast(ctx, db, r.PathValue("guid"))
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return framework.HttpNotFound()
+			}
+			return err
+		}
+
+		w.Header().Set(
+			"Content-Disposition",
+			fmt.Sprintf("attachment; filename=%s.zip", util.SanitiseGUID(pod.GUID)),
+		)
+		w.Header().Set("Content-Type", "application/zip")
+
+		// archives of large podcasts take longer to stream than the server's
+		// write timeout
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			framework.GetLogger(ctx).WarnContext(ctx, "failed to clear write deadline", "error", err)
+		}
+
+		err = podcastarchive.Export(ctx, db, os, pod.GUID, w)
+		if err != nil {
+			// part of the archive may already be sent, so the error can't be
+			// rendered. The connection is aborted so that the download fails,
+			// rather than leaving a truncated archive.
+			framework.GetLogger(ctx).ErrorContext(ctx, fmt.Sprintf("failed to export podcast '%s'", pod.GUID), "error", err)
+			panic(http.ErrAbortHandler)
+		}
+		return nil
 	}
 }
 
@@ -577,6 +971,24 @@ func parseFormData(r *http.Request, formData any) error {
 	return nil
 }
 
+// multipartMaxMemory is the size of a multipart form held in memory, beyond
+// which uploaded files are stored in temporary files
+const multipartMaxMemory = 32 * 1024 * 1024
+
+func parseMultipartFormData(r *http.Request, formData any) error {
+	err := r.ParseMultipartForm(multipartMaxMemory)
+	if err != nil {
+		return err
+	}
+
+	err = decoder.Decode(formData, r.PostForm)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 // TODO move to validation package
 func translateValidationErrs(err error) (string, bool) {
 	errorTexts := make([]string, 0)
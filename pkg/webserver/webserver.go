@@ -14,6 +14,7 @@ import (
 	"github.com/webbgeorge/castkeeper/pkg/itunes"
 	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
 	"github.com/webbgeorge/castkeeper/pkg/podcasts"
+	"github.com/webbgeorge/castkeeper/pkg/util"
 	"github.com/webbgeorge/castkeeper/web"
 	"gorm.io/gorm"
 )
@@ -28,6 +29,8 @@ func NewWebserver(
 	encService *encryption.EncryptedValueService,
 ) *framework.Server {
 	port := fmt.Sprintf(":%d", cfg.WebServer.Port)
+	totalQuotaBytes := cfg.Quotas.TotalMB * util.BytesPerMB
+	maxUploadBytes := cfg.WebServer.MaxUploadMB * util.BytesPerMB
 	server := framework.NewServer(port, logger)
 
 	mw := middleware.DefaultMiddlewareStack()
@@ -47,7 +50,8 @@ func NewWebserver(
 
 	return server.SetServerMiddlewares(mw...).
 		AddFileServer("GET /static/", http.FileServer(http.FS(web.StaticAssets)), skipAuth, requireNone).
-		AddRoute("GET /", NewHomeHandler(db), requireReadOnly).
+		AddRoute("GET /", NewHomeHandler(db, totalQuotaBytes), requireReadOnly).
+		AddRoute("GET /storage", NewStorageHandler(db, totalQuotaBytes), requireReadOnly).
 		AddRoute("GET /auth/login", auth.NewGetLoginHandler(), skipAuth, requireNone).
 		AddRoute("POST /auth/login", auth.NewPostLoginHandler(cfg.BaseURL, db), skipAuth, requireNone).
 		AddRoute("GET /auth/logout", auth.NewLogoutHandler(cfg.BaseURL, db), skipAuth, requireNone).
@@ -64,10 +68,18 @@ func NewWebserver(
 		AddRoute("GET /podcasts/search", NewSearchPodcastsHandler(), requireManagePods).
 		AddRoute("POST /podcasts/search", NewSearchResultsHandler(itunesAPI), requireManagePods).
 		AddRoute("POST /podcasts/add", NewAddPodcastHandler(feedService, db, os, encService), requireManagePods).
+		AddRoute("POST /podcasts/create", NewCreatePrivatePodcastHandler(db, os), requireManagePods).
 		AddRoute("GET /podcasts/{guid}/image", NewDownloadImageHandler(db, os), requireReadOnly).
+		AddRoute("GET /podcasts/{guid}/export", NewExportPodcastHandler(db, os), requireManagePods).
+		AddRoute("POST /podcasts/{guid}/requeue-failed", NewRequeuePodcastDownloadsHandler(db, false), requireManagePods).
+		AddRoute("POST /podcasts/{guid}/requeue-selected", NewRequeuePodcastDownloadsHandler(db, true), requireManagePods).
+		AddRoute("POST /podcasts/{guid}/quota", NewUpdatePodcastQuotaHandler(db, totalQuotaBytes), requireManagePods).
+		AddRoute("POST /podcasts/{guid}/episodes/upload", NewUploadEpisodeHandler(db, os, maxUploadBytes), requireManagePods).
 		AddRoute("GET /episodes/{guid}", NewViewEpisodeHandler(db), requireReadOnly).
+		AddRoute("GET /episodes/{guid}/status", NewEpisodeStatusHandler(db), requireReadOnly).
 		AddRoute("GET /episodes/{guid}/download", NewDownloadEpisodeHandler(db, os), requireReadOnly).
 		AddRoute("POST /episodes/{guid}/requeue-download", NewRequeueDownloadHandler(db), requireManagePods).
+		AddRoute("POST /episodes/requeue-failed", NewRequeueFailedDownloadsHandler(db), requireManagePods).
 		AddRoute("GET /feeds/{guid}", NewFeedHandler(cfg.BaseURL, db), useBasicAuth, requireReadOnly).
 		AddRoute("GET /feeds/{guid}/image", NewDownloadImageHandler(db, os), useBasicAuth, requireReadOnly).
 		AddRoute("GET /feeds/episodes/{guid}/download", NewDownloadEpisodeHandler(db, os), useBasicAuth, requireReadOnly)
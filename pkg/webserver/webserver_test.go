@@ -1,14 +1,19 @@
 package webserver_test
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"log/slog"
+	"mime/multipart"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/steinfletcher/apitest"
@@ -21,6 +26,7 @@ import (
 	"github.com/webbgeorge/castkeeper/pkg/fixtures"
 	"github.com/webbgeorge/castkeeper/pkg/framework"
 	"github.com/webbgeorge/castkeeper/pkg/itunes"
+	"github.com/webbgeorge/castkeeper/pkg/mediainfo"
 	"github.com/webbgeorge/castkeeper/pkg/objectstorage"
 	"github.com/webbgeorge/castkeeper/pkg/podcasts"
 	"github.com/webbgeorge/castkeeper/pkg/webserver"
@@ -41,6 +47,8 @@ func TestHomePage(t *testing.T) {
 		Assert(selector.TextExists("CastKeeper")).
 		Assert(selector.TextExists("Your Podcasts")).
 		Assert(selector.TextExists("Test podcast 916ed63b-7e5e-5541-af78-e214a0c14d95")). // from fixtures
+		Assert(selector.ContainsTextValue("#storage-summary", "0 B used by 2 downloaded episodes")).
+		Assert(selector.Exists("#storage-summary a[href='/storage']")).
 		End()
 }
 
@@ -338,6 +346,270 @@ func TestAddPodcast_AlreadyAdded(t *testing.T) {
 		End()
 }
 
+func TestAddPodcast_PremiumFeedOfExistingPodcast(t *testing.T) {
+	ctx, server, db, _, reset := setupServerForTest()
+	defer reset()
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Post("/podcasts/add").
+		WithContext(ctx).
+		Header("Content-Type", "application/x-www-form-urlencoded").
+		Body("feedUrl=http://testdata/feeds/valid-premium.xml&feedPremium=true"). // from fixtures, shares GUID with valid.xml
+		Cookie("Session-Id", "validSession1").                                    // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(selector.TextExists("Feed added to existing podcast 'Test podcast 916ed63b-7e5e-5541-af78-e214a0c14d95'")).
+		End()
+
+	// assert feed was added to the existing podcast
+	var feed podcasts.PodcastFeed
+	result := db.First(&feed, "feed_url = ?", "http://testdata/feeds/valid-premium.xml")
+	if result.Error != nil {
+		panic(result.Error)
+	}
+	assert.Equal(t, genGUID("abc-123"), feed.PodcastGUID)
+	assert.True(t, feed.IsPremium)
+}
+
+func TestCreatePrivatePodcast(t *testing.T) {
+	ctx, server, db, _, reset := setupServerForTest()
+	defer reset()
+
+	res := apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Post("/podcasts/create").
+		WithContext(ctx).
+		MultipartFormData("title", "Team meetings").
+		MultipartFormData("description", "Recordings of team meetings").
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		End()
+
+	var podcast podcasts.Podcast
+	result := db.First(&podcast, "title = ?", "Team meetings")
+	if result.Error != nil {
+		panic(result.Error)
+	}
+	assert.True(t, podcast.IsPrivate())
+	assert.Equal(t, "Recordings of team meetings", podcast.Description)
+	assert.Equal(t, fmt.Sprintf("/podcasts/%s", podcast.GUID), res.Response.Header.Get("HX-Redirect"))
+}
+
+func TestCreatePrivatePodcast_InvalidImage(t *testing.T) {
+	ctx, server, db, _, reset := setupServerForTest()
+	defer reset()
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Post("/podcasts/create").
+		WithContext(ctx).
+		MultipartFormData("title", "Team meetings").
+		MultipartFile("image", "./testdata/expected-generated-feed.xml").
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(selector.TextExists("Image must be a JPEG or PNG")).
+		End()
+
+	var count int64
+	db.Model(&podcasts.Podcast{}).Where("title = ?", "Team meetings").Count(&count)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestCreatePrivatePodcast_InvalidRequest(t *testing.T) {
+	ctx, server, _, _, reset := setupServerForTest()
+	defer reset()
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Post("/podcasts/create").
+		WithContext(ctx).
+		MultipartFormData("title", "").
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(selector.TextExists("Title is a required field")).
+		End()
+}
+
+func TestUploadEpisode(t *testing.T) {
+	ctx, server, db, root, reset := setupServerForTest()
+	defer reset()
+
+	pod, err := podcasts.CreatePrivatePodcast(ctx, db, podcasts.PrivatePodcast{Title: "Team meetings"})
+	if err != nil {
+		panic(err)
+	}
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Post(fmt.Sprintf("/podcasts/%s/episodes/upload", pod.GUID)).
+		WithContext(ctx).
+		MultipartFormData("title", "Planning meeting").
+		MultipartFormData("publishedAt", "2025-04-01").
+		MultipartFile("file", "../fixtures/testdata/audio/with-chapters.mp3").
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Header("HX-Refresh", "true").
+		End()
+
+	eps, err := podcasts.ListEpisodes(ctx, db, pod.GUID)
+	if err != nil {
+		panic(err)
+	}
+	if !assert.Len(t, eps, 1) {
+		return
+	}
+	assert.Equal(t, "Planning meeting", eps[0].Title)
+	assert.Equal(t, "2025-04-01", eps[0].PublishedAt.UTC().Format("2006-01-02"))
+	assert.Equal(t, podcasts.EpisodeStatusSuccess, eps[0].Status)
+	_, err = root.Stat(fmt.Sprintf("%s/%s", podcasts.BlobDir, eps[0].BlobSHA256))
+	assert.Nil(t, err)
+
+	// served through the podcast's CastKeeper feed
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Get(fmt.Sprintf("/feeds/%s", pod.GUID)).
+		WithContext(ctx).
+		BasicAuth("unittest", "unittestpw"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(func(res *http.Response, req *http.Request) error {
+			body, err := io.ReadAll(res.Body)
+			if err != nil {
+				return err
+			}
+			assert.Contains(t, string(body), "<title>Planning meeting</title>")
+			assert.Contains(t, string(body), fmt.Sprintf("http://example.com/feeds/episodes/%s/download", eps[0].GUID))
+			return nil
+		}).
+		End()
+}
+
+func TestUploadEpisode_NotMedia(t *testing.T) {
+	ctx, server, db, _, reset := setupServerForTest()
+	defer reset()
+
+	pod, err := podcasts.CreatePrivatePodcast(ctx, db, podcasts.PrivatePodcast{Title: "Team meetings"})
+	if err != nil {
+		panic(err)
+	}
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Post(fmt.Sprintf("/podcasts/%s/episodes/upload", pod.GUID)).
+		WithContext(ctx).
+		MultipartFile("file", "./testdata/expected-generated-feed.xml").
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(selector.TextExists("Not a supported media file")).
+		End()
+}
+
+func TestUploadEpisode_TooLarge(t *testing.T) {
+	ctx, server, db, _, reset := setupServerForTest()
+	defer reset()
+
+	pod, err := podcasts.CreatePrivatePodcast(ctx, db, podcasts.PrivatePodcast{Title: "Team meetings"})
+	if err != nil {
+		panic(err)
+	}
+	// larger than the 1 MB limit of the test server
+	largeFile := path.Join(t.TempDir(), "large.mp3")
+	if err := os.WriteFile(largeFile, make([]byte, 1024*1024+1), 0o600); err != nil {
+		panic(err)
+	}
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Post(fmt.Sprintf("/podcasts/%s/episodes/upload", pod.GUID)).
+		WithContext(ctx).
+		MultipartFormData("title", "Planning meeting").
+		MultipartFile("file", largeFile).
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(selector.TextExists("The file is too large, the maximum upload size is 1.0 MB")).
+		End()
+
+	eps, err := podcasts.ListEpisodes(ctx, db, pod.GUID)
+	assert.Nil(t, err)
+	assert.Len(t, eps, 0)
+}
+
+func TestUploadEpisode_ClearsDeadlines(t *testing.T) {
+	ctx, server, db, _, reset := setupServerForTest()
+	defer reset()
+
+	pod, err := podcasts.CreatePrivatePodcast(ctx, db, podcasts.PrivatePodcast{Title: "Team meetings"})
+	if err != nil {
+		panic(err)
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	if err := mw.WriteField("title", "Planning meeting"); err != nil {
+		panic(err)
+	}
+	if err := mw.Close(); err != nil {
+		panic(err)
+	}
+	req := httptest.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("/podcasts/%s/episodes/upload", pod.GUID), &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.AddCookie(&http.Cookie{Name: "Session-Id", Value: "validSession1"}) // from fixtures
+	w := &deadlineRecorder{ResponseRecorder: httptest.NewRecorder()}
+
+	server.Mux.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	if assert.NotNil(t, w.readDeadline) {
+		assert.True(t, w.readDeadline.IsZero())
+	}
+	if assert.NotNil(t, w.writeDeadline) {
+		assert.True(t, w.writeDeadline.IsZero())
+	}
+}
+
+func TestUploadEpisode_NotFound(t *testing.T) {
+	ctx, server, _, _, reset := setupServerForTest()
+	defer reset()
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Post("/podcasts/not-a-pod/episodes/upload").
+		WithContext(ctx).
+		MultipartFile("file", "../fixtures/testdata/audio/with-chapters.mp3").
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusNotFound).
+		End()
+}
+
+func TestViewPodcast_Private(t *testing.T) {
+	ctx, server, db, _, reset := setupServerForTest()
+	defer reset()
+
+	pod, err := podcasts.CreatePrivatePodcast(ctx, db, podcasts.PrivatePodcast{Title: "Team meetings"})
+	if err != nil {
+		panic(err)
+	}
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Get(fmt.Sprintf("/podcasts/%s", pod.GUID)).
+		WithContext(ctx).
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(selector.TextExists("Private")).
+		Assert(selector.Exists("#uploadEpisodeModal")).
+		End()
+}
+
 func TestViewPodcast(t *testing.T) {
 	ctx, server, _, _, reset := setupServerForTest()
 	defer reset()
@@ -398,6 +670,44 @@ func TestViewEpisode(t *testing.T) {
 		End()
 }
 
+func TestViewEpisode_MediaInfo(t *testing.T) {
+	ctx, server, db, _, reset := setupServerForTest()
+	defer reset()
+
+	ep, err := podcasts.GetEpisode(ctx, db, genGUID("ep-1"))
+	if err != nil {
+		panic(err)
+	}
+	err = podcasts.UpdateEpisodeMediaInfo(ctx, db, &ep, mediainfo.Info{
+		DurationSecs: 1500,
+		Bitrate:      128000,
+		SampleRate:   44100,
+		Channels:     2,
+		Chapters: []mediainfo.Chapter{
+			{StartSecs: 0, Title: "Intro"},
+			{StartSecs: 95, Title: "Main topic"},
+		},
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Get(fmt.Sprintf("/episodes/%s", genGUID("ep-1"))). // from fixtures
+		WithContext(ctx).
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		// duration from the file is preferred over the feed's 1234s
+		Assert(selector.TextExists("25m0s")).
+		Assert(selector.ContainsTextValue(".media-details", "128 kbps, 44.1 kHz, stereo")).
+		Assert(selector.ContainsTextValue(".chapters li:nth-child(1)", "Intro")).
+		Assert(selector.ContainsTextValue(".chapters li:nth-child(2)", "1m35s")).
+		Assert(selector.ContainsTextValue(".chapters li:nth-child(2)", "Main topic")).
+		End()
+}
+
 func TestViewEpisode_NotFound(t *testing.T) {
 	ctx, server, _, _, reset := setupServerForTest()
 	defer reset()
@@ -442,6 +752,37 @@ func TestDownloadImage_NotFound(t *testing.T) {
 		End()
 }
 
+func TestExportPodcast(t *testing.T) {
+	ctx, server, _, _, reset := setupServerForTest()
+	defer reset()
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Get(fmt.Sprintf("/podcasts/%s/export", genGUID("abc-123"))).
+		WithContext(ctx).
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Header("Content-Type", "application/zip").
+		Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.zip", genGUID("abc-123"))).
+		Assert(selector.TextExists("Not a real MP3")). // files are stored uncompressed
+		End()
+}
+
+func TestExportPodcast_NotFound(t *testing.T) {
+	ctx, server, _, _, reset := setupServerForTest()
+	defer reset()
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Get("/podcasts/not-a-pod/export").
+		WithContext(ctx).
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusNotFound).
+		End()
+}
+
 func TestDownloadEpisode(t *testing.T) {
 	ctx, server, _, _, reset := setupServerForTest()
 	defer reset()
@@ -471,6 +812,188 @@ func TestDownloadEpisode_NotFound(t *testing.T) {
 		End()
 }
 
+func TestEpisodeStatus_InProgress(t *testing.T) {
+	ctx, server, db, _, reset := setupServerForTest()
+	defer reset()
+
+	ep, err := podcasts.GetEpisode(ctx, db, genGUID("ep-1")) // from fixtures
+	if err != nil {
+		panic(err)
+	}
+	err = podcasts.UpdateEpisodeProgress(ctx, db, &ep, 50, 200)
+	if err != nil {
+		panic(err)
+	}
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Get(fmt.Sprintf("/episodes/%s/status", genGUID("ep-1"))).
+		WithContext(ctx).
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(selector.TextExists("in progress 25%")).
+		Assert(selector.Exists(fmt.Sprintf("[hx-get='/episodes/%s/status']", genGUID("ep-1")))).
+		End()
+}
+
+func TestEpisodeStatus_Success(t *testing.T) {
+	ctx, server, _, _, reset := setupServerForTest()
+	defer reset()
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Get(fmt.Sprintf("/episodes/%s/status", genGUID("ep-1"))). // from fixtures
+		WithContext(ctx).
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(selector.TextExists("success")).
+		Assert(selector.NotExists("[hx-get]")).
+		End()
+}
+
+func TestEpisodeStatus_NotFound(t *testing.T) {
+	ctx, server, _, _, reset := setupServerForTest()
+	defer reset()
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Get("/episodes/not-an-ep/status").
+		WithContext(ctx).
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusNotFound).
+		End()
+}
+
+func TestEpisodeStatus_Paused(t *testing.T) {
+	ctx, server, db, _, reset := setupServerForTest()
+	defer reset()
+
+	err := podcasts.UpdateEpisodesStatus(ctx, db, []string{genGUID("ep-1")}, podcasts.EpisodeStatusPaused) // from fixtures
+	if err != nil {
+		panic(err)
+	}
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Get(fmt.Sprintf("/episodes/%s/status", genGUID("ep-1"))).
+		WithContext(ctx).
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(selector.TextExists("paused: quota")).
+		End()
+}
+
+func TestStoragePage(t *testing.T) {
+	ctx, server, db, _, reset := setupServerForTest()
+	defer reset()
+
+	setEpisodeBytesForTest(db, genGUID("ep-1"), 1500000) // from fixtures
+	pod, err := podcasts.GetPodcast(ctx, db, genGUID("abc-123"))
+	if err != nil {
+		panic(err)
+	}
+	err = podcasts.UpdatePodcastQuota(ctx, db, &pod, 5000000)
+	if err != nil {
+		panic(err)
+	}
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Get("/storage").
+		WithContext(ctx).
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(selector.TextExists("1.5 MB used by 2 downloaded episodes")).
+		Assert(selector.ContainsTextValue("#storage-podcasts tbody tr:first-child", "Test podcast 916ed63b-7e5e-5541-af78-e214a0c14d95")).
+		Assert(selector.Exists(fmt.Sprintf("form[hx-post='/podcasts/%s/quota'] input[value='5']", genGUID("abc-123")))).
+		End()
+}
+
+func TestStoragePage_ReadOnly(t *testing.T) {
+	ctx, server, _, _, reset := setupServerForTest()
+	defer reset()
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Get("/storage").
+		WithContext(ctx).
+		Cookie("Session-Id", "validSessionReadOnly"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Assert(selector.TextExists("Unlimited")).
+		Assert(selector.NotExists("form[hx-post]")).
+		End()
+}
+
+func TestUpdatePodcastQuota(t *testing.T) {
+	ctx, server, db, _, reset := setupServerForTest()
+	defer reset()
+
+	podGUID := genGUID("pod-eps-pending") // from fixtures
+	epGUID := genGUID("pending-ep-1")     // from fixtures, 1001 bytes
+	err := podcasts.UpdateEpisodesStatus(ctx, db, []string{epGUID}, podcasts.EpisodeStatusPaused)
+	if err != nil {
+		panic(err)
+	}
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Post(fmt.Sprintf("/podcasts/%s/quota", podGUID)).
+		WithContext(ctx).
+		Header("Content-Type", "application/x-www-form-urlencoded").
+		Body("quotaMB=10").
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Header("HX-Trigger", `{"showMessage":{"level":"success","message":"Quota updated, resumed 1 paused downloads"}}`).
+		End()
+
+	pod, err := podcasts.GetPodcast(ctx, db, podGUID)
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, int64(10000000), pod.QuotaBytes)
+	assertEpisodesRequeued(t, ctx, db, epGUID)
+}
+
+func TestUpdatePodcastQuota_Invalid(t *testing.T) {
+	ctx, server, _, _, reset := setupServerForTest()
+	defer reset()
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Post(fmt.Sprintf("/podcasts/%s/quota", genGUID("abc-123"))). // from fixtures
+		WithContext(ctx).
+		Header("Content-Type", "application/x-www-form-urlencoded").
+		Body("quotaMB=-1").
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Header("HX-Trigger", `{"showMessage":{"level":"error","message":"Quota must be a whole number of MB"}}`).
+		End()
+}
+
+func TestUpdatePodcastQuota_NotFound(t *testing.T) {
+	ctx, server, _, _, reset := setupServerForTest()
+	defer reset()
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Post("/podcasts/not-a-pod/quota").
+		WithContext(ctx).
+		Header("Content-Type", "application/x-www-form-urlencoded").
+		Body("quotaMB=10").
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusNotFound).
+		End()
+}
+
 func TestRequeuePodcast(t *testing.T) {
 	ctx, server, db, _, reset := setupServerForTest()
 	defer reset()
@@ -515,6 +1038,88 @@ func TestRequeuePodcast_NotFound(t *testing.T) {
 		End()
 }
 
+func TestRequeueFailedDownloads(t *testing.T) {
+	ctx, server, db, _, reset := setupServerForTest()
+	defer reset()
+
+	failEpisodesForTest(ctx, db, genGUID("ep-1"), genGUID("ep-2")) // from fixtures
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Post("/episodes/requeue-failed").
+		WithContext(ctx).
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Header("HX-Trigger", `{"showMessage":{"level":"success","message":"Requeued 2 failed downloads"}}`).
+		End()
+
+	assertEpisodesRequeued(t, ctx, db, genGUID("ep-1"), genGUID("ep-2"))
+}
+
+func TestRequeuePodcastFailedDownloads(t *testing.T) {
+	ctx, server, db, _, reset := setupServerForTest()
+	defer reset()
+
+	failEpisodesForTest(ctx, db, genGUID("ep-1"), genGUID("ep-2")) // from fixtures
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Post(fmt.Sprintf("/podcasts/%s/requeue-failed", genGUID("abc-123"))). // from fixtures
+		WithContext(ctx).
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Header("HX-Trigger", `{"showMessage":{"level":"success","message":"Requeued 2 failed downloads"}}`).
+		Assert(selector.Exists("#episode-list")).
+		Assert(selector.NotExists("#episode-list input[name='episodes']")).
+		End()
+
+	assertEpisodesRequeued(t, ctx, db, genGUID("ep-1"), genGUID("ep-2"))
+}
+
+func TestRequeueSelectedDownloads(t *testing.T) {
+	ctx, server, db, _, reset := setupServerForTest()
+	defer reset()
+
+	failEpisodesForTest(ctx, db, genGUID("ep-1"), genGUID("ep-2")) // from fixtures
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Post(fmt.Sprintf("/podcasts/%s/requeue-selected", genGUID("abc-123"))). // from fixtures
+		WithContext(ctx).
+		Header("Content-Type", "application/x-www-form-urlencoded").
+		Body(fmt.Sprintf("episodes=%s", genGUID("ep-1"))).
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusOK).
+		Header("HX-Trigger", `{"showMessage":{"level":"success","message":"Requeued 1 failed downloads"}}`).
+		Assert(selector.Exists(fmt.Sprintf("#episode-list input[value='%s']", genGUID("ep-2")))).
+		End()
+
+	assertEpisodesRequeued(t, ctx, db, genGUID("ep-1"))
+
+	ep, err := podcasts.GetEpisode(ctx, db, genGUID("ep-2"))
+	if err != nil {
+		panic(err)
+	}
+	assert.Equal(t, "failed", ep.Status)
+}
+
+func TestRequeuePodcastFailedDownloads_NotFound(t *testing.T) {
+	ctx, server, _, _, reset := setupServerForTest()
+	defer reset()
+
+	apitest.New().
+		HandlerFunc(server.Mux.ServeHTTP).
+		Post("/podcasts/not-a-pod/requeue-failed").
+		WithContext(ctx).
+		Cookie("Session-Id", "validSession1"). // from fixtures
+		Expect(t).
+		Status(http.StatusNotFound).
+		End()
+}
+
 func TestGetFeed(t *testing.T) {
 	ctx, server, _, _, reset := setupServerForTest()
 	defer reset()
@@ -1128,7 +1733,8 @@ func setupServerForTest() (context.Context, *framework.Server, *gorm.DB, *os.Roo
 	cfg := config.Config{
 		BaseURL: "http://example.com",
 		WebServer: config.WebServerConfig{
-			Port: 8000,
+			Port:        8000,
+			MaxUploadMB: 1,
 		},
 		Encryption: config.EncryptionConfig{
 			Driver:    config.EncryptionDriverSecretKey,
@@ -1157,6 +1763,59 @@ func setupServerForTest() (context.Context, *framework.Server, *gorm.DB, *os.Roo
 	}
 }
 
+// deadlineRecorder records the deadlines set through an http.ResponseController
+type deadlineRecorder struct {
+	*httptest.ResponseRecorder
+	readDeadline  *time.Time
+	writeDeadline *time.Time
+}
+
+func (d *deadlineRecorder) SetReadDeadline(deadline time.Time) error {
+	d.readDeadline = &deadline
+	return nil
+}
+
+func (d *deadlineRecorder) SetWriteDeadline(deadline time.Time) error {
+	d.writeDeadline = &deadline
+	return nil
+}
+
 func genGUID(s string) string {
 	return uuid.NewV5(uuid.NamespaceOID, s).String()
 }
+
+func failEpisodesForTest(ctx context.Context, db *gorm.DB, guids ...string) {
+	err := podcasts.UpdateEpisodesStatus(ctx, db, guids, podcasts.EpisodeStatusFailed)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func assertEpisodesRequeued(t *testing.T, ctx context.Context, db *gorm.DB, guids ...string) {
+	t.Helper()
+
+	queued := make([]string, 0)
+	for {
+		qt, err := framework.PopQueueTask(ctx, db, downloadworker.DownloadWorkerQueueName)
+		if err != nil {
+			break
+		}
+		queued = append(queued, qt.Data.(string))
+	}
+	assert.ElementsMatch(t, guids, queued)
+
+	for _, guid := range guids {
+		ep, err := podcasts.GetEpisode(ctx, db, guid)
+		if err != nil {
+			panic(err)
+		}
+		assert.Equal(t, "pending", ep.Status)
+	}
+}
+
+func setEpisodeBytesForTest(db *gorm.DB, guid string, bytes int64) {
+	err := db.Exec("UPDATE episodes SET bytes = ? WHERE guid = ?", bytes, guid).Error
+	if err != nil {
+		panic(err)
+	}
+}